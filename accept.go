@@ -11,11 +11,15 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"log/slog"
 	"net/http"
 	"net/textproto"
 	"net/url"
 	"path"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/coder/websocket/internal/errd"
 )
@@ -27,6 +31,14 @@ type AcceptOptions struct {
 	// reject it, close the connection when c.Subprotocol() == "".
 	Subprotocols []string
 
+	// PreferClientSubprotocols selects the first of the client's subprotocols
+	// that is also in Subprotocols. By default, the first of Subprotocols that
+	// the client supports is selected.
+	//
+	// The client's preference is the order in which it lists its subprotocols
+	// across all of its Sec-WebSocket-Protocol headers.
+	PreferClientSubprotocols bool
+
 	// InsecureSkipVerify is used to disable Accept's origin verification behaviour.
 	//
 	// You probably want to use OriginPatterns instead.
@@ -52,6 +64,39 @@ type AcceptOptions struct {
 	// to bring attention to the danger of such a setting.
 	OriginPatterns []string
 
+	// VerifyOrigin is an optional callback to authorize origins that are neither the
+	// request host nor matched by OriginPatterns, such as per tenant domains stored in
+	// a database. origin is the raw Origin header.
+	//
+	// Return nil to authorize the origin. Otherwise the client is rejected with
+	// 403 Forbidden and Accept returns an error wrapping the returned error.
+	// Return a RejectionError to control the response instead.
+	//
+	// It is not called when InsecureSkipVerify is set or the Origin header is absent.
+	VerifyOrigin func(r *http.Request, origin string) error
+
+	// Authorize is an optional callback to authorize the handshake request, e.g.
+	// with its credentials. It is called for every valid handshake request,
+	// before the origin is verified.
+	//
+	// Return nil to authorize the request. Otherwise the client is rejected with
+	// 403 Forbidden and Accept returns an error wrapping the returned error.
+	// Return a RejectionError to control the response instead, e.g. to reply
+	// 401 Unauthorized with a WWW-Authenticate header.
+	Authorize func(r *http.Request) error
+
+	// Limiter, if set, limits the number of open connections and the rate
+	// at which they are accepted. See AcceptLimiter.
+	Limiter *AcceptLimiter
+
+	// ResponseHeader specifies additional HTTP headers included in the 101 Switching
+	// Protocols response, such as Set-Cookie.
+	//
+	// Upgrade, Connection and Sec-WebSocket-* headers are ignored as Accept
+	// controls them. Headers set on the http.ResponseWriter before calling
+	// Accept are also sent.
+	ResponseHeader http.Header
+
 	// CompressionMode controls the compression mode.
 	// Defaults to CompressionDisabled.
 	//
@@ -64,6 +109,83 @@ type AcceptOptions struct {
 	// for CompressionContextTakeover.
 	CompressionThreshold int
 
+	// CompressionLevel is the flate compression level, from flate.HuffmanOnly
+	// to flate.BestCompression. Defaults to flate.BestSpeed, including when set
+	// to flate.NoCompression as it is the zero value. Use CompressionDisabled
+	// to send messages uncompressed.
+	//
+	// Higher levels improve the compression ratio at the cost of CPU time.
+	CompressionLevel int
+
+	// CompressionDictionary is an optional preset dictionary for compressing and
+	// decompressing messages without context takeover. Protocols with known
+	// message shapes can use one to improve the compression ratio of small messages.
+	//
+	// It is not negotiated so both peers must be configured with the same
+	// dictionary. It is ignored with context takeover as the previous messages
+	// serve as the dictionary.
+	CompressionDictionary []byte
+
+	// CompressionBackend optionally replaces compress/flate as the DEFLATE
+	// implementation, e.g. with github.com/klauspost/compress/flate.
+	CompressionBackend CompressionBackend
+
+	// StrictUTF8 enables validation of text messages and close reasons as required
+	// by RFC 6455. When invalid UTF-8 is received, the read returns an error and the
+	// connection is closed with StatusInvalidFramePayloadData.
+	//
+	// Validation is incremental so messages are still streamed but every byte of
+	// every text message is inspected, which adds measurable CPU overhead.
+	// It is disabled by default as most applications validate their payloads
+	// while decoding them anyway.
+	StrictUTF8 bool
+
+	// ConcurrentReads allows Read and ReadTimeout to be called from multiple
+	// goroutines, e.g. by a pool of workers. Each call reads a whole message
+	// and calls are served one at a time in the order they block, which is
+	// first in first out in practice but not guaranteed.
+	//
+	// Reader must not be called while Read calls are in progress.
+	// SetReadLimit applies to the messages whose reads start after it
+	// returns and a message exceeding the limit fails the connection for
+	// all readers.
+	ConcurrentReads bool
+
+	// AllowUnmaskedFrames accepts unmasked frames from the client instead of
+	// closing the connection with StatusProtocolError as RFC 6455 requires.
+	//
+	// It is meant for trusted internal hops and clients that cannot be
+	// fixed yet. Masking protects intermediaries from cache poisoning so
+	// never enable it for connections from browsers or the internet.
+	AllowUnmaskedFrames bool
+
+	// AllowHTTP10 accepts handshake requests made with HTTP/1.0 instead of
+	// rejecting them as RFC 6455 requires HTTP/1.1.
+	//
+	// It is meant for legacy intermediaries that downgrade requests to
+	// HTTP/1.0 but forward the Upgrade and Connection headers. Such
+	// intermediaries may not understand the upgrade and close or buffer the
+	// connection so only enable it if the path to the client is known to
+	// relay the connection as is.
+	AllowHTTP10 bool
+
+	// AllowRequestBody accepts handshake requests with a non zero
+	// Content-Length or a Transfer-Encoding. By default they are rejected
+	// with 400 Bad Request as the handshake has no body and a front proxy
+	// that disagrees with the server on where the request ends could be
+	// used to smuggle requests.
+	//
+	// It is meant for intermediaries known to add benign lengths.
+	AllowRequestBody bool
+
+	// EmulatedPing responds to the emulated pings sent by Wasm clients dialed with
+	// DialOptions.EmulatedPing, as browsers cannot send ping frames.
+	//
+	// Emulated pings are small binary messages with a reserved prefix. They are
+	// answered by Reader and never returned to the application. This adds an
+	// allocation to every binary message of at most 125 bytes.
+	EmulatedPing bool
+
 	// OnPingReceived is an optional callback invoked synchronously when a ping frame is received.
 	//
 	// The payload contains the application data of the ping frame.
@@ -71,6 +193,16 @@ type AcceptOptions struct {
 	// To avoid blocking, any expensive processing should be performed asynchronously using a goroutine.
 	OnPingReceived func(ctx context.Context, payload []byte) bool
 
+	// OnPingReply is like OnPingReceived but also returns the payload of the
+	// pong, which must be at most 125 bytes. It allows protocols to piggyback
+	// data such as sequence numbers on pings and their pongs. If set,
+	// OnPingReceived is not called.
+	//
+	// A peer pinging with Ping or PingWithPayload fails with PongMismatchError
+	// when the payload changes so it should receive the pong with
+	// OnPongReceived instead.
+	OnPingReply func(ctx context.Context, payload []byte) (pong []byte, respond bool)
+
 	// OnPongReceived is an optional callback invoked synchronously when a pong frame is received.
 	//
 	// The payload contains the application data of the pong frame.
@@ -79,6 +211,163 @@ type AcceptOptions struct {
 	// Unlike OnPingReceived, this callback does not return a value because a pong frame
 	// is a response to a ping and does not trigger any further frame transmission.
 	OnPongReceived func(ctx context.Context, payload []byte)
+
+	// OnCloseReceived is an optional callback invoked synchronously when a close frame
+	// is received, once the close frame is echoed.
+	//
+	// It allows recording why the peer disconnected in one place instead of
+	// inspecting the error at every Read site.
+	// It is called without locks held so it may call methods of the Conn, e.g. CloseNow.
+	// To avoid blocking, any expensive processing should be performed asynchronously using a goroutine.
+	OnCloseReceived func(ctx context.Context, code StatusCode, reason string)
+
+	// OnCloseSent is an optional callback invoked synchronously after a close frame
+	// is written, whether initiating the close handshake or echoing the peer's.
+	// Like OnCloseReceived, it is called without locks held.
+	// To avoid blocking, any expensive processing should be performed asynchronously using a goroutine.
+	OnCloseSent func(ctx context.Context, code StatusCode, reason string)
+
+	// OnStateChange is an optional callback invoked with the new state of the
+	// connection on each transition, starting with StateOpen once the handshake
+	// completed. See Conn.State.
+	//
+	// It is called without locks held so it may call methods of the Conn, e.g.
+	// CloseNow. Calls are serialized and in the order of the transitions, so a
+	// transition may be reported by another goroutine already calling it.
+	// To avoid blocking, any expensive processing should be performed asynchronously using a goroutine.
+	OnStateChange func(s State)
+
+	// OnReservedFrame is an optional callback invoked synchronously when a frame
+	// with a reserved opcode (0x3-0x7 or 0xB-0xF) is received, to prototype
+	// extensions. The payload is unmasked.
+	// If the callback is nil or returns false, the connection fails with
+	// StatusProtocolError.
+	//
+	// Frames with reserved opcodes must not be fragmented. Payloads of the
+	// non-control opcodes 0x3-0x7 are bounded by the read limit, or 32768 bytes
+	// if it is disabled, and payloads of the control opcodes 0xB-0xF by the
+	// 125 byte limit of control frames.
+	OnReservedFrame func(ctx context.Context, opcode int, payload []byte) bool
+
+	// Extensions are accepted when offered by the client, in the order they
+	// are registered. Extensions that are accepted transform every data
+	// message of the connection. See Extension.
+	Extensions []Extension
+
+	// WriteQueueLength is the number of messages Conn.WriteAsync can queue.
+	// Defaults to 64.
+	WriteQueueLength int
+
+	// WriteQueuePolicy selects the message dropped when Conn.WriteAsync is
+	// called with a full queue. Defaults to DropNewest.
+	WriteQueuePolicy DropPolicy
+
+	// WriteBackpressureTimeout, if set, bounds how long a write waits for the
+	// previous message to be written before failing with ErrWriteBackpressure,
+	// which lets servers shed load without closing the connection.
+	//
+	// Only the wait behind another write is bounded. A write already blocked
+	// because the peer is not reading is not, as abandoning a partially
+	// written frame would corrupt the connection. Bound it with the context
+	// instead, which closes the connection when it expires.
+	WriteBackpressureTimeout time.Duration
+
+	// WaitCloseOnError makes a read that fails the connection, e.g. on a
+	// protocol error or a message exceeding the read limit, wait up to 5s for
+	// the peer's close frame like Close does before closing the connection.
+	// Otherwise the close frame is sent and the connection is left for the
+	// application to close, which may drop the close frame before a slow peer
+	// reads it.
+	WaitCloseOnError bool
+
+	// ControlWriteTimeout bounds writing a control frame, e.g. the pong
+	// answering a ping or the close frame of Close. Defaults to 5s. Shrink it
+	// to detect stuck peers sooner or extend it for links with a high RTT.
+	ControlWriteTimeout time.Duration
+
+	// IdleTimeout, if set, closes the connection with StatusGoingAway when
+	// nothing, not even a pong, was received from the peer for the duration.
+	// If the peer does not answer the close frame within another IdleTimeout,
+	// the connection is closed without completing the handshake. Combined
+	// with periodic pings it reaps connections to dead peers.
+	//
+	// Frames are only received while reading so keep reading, e.g. with
+	// CloseRead.
+	IdleTimeout time.Duration
+
+	// Clock, if set, is the source of time of the timeouts of the connection
+	// in place of the system clock. It is meant for tests, see Clock.
+	Clock Clock
+
+	// WireDump, if set, receives a copy of the raw bytes read from and
+	// written to the connection after the handshake, decrypted if TLS is
+	// used, to debug interoperability issues at the wire level.
+	//
+	// Each read and write is dumped as a record of a direction byte,
+	// WireDumpRead or WireDumpWrite, the time in nanoseconds since the Unix
+	// epoch as a big endian uint64, the length of the bytes as a big endian
+	// uint32 and the bytes. Records are written atomically so WireDump may be
+	// shared by connections but they cannot be told apart.
+	//
+	// Errors writing to WireDump are ignored. Writes are synchronous so a slow
+	// WireDump slows down the connection.
+	WireDump io.Writer
+
+	// Metrics, if set, collects metrics about the connection.
+	Metrics Metrics
+
+	// Logger is an optional logger for connection lifecycle events.
+	//
+	// Handshake results and negotiated extensions, close frames sent and received,
+	// and connections failed due to protocol errors are logged.
+	// Successful events are logged at the debug level and failures at the warn level.
+	Logger *slog.Logger
+}
+
+// RejectionError rejects a handshake with a custom HTTP response.
+// Return it from Authorize or VerifyOrigin. Accept also returns one when
+// the Limiter rejects a handshake.
+//
+// Use errors.As to check for it in the error returned by Accept.
+type RejectionError struct {
+	// StatusCode defaults to the status Accept would otherwise use.
+	StatusCode int
+	// Header is added to the response headers.
+	Header http.Header
+	// Body defaults to the status text.
+	Body string
+}
+
+func (re RejectionError) Error() string {
+	if re.StatusCode == 0 {
+		return "handshake rejected"
+	}
+	return fmt.Sprintf("handshake rejected with status %v", re.StatusCode)
+}
+
+// rejectHandshake writes the response for a handshake failing with err.
+// A RejectionError in err controls the response, otherwise the error
+// text is written with the given status code.
+func rejectHandshake(w http.ResponseWriter, err error, code int) {
+	var re RejectionError
+	if !errors.As(err, &re) {
+		http.Error(w, err.Error(), code)
+		return
+	}
+
+	for k, vv := range re.Header {
+		for _, v := range vv {
+			w.Header().Add(k, v)
+		}
+	}
+	if re.StatusCode != 0 {
+		code = re.StatusCode
+	}
+	body := re.Body
+	if body == "" {
+		body = http.StatusText(code)
+	}
+	http.Error(w, body, code)
 }
 
 func (opts *AcceptOptions) cloneWithDefaults() *AcceptOptions {
@@ -106,46 +395,105 @@ func Accept(w http.ResponseWriter, r *http.Request, opts *AcceptOptions) (*Conn,
 func accept(w http.ResponseWriter, r *http.Request, opts *AcceptOptions) (_ *Conn, err error) {
 	defer errd.Wrap(&err, "failed to accept WebSocket connection")
 
-	errCode, err := verifyClientRequest(w, r)
+	start := time.Now()
+	opts = opts.cloneWithDefaults()
+	defer func() {
+		if err != nil && opts.Logger != nil {
+			opts.Logger.Warn("failed to accept WebSocket connection", "remote_addr", r.RemoteAddr, "error", err)
+		}
+	}()
+
+	err = validateCompressionLevel(opts.CompressionLevel)
 	if err != nil {
-		http.Error(w, err.Error(), errCode)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return nil, err
 	}
 
-	opts = opts.cloneWithDefaults()
+	err = validateExtensions(opts.Extensions)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return nil, err
+	}
+
+	errCode, err := verifyClientRequest(w, r, opts.AllowHTTP10, opts.AllowRequestBody)
+	if err != nil {
+		rejectHandshake(w, err, errCode)
+		return nil, &HandshakeError{StatusCode: errCode, Err: err}
+	}
+
+	if opts.Authorize != nil {
+		err = opts.Authorize(r)
+		if err != nil {
+			rejectHandshake(w, err, http.StatusForbidden)
+			return nil, fmt.Errorf("request not authorized: %w", err)
+		}
+	}
+
 	if !opts.InsecureSkipVerify {
-		err = authenticateOrigin(r, opts.OriginPatterns)
+		err = authenticateOrigin(r, opts.OriginPatterns, opts.VerifyOrigin)
 		if err != nil {
 			if errors.Is(err, path.ErrBadPattern) {
 				log.Printf("websocket: %v", err)
 				err = errors.New(http.StatusText(http.StatusForbidden))
 			}
-			http.Error(w, err.Error(), http.StatusForbidden)
+			rejectHandshake(w, err, http.StatusForbidden)
 			return nil, err
 		}
 	}
 
-	hj, ok := hijacker(w)
+	var release func()
+	if opts.Limiter != nil {
+		err = opts.Limiter.acquire(time.Now())
+		if err != nil {
+			rejectHandshake(w, err, http.StatusServiceUnavailable)
+			return nil, err
+		}
+		release = opts.Limiter.release
+		defer func() {
+			if err != nil {
+				release()
+			}
+		}()
+	}
+
+	_, ok := hijacker(w)
 	if !ok {
-		err = errors.New("http.ResponseWriter does not implement http.Hijacker")
+		err = errors.New("http.ResponseWriter does not implement http.Hijacker nor Unwrap to one that does")
 		http.Error(w, http.StatusText(http.StatusNotImplemented), http.StatusNotImplemented)
 		return nil, err
 	}
 
+	for k, vv := range opts.ResponseHeader {
+		if isHandshakeHeader(k) {
+			continue
+		}
+		for _, v := range vv {
+			w.Header().Add(k, v)
+		}
+	}
+
 	w.Header().Set("Upgrade", "websocket")
 	w.Header().Set("Connection", "Upgrade")
 
 	key := r.Header.Get("Sec-WebSocket-Key")
 	w.Header().Set("Sec-WebSocket-Accept", secWebSocketAccept(key))
 
-	subproto := selectSubprotocol(r, opts.Subprotocols)
+	subproto := selectSubprotocol(r, opts.Subprotocols, opts.PreferClientSubprotocols)
 	if subproto != "" {
 		w.Header().Set("Sec-WebSocket-Protocol", subproto)
 	}
 
-	copts, ok := selectDeflate(websocketExtensions(r.Header), opts.CompressionMode)
+	offers := websocketExtensions(r.Header)
+	var extResp []string
+	copts, ok := selectDeflate(offers, opts.CompressionMode)
 	if ok {
-		w.Header().Set("Sec-WebSocket-Extensions", copts.String())
+		extResp = append(extResp, copts.String())
+		copts.setFlateParams(opts.CompressionLevel, opts.CompressionDictionary, opts.CompressionBackend)
+	}
+	resp, exts := acceptExtensions(opts.Extensions, offers)
+	extResp = append(extResp, resp...)
+	if len(extResp) > 0 {
+		w.Header().Set("Sec-WebSocket-Extensions", strings.Join(extResp, ", "))
 	}
 
 	w.WriteHeader(http.StatusSwitchingProtocols)
@@ -156,76 +504,177 @@ func accept(w http.ResponseWriter, r *http.Request, opts *AcceptOptions) (_ *Con
 		ginWriter.WriteHeaderNow()
 	}
 
-	netConn, brw, err := hj.Hijack()
+	// Wrapped writers of middleware are unwrapped like hijacker does.
+	netConn, brw, err := http.NewResponseController(w).Hijack()
 	if err != nil {
 		err = fmt.Errorf("failed to hijack connection: %w", err)
 		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return nil, err
 	}
 
+	var rwc io.ReadWriteCloser = netConn
 	// https://github.com/golang/go/issues/32314
 	b, _ := brw.Reader.Peek(brw.Reader.Buffered())
-	brw.Reader.Reset(io.MultiReader(bytes.NewReader(b), netConn))
+	if opts.WireDump != nil {
+		d := newWireDump(netConn, opts.WireDump)
+		// The bytes buffered by net/http were read before the hijack.
+		d.dump(WireDumpRead, b)
+		rwc = d
+
+		// Bytes buffered before the hijack are flushed to netConn directly,
+		// the writes of the connection go through the dump.
+		err = brw.Writer.Flush()
+		if err != nil {
+			netConn.Close()
+			return nil, fmt.Errorf("failed to flush hijacked connection: %w", err)
+		}
+		brw.Writer.Reset(rwc)
+	}
+	brw.Reader.Reset(io.MultiReader(bytes.NewReader(b), rwc))
+
+	if opts.Logger != nil {
+		opts.Logger.Debug("accepted WebSocket connection",
+			"remote_addr", r.RemoteAddr,
+			"subprotocol", subproto,
+			"extensions", w.Header().Get("Sec-WebSocket-Extensions"),
+		)
+	}
+
+	hr := r.Clone(context.Background())
+	hr.Body = http.NoBody
+
+	if opts.Metrics != nil {
+		opts.Metrics.ObserveHandshakeDuration(time.Since(start))
+	}
 
 	return newConn(connConfig{
-		subprotocol:    w.Header().Get("Sec-WebSocket-Protocol"),
-		rwc:            netConn,
-		client:         false,
-		copts:          copts,
-		flateThreshold: opts.CompressionThreshold,
-		onPingReceived: opts.OnPingReceived,
-		onPongReceived: opts.OnPongReceived,
+		subprotocol:              w.Header().Get("Sec-WebSocket-Protocol"),
+		extHeader:                w.Header().Get("Sec-WebSocket-Extensions"),
+		handshakeReq:             hr,
+		tlsState:                 r.TLS,
+		rwc:                      rwc,
+		netConn:                  netConn,
+		client:                   false,
+		copts:                    copts,
+		extensions:               exts,
+		flateThreshold:           opts.CompressionThreshold,
+		strictUTF8:               opts.StrictUTF8,
+		concurrentReads:          opts.ConcurrentReads,
+		allowUnmaskedFrames:      opts.AllowUnmaskedFrames,
+		emulatedPing:             opts.EmulatedPing,
+		onPingReceived:           opts.OnPingReceived,
+		onPingReply:              opts.OnPingReply,
+		onPongReceived:           opts.OnPongReceived,
+		onCloseReceived:          opts.OnCloseReceived,
+		onCloseSent:              opts.OnCloseSent,
+		onStateChange:            opts.OnStateChange,
+		onReservedFrame:          opts.OnReservedFrame,
+		onClose:                  release,
+		writeQueueLength:         opts.WriteQueueLength,
+		writeQueuePolicy:         opts.WriteQueuePolicy,
+		writeBackpressureTimeout: opts.WriteBackpressureTimeout,
+		waitCloseOnError:         opts.WaitCloseOnError,
+		controlWriteTimeout:      opts.ControlWriteTimeout,
+		idleTimeout:              opts.IdleTimeout,
+		clock:                    opts.Clock,
+		logger:                   opts.Logger,
+		metrics:                  opts.Metrics,
 
 		br: brw.Reader,
 		bw: brw.Writer,
 	}), nil
 }
 
-func verifyClientRequest(w http.ResponseWriter, r *http.Request) (errCode int, _ error) {
-	if !r.ProtoAtLeast(1, 1) {
-		return http.StatusUpgradeRequired, fmt.Errorf("WebSocket protocol violation: handshake request must be at least HTTP/1.1: %q", r.Proto)
+// isHandshakeHeader reports whether k is a header controlled by the handshake.
+func isHandshakeHeader(k string) bool {
+	k = textproto.CanonicalMIMEHeaderKey(k)
+	return k == "Upgrade" || k == "Connection" || strings.HasPrefix(k, "Sec-Websocket-")
+}
+
+func verifyClientRequest(w http.ResponseWriter, r *http.Request, allowHTTP10, allowBody bool) (errCode int, _ error) {
+	if !r.ProtoAtLeast(1, 1) && !(allowHTTP10 && r.ProtoAtLeast(1, 0)) {
+		return http.StatusUpgradeRequired, fmt.Errorf("%w: handshake request must be at least HTTP/1.1: %q", ErrProtocolViolation, r.Proto)
 	}
 
 	if !headerContainsTokenIgnoreCase(r.Header, "Connection", "Upgrade") {
 		w.Header().Set("Connection", "Upgrade")
 		w.Header().Set("Upgrade", "websocket")
-		return http.StatusUpgradeRequired, fmt.Errorf("WebSocket protocol violation: Connection header %q does not contain Upgrade", r.Header.Get("Connection"))
+		return http.StatusUpgradeRequired, fmt.Errorf("%w: Connection header %q does not contain Upgrade", ErrProtocolViolation, r.Header.Get("Connection"))
 	}
 
 	if !headerContainsTokenIgnoreCase(r.Header, "Upgrade", "websocket") {
 		w.Header().Set("Connection", "Upgrade")
 		w.Header().Set("Upgrade", "websocket")
-		return http.StatusUpgradeRequired, fmt.Errorf("WebSocket protocol violation: Upgrade header %q does not contain websocket", r.Header.Get("Upgrade"))
+		return http.StatusUpgradeRequired, fmt.Errorf("%w: Upgrade header %q does not contain websocket", ErrProtocolViolation, r.Header.Get("Upgrade"))
 	}
 
 	if r.Method != "GET" {
-		return http.StatusMethodNotAllowed, fmt.Errorf("WebSocket protocol violation: handshake request method is not GET but %q", r.Method)
+		return http.StatusMethodNotAllowed, fmt.Errorf("%w: handshake request method is not GET but %q", ErrProtocolViolation, r.Method)
 	}
 
-	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+	if !allowBody {
+		if len(r.TransferEncoding) > 0 {
+			return http.StatusBadRequest, &HeaderError{Header: "Transfer-Encoding", Value: strings.Join(r.TransferEncoding, ", "), Reason: "handshake request must not have a body"}
+		}
+		if r.ContentLength != 0 {
+			return http.StatusBadRequest, &HeaderError{Header: "Content-Length", Value: strconv.FormatInt(r.ContentLength, 10), Reason: "handshake request must not have a body"}
+		}
+	}
+
+	if v := r.Header.Get("Sec-WebSocket-Version"); v != "13" {
+		// RFC 6455 section 4.2.2 lists the supported versions for the
+		// client to retry with one of them.
 		w.Header().Set("Sec-WebSocket-Version", "13")
-		return http.StatusBadRequest, fmt.Errorf("unsupported WebSocket protocol version (only 13 is supported): %q", r.Header.Get("Sec-WebSocket-Version"))
+		return http.StatusUpgradeRequired, &VersionError{Requested: v, Supported: []string{"13"}}
 	}
 
 	websocketSecKeys := r.Header.Values("Sec-WebSocket-Key")
 	if len(websocketSecKeys) == 0 {
-		return http.StatusBadRequest, errors.New("WebSocket protocol violation: missing Sec-WebSocket-Key")
+		return http.StatusBadRequest, &HeaderError{Header: "Sec-WebSocket-Key", Reason: "is missing"}
 	}
 
 	if len(websocketSecKeys) > 1 {
-		return http.StatusBadRequest, errors.New("WebSocket protocol violation: multiple Sec-WebSocket-Key headers")
+		return http.StatusBadRequest, &HeaderError{Header: "Sec-WebSocket-Key", Reason: "is repeated"}
 	}
 
 	// The RFC states to remove any leading or trailing whitespace.
 	websocketSecKey := strings.TrimSpace(websocketSecKeys[0])
 	if v, err := base64.StdEncoding.DecodeString(websocketSecKey); err != nil || len(v) != 16 {
-		return http.StatusBadRequest, fmt.Errorf("WebSocket protocol violation: invalid Sec-WebSocket-Key %q, must be a 16 byte base64 encoded string", websocketSecKey)
+		return http.StatusBadRequest, &HeaderError{Header: "Sec-WebSocket-Key", Value: websocketSecKey, Reason: "must be a 16 byte base64 encoded string"}
+	}
+
+	for _, v := range r.Header.Values("Sec-WebSocket-Protocol") {
+		for _, p := range strings.Split(v, ",") {
+			p = strings.TrimSpace(p)
+			// RFC 7230 section 7 requires empty list elements to be ignored.
+			if p == "" {
+				continue
+			}
+			if !isToken(p) {
+				return http.StatusBadRequest, &HeaderError{Header: "Sec-WebSocket-Protocol", Value: v, Reason: fmt.Sprintf("subprotocol %q is not a token", p)}
+			}
+		}
 	}
 
 	return 0, nil
 }
 
-func authenticateOrigin(r *http.Request, originHosts []string) error {
+// isToken reports whether s is a non empty token as defined in RFC 7230
+// section 3.2.6, which RFC 6455 section 4.1 requires of subprotocols.
+func isToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c <= ' ' || c >= 0x7f || strings.IndexByte(`"(),/:;<=>?@[\]{}`, c) >= 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func authenticateOrigin(r *http.Request, originHosts []string, verify func(*http.Request, string) error) error {
 	origin := r.Header.Get("Origin")
 	if origin == "" {
 		return nil
@@ -256,6 +705,13 @@ func authenticateOrigin(r *http.Request, originHosts []string) error {
 	if u.Host == "" {
 		return fmt.Errorf("request Origin %q is not a valid URL with a host", origin)
 	}
+	if verify != nil {
+		err := verify(r, origin)
+		if err != nil {
+			return fmt.Errorf("request Origin %q is not authorized for Host %q: %w", u.Host, r.Host, err)
+		}
+		return nil
+	}
 	return fmt.Errorf("request Origin %q is not authorized for Host %q", u.Host, r.Host)
 }
 
@@ -263,8 +719,18 @@ func match(pattern, s string) (bool, error) {
 	return path.Match(strings.ToLower(pattern), strings.ToLower(s))
 }
 
-func selectSubprotocol(r *http.Request, subprotocols []string) string {
+func selectSubprotocol(r *http.Request, subprotocols []string, preferClient bool) string {
 	cps := headerTokens(r.Header, "Sec-WebSocket-Protocol")
+	if preferClient {
+		for _, cp := range cps {
+			for _, sp := range subprotocols {
+				if strings.EqualFold(sp, cp) {
+					return cp
+				}
+			}
+		}
+		return ""
+	}
 	for _, sp := range subprotocols {
 		for _, cp := range cps {
 			if strings.EqualFold(sp, cp) {
@@ -275,44 +741,116 @@ func selectSubprotocol(r *http.Request, subprotocols []string) string {
 	return ""
 }
 
+// MatchSubprotocolVersions returns the highest version of the subprotocol name
+// offered by the client in r that is also in versions, formatted as "name.vN"
+// like the client sent it. Pass it in AcceptOptions.Subprotocols to negotiate it.
+// An empty string is returned if there is no common version.
+//
+// See ParseSubprotocolVersion and Conn.SubprotocolVersion.
+func MatchSubprotocolVersions(r *http.Request, name string, versions ...int) string {
+	var match string
+	best := -1
+	for _, cp := range headerTokens(r.Header, "Sec-WebSocket-Protocol") {
+		n, v, ok := ParseSubprotocolVersion(cp)
+		if !ok || !strings.EqualFold(n, name) || v <= best || !slices.Contains(versions, v) {
+			continue
+		}
+		match, best = cp, v
+	}
+	return match
+}
+
+// SubprotocolToken returns the token the client in r offered as the
+// subprotocol prefix+token, e.g. "access_token.<jwt>" for the prefix
+// "access_token.". Browsers cannot set the Authorization header of the
+// handshake request so tokens are commonly passed this way.
+//
+// ok is false if prefix is empty or if no token or more than one was
+// offered. The token must still be verified by the caller.
+//
+// Accept never echoes the token as it only selects subprotocols in
+// AcceptOptions.Subprotocols. Browsers fail the connection if none is
+// selected so clients must also offer the subprotocol to negotiate, e.g.
+//
+//	new WebSocket(url, ["chat", "access_token." + token])
+func SubprotocolToken(r *http.Request, prefix string) (token string, ok bool) {
+	if prefix == "" {
+		return "", false
+	}
+	for _, cp := range headerTokens(r.Header, "Sec-WebSocket-Protocol") {
+		t, found := strings.CutPrefix(cp, prefix)
+		if !found {
+			continue
+		}
+		if ok || t == "" {
+			return "", false
+		}
+		token, ok = t, true
+	}
+	return token, ok
+}
+
+// selectDeflate selects the permessage-deflate offer in extensions that best
+// matches mode. Offers with unsupported parameters are skipped. Of the
+// rest, the offer disabling the fewest context takeovers that mode allows
+// is selected, with ties going to the offer the client prefers.
 func selectDeflate(extensions []websocketExtension, mode CompressionMode) (*compressionOptions, bool) {
 	if mode == CompressionDisabled {
 		return nil, false
 	}
+	var best *compressionOptions
+	var bestScore int
 	for _, ext := range extensions {
 		switch ext.name {
 		// We used to implement x-webkit-deflate-frame too for Safari but Safari has bugs...
 		// See https://github.com/nhooyr/websocket/issues/218
 		case "permessage-deflate":
 			copts, ok := acceptDeflate(ext, mode)
-			if ok {
-				return copts, true
+			if !ok {
+				continue
+			}
+			score := deflateScore(copts, mode)
+			if best == nil || score > bestScore {
+				best, bestScore = copts, score
 			}
 		}
 	}
-	return nil, false
+	return best, best != nil
+}
+
+// deflateScore scores copts by the context takeovers allowed by mode that
+// it keeps.
+func deflateScore(copts *compressionOptions, mode CompressionMode) int {
+	want := mode.opts()
+	var score int
+	if copts.clientNoContextTakeover == want.clientNoContextTakeover {
+		score++
+	}
+	if copts.serverNoContextTakeover == want.serverNoContextTakeover {
+		score++
+	}
+	return score
 }
 
 func acceptDeflate(ext websocketExtension, mode CompressionMode) (*compressionOptions, bool) {
 	copts := mode.opts()
 	for _, p := range ext.params {
-		switch p {
-		case "client_no_context_takeover":
+		name, value, hasValue := strings.Cut(p, "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		// Values may be quoted strings. See RFC 7692 section 7.1.
+		value = strings.Trim(strings.TrimSpace(value), `"`)
+
+		switch {
+		case name == "client_no_context_takeover" && !hasValue:
 			copts.clientNoContextTakeover = true
-			continue
-		case "server_no_context_takeover":
+		case name == "server_no_context_takeover" && !hasValue:
 			copts.serverNoContextTakeover = true
-			continue
-		case "client_max_window_bits",
-			"server_max_window_bits=15":
-			continue
-		}
-
-		if strings.HasPrefix(p, "client_max_window_bits=") {
+		case name == "client_max_window_bits":
 			// We can't adjust the deflate window, but decoding with a larger window is acceptable.
-			continue
+		case name == "server_max_window_bits" && value == "15":
+		default:
+			return nil, false
 		}
-		return nil, false
 	}
 	return copts, true
 }
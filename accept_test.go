@@ -4,16 +4,21 @@ package websocket
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"errors"
+	"io"
 	"net"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/coder/websocket/internal/test/assert"
 	"github.com/coder/websocket/internal/test/xrand"
+	"github.com/coder/websocket/internal/xsync"
 )
 
 func TestAccept(t *testing.T) {
@@ -27,6 +32,46 @@ func TestAccept(t *testing.T) {
 
 		_, err := Accept(w, r, nil)
 		assert.Contains(t, err, "protocol violation")
+		assert.ErrorIs(t, ErrBadHandshake, err)
+		assert.ErrorIs(t, ErrProtocolViolation, err)
+	})
+
+	t.Run("headerError", func(t *testing.T) {
+		t.Parallel()
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Connection", "Upgrade")
+		r.Header.Set("Upgrade", "websocket")
+		r.Header.Set("Sec-WebSocket-Version", "13")
+		r.Header.Add("Sec-WebSocket-Key", xrand.Base64(16))
+		r.Header.Add("Sec-WebSocket-Key", xrand.Base64(16))
+
+		_, err := Accept(w, r, nil)
+		var herr *HeaderError
+		assert.Equal(t, "errors.As", true, errors.As(err, &herr))
+		assert.Equal(t, "header", "Sec-WebSocket-Key", herr.Header)
+		assert.ErrorIs(t, ErrProtocolViolation, err)
+		assert.Equal(t, "status code", http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), "Sec-WebSocket-Key is repeated")
+	})
+
+	t.Run("badVersion", func(t *testing.T) {
+		t.Parallel()
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Connection", "Upgrade")
+		r.Header.Set("Upgrade", "websocket")
+		r.Header.Set("Sec-WebSocket-Version", "8")
+		r.Header.Set("Sec-WebSocket-Key", xrand.Base64(16))
+
+		_, err := Accept(w, r, nil)
+		var verr *VersionError
+		assert.Equal(t, "errors.As", true, errors.As(err, &verr))
+		assert.Equal(t, "requested version", "8", verr.Requested)
+		assert.Equal(t, "status code", http.StatusUpgradeRequired, w.Code)
+		assert.Equal(t, "Sec-WebSocket-Version", "13", w.Header().Get("Sec-WebSocket-Version"))
 	})
 
 	t.Run("badOrigin", func(t *testing.T) {
@@ -60,6 +105,145 @@ func TestAccept(t *testing.T) {
 		assert.Contains(t, err, `request Origin "harhar.com" is not authorized for Host "example.com"`)
 	})
 
+	t.Run("verifyOriginRejection", func(t *testing.T) {
+		t.Parallel()
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Connection", "Upgrade")
+		r.Header.Set("Upgrade", "websocket")
+		r.Header.Set("Sec-WebSocket-Version", "13")
+		r.Header.Set("Sec-WebSocket-Key", xrand.Base64(16))
+		r.Header.Set("Origin", "https://tenant.example.org")
+
+		_, err := Accept(w, r, &AcceptOptions{
+			VerifyOrigin: func(r *http.Request, origin string) error {
+				return RejectionError{
+					StatusCode: http.StatusUnauthorized,
+					Header:     http.Header{"Www-Authenticate": {"Bearer"}},
+					Body:       "token expired",
+				}
+			},
+		})
+		var re RejectionError
+		assert.Equal(t, "rejection error", true, errors.As(err, &re))
+		assert.Equal(t, "status code", http.StatusUnauthorized, w.Code)
+		assert.Equal(t, "header", "Bearer", w.Header().Get("WWW-Authenticate"))
+		assert.Equal(t, "body", "token expired\n", w.Body.String())
+		assert.Equal(t, "error", "handshake rejected with status 401", re.Error())
+		assert.Equal(t, "default status error", "handshake rejected", RejectionError{}.Error())
+	})
+
+	t.Run("wireDumpBufferedWriter", func(t *testing.T) {
+		t.Parallel()
+
+		c1, c2 := net.Pipe()
+		defer c1.Close()
+		defer c2.Close()
+		readDone := xsync.Go(func() error {
+			_, err := io.Copy(io.Discard, c2)
+			return err
+		})
+
+		w := mockHijacker{
+			ResponseWriter: httptest.NewRecorder(),
+			hijack: func() (net.Conn, *bufio.ReadWriter, error) {
+				brw := bufio.NewReadWriter(bufio.NewReader(c1), bufio.NewWriter(c1))
+				// Left buffered by the server before the hijack.
+				brw.WriteString("buffered")
+				return c1, brw, nil
+			},
+		}
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Connection", "Upgrade")
+		r.Header.Set("Upgrade", "websocket")
+		r.Header.Set("Sec-WebSocket-Version", "13")
+		r.Header.Set("Sec-WebSocket-Key", xrand.Base64(16))
+
+		var dump bytes.Buffer
+		c, err := Accept(w, r, &AcceptOptions{
+			WireDump: &dump,
+		})
+		assert.Success(t, err)
+		err = c.Write(context.Background(), MessageText, []byte("hello"))
+		assert.Success(t, err)
+		c.CloseNow()
+		<-readDone
+
+		assert.Contains(t, dump.String(), "hello")
+	})
+
+	t.Run("authorize", func(t *testing.T) {
+		t.Parallel()
+
+		errUnauthorized := RejectionError{
+			StatusCode: http.StatusUnauthorized,
+			Header:     http.Header{"Www-Authenticate": {"Bearer"}},
+		}
+		opts := &AcceptOptions{
+			// Authorize runs even when the origin is not verified.
+			InsecureSkipVerify: true,
+			Authorize: func(r *http.Request) error {
+				if r.Header.Get("Authorization") == "" {
+					return errUnauthorized
+				}
+				return errors.New("token expired")
+			},
+		}
+
+		// Without an Origin header as sent by non-browser clients.
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Connection", "Upgrade")
+		r.Header.Set("Upgrade", "websocket")
+		r.Header.Set("Sec-WebSocket-Version", "13")
+		r.Header.Set("Sec-WebSocket-Key", xrand.Base64(16))
+
+		_, err := Accept(w, r, opts)
+		var re RejectionError
+		assert.Equal(t, "rejection error", true, errors.As(err, &re))
+		assert.Equal(t, "status code", http.StatusUnauthorized, w.Code)
+		assert.Equal(t, "header", "Bearer", w.Header().Get("WWW-Authenticate"))
+		assert.Equal(t, "body", "Unauthorized\n", w.Body.String())
+
+		w = httptest.NewRecorder()
+		r.Header.Set("Authorization", "Bearer expired")
+		_, err = Accept(w, r, opts)
+		assert.Contains(t, err, "request not authorized: token expired")
+		assert.Equal(t, "status code", http.StatusForbidden, w.Code)
+	})
+
+	t.Run("responseHeader", func(t *testing.T) {
+		t.Parallel()
+
+		rr := httptest.NewRecorder()
+		w := mockHijacker{
+			ResponseWriter: rr,
+			hijack: func() (net.Conn, *bufio.ReadWriter, error) {
+				return nil, nil, errors.New("haha")
+			},
+		}
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Connection", "Upgrade")
+		r.Header.Set("Upgrade", "websocket")
+		r.Header.Set("Sec-WebSocket-Version", "13")
+		r.Header.Set("Sec-WebSocket-Key", xrand.Base64(16))
+
+		_, err := Accept(w, r, &AcceptOptions{
+			ResponseHeader: http.Header{
+				"Set-Cookie":             {"session=abc"},
+				"X-Backend":              {"b1"},
+				"Sec-WebSocket-Protocol": {"evil"},
+				"Connection":             {"close"},
+			},
+		})
+		assert.Contains(t, err, "failed to hijack connection")
+		assert.Equal(t, "set-cookie", "session=abc", rr.Header().Get("Set-Cookie"))
+		assert.Equal(t, "x-backend", "b1", rr.Header().Get("X-Backend"))
+		assert.Equal(t, "subprotocol", "", rr.Header().Get("Sec-WebSocket-Protocol"))
+		assert.Equal(t, "connection", []string{"Upgrade"}, rr.Header().Values("Connection"))
+	})
+
 	t.Run("badCompression", func(t *testing.T) {
 		t.Parallel()
 
@@ -169,6 +353,91 @@ func TestAccept(t *testing.T) {
 		assert.Contains(t, err, "failed to hijack connection")
 	})
 
+	t.Run("nestedWrapperHijackerIsUnwrapped", func(t *testing.T) {
+		t.Parallel()
+
+		server, _ := net.Pipe()
+		rr := httptest.NewRecorder()
+		hj := mockHijacker{
+			ResponseWriter: rr,
+			hijack: func() (net.Conn, *bufio.ReadWriter, error) {
+				return server, bufio.NewReadWriter(bufio.NewReader(server), bufio.NewWriter(server)), nil
+			},
+		}
+		w := mockUnwrapper{
+			ResponseWriter: rr,
+			unwrap: func() http.ResponseWriter {
+				return mockUnwrapper{
+					ResponseWriter: rr,
+					unwrap: func() http.ResponseWriter {
+						return hj
+					},
+				}
+			},
+		}
+
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Connection", "Upgrade")
+		r.Header.Set("Upgrade", "websocket")
+		r.Header.Set("Sec-WebSocket-Version", "13")
+		r.Header.Set("Sec-WebSocket-Key", xrand.Base64(16))
+
+		c, err := Accept(w, r, nil)
+		assert.Success(t, err)
+		c.CloseNow()
+	})
+
+	t.Run("limiter", func(t *testing.T) {
+		t.Parallel()
+
+		accept := func(l *AcceptLimiter) (*Conn, *httptest.ResponseRecorder, error) {
+			server, _ := net.Pipe()
+			rr := httptest.NewRecorder()
+			w := mockHijacker{
+				ResponseWriter: rr,
+				hijack: func() (net.Conn, *bufio.ReadWriter, error) {
+					return server, bufio.NewReadWriter(bufio.NewReader(server), bufio.NewWriter(server)), nil
+				},
+			}
+			r := httptest.NewRequest("GET", "/", nil)
+			r.Header.Set("Connection", "Upgrade")
+			r.Header.Set("Upgrade", "websocket")
+			r.Header.Set("Sec-WebSocket-Version", "13")
+			r.Header.Set("Sec-WebSocket-Key", xrand.Base64(16))
+
+			c, err := Accept(w, r, &AcceptOptions{Limiter: l})
+			return c, rr, err
+		}
+
+		l := &AcceptLimiter{MaxConns: 1, RetryAfter: time.Millisecond * 1500}
+		c, _, err := accept(l)
+		assert.Success(t, err)
+		assert.Equal(t, "conns", 1, l.Conns())
+
+		_, rr, err := accept(l)
+		var re RejectionError
+		assert.Equal(t, "rejection error", true, errors.As(err, &re))
+		assert.Equal(t, "status code", http.StatusServiceUnavailable, rr.Code)
+		assert.Equal(t, "retry after", "2", rr.Header().Get("Retry-After"))
+
+		c.CloseNow()
+		assert.Equal(t, "conns", 0, l.Conns())
+		c, _, err = accept(l)
+		assert.Success(t, err)
+		c.CloseNow()
+
+		l = &AcceptLimiter{Rate: 0.001, Burst: 2}
+		for range 2 {
+			c, _, err = accept(l)
+			assert.Success(t, err)
+			c.CloseNow()
+		}
+		_, rr, err = accept(l)
+		assert.Equal(t, "rejection error", true, errors.As(err, &re))
+		assert.Equal(t, "status code", http.StatusServiceUnavailable, rr.Code)
+		assert.Equal(t, "retry after", "1", rr.Header().Get("Retry-After"))
+	})
+
 	t.Run("closeRace", func(t *testing.T) {
 		t.Parallel()
 
@@ -211,11 +480,15 @@ func Test_verifyClientHandshake(t *testing.T) {
 	t.Parallel()
 
 	testCases := []struct {
-		name    string
-		method  string
-		http1   bool
-		h       map[string]string
-		success bool
+		name             string
+		method           string
+		http1            bool
+		allowHTTP10      bool
+		allowBody        bool
+		body             string
+		transferEncoding []string
+		h                map[string]string
+		success          bool
 	}{
 		{
 			name: "badConnection",
@@ -238,6 +511,38 @@ func Test_verifyClientHandshake(t *testing.T) {
 				"Upgrade":    "websocket",
 			},
 		},
+		{
+			name: "badContentLength",
+			body: "GET /smuggled HTTP/1.1\r\n\r\n",
+			h: map[string]string{
+				"Connection":            "Upgrade",
+				"Upgrade":               "websocket",
+				"Sec-WebSocket-Version": "13",
+				"Sec-WebSocket-Key":     xrand.Base64(16),
+			},
+		},
+		{
+			name:             "badTransferEncoding",
+			transferEncoding: []string{"chunked"},
+			h: map[string]string{
+				"Connection":            "Upgrade",
+				"Upgrade":               "websocket",
+				"Sec-WebSocket-Version": "13",
+				"Sec-WebSocket-Key":     xrand.Base64(16),
+			},
+		},
+		{
+			name:      "allowedBody",
+			body:      "x",
+			allowBody: true,
+			h: map[string]string{
+				"Connection":            "Upgrade",
+				"Upgrade":               "websocket",
+				"Sec-WebSocket-Version": "13",
+				"Sec-WebSocket-Key":     xrand.Base64(16),
+			},
+			success: true,
+		},
 		{
 			name: "badWebSocketVersion",
 			h: map[string]string{
@@ -293,6 +598,49 @@ func Test_verifyClientHandshake(t *testing.T) {
 				"sec-webSocket-key": xrand.Base64(16),
 			},
 		},
+		{
+			name: "badSubprotocol",
+			h: map[string]string{
+				"Connection":             "Upgrade",
+				"Upgrade":                "websocket",
+				"Sec-WebSocket-Version":  "13",
+				"Sec-WebSocket-Key":      xrand.Base64(16),
+				"Sec-WebSocket-Protocol": "chat, a/b",
+			},
+		},
+		{
+			name: "emptySubprotocol",
+			h: map[string]string{
+				"Connection":             "Upgrade",
+				"Upgrade":                "websocket",
+				"Sec-WebSocket-Version":  "13",
+				"Sec-WebSocket-Key":      xrand.Base64(16),
+				"Sec-WebSocket-Protocol": "chat,,echo",
+			},
+			success: true,
+		},
+		{
+			name: "trailingCommaSubprotocol",
+			h: map[string]string{
+				"Connection":             "Upgrade",
+				"Upgrade":                "websocket",
+				"Sec-WebSocket-Version":  "13",
+				"Sec-WebSocket-Key":      xrand.Base64(16),
+				"Sec-WebSocket-Protocol": "chat, ",
+			},
+			success: true,
+		},
+		{
+			name: "successSubprotocols",
+			h: map[string]string{
+				"Connection":             "Upgrade",
+				"Upgrade":                "websocket",
+				"Sec-WebSocket-Version":  "13",
+				"Sec-WebSocket-Key":      xrand.Base64(16),
+				"Sec-WebSocket-Protocol": "chat, access_token.abc-DEF_123",
+			},
+			success: true,
+		},
 		{
 			name: "badHTTPVersion",
 			h: map[string]string{
@@ -303,6 +651,18 @@ func Test_verifyClientHandshake(t *testing.T) {
 			},
 			http1: true,
 		},
+		{
+			name: "allowHTTP10",
+			h: map[string]string{
+				"Connection":            "Upgrade",
+				"Upgrade":               "websocket",
+				"Sec-WebSocket-Version": "13",
+				"Sec-WebSocket-Key":     xrand.Base64(16),
+			},
+			http1:       true,
+			allowHTTP10: true,
+			success:     true,
+		},
 		{
 			name: "success",
 			h: map[string]string{
@@ -342,7 +702,13 @@ func Test_verifyClientHandshake(t *testing.T) {
 				r.Header.Add(k, v)
 			}
 
-			_, err := verifyClientRequest(httptest.NewRecorder(), r)
+			if tc.body != "" {
+				r.Body = io.NopCloser(strings.NewReader(tc.body))
+				r.ContentLength = int64(len(tc.body))
+			}
+			r.TransferEncoding = tc.transferEncoding
+
+			_, err := verifyClientRequest(httptest.NewRecorder(), r, tc.allowHTTP10, tc.allowBody)
 			if tc.success {
 				assert.Success(t, err)
 			} else {
@@ -359,6 +725,8 @@ func Test_selectSubprotocol(t *testing.T) {
 		name            string
 		clientProtocols []string
 		serverProtocols []string
+		preferClient    bool
+		multipleHeaders bool
 		negotiated      string
 	}{
 		{
@@ -391,6 +759,35 @@ func Test_selectSubprotocol(t *testing.T) {
 			serverProtocols: []string{"echo1"},
 			negotiated:      "Echo1",
 		},
+		{
+			name:            "preferClient",
+			clientProtocols: []string{"echo", "echo2"},
+			serverProtocols: []string{"echo2", "echo"},
+			preferClient:    true,
+			negotiated:      "echo",
+		},
+		{
+			name:            "preferClientNone",
+			clientProtocols: []string{"echo", "echo3"},
+			serverProtocols: []string{"echo2", "echo4"},
+			preferClient:    true,
+			negotiated:      "",
+		},
+		{
+			name:            "multipleHeaders",
+			clientProtocols: []string{"echo3", "echo", "echo2"},
+			serverProtocols: []string{"echo2", "echo"},
+			multipleHeaders: true,
+			negotiated:      "echo2",
+		},
+		{
+			name:            "multipleHeadersPreferClient",
+			clientProtocols: []string{"echo3", "echo", "echo2"},
+			serverProtocols: []string{"echo2", "echo"},
+			preferClient:    true,
+			multipleHeaders: true,
+			negotiated:      "echo",
+		},
 	}
 
 	for _, tc := range testCases {
@@ -399,9 +796,15 @@ func Test_selectSubprotocol(t *testing.T) {
 			t.Parallel()
 
 			r := httptest.NewRequest("GET", "/", nil)
-			r.Header.Set("Sec-WebSocket-Protocol", strings.Join(tc.clientProtocols, ","))
+			if tc.multipleHeaders {
+				for _, p := range tc.clientProtocols {
+					r.Header.Add("Sec-WebSocket-Protocol", p)
+				}
+			} else {
+				r.Header.Set("Sec-WebSocket-Protocol", strings.Join(tc.clientProtocols, ","))
+			}
 
-			negotiated := selectSubprotocol(r, tc.serverProtocols)
+			negotiated := selectSubprotocol(r, tc.serverProtocols, tc.preferClient)
 			assert.Equal(t, "negotiated", tc.negotiated, negotiated)
 		})
 	}
@@ -415,6 +818,7 @@ func Test_authenticateOrigin(t *testing.T) {
 		origin         string
 		host           string
 		originPatterns []string
+		verifyOrigin   func(*http.Request, string) error
 		success        bool
 	}{
 		{
@@ -502,6 +906,36 @@ func Test_authenticateOrigin(t *testing.T) {
 			},
 			success: true,
 		},
+		{
+			name:   "verifyOriginAuthorized",
+			origin: "https://tenant.example.org",
+			host:   "example.com",
+			verifyOrigin: func(r *http.Request, origin string) error {
+				if origin != "https://tenant.example.org" {
+					return errors.New("unknown tenant")
+				}
+				return nil
+			},
+			success: true,
+		},
+		{
+			name:   "verifyOriginUnauthorized",
+			origin: "https://other.example.org",
+			host:   "example.com",
+			verifyOrigin: func(r *http.Request, origin string) error {
+				return errors.New("unknown tenant")
+			},
+			success: false,
+		},
+		{
+			name:   "verifyOriginInvalid",
+			origin: "harhar.com",
+			host:   "example.com",
+			verifyOrigin: func(r *http.Request, origin string) error {
+				return nil
+			},
+			success: false,
+		},
 	}
 
 	for _, tc := range testCases {
@@ -512,7 +946,7 @@ func Test_authenticateOrigin(t *testing.T) {
 			r := httptest.NewRequest("GET", "http://"+tc.host+"/", nil)
 			r.Header.Set("Origin", tc.origin)
 
-			err := authenticateOrigin(r, tc.originPatterns)
+			err := authenticateOrigin(r, tc.originPatterns, tc.verifyOrigin)
 			if tc.success {
 				assert.Success(t, err)
 			} else {
@@ -570,6 +1004,29 @@ func Test_selectDeflate(t *testing.T) {
 			},
 			expOK: true,
 		},
+		{
+			name:     "permessage-deflate/preferContextTakeover",
+			mode:     CompressionContextTakeover,
+			header:   "permessage-deflate; client_no_context_takeover, permessage-deflate; client_max_window_bits, permessage-deflate",
+			expCopts: &compressionOptions{},
+			expOK:    true,
+		},
+		{
+			name:     "permessage-deflate/quotedValue",
+			mode:     CompressionContextTakeover,
+			header:   `permessage-deflate; Server_Max_Window_Bits = "15"; client_max_window_bits="10"`,
+			expCopts: &compressionOptions{},
+			expOK:    true,
+		},
+		{
+			name:   "permessage-deflate/invalidValue",
+			mode:   CompressionContextTakeover,
+			header: "permessage-deflate; server_max_window_bits=10, permessage-deflate; server_no_context_takeover=1, permessage-deflate; server_no_context_takeover",
+			expCopts: &compressionOptions{
+				serverNoContextTakeover: true,
+			},
+			expOK: true,
+		},
 	}
 
 	for _, tc := range testCases {
@@ -607,3 +1064,178 @@ var _ rwUnwrapper = mockUnwrapper{}
 func (mu mockUnwrapper) Unwrap() http.ResponseWriter {
 	return mu.unwrap()
 }
+
+func TestMatchSubprotocolVersions(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name            string
+		clientProtocols []string
+		versions        []int
+		negotiated      string
+	}{
+		{
+			name:            "highest",
+			clientProtocols: []string{"chat.v1", "chat.v3", "chat.v2"},
+			versions:        []int{1, 2},
+			negotiated:      "chat.v2",
+		},
+		{
+			name:            "none",
+			clientProtocols: []string{"chat.v1", "other.v2"},
+			versions:        []int{2},
+			negotiated:      "",
+		},
+		{
+			name:            "clientCasePreserved",
+			clientProtocols: []string{"Chat.v1"},
+			versions:        []int{1},
+			negotiated:      "Chat.v1",
+		},
+		{
+			name:            "unversioned",
+			clientProtocols: []string{"chat", "chat.vx", "chat.v"},
+			versions:        []int{0, 1},
+			negotiated:      "",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			r := httptest.NewRequest("GET", "/", nil)
+			r.Header.Set("Sec-WebSocket-Protocol", strings.Join(tc.clientProtocols, ","))
+
+			negotiated := MatchSubprotocolVersions(r, "chat", tc.versions...)
+			assert.Equal(t, "negotiated", tc.negotiated, negotiated)
+		})
+	}
+}
+
+func TestSubprotocolToken(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name            string
+		clientProtocols []string
+		token           string
+		ok              bool
+	}{
+		{
+			name:            "token",
+			clientProtocols: []string{"chat", "access_token.abc"},
+			token:           "abc",
+			ok:              true,
+		},
+		{
+			name:            "none",
+			clientProtocols: []string{"chat"},
+		},
+		{
+			name:            "empty",
+			clientProtocols: []string{"chat", "access_token."},
+		},
+		{
+			name:            "multiple",
+			clientProtocols: []string{"access_token.abc", "chat", "access_token.def"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			r := httptest.NewRequest("GET", "/", nil)
+			r.Header.Set("Sec-WebSocket-Protocol", strings.Join(tc.clientProtocols, ","))
+
+			token, ok := SubprotocolToken(r, "access_token.")
+			assert.Equal(t, "token", tc.token, token)
+			assert.Equal(t, "ok", tc.ok, ok)
+
+			for _, preferClient := range []bool{false, true} {
+				negotiated := selectSubprotocol(r, []string{"chat"}, preferClient)
+				assert.Equal(t, "negotiated", "chat", negotiated)
+			}
+		})
+	}
+}
+
+func TestParseSubprotocolVersion(t *testing.T) {
+	t.Parallel()
+
+	name, version, ok := ParseSubprotocolVersion("graphql.ws.v12")
+	assert.Equal(t, "ok", true, ok)
+	assert.Equal(t, "name", "graphql.ws", name)
+	assert.Equal(t, "version", 12, version)
+
+	for _, p := range []string{"", "chat", ".v1", "chat.v", "chat.v-1", "chat.v1a"} {
+		_, _, ok := ParseSubprotocolVersion(p)
+		assert.Equal(t, p, false, ok)
+	}
+}
+
+func FuzzExtensionNegotiation(f *testing.F) {
+	f.Add("permessage-deflate")
+	f.Add("permessage-deflate; client_max_window_bits")
+	f.Add("permessage-deflate; client_no_context_takeover; server_no_context_takeover")
+	f.Add("permessage-deflate; meow, permessage-deflate; client_max_window_bits=10")
+	f.Add("x-webkit-deflate-frame, ; ,permessage-deflate;server_max_window_bits=15")
+
+	f.Fuzz(func(t *testing.T, ext string) {
+		h := http.Header{}
+		h.Set("Sec-WebSocket-Extensions", ext)
+		exts := websocketExtensions(h)
+
+		for _, mode := range []CompressionMode{CompressionDisabled, CompressionNoContextTakeover, CompressionContextTakeover} {
+			copts, ok := selectDeflate(exts, mode)
+			if !ok {
+				continue
+			}
+			// A client offering the same mode must accept our response.
+			resp := http.Header{}
+			resp.Set("Sec-WebSocket-Extensions", copts.String())
+			copts2, err := verifyServerExtensions(mode.opts(), websocketExtensions(resp))
+			assert.Success(t, err)
+			assert.Equal(t, "compression options", copts, copts2)
+		}
+	})
+}
+
+func FuzzClientHandshake(f *testing.F) {
+	f.Add("GET / HTTP/1.1\r\nHost: example.com\r\nConnection: Upgrade\r\nUpgrade: websocket\r\nSec-WebSocket-Version: 13\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n\r\n")
+	f.Add("GET /chat HTTP/1.1\r\nHost: example.com\r\nConnection: keep-alive, Upgrade\r\nUpgrade: WebSocket\r\nSec-WebSocket-Version: 13\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Protocol: echo, chat\r\nSec-WebSocket-Extensions: permessage-deflate; client_max_window_bits\r\nOrigin: http://example.com\r\n\r\n")
+	f.Add("POST / HTTP/1.0\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n")
+	f.Add("GET / HTTP/1.1\r\nHost: a\r\nConnection: Upgrade\r\nUpgrade: websocket\r\nSec-WebSocket-Version: 13\r\nSec-WebSocket-Key: a\r\nSec-WebSocket-Key: b\r\n\r\n")
+
+	f.Fuzz(func(t *testing.T, req string) {
+		r, err := http.ReadRequest(bufio.NewReader(strings.NewReader(req)))
+		if err != nil {
+			return
+		}
+
+		c1, c2 := net.Pipe()
+		defer c2.Close()
+		discardErrs := xsync.Go(func() error {
+			_, err := io.Copy(io.Discard, c2)
+			return err
+		})
+		defer func() { <-discardErrs }()
+
+		w := mockHijacker{
+			ResponseWriter: httptest.NewRecorder(),
+			hijack: func() (net.Conn, *bufio.ReadWriter, error) {
+				return c1, bufio.NewReadWriter(bufio.NewReader(c1), bufio.NewWriter(c1)), nil
+			},
+		}
+		c, err := Accept(w, r, &AcceptOptions{
+			Subprotocols:    []string{"echo"},
+			CompressionMode: CompressionContextTakeover,
+		})
+		if err != nil {
+			c1.Close()
+			return
+		}
+		c.CloseNow()
+	})
+}
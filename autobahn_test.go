@@ -24,10 +24,6 @@ import (
 )
 
 var excludedAutobahnCases = []string{
-	// We skip the UTF-8 handling tests as there isn't any reason to reject invalid UTF-8, just
-	// more performance overhead.
-	"6.*", "7.5.1",
-
 	// We skip the tests related to requestMaxWindowBits as that is unimplemented due
 	// to limitations in compress/flate. See https://github.com/golang/go/issues/3155
 	"13.3.*", "13.4.*", "13.5.*", "13.6.*",
@@ -83,6 +79,7 @@ func TestAutobahn(t *testing.T) {
 
 				c, _, err := websocket.Dial(ctx, fmt.Sprintf(wstestURL+"/runCase?case=%v&agent=main", i), &websocket.DialOptions{
 					CompressionMode: websocket.CompressionContextTakeover,
+					StrictUTF8:      true,
 				})
 				assert.Success(t, err)
 				err = wstest.EchoLoop(ctx, c)
@@ -6,6 +6,7 @@ X_TOOLS_VERSION=v0.31.0
 
 go mod tidy
 (cd ./internal/thirdparty && go mod tidy)
+(cd ./wsmetrics/wsprom && go mod tidy)
 (cd ./internal/examples && go mod tidy)
 gofmt -w -s .
 go run golang.org/x/tools/cmd/goimports@${X_TOOLS_VERSION} -w "-local=$(go list -m)" .
@@ -0,0 +1,8 @@
+#!/bin/sh
+set -eu
+cd -- "$(dirname "$0")/.."
+
+# Runs each fuzz target for FUZZTIME, 30s by default.
+for target in FuzzReadFrameHeader FuzzParseClosePayload FuzzExtensionNegotiation FuzzClientHandshake; do
+  go test --run=^$ --fuzz="^$target\$" --fuzztime="${FUZZTIME-30s}" "$@" .
+done
@@ -7,6 +7,10 @@ GOVULNCHECK_VERSION=v1.1.4
 
 go vet ./...
 GOOS=js GOARCH=wasm go vet ./...
+GOOS=wasip1 GOARCH=wasm go vet ./...
+if command -v tinygo >/dev/null; then
+	tinygo build -target=wasip1 -o /dev/null .
+fi
 
 go install honnef.co/go/tools/cmd/staticcheck@${STATICCHECK_VERSION}
 staticcheck ./...
@@ -34,3 +38,9 @@ GOOS=js GOARCH=wasm govulncheck ./...
   staticcheck ./...
   govulncheck ./...
 )
+(
+  cd ./wsmetrics/wsprom
+  go vet ./...
+  staticcheck ./...
+  govulncheck ./...
+)
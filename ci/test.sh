@@ -10,6 +10,10 @@ cd -- "$(dirname "$0")/.."
   cd ./internal/thirdparty
   go test "$@" ./...
 )
+(
+  cd ./wsmetrics/wsprom
+  go test "$@" ./...
+)
 
 (
   GOARCH=arm64 go test -c -o ./ci/out/websocket-arm64.test "$@" .
@@ -0,0 +1,67 @@
+//go:build !js
+
+package websocket
+
+import (
+	"context"
+	"time"
+)
+
+// Clock is the source of time of the timeouts of a connection: control frame
+// writes, the close handshake and IdleTimeout. See DialOptions.Clock.
+//
+// Tests may use a fake clock, e.g. websockettest.FakeClock, to expire the
+// timeouts without sleeping.
+type Clock interface {
+	// AfterFunc calls f in its own goroutine once d elapsed, like
+	// time.AfterFunc.
+	AfterFunc(d time.Duration, f func()) ClockTimer
+}
+
+// ClockTimer is a timer returned by Clock.AfterFunc. *time.Timer implements
+// it.
+type ClockTimer interface {
+	// Stop prevents the timer from firing and reports whether it was active.
+	Stop() bool
+
+	// Reset changes the timer to fire after d and reports whether it was
+	// active.
+	Reset(d time.Duration) bool
+}
+
+type realClock struct{}
+
+func (realClock) AfterFunc(d time.Duration, f func()) ClockTimer {
+	return time.AfterFunc(d, f)
+}
+
+// withTimeout is like context.WithTimeout but measures d with the clock of the
+// connection.
+func (c *Conn) withTimeout(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	if _, ok := c.clock.(realClock); ok {
+		return context.WithTimeout(ctx, d)
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	t := c.clock.AfterFunc(d, func() {
+		cancel(context.DeadlineExceeded)
+	})
+	return clockContext{ctx}, func() {
+		t.Stop()
+		cancel(context.Canceled)
+	}
+}
+
+// clockContext reports context.DeadlineExceeded from Err once the timer of
+// withTimeout fired.
+type clockContext struct {
+	context.Context
+}
+
+func (ctx clockContext) Err() error {
+	err := ctx.Context.Err()
+	if err != nil && context.Cause(ctx.Context) == context.DeadlineExceeded {
+		return context.DeadlineExceeded
+	}
+	return err
+}
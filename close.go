@@ -7,6 +7,7 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"log/slog"
 	"net"
 	"time"
 
@@ -83,10 +84,25 @@ func CloseStatus(err error) StatusCode {
 	return -1
 }
 
+// CloseReceived returns the status code and reason of the close frame received
+// from the peer, if any. This applies whether the peer or this side initiated
+// the close handshake.
+func (c *Conn) CloseReceived() (*CloseError, bool) {
+	c.closeStateMu.RLock()
+	err := c.closeReceivedErr
+	c.closeStateMu.RUnlock()
+
+	var ce CloseError
+	if errors.As(err, &ce) {
+		return &ce, true
+	}
+	return nil, false
+}
+
 // Close performs the WebSocket close handshake with the given status code and reason.
 //
-// It will write a WebSocket close frame with a timeout of 5s and then wait 5s for
-// the peer to send a close frame.
+// It will write a WebSocket close frame with a timeout of ControlWriteTimeout,
+// 5s by default, and then wait 5s for the peer to send a close frame.
 // All data messages received from the peer during the close handshake will be discarded.
 //
 // The connection can only be closed once. Additional calls to Close
@@ -96,7 +112,17 @@ func CloseStatus(err error) StatusCode {
 //
 // Close will unblock all goroutines interacting with the connection once
 // complete.
-func (c *Conn) Close(code StatusCode, reason string) (err error) {
+func (c *Conn) Close(code StatusCode, reason string) error {
+	return c.CloseWithContext(context.Background(), code, reason)
+}
+
+// CloseWithContext is like Close but ctx additionally bounds the time spent
+// writing the close frame and waiting for the peer's close frame.
+// The 5s timeouts of Close still apply.
+//
+// If ctx expires before the handshake completes, the connection is closed
+// without waiting further and the context error is returned.
+func (c *Conn) CloseWithContext(ctx context.Context, code StatusCode, reason string) (err error) {
 	defer errd.Wrap(&err, "failed to close WebSocket")
 
 	if c.casClosing() {
@@ -112,7 +138,11 @@ func (c *Conn) Close(code StatusCode, reason string) (err error) {
 		}
 	}()
 
-	err = c.closeHandshake(code, reason)
+	c.setCloseCause(fmt.Errorf("sent close frame: %w", CloseError{Code: code, Reason: reason}))
+	err = c.closeHandshake(ctx, code, reason)
+	if err != nil && ctx.Err() != nil {
+		err = ctx.Err()
+	}
 
 	err2 := c.close()
 	if err == nil && err2 != nil {
@@ -127,6 +157,48 @@ func (c *Conn) Close(code StatusCode, reason string) (err error) {
 	return err
 }
 
+// CloseWrite sends a close frame with the given status code and reason but
+// keeps the connection open for reading, like shutdown(SHUT_WR) on a TCP socket.
+//
+// Further data writes fail with net.ErrClosed. Reads continue to return the
+// messages the peer sent before its close frame. Once the peer's close frame
+// is read, the read returns a CloseError and the connection is closed.
+//
+// You must keep reading to complete the close handshake and still call Close or
+// CloseNow to release resources if the peer never responds.
+func (c *Conn) CloseWrite(code StatusCode, reason string) error {
+	return c.WriteCloseFrame(context.Background(), code, reason)
+}
+
+// WriteCloseFrame is like CloseWrite but the write of the close frame is
+// bounded by ctx. It only sends the close frame so the rest of the close
+// handshake, e.g. draining messages until the peer acknowledges them and
+// sends its own close frame, is up to the caller.
+//
+// If ctx expires before the close frame is written, the connection is closed.
+func (c *Conn) WriteCloseFrame(ctx context.Context, code StatusCode, reason string) (err error) {
+	defer errd.Wrap(&err, "failed to close WebSocket for writing")
+
+	if c.closing.Load() {
+		return net.ErrClosed
+	}
+
+	c.closeStateMu.Lock()
+	closeSent := c.closeSentErr != nil
+	c.closeStateMu.Unlock()
+	if closeSent {
+		return net.ErrClosed
+	}
+
+	err = c.writeClose(ctx, code, reason)
+	c.runCallbacks()
+	if err == nil && c.isClosed() && ctx.Err() != nil {
+		// writeClose ignores the connection being closed by the expired ctx.
+		return ctx.Err()
+	}
+	return err
+}
+
 // CloseNow closes the WebSocket connection without attempting a close handshake.
 // Use when you do not want the overhead of the close handshake.
 func (c *Conn) CloseNow() (err error) {
@@ -145,6 +217,7 @@ func (c *Conn) CloseNow() (err error) {
 		}
 	}()
 
+	c.setCloseCause(errors.New("closed with CloseNow"))
 	err = c.close()
 
 	err2 := c.waitGoroutines()
@@ -154,20 +227,23 @@ func (c *Conn) CloseNow() (err error) {
 	return err
 }
 
-func (c *Conn) closeHandshake(code StatusCode, reason string) error {
-	err := c.writeClose(code, reason)
+func (c *Conn) closeHandshake(ctx context.Context, code StatusCode, reason string) error {
+	err := c.writeClose(ctx, code, reason)
+	c.runCallbacks()
 	if err != nil {
 		return err
 	}
 
-	err = c.waitCloseHandshake()
+	err = c.waitCloseHandshake(ctx)
 	if CloseStatus(err) != code {
 		return err
 	}
 	return nil
 }
 
-func (c *Conn) writeClose(code StatusCode, reason string) error {
+// writeClose writes a close frame. Call runCallbacks afterwards for
+// onCloseSent.
+func (c *Conn) writeClose(ctx context.Context, code StatusCode, reason string) error {
 	ce := CloseError{
 		Code:   code,
 		Reason: reason,
@@ -182,23 +258,29 @@ func (c *Conn) writeClose(code StatusCode, reason string) error {
 		}
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	writeCtx, cancel := c.withTimeout(ctx, c.controlTimeout())
 	defer cancel()
 
-	err = c.writeControl(ctx, opClose, p)
+	err = c.writeControl(writeCtx, opClose, p)
 	// If the connection closed as we're writing we ignore the error as we might
 	// have written the close frame, the peer responded and then someone else read it
 	// and closed the connection.
 	if err != nil && !errors.Is(err, net.ErrClosed) {
 		return err
 	}
+	c.log(slog.LevelDebug, "sent close frame", "code", ce.Code, "reason", ce.Reason)
+	if err == nil && c.onCloseSent != nil {
+		c.queueCallback(func() { c.onCloseSent(ctx, ce.Code, ce.Reason) })
+	}
 	return nil
 }
 
-func (c *Conn) waitCloseHandshake() error {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+func (c *Conn) waitCloseHandshake(ctx context.Context) error {
+	ctx, cancel := c.withTimeout(ctx, time.Second*5)
 	defer cancel()
 
+	// The peer's close frame is read with readMu held.
+	defer c.runCallbacks()
 	err := c.readMu.lock(ctx)
 	if err != nil {
 		return err
@@ -227,6 +309,38 @@ func (c *Conn) waitCloseHandshake() error {
 	}
 }
 
+// waitCloseError waits for the peer's close frame after writeError and closes
+// the connection. It must be called with readMu held.
+func (c *Conn) waitCloseError() {
+	c.skipToClose()
+	if !c.casClosing() {
+		c.readMu.unlock()
+		_ = c.close()
+	}
+}
+
+// skipToClose reads until the peer's close frame for up to 5s. Frames are
+// skipped without validation as the stream may still hold the data that
+// failed the connection.
+func (c *Conn) skipToClose() {
+	ctx, cancel := c.withTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	var b [512]byte
+	for {
+		for c.readFrameLeft > 0 {
+			_, err := c.readFramePayload(ctx, b[:min(c.readFrameLeft, int64(len(b)))])
+			if err != nil {
+				return
+			}
+		}
+		h, err := c.readFrameHeader(ctx)
+		if err != nil || h.opcode == opClose {
+			return
+		}
+	}
+}
+
 func (c *Conn) waitGoroutines() error {
 	t := time.NewTimer(time.Second * 15)
 	defer t.Stop()
@@ -248,6 +362,17 @@ func (c *Conn) waitGoroutines() error {
 		return errors.New("failed to wait for connection to be closed")
 	}
 
+	c.writeQueueMu.Lock()
+	writeLoopDone := c.writeLoopDone
+	c.writeQueueMu.Unlock()
+	if writeLoopDone != nil {
+		select {
+		case <-writeLoopDone:
+		case <-t.C:
+			return errors.New("failed to wait for write queue goroutine to exit")
+		}
+	}
+
 	return nil
 }
 
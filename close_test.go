@@ -205,3 +205,22 @@ func TestCloseStatus(t *testing.T) {
 		})
 	}
 }
+
+func FuzzParseClosePayload(f *testing.F) {
+	f.Add([]byte{})
+	f.Add([]byte{0x03, 0xe8})
+	f.Add([]byte{0x03, 0xe8, 'b', 'y', 'e'})
+	f.Add([]byte{0x03, 0xed})
+	f.Add([]byte{0x0f, 0xa0, 0xff})
+
+	f.Fuzz(func(t *testing.T, p []byte) {
+		ce, err := parseClosePayload(p)
+		if err != nil || len(p) == 0 || len(ce.Reason) > maxCloseReason {
+			return
+		}
+		// Any close payload that parses must marshal back to itself.
+		p2, err := ce.bytesErr()
+		assert.Success(t, err)
+		assert.Equal(t, "close payload", p, p2)
+	})
+}
@@ -0,0 +1,83 @@
+//go:build !js
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/internal/echo"
+)
+
+// runClient runs every case of the Autobahn fuzzingserver at cfg.url
+// and then asks it to generate reports for cfg.agent.
+func runClient(ctx context.Context, cfg *config) error {
+	cases, err := caseCount(ctx, cfg.url)
+	if err != nil {
+		return err
+	}
+	log.Printf("running %d cases against %v", cases, cfg.url)
+
+	for i := 1; i <= cases; i++ {
+		err = runCase(ctx, cfg, i)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			log.Printf("case %d: %v", i, err)
+		}
+	}
+
+	c, _, err := websocket.Dial(ctx, cfg.url+"/updateReports?"+agentQuery(cfg.agent), nil)
+	if err != nil {
+		return fmt.Errorf("failed to update reports: %w", err)
+	}
+	return c.Close(websocket.StatusNormalClosure, "")
+}
+
+func caseCount(ctx context.Context, u string) (int, error) {
+	c, _, err := websocket.Dial(ctx, u+"/getCaseCount", nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get case count: %w", err)
+	}
+	defer c.CloseNow()
+
+	_, b, err := c.Read(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read case count: %w", err)
+	}
+	cases, err := strconv.Atoi(string(b))
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse case count: %w", err)
+	}
+
+	c.Close(websocket.StatusNormalClosure, "")
+	return cases, nil
+}
+
+func runCase(ctx context.Context, cfg *config, i int) error {
+	u := fmt.Sprintf("%v/runCase?case=%d&%v", cfg.url, i, agentQuery(cfg.agent))
+	c, _, err := websocket.Dial(ctx, u, &websocket.DialOptions{
+		CompressionMode: cfg.compression,
+	})
+	if err != nil {
+		return err
+	}
+	defer c.CloseNow()
+
+	c.SetReadLimit(cfg.readLimit)
+	err = echo.Loop(ctx, c)
+	switch websocket.CloseStatus(err) {
+	case websocket.StatusNormalClosure, websocket.StatusGoingAway:
+		return nil
+	}
+	return err
+}
+
+func agentQuery(agent string) string {
+	return url.Values{"agent": {agent}}.Encode()
+}
@@ -0,0 +1,159 @@
+//go:build !js
+
+// Command websocket-echo is a WebSocket echo server and Autobahn fuzzing client.
+//
+// In server mode, every message received is echoed back to the sender. Point the
+// Autobahn fuzzingclient at it to validate a deployment:
+//
+//	websocket-echo -addr localhost:9001
+//
+// In client mode, it runs every case of an Autobahn fuzzingserver and then asks
+// the server to generate its reports:
+//
+//	websocket-echo -mode client -url ws://localhost:9001
+//
+// See https://github.com/crossbario/autobahn-testsuite.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/coder/websocket"
+)
+
+func main() {
+	log.SetFlags(0)
+
+	err := run(os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+type config struct {
+	mode        string
+	addr        string
+	url         string
+	agent       string
+	compression websocket.CompressionMode
+	readLimit   int64
+	tlsCert     string
+	tlsKey      string
+}
+
+func parseFlags(args []string) (*config, error) {
+	fs := flag.NewFlagSet("websocket-echo", flag.ContinueOnError)
+
+	var cfg config
+	var compression string
+	fs.StringVar(&cfg.mode, "mode", "server", "server or client")
+	fs.StringVar(&cfg.addr, "addr", "localhost:9001", "address to listen on in server mode")
+	fs.StringVar(&cfg.url, "url", "ws://localhost:9001", "Autobahn fuzzingserver URL in client mode")
+	fs.StringVar(&cfg.agent, "agent", "websocket-echo", "agent name reported to the Autobahn fuzzingserver")
+	fs.StringVar(&compression, "compression", "disabled", "disabled, context-takeover or no-context-takeover")
+	fs.Int64Var(&cfg.readLimit, "read-limit", 1<<24, "max message size in bytes, -1 to disable")
+	fs.StringVar(&cfg.tlsCert, "tls-cert", "", "TLS certificate file to serve wss in server mode")
+	fs.StringVar(&cfg.tlsKey, "tls-key", "", "TLS key file to serve wss in server mode")
+
+	err := fs.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.compression, err = parseCompressionMode(compression)
+	if err != nil {
+		return nil, err
+	}
+
+	switch cfg.mode {
+	case "server", "client":
+	default:
+		return nil, fmt.Errorf("unknown mode %q", cfg.mode)
+	}
+
+	if (cfg.tlsCert == "") != (cfg.tlsKey == "") {
+		return nil, errors.New("-tls-cert and -tls-key must be set together")
+	}
+
+	return &cfg, nil
+}
+
+func parseCompressionMode(s string) (websocket.CompressionMode, error) {
+	switch s {
+	case "disabled":
+		return websocket.CompressionDisabled, nil
+	case "context-takeover":
+		return websocket.CompressionContextTakeover, nil
+	case "no-context-takeover":
+		return websocket.CompressionNoContextTakeover, nil
+	default:
+		return 0, fmt.Errorf("unknown compression mode %q", s)
+	}
+}
+
+func run(args []string) error {
+	cfg, err := parseFlags(args)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	if cfg.mode == "client" {
+		return runClient(ctx, cfg)
+	}
+	return runServer(ctx, cfg)
+}
+
+// runServer serves echoServer on cfg.addr until ctx is cancelled.
+func runServer(ctx context.Context, cfg *config) error {
+	l, err := net.Listen("tcp", cfg.addr)
+	if err != nil {
+		return err
+	}
+
+	scheme := "ws"
+	if cfg.tlsCert != "" {
+		scheme = "wss"
+	}
+	log.Printf("listening on %v://%v", scheme, l.Addr())
+
+	s := &http.Server{
+		Handler: echoServer{
+			compression: cfg.compression,
+			readLimit:   cfg.readLimit,
+			logf:        log.Printf,
+		},
+		ReadHeaderTimeout: time.Second * 10,
+	}
+	errc := make(chan error, 1)
+	go func() {
+		if cfg.tlsCert != "" {
+			errc <- s.ServeTLS(l, cfg.tlsCert, cfg.tlsKey)
+			return
+		}
+		errc <- s.Serve(l)
+	}()
+
+	select {
+	case err := <-errc:
+		return fmt.Errorf("failed to serve: %w", err)
+	case <-ctx.Done():
+		log.Printf("terminating: %v", context.Cause(ctx))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	return s.Shutdown(ctx)
+}
@@ -0,0 +1,42 @@
+//go:build !js
+
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/internal/echo"
+)
+
+// echoServer echoes every message it receives back to the client.
+// It accepts any origin and subprotocol so that test harnesses can
+// connect without configuration.
+type echoServer struct {
+	compression websocket.CompressionMode
+	readLimit   int64
+
+	// logf controls where logs are sent.
+	logf func(f string, v ...any)
+}
+
+func (s echoServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c, err := websocket.Accept(w, r, &websocket.AcceptOptions{
+		InsecureSkipVerify: true,
+		CompressionMode:    s.compression,
+	})
+	if err != nil {
+		s.logf("%v", err)
+		return
+	}
+	defer c.CloseNow()
+
+	c.SetReadLimit(s.readLimit)
+	err = echo.Loop(context.Background(), c)
+	switch websocket.CloseStatus(err) {
+	case websocket.StatusNormalClosure, websocket.StatusGoingAway:
+		return
+	}
+	s.logf("failed to echo with %v: %v", r.RemoteAddr, err)
+}
@@ -0,0 +1,92 @@
+//go:build !js
+
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/internal/test/assert"
+)
+
+func Test_echoServer(t *testing.T) {
+	t.Parallel()
+
+	s := httptest.NewServer(echoServer{
+		compression: websocket.CompressionContextTakeover,
+		readLimit:   1 << 20,
+		logf:        t.Logf,
+	})
+	defer s.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	c, _, err := websocket.Dial(ctx, s.URL, &websocket.DialOptions{
+		CompressionMode: websocket.CompressionContextTakeover,
+	})
+	assert.Success(t, err)
+	defer c.CloseNow()
+
+	for _, msg := range []string{"hello", "world"} {
+		err = c.Write(ctx, websocket.MessageText, []byte(msg))
+		assert.Success(t, err)
+
+		typ, b, err := c.Read(ctx)
+		assert.Success(t, err)
+		assert.Equal(t, "message type", websocket.MessageText, typ)
+		assert.Equal(t, "message", msg, string(b))
+	}
+
+	err = c.Close(websocket.StatusNormalClosure, "")
+	assert.Success(t, err)
+}
+
+func Test_parseFlags(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name    string
+		args    []string
+		success bool
+	}{
+		{
+			name:    "defaults",
+			success: true,
+		},
+		{
+			name:    "client",
+			args:    []string{"-mode", "client", "-compression", "no-context-takeover"},
+			success: true,
+		},
+		{
+			name: "badMode",
+			args: []string{"-mode", "proxy"},
+		},
+		{
+			name: "badCompression",
+			args: []string{"-compression", "gzip"},
+		},
+		{
+			name: "tlsCertWithoutKey",
+			args: []string{"-tls-cert", "cert.pem"},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := parseFlags(tc.args)
+			if tc.success {
+				assert.Success(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
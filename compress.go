@@ -4,8 +4,10 @@ package websocket
 
 import (
 	"compress/flate"
+	"fmt"
 	"io"
 	"sync"
+	"sync/atomic"
 )
 
 // CompressionMode represents the modes available to the permessage-deflate extension.
@@ -59,6 +61,103 @@ func (m CompressionMode) opts() *compressionOptions {
 type compressionOptions struct {
 	clientNoContextTakeover bool
 	serverNoContextTakeover bool
+
+	// level, dict and backend are not negotiated. See CompressionLevel,
+	// CompressionDictionary and CompressionBackend.
+	level   int
+	dict    []byte
+	backend CompressionBackend
+	// dictKey is dict as a flatePoolKey.dict.
+	dictKey string
+
+	// uncompressedWrites is set when the server limits the client's window
+	// below the 32 KiB window compress/flate writes with. Messages are then
+	// written uncompressed, which is valid with any window.
+	uncompressedWrites bool
+}
+
+// setFlateParams sets the compression level, preset dictionary and backend
+// of the negotiated options.
+func (copts *compressionOptions) setFlateParams(level int, dict []byte, backend CompressionBackend) {
+	copts.level = level
+	copts.dict = dict
+	copts.dictKey = string(dict)
+	copts.backend = backend
+}
+
+// flateBackend returns the compression backend defaulting to compress/flate.
+func (copts *compressionOptions) flateBackend() CompressionBackend {
+	if copts.backend == nil {
+		return stdFlate{}
+	}
+	return copts.backend
+}
+
+// flateLevel returns the compression level defaulting to flate.BestSpeed.
+// flate.NoCompression is the zero value and so also means the default.
+func (copts *compressionOptions) flateLevel() int {
+	if copts.level == 0 {
+		return flate.BestSpeed
+	}
+	return copts.level
+}
+
+func validateCompressionLevel(level int) error {
+	if level < flate.HuffmanOnly || level > flate.BestCompression {
+		return fmt.Errorf("invalid CompressionLevel %d: must be between %d and %d", level, flate.HuffmanOnly, flate.BestCompression)
+	}
+	return nil
+}
+
+// CompressionBackend provides the DEFLATE implementation used by the
+// permessage-deflate extension. It allows using a faster implementation such
+// as github.com/klauspost/compress/flate without this package depending on it.
+//
+// Readers and writers are pooled per backend so implementations must be
+// comparable, e.g. a struct type without fields.
+type CompressionBackend interface {
+	// NewWriter returns a writer compressing to w at the given level
+	// with the preset dictionary dict, which may be nil.
+	NewWriter(w io.Writer, level int, dict []byte) (FlateWriter, error)
+
+	// NewReader returns a reader decompressing r with the preset
+	// dictionary dict, which may be nil.
+	NewReader(r io.Reader, dict []byte) FlateReader
+}
+
+// FlateWriter is a DEFLATE compressor returned by CompressionBackend.
+// *flate.Writer implements it.
+type FlateWriter interface {
+	io.Writer
+
+	// Flush writes any pending data to the underlying writer
+	// and aligns the output to a byte boundary.
+	Flush() error
+
+	// Reset discards the writer's state and makes it write to w
+	// with its original level and dictionary.
+	Reset(w io.Writer)
+}
+
+// FlateReader is a DEFLATE decompressor returned by CompressionBackend.
+// The reader returned by flate.NewReader implements it.
+type FlateReader interface {
+	io.Reader
+
+	// Reset discards any buffered data and makes the reader read from r
+	// with the preset dictionary dict.
+	Reset(r io.Reader, dict []byte) error
+}
+
+// stdFlate is the default CompressionBackend using compress/flate.
+type stdFlate struct{}
+
+func (stdFlate) NewWriter(w io.Writer, level int, dict []byte) (FlateWriter, error) {
+	return flate.NewWriterDict(w, level, dict)
+}
+
+func (stdFlate) NewReader(r io.Reader, dict []byte) FlateReader {
+	return flate.NewReaderDict(r, dict).(FlateReader)
 }
 
 func (copts *compressionOptions) String() string {
@@ -72,6 +171,17 @@ func (copts *compressionOptions) String() string {
 	return s
 }
 
+// offers returns the permessage-deflate offers of copts in order of
+// preference. The first offers client_max_window_bits for servers that
+// require it and the second omits it for servers that reject it.
+//
+// A server may then reply with a smaller client window, see
+// uncompressedWrites.
+func (copts *compressionOptions) offers() []string {
+	s := copts.String()
+	return []string{s + "; client_max_window_bits", s}
+}
+
 // These bytes are required to get flate.Reader to return.
 // They are removed when sending to avoid the overhead as
 // WebSocket framing tell's when the message has ended but then
@@ -132,35 +242,79 @@ func (tw *trimLastFourBytesWriter) Write(p []byte) (int, error) {
 	return n + 4, err
 }
 
-var flateReaderPool sync.Pool
+// flatePoolKey identifies the flate readers and writers that can be reused for
+// each other. Writers keep their level and dictionary when reset.
+type flatePoolKey struct {
+	backend CompressionBackend
+	reader  bool
+	level   int
+	dict    string
+}
 
-func getFlateReader(r io.Reader, dict []byte) io.Reader {
-	fr, ok := flateReaderPool.Get().(io.Reader)
+// Estimated memory of the pooled compression state. See CompressionMode.
+const (
+	flateWriterMemory = 1200 << 10
+	flateReaderMemory = 40 << 10
+)
+
+var (
+	flatePoolsMu sync.RWMutex
+	flatePools   = map[flatePoolKey]*memPool{}
+)
+
+func flatePool(key flatePoolKey) *memPool {
+	flatePoolsMu.RLock()
+	p, ok := flatePools[key]
+	flatePoolsMu.RUnlock()
+	if ok {
+		return p
+	}
+
+	flatePoolsMu.Lock()
+	defer flatePoolsMu.Unlock()
+	p, ok = flatePools[key]
 	if !ok {
-		return flate.NewReaderDict(r, dict)
+		p = &memPool{size: flateWriterMemory}
+		if key.reader {
+			p.size = flateReaderMemory
+		}
+		flatePools[key] = p
 	}
-	fr.(flate.Resetter).Reset(r, dict)
-	return fr
+	return p
 }
 
-func putFlateReader(fr io.Reader) {
-	flateReaderPool.Put(fr)
+func getFlateReader(backend CompressionBackend, r io.Reader, dict []byte) FlateReader {
+	p := flatePool(flatePoolKey{backend: backend, reader: true})
+	fr, ok := p.get().(FlateReader)
+	if !ok {
+		p.alloc()
+		return backend.NewReader(r, dict)
+	}
+	fr.Reset(r, dict)
+	return fr
 }
 
-var flateWriterPool sync.Pool
+func putFlateReader(backend CompressionBackend, fr FlateReader) {
+	flatePool(flatePoolKey{backend: backend, reader: true}).put(fr)
+}
 
-func getFlateWriter(w io.Writer) *flate.Writer {
-	fw, ok := flateWriterPool.Get().(*flate.Writer)
+func getFlateWriter(key flatePoolKey, w io.Writer, dict []byte) (FlateWriter, error) {
+	p := flatePool(key)
+	fw, ok := p.get().(FlateWriter)
 	if !ok {
-		fw, _ = flate.NewWriter(w, flate.BestSpeed)
-		return fw
+		fw, err := key.backend.NewWriter(w, key.level, dict)
+		if err != nil {
+			return nil, err
+		}
+		p.alloc()
+		return fw, nil
 	}
 	fw.Reset(w)
-	return fw
+	return fw, nil
 }
 
-func putFlateWriter(w *flate.Writer) {
-	flateWriterPool.Put(w)
+func putFlateWriter(key flatePoolKey, fw FlateWriter) {
+	flatePool(key).put(fw)
 }
 
 type slidingWindow struct {
@@ -169,10 +323,10 @@ type slidingWindow struct {
 
 var (
 	swPoolMu sync.RWMutex
-	swPool   = map[int]*sync.Pool{}
+	swPool   = map[int]*memPool{}
 )
 
-func slidingWindowPool(n int) *sync.Pool {
+func slidingWindowPool(n int) *memPool {
 	swPoolMu.RLock()
 	p, ok := swPool[n]
 	swPoolMu.RUnlock()
@@ -180,12 +334,13 @@ func slidingWindowPool(n int) *sync.Pool {
 		return p
 	}
 
-	p = &sync.Pool{}
-
 	swPoolMu.Lock()
-	swPool[n] = p
-	swPoolMu.Unlock()
-
+	defer swPoolMu.Unlock()
+	p, ok = swPool[n]
+	if !ok {
+		p = &memPool{size: int64(n)}
+		swPool[n] = p
+	}
 	return p
 }
 
@@ -199,19 +354,18 @@ func (sw *slidingWindow) init(n int) {
 	}
 
 	p := slidingWindowPool(n)
-	sw2, ok := p.Get().(*slidingWindow)
+	sw2, ok := p.get().(*slidingWindow)
 	if ok {
 		*sw = *sw2
 	} else {
+		p.alloc()
 		sw.buf = make([]byte, 0, n)
 	}
 }
 
 func (sw *slidingWindow) close() {
 	sw.buf = sw.buf[:0]
-	swPoolMu.Lock()
-	swPool[cap(sw.buf)].Put(sw)
-	swPoolMu.Unlock()
+	slidingWindowPool(cap(sw.buf)).put(sw)
 }
 
 func (sw *slidingWindow) write(p []byte) {
@@ -232,3 +386,117 @@ func (sw *slidingWindow) write(p []byte) {
 
 	sw.buf = append(sw.buf, p...)
 }
+
+// SetCompressionPoolLimit caps the memory in bytes of the idle compression state,
+// i.e. flate readers, flate writers and sliding windows, that is pooled for reuse
+// across connections. Compression state released into a full pool is left to the
+// garbage collector. Use it to bound the memory retained after bursts of
+// compressed connections.
+//
+// By default the pools are unbounded and shrink through garbage collection.
+// A limit of 0 or less restores the default. The limit applies to compression
+// state released after the call and is enforced on estimated sizes.
+func SetCompressionPoolLimit(n int64) {
+	compressionPoolLimit.Store(n)
+}
+
+// CompressionPoolStats describes the compression state allocated by the package.
+// See ReadCompressionPoolStats.
+type CompressionPoolStats struct {
+	// InUse is the estimated memory in bytes of the compression state held by
+	// connections.
+	InUse int64
+
+	// Idle is the estimated memory in bytes of the compression state retained
+	// under the limit set with SetCompressionPoolLimit. Idle state in the
+	// unbounded pools is not counted as the garbage collector may free it at
+	// any time.
+	Idle int64
+
+	// Allocs is the number of flate readers, flate writers and sliding windows
+	// allocated because none were pooled.
+	Allocs int64
+
+	// Dropped is the number of flate readers, flate writers and sliding windows
+	// released into a full pool.
+	Dropped int64
+}
+
+// ReadCompressionPoolStats returns the current statistics of the compression
+// state pools.
+func ReadCompressionPoolStats() CompressionPoolStats {
+	return CompressionPoolStats{
+		InUse:   compressionPoolStats.inUse.Load(),
+		Idle:    compressionPoolStats.idle.Load(),
+		Allocs:  compressionPoolStats.allocs.Load(),
+		Dropped: compressionPoolStats.dropped.Load(),
+	}
+}
+
+var (
+	compressionPoolLimit atomic.Int64
+	compressionPoolStats struct {
+		inUse   atomic.Int64
+		idle    atomic.Int64
+		allocs  atomic.Int64
+		dropped atomic.Int64
+	}
+)
+
+// memPool pools items of an estimated size while accounting for their memory.
+// Without a limit it is a sync.Pool. With one, items are kept in a free list
+// until the idle memory of all pools reaches the limit.
+type memPool struct {
+	size int64
+
+	pool sync.Pool
+
+	mu   sync.Mutex
+	free []any
+}
+
+// get returns a pooled item or nil. Call alloc when allocating an item instead.
+func (p *memPool) get() any {
+	p.mu.Lock()
+	if n := len(p.free); n > 0 {
+		x := p.free[n-1]
+		p.free[n-1] = nil
+		p.free = p.free[:n-1]
+		p.mu.Unlock()
+
+		compressionPoolStats.idle.Add(-p.size)
+		compressionPoolStats.inUse.Add(p.size)
+		return x
+	}
+	p.mu.Unlock()
+
+	x := p.pool.Get()
+	if x != nil {
+		compressionPoolStats.inUse.Add(p.size)
+	}
+	return x
+}
+
+func (p *memPool) alloc() {
+	compressionPoolStats.allocs.Add(1)
+	compressionPoolStats.inUse.Add(p.size)
+}
+
+func (p *memPool) put(x any) {
+	compressionPoolStats.inUse.Add(-p.size)
+
+	limit := compressionPoolLimit.Load()
+	if limit <= 0 {
+		p.pool.Put(x)
+		return
+	}
+
+	if compressionPoolStats.idle.Add(p.size) > limit {
+		compressionPoolStats.idle.Add(-p.size)
+		compressionPoolStats.dropped.Add(1)
+		return
+	}
+	p.mu.Lock()
+	p.free = append(p.free, x)
+	p.mu.Unlock()
+}
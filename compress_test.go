@@ -309,3 +309,36 @@ func TestCompressionDictionaryPreserved(t *testing.T) {
 			withTakeoverSizes[2], withoutTakeoverSizes[2])
 	}
 }
+
+// TestCompressionPoolLimit is not parallel as the limit and stats are global.
+func TestCompressionPoolLimit(t *testing.T) {
+	SetCompressionPoolLimit(flateReaderMemory)
+	defer SetCompressionPoolLimit(0)
+
+	p := &memPool{size: flateReaderMemory}
+	before := ReadCompressionPoolStats()
+
+	p.alloc()
+	p.alloc()
+	mid := ReadCompressionPoolStats()
+	assert.Equal(t, "allocs", before.Allocs+2, mid.Allocs)
+	assert.Equal(t, "in use", before.InUse+2*flateReaderMemory, mid.InUse)
+
+	p.put("a")
+	p.put("b")
+	after := ReadCompressionPoolStats()
+	assert.Equal(t, "in use", before.InUse, after.InUse)
+	assert.Equal(t, "idle", before.Idle+flateReaderMemory, after.Idle)
+	assert.Equal(t, "dropped", before.Dropped+1, after.Dropped)
+
+	assert.Equal(t, "pooled item", "a", p.get())
+	assert.Equal(t, "pooled item", nil, p.get())
+	assert.Equal(t, "idle", before.Idle, ReadCompressionPoolStats().Idle)
+
+	// Without a limit, released items are left to sync.Pool.
+	SetCompressionPoolLimit(0)
+	p.put("a")
+	after = ReadCompressionPoolStats()
+	assert.Equal(t, "in use", before.InUse, after.InUse)
+	assert.Equal(t, "idle", before.Idle, after.Idle)
+}
@@ -5,13 +5,19 @@ package websocket
 import (
 	"bufio"
 	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net"
+	"net/http"
 	"runtime"
 	"strconv"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 // MessageType represents the type of a WebSocket message.
@@ -26,6 +32,12 @@ const (
 	MessageBinary
 )
 
+// Message is a data message written by Conn.WriteMessages.
+type Message struct {
+	Type MessageType
+	Data []byte
+}
+
 // Conn represents a WebSocket connection.
 // All methods may be called concurrently except for Reader and Read.
 //
@@ -43,59 +55,168 @@ const (
 type Conn struct {
 	noCopy noCopy
 
-	subprotocol    string
-	rwc            io.ReadWriteCloser
+	subprotocol  string
+	extHeader    string
+	handshakeReq *http.Request
+	rwc          io.ReadWriteCloser
+	netConn      net.Conn
+	tlsState     *tls.ConnectionState
+	// rand generates the masking keys of a client.
+	rand           io.Reader
 	client         bool
 	copts          *compressionOptions
 	flateThreshold int
-	br             *bufio.Reader
-	bw             *bufio.Writer
+	strictUTF8     bool
+	// readMsgMu serializes Read calls if concurrentReads is set.
+	// See DialOptions.ConcurrentReads.
+	concurrentReads bool
+	readMsgMu       *mu
+	emulatedPing    bool
+	// rejectMasked and allowUnmasked relax or tighten the masking rules
+	// for frames from the peer. See DialOptions.RejectMaskedFrames and
+	// AcceptOptions.AllowUnmaskedFrames.
+	rejectMasked  bool
+	allowUnmasked bool
+	// extensions are the negotiated extensions and extRSV the bits they
+	// claim.
+	extensions []negotiatedExtension
+	extRSV     RSV
+	br         *bufio.Reader
+	bw         *bufio.Writer
 
 	readTimeoutStop  atomic.Pointer[func() bool]
 	writeTimeoutStop atomic.Pointer[func() bool]
 
+	// idleTimer closes the connection after idleTimeout without reading.
+	idleTimeout time.Duration
+	idleTimer   ClockTimer
+	idleClosing atomic.Bool
+
+	// clock measures the timeouts of the connection.
+	clock Clock
+
+	// readDeadline and writeDeadline bound ReadMessage and WriteMessage.
+	// nil means no deadline.
+	readDeadline  atomic.Pointer[time.Time]
+	writeDeadline atomic.Pointer[time.Time]
+
 	// Read state.
 	readMu         *mu
 	readHeaderBuf  [8]byte
 	readControlBuf [maxControlPayload]byte
 	msgReader      *msgReader
+	// readFrameLeft is the unread payload length of the current frame.
+	readFrameLeft int64
+
+	stats connStats
 
 	// Write state.
-	msgWriter      *msgWriter
-	writeFrameMu   *mu
-	writeBuf       []byte
-	writeHeaderBuf [8]byte
-	writeHeader    header
+	msgWriter         *msgWriter
+	writeFrameMu      *mu
+	writeBuf          []byte
+	writeHeaderBuf    [8]byte
+	writeHeader       header
+	writeFragmentSize atomic.Int64
+	// controlWriteTimeout bounds control frame writes, see controlTimeout.
+	controlWriteTimeout time.Duration
+	// writeBatch defers flushing data frames while WriteMessages holds
+	// msgWriter.mu.
+	writeBatch bool
+
+	// WriteAsync state.
+	writeQueueMu             sync.Mutex // Protects following.
+	writeQueue               chan asyncWrite
+	writeQueueUrgent         chan asyncWrite
+	writeLoopDone            chan struct{}
+	writeQueueClosed         bool
+	writeQueueLength         int
+	writeQueuePolicy         DropPolicy
+	writeBackpressureTimeout time.Duration
 
 	// Close handshake state.
 	closeStateMu     sync.RWMutex
 	closeReceivedErr error
 	closeSentErr     error
-
-	// CloseRead state.
-	closeReadMu   sync.Mutex
-	closeReadCtx  context.Context
-	closeReadDone chan struct{}
+	waitCloseOnError bool
+
+	// CloseRead state. closeReadResume is set by ResumeRead for the
+	// CloseRead goroutine to hand the next data frame, stored in
+	// readPending, to Reader instead of failing the connection.
+	closeReadMu     sync.Mutex
+	closeReadCtx    context.Context
+	closeReadDone   chan struct{}
+	closeReadResume bool
+	readPending     *header
+
+	// state is the State of the connection. stateMu serializes transitions
+	// and protects callbacks. Calls of onStateChange, onCloseReceived and
+	// onCloseSent are queued in callbacks and made by runCallbacks once the
+	// locks held when they were queued are released.
+	state            atomic.Int32
+	stateMu          sync.Mutex
+	callbacks        []func()
+	callbacksRunning bool
+	onStateChange    func(State)
 
 	closing atomic.Bool
 	closeMu sync.Mutex // Protects following.
 	closed  chan struct{}
-
-	pingCounter    atomic.Int64
-	activePingsMu  sync.Mutex
-	activePings    map[string]chan<- struct{}
-	onPingReceived func(context.Context, []byte) bool
-	onPongReceived func(context.Context, []byte)
+	onClose func()
+
+	// closeCause is the first recorded cause of the closure, see
+	// CloseCause. It has its own mutex as it is recorded with readMu or
+	// writeFrameMu held, which close acquires while holding closeMu.
+	closeCauseMu sync.Mutex
+	closeCause   error
+
+	pingCounter     atomic.Int64
+	activePingsMu   sync.Mutex
+	activePings     map[string]*activePing
+	onPingReceived  func(context.Context, []byte) bool
+	onPingReply     func(context.Context, []byte) ([]byte, bool)
+	onPongReceived  func(context.Context, []byte)
+	onCloseReceived func(context.Context, StatusCode, string)
+	onCloseSent     func(context.Context, StatusCode, string)
+	onReservedFrame func(context.Context, int, []byte) bool
+
+	logger  *slog.Logger
+	metrics Metrics
 }
 
 type connConfig struct {
-	subprotocol    string
-	rwc            io.ReadWriteCloser
-	client         bool
-	copts          *compressionOptions
-	flateThreshold int
-	onPingReceived func(context.Context, []byte) bool
-	onPongReceived func(context.Context, []byte)
+	subprotocol              string
+	extHeader                string
+	handshakeReq             *http.Request
+	rwc                      io.ReadWriteCloser
+	netConn                  net.Conn
+	tlsState                 *tls.ConnectionState
+	rand                     io.Reader
+	client                   bool
+	copts                    *compressionOptions
+	flateThreshold           int
+	strictUTF8               bool
+	concurrentReads          bool
+	emulatedPing             bool
+	rejectMaskedFrames       bool
+	allowUnmaskedFrames      bool
+	extensions               []negotiatedExtension
+	onPingReceived           func(context.Context, []byte) bool
+	onPingReply              func(context.Context, []byte) ([]byte, bool)
+	onPongReceived           func(context.Context, []byte)
+	onCloseReceived          func(context.Context, StatusCode, string)
+	onCloseSent              func(context.Context, StatusCode, string)
+	onStateChange            func(State)
+	onReservedFrame          func(context.Context, int, []byte) bool
+	onClose                  func()
+	writeQueueLength         int
+	writeQueuePolicy         DropPolicy
+	writeBackpressureTimeout time.Duration
+	waitCloseOnError         bool
+	controlWriteTimeout      time.Duration
+	idleTimeout              time.Duration
+	clock                    Clock
+	logger                   *slog.Logger
+	metrics                  Metrics
 
 	br *bufio.Reader
 	bw *bufio.Writer
@@ -103,28 +224,70 @@ type connConfig struct {
 
 func newConn(cfg connConfig) *Conn {
 	c := &Conn{
-		subprotocol:    cfg.subprotocol,
-		rwc:            cfg.rwc,
-		client:         cfg.client,
-		copts:          cfg.copts,
-		flateThreshold: cfg.flateThreshold,
+		subprotocol:     cfg.subprotocol,
+		extHeader:       cfg.extHeader,
+		handshakeReq:    cfg.handshakeReq,
+		rwc:             cfg.rwc,
+		netConn:         cfg.netConn,
+		tlsState:        cfg.tlsState,
+		rand:            cfg.rand,
+		client:          cfg.client,
+		copts:           cfg.copts,
+		flateThreshold:  cfg.flateThreshold,
+		strictUTF8:      cfg.strictUTF8,
+		concurrentReads: cfg.concurrentReads,
+		emulatedPing:    cfg.emulatedPing,
+		rejectMasked:    cfg.rejectMaskedFrames,
+		allowUnmasked:   cfg.allowUnmaskedFrames,
+		extensions:      cfg.extensions,
 
 		br: cfg.br,
 		bw: cfg.bw,
 
-		closed:         make(chan struct{}),
-		activePings:    make(map[string]chan<- struct{}),
-		onPingReceived: cfg.onPingReceived,
-		onPongReceived: cfg.onPongReceived,
+		closed:                   make(chan struct{}),
+		activePings:              make(map[string]*activePing),
+		onPingReceived:           cfg.onPingReceived,
+		onPingReply:              cfg.onPingReply,
+		onPongReceived:           cfg.onPongReceived,
+		onCloseReceived:          cfg.onCloseReceived,
+		onCloseSent:              cfg.onCloseSent,
+		onStateChange:            cfg.onStateChange,
+		onReservedFrame:          cfg.onReservedFrame,
+		onClose:                  cfg.onClose,
+		writeQueueLength:         cfg.writeQueueLength,
+		writeQueuePolicy:         cfg.writeQueuePolicy,
+		writeBackpressureTimeout: cfg.writeBackpressureTimeout,
+		waitCloseOnError:         cfg.waitCloseOnError,
+		controlWriteTimeout:      cfg.controlWriteTimeout,
+		idleTimeout:              cfg.idleTimeout,
+		clock:                    cfg.clock,
+		logger:                   cfg.logger,
+		metrics:                  cfg.metrics,
+	}
+
+	if c.rand == nil {
+		c.rand = rand.Reader
+	}
+	if c.clock == nil {
+		c.clock = realClock{}
 	}
 
 	c.readMu = newMu(c)
+	if c.concurrentReads {
+		c.readMsgMu = newMu(c)
+	}
 	c.writeFrameMu = newMu(c)
 
 	c.msgReader = newMsgReader(c)
 
 	c.msgWriter = newMsgWriter(c)
-	if c.client {
+	if c.idleTimeout > 0 {
+		c.idleTimer = c.clock.AfterFunc(c.idleTimeout, c.closeIdle)
+	}
+	for _, ext := range c.extensions {
+		c.extRSV |= ext.rsv
+	}
+	if c.client || c.bw.Buffered() == 0 {
 		c.writeBuf = extractBufioWriterBuf(c.bw, c.rwc)
 	}
 
@@ -135,7 +298,11 @@ func newConn(cfg connConfig) *Conn {
 		}
 	}
 
+	c.setState(StateOpen)
+	c.runCallbacks()
+
 	runtime.SetFinalizer(c, func(c *Conn) {
+		c.setCloseCause(errors.New("connection garbage collected"))
 		c.close()
 	})
 
@@ -148,15 +315,71 @@ func (c *Conn) Subprotocol() string {
 	return c.subprotocol
 }
 
+// Extensions returns the negotiated extensions as listed in the server's
+// Sec-WebSocket-Extensions header, e.g. the permessage-deflate offer the
+// server accepted. An empty string means no extensions were negotiated.
+func (c *Conn) Extensions() string {
+	return c.extHeader
+}
+
+// HandshakeRequest returns the HTTP request of the opening handshake.
+//
+// For connections from Accept, it is a copy of the client's request so that
+// message handlers can access headers, the URL and RemoteAddr after the
+// http.Handler has returned. For connections from Dial, it is the request
+// that was sent to the server.
+//
+// The request body is always http.NoBody and its context is
+// context.Background. It must not be modified.
+func (c *Conn) HandshakeRequest() *http.Request {
+	return c.handshakeReq
+}
+
+// UnderlyingConn returns the network connection of c, e.g. to set TCP
+// options or to inspect the state of a *tls.Conn. It returns nil for
+// connections from Dial if the HTTPClient's transport does not report the
+// connection through httptrace.
+//
+// Reading from or writing to the returned connection corrupts the WebSocket
+// stream. It must not be used for anything but options and state.
+func (c *Conn) UnderlyingConn() net.Conn {
+	if c.netConn != nil {
+		return c.netConn
+	}
+	nc, _ := c.rwc.(net.Conn)
+	return nc
+}
+
+// TLSConnectionState returns the state of the TLS connection, e.g. to check
+// the negotiated version and cipher suite or to pin the peer's certificates.
+// ok is false if the connection does not use TLS.
+func (c *Conn) TLSConnectionState() (state *tls.ConnectionState, ok bool) {
+	return c.tlsState, c.tlsState != nil
+}
+
 func (c *Conn) close() error {
+	// The callbacks run once closeMu is released as they may close c.
+	var closed bool
+	defer func() {
+		if closed {
+			c.afterClose()
+		}
+	}()
+
 	c.closeMu.Lock()
 	defer c.closeMu.Unlock()
 
 	if c.isClosed() {
 		return net.ErrClosed
 	}
+	closed = true
 	runtime.SetFinalizer(c, nil)
+	c.setCloseCause(net.ErrClosed)
 	close(c.closed)
+	c.setState(StateClosed)
+	if c.idleTimer != nil {
+		c.idleTimer.Stop()
+	}
 
 	// Have to close after c.closed is closed to ensure any goroutine that wakes up
 	// from the connection being closed also sees that c.closed is closed and returns
@@ -168,6 +391,118 @@ func (c *Conn) close() error {
 	return err
 }
 
+// afterClose calls the callbacks of the closure of the connection.
+func (c *Conn) afterClose() {
+	c.runCallbacks()
+	if c.onClose != nil {
+		c.onClose()
+	}
+	if c.metrics != nil {
+		code := StatusAbnormalClosure
+		if ce, ok := c.CloseReceived(); ok {
+			code = ce.Code
+		}
+		c.metrics.IncClose(code)
+	}
+}
+
+// CloseCause returns the root cause of the closure of the connection, e.g.
+// the peer's close frame, a protocol violation, a failed read or the cause
+// of the cancellation of a context passed to a method. See context.Cause.
+//
+// It returns nil while the connection is open.
+func (c *Conn) CloseCause() error {
+	if !c.isClosed() {
+		return nil
+	}
+	c.closeCauseMu.Lock()
+	defer c.closeCauseMu.Unlock()
+	return c.closeCause
+}
+
+// setCloseCause records err as the cause of the closure of the connection
+// unless a cause was already recorded.
+func (c *Conn) setCloseCause(err error) {
+	c.closeCauseMu.Lock()
+	defer c.closeCauseMu.Unlock()
+	if c.closeCause == nil {
+		c.closeCause = err
+	}
+}
+
+// State returns the state of the connection.
+func (c *Conn) State() State {
+	return State(c.state.Load())
+}
+
+// setState transitions the connection to s. A connection only transitions
+// from StateOpen to either closing state and from any state to StateClosed.
+//
+// The transition is only queued for onStateChange as locks may be held.
+// Call runCallbacks once they are released.
+func (c *Conn) setState(s State) {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+
+	old := c.State()
+	switch {
+	case old == s, old == StateClosed:
+		return
+	case (s == StateClosingSent || s == StateClosingReceived) && old != StateOpen:
+		return
+	}
+	c.state.Store(int32(s))
+	if c.onStateChange != nil {
+		c.callbacks = append(c.callbacks, func() { c.onStateChange(s) })
+	}
+}
+
+// queueCallback queues fn for runCallbacks as locks may be held.
+func (c *Conn) queueCallback(fn func()) {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+	c.callbacks = append(c.callbacks, fn)
+}
+
+// runCallbacks makes the queued callback calls. It must be called without
+// locks held as the callbacks may call methods of c, e.g. CloseNow. If
+// another goroutine is already running them, it makes the calls instead so
+// that they stay serialized and in order.
+func (c *Conn) runCallbacks() {
+	if c.onStateChange == nil && c.onCloseReceived == nil && c.onCloseSent == nil {
+		return
+	}
+
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+	if c.callbacksRunning {
+		return
+	}
+	c.callbacksRunning = true
+	for len(c.callbacks) > 0 {
+		fn := c.callbacks[0]
+		c.callbacks = c.callbacks[1:]
+		c.stateMu.Unlock()
+		fn()
+		c.stateMu.Lock()
+	}
+	c.callbacksRunning = false
+}
+
+// closeIdle sends a close frame once the connection was idle for idleTimeout
+// and closes the connection if it stays idle for another idleTimeout, i.e.
+// the peer did not respond.
+func (c *Conn) closeIdle() {
+	if c.idleClosing.Swap(true) {
+		c.close()
+		return
+	}
+	c.log(slog.LevelInfo, "closing idle WebSocket connection", "idle_timeout", c.idleTimeout)
+	c.setCloseCause(fmt.Errorf("connection idle for %v", c.idleTimeout))
+	c.CloseWrite(StatusGoingAway, "idle timeout")
+	c.idleTimer.Reset(c.idleTimeout)
+}
+
 func (c *Conn) setupWriteTimeout(ctx context.Context) bool {
 	if ctx.Done() == nil {
 		return false
@@ -175,6 +510,7 @@ func (c *Conn) setupWriteTimeout(ctx context.Context) bool {
 
 	stop := context.AfterFunc(ctx, func() {
 		c.clearWriteTimeout()
+		c.setCloseCause(fmt.Errorf("write context done: %w", context.Cause(ctx)))
 		c.close()
 	})
 	swapTimeoutStop(&c.writeTimeoutStop, &stop)
@@ -192,6 +528,7 @@ func (c *Conn) setupReadTimeout(ctx context.Context) bool {
 
 	stop := context.AfterFunc(ctx, func() {
 		c.clearReadTimeout()
+		c.setCloseCause(fmt.Errorf("read context done: %w", context.Cause(ctx)))
 		c.close()
 	})
 	swapTimeoutStop(&c.readTimeoutStop, &stop)
@@ -209,10 +546,62 @@ func swapTimeoutStop(p *atomic.Pointer[func() bool], newStop *func() bool) {
 	}
 }
 
+// deadlineTimer closes a connection when the timeout passed to ReadTimeout or
+// WriteTimeout expires. Timers are pooled so that those methods do not allocate
+// a context and timer per message.
+type deadlineTimer struct {
+	t *time.Timer
+	c atomic.Pointer[Conn]
+}
+
+var deadlineTimerPool sync.Pool
+
+func (c *Conn) startDeadlineTimer(d time.Duration) *deadlineTimer {
+	dt, ok := deadlineTimerPool.Get().(*deadlineTimer)
+	if !ok {
+		dt = &deadlineTimer{}
+		dt.c.Store(c)
+		dt.t = time.AfterFunc(d, func() {
+			c := dt.c.Load()
+			c.setCloseCause(fmt.Errorf("timeout exceeded: %w", context.DeadlineExceeded))
+			c.close()
+		})
+		return dt
+	}
+	dt.c.Store(c)
+	dt.t.Reset(d)
+	return dt
+}
+
+// stop stops the timer and reports whether it expired. A timer that expired is
+// not returned to the pool as its function may still be running.
+func (dt *deadlineTimer) stop() bool {
+	if !dt.t.Stop() {
+		return true
+	}
+	dt.c.Store(nil)
+	deadlineTimerPool.Put(dt)
+	return false
+}
+
+// log logs msg with args if a Logger was configured.
+func (c *Conn) log(level slog.Level, msg string, args ...any) {
+	if c.logger != nil {
+		c.logger.Log(context.Background(), level, msg, args...)
+	}
+}
+
 func (c *Conn) flate() bool {
 	return c.copts != nil
 }
 
+// emulatedPingPrefix and emulatedPongPrefix start the binary messages used to
+// emulate ping and pong frames for Wasm clients. See EmulatedPing.
+var (
+	emulatedPingPrefix = []byte("\x00websocket-ping\x00")
+	emulatedPongPrefix = []byte("\x00websocket-pong\x00")
+)
+
 // Ping sends a ping to the peer and waits for a pong.
 // Use this to measure latency or ensure the peer is responsive.
 // Ping must be called concurrently with Reader as it does
@@ -230,11 +619,60 @@ func (c *Conn) Ping(ctx context.Context) error {
 	return nil
 }
 
+// PingWithPayload is like Ping but sends the given payload, e.g. a timestamp
+// or sequence number, which must be at most 125 bytes.
+//
+// Only a pong echoing the payload completes the ping. If the context expires
+// after a pong with a different payload was received, a *PongMismatchError
+// is returned.
+func (c *Conn) PingWithPayload(ctx context.Context, payload []byte) error {
+	if len(payload) > maxControlPayload {
+		return fmt.Errorf("failed to ping: payload of %d bytes exceeds %d bytes", len(payload), maxControlPayload)
+	}
+
+	err := c.ping(ctx, string(payload))
+	if err != nil {
+		return fmt.Errorf("failed to ping: %w", err)
+	}
+	return nil
+}
+
+// Pong sends an unsolicited pong with the given payload, which must be at most
+// 125 bytes. No response is expected from the peer.
+//
+// RFC 6455 allows unsolicited pongs to serve as a unidirectional heartbeat,
+// e.g. to keep NAT mappings alive without waiting on the peer.
+func (c *Conn) Pong(ctx context.Context, payload []byte) error {
+	if len(payload) > maxControlPayload {
+		return fmt.Errorf("failed to pong: payload of %d bytes exceeds %d bytes", len(payload), maxControlPayload)
+	}
+
+	err := c.writeControl(ctx, opPong, payload)
+	if err != nil {
+		return fmt.Errorf("failed to pong: %w", err)
+	}
+	return nil
+}
+
+// activePing is a ping waiting for its pong.
+type activePing struct {
+	pong chan struct{}
+	// unmatched is the payload of the last pong that did not match any ping.
+	// Protected by activePingsMu.
+	unmatched []byte
+}
+
 func (c *Conn) ping(ctx context.Context, p string) error {
-	pong := make(chan struct{}, 1)
+	ap := &activePing{
+		pong: make(chan struct{}, 1),
+	}
 
 	c.activePingsMu.Lock()
-	c.activePings[p] = pong
+	if _, ok := c.activePings[p]; ok {
+		c.activePingsMu.Unlock()
+		return fmt.Errorf("ping with payload %q already in flight", p)
+	}
+	c.activePings[p] = ap
 	c.activePingsMu.Unlock()
 
 	defer func() {
@@ -252,8 +690,18 @@ func (c *Conn) ping(ctx context.Context, p string) error {
 	case <-c.closed:
 		return net.ErrClosed
 	case <-ctx.Done():
+		c.activePingsMu.Lock()
+		unmatched := ap.unmatched
+		c.activePingsMu.Unlock()
+		if unmatched != nil {
+			return fmt.Errorf("failed to wait for pong: %w", &PongMismatchError{
+				Expected: []byte(p),
+				Received: unmatched,
+				Err:      ctx.Err(),
+			})
+		}
 		return fmt.Errorf("failed to wait for pong: %w", ctx.Err())
-	case <-pong:
+	case <-ap.pong:
 		return nil
 	}
 }
@@ -284,11 +732,26 @@ func (m *mu) tryLock() bool {
 }
 
 func (m *mu) lock(ctx context.Context) error {
+	return m.lockTimeout(ctx, 0)
+}
+
+// lockTimeout is like lock but fails with ErrWriteBackpressure if the lock is
+// not acquired within d. A zero d waits like lock.
+func (m *mu) lockTimeout(ctx context.Context, d time.Duration) error {
+	var timeout <-chan time.Time
+	if d > 0 {
+		t := time.NewTimer(d)
+		defer t.Stop()
+		timeout = t.C
+	}
+
 	select {
 	case <-m.c.closed:
 		return net.ErrClosed
 	case <-ctx.Done():
 		return fmt.Errorf("failed to acquire lock: %w", ctx.Err())
+	case <-timeout:
+		return ErrWriteBackpressure
 	case m.ch <- struct{}{}:
 		// To make sure the connection is certainly alive.
 		// As it's possible the send on m.ch was selected
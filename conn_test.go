@@ -4,16 +4,25 @@ package websocket_test
 
 import (
 	"bytes"
+	"compress/flate"
 	"context"
+	"encoding/binary"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"os/exec"
+	"runtime"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -26,6 +35,43 @@ import (
 	"github.com/coder/websocket/wsjson"
 )
 
+// TestConnIdleGoroutines verifies that idle connections run no goroutines as
+// context deadlines are propagated with context.AfterFunc instead of a
+// goroutine per connection. It is not parallel as it counts goroutines.
+func TestConnIdleGoroutines(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+
+	before := runtime.NumGoroutine()
+
+	var conns []*websocket.Conn
+	defer func() {
+		for _, c := range conns {
+			c.CloseNow()
+		}
+	}()
+	for range 10 {
+		c1, c2 := wstest.Pipe(nil, nil)
+		conns = append(conns, c1, c2)
+
+		// Register and clear the read and write deadlines.
+		writeErr := xsync.Go(func() error {
+			return c1.Write(ctx, websocket.MessageText, []byte("hello"))
+		})
+		_, _, err := c2.Read(ctx)
+		assert.Success(t, err)
+		assert.Success(t, <-writeErr)
+	}
+
+	// Allow the write goroutines to exit.
+	for i := 0; runtime.NumGoroutine() > before && i < 100; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	if n := runtime.NumGoroutine(); n > before {
+		t.Fatalf("expected no goroutines for %d idle connections but got %d: %s", len(conns), n-before, goroutineStacks())
+	}
+}
+
 func TestConn(t *testing.T) {
 	t.Parallel()
 
@@ -85,6 +131,78 @@ func TestConn(t *testing.T) {
 		assert.Success(t, err)
 	})
 
+	t.Run("resumeRead", func(t *testing.T) {
+		tt, c1, c2 := newConnTest(t, nil, nil)
+
+		c1.CloseRead(tt.ctx)
+		ctx := c2.CloseRead(tt.ctx)
+
+		err := c2.Ping(tt.ctx)
+		assert.Success(t, err)
+
+		c2.ResumeRead()
+		err = c1.Write(tt.ctx, websocket.MessageText, []byte("hello"))
+		assert.Success(t, err)
+		_, p, err := c2.Read(tt.ctx)
+		assert.Success(t, err)
+		assert.Equal(t, "message", "hello", string(p))
+
+		select {
+		case <-ctx.Done():
+		case <-tt.ctx.Done():
+			t.Fatal("CloseRead context not cancelled after resuming")
+		}
+
+		// Reading can be closed again.
+		c2.CloseRead(tt.ctx)
+		err = c2.Ping(tt.ctx)
+		assert.Success(t, err)
+
+		err = c2.Close(websocket.StatusNormalClosure, "")
+		assert.Success(t, err)
+	})
+
+	t.Run("concurrentReads", func(t *testing.T) {
+		tt, c1, c2 := newConnTest(t, &websocket.DialOptions{
+			ConcurrentReads: true,
+		}, &websocket.AcceptOptions{
+			ConcurrentReads: true,
+		})
+
+		const n = 100
+		received := make(chan string, n)
+		var wg sync.WaitGroup
+		for range 4 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					_, p, err := c2.Read(tt.ctx)
+					if err != nil {
+						// One reader receives the close frame and the rest
+						// net.ErrClosed.
+						return
+					}
+					received <- string(p)
+				}
+			}()
+		}
+
+		for i := range n {
+			err := c1.Write(tt.ctx, websocket.MessageText, []byte(strconv.Itoa(i)))
+			assert.Success(t, err)
+		}
+		seen := make(map[string]bool)
+		for range n {
+			seen[<-received] = true
+		}
+		assert.Equal(t, "messages", n, len(seen))
+
+		err := c1.Close(websocket.StatusNormalClosure, "")
+		assert.Success(t, err)
+		wg.Wait()
+	})
+
 	t.Run("badPing", func(t *testing.T) {
 		tt, c1, c2 := newConnTest(t, nil, nil)
 
@@ -137,6 +255,147 @@ func TestConn(t *testing.T) {
 		assert.Equal(t, "ping and pong received", true, (pingReceived1 && pongReceived2) || (pingReceived2 && pongReceived1))
 	})
 
+	t.Run("pingWithPayload", func(t *testing.T) {
+		tt, c1, c2 := newConnTest(t, nil, nil)
+
+		c1.CloseRead(tt.ctx)
+		c2.CloseRead(tt.ctx)
+
+		err := c1.PingWithPayload(tt.ctx, []byte("hello"))
+		assert.Success(t, err)
+
+		err = c1.PingWithPayload(tt.ctx, make([]byte, 126))
+		assert.Contains(t, err, "exceeds 125 bytes")
+	})
+
+	t.Run("pingWithPayloadMismatch", func(t *testing.T) {
+		pingReceived := make(chan []byte, 1)
+		onPingReceived := func(ctx context.Context, payload []byte) bool {
+			pingReceived <- payload
+			return false
+		}
+		tt, c1, c2 := newConnTest(t,
+			&websocket.DialOptions{OnPingReceived: onPingReceived},
+			&websocket.AcceptOptions{OnPingReceived: onPingReceived},
+		)
+
+		c1.CloseRead(tt.ctx)
+		c2.CloseRead(tt.ctx)
+
+		ctx, cancel := context.WithTimeout(tt.ctx, time.Millisecond*100)
+		defer cancel()
+
+		errs := xsync.Go(func() error {
+			return c1.PingWithPayload(ctx, []byte("hello"))
+		})
+
+		select {
+		case p := <-pingReceived:
+			assert.Equal(t, "ping payload", "hello", string(p))
+		case <-tt.ctx.Done():
+			t.Fatal(tt.ctx.Err())
+		}
+		err := c2.Pong(tt.ctx, []byte("world"))
+		assert.Success(t, err)
+
+		err = <-errs
+		var pme *websocket.PongMismatchError
+		if !errors.As(err, &pme) {
+			t.Fatalf("expected PongMismatchError: %v", err)
+		}
+		assert.Equal(t, "expected", "hello", string(pme.Expected))
+		assert.Equal(t, "received", "world", string(pme.Received))
+		assert.ErrorIs(t, context.DeadlineExceeded, err)
+	})
+
+	t.Run("pingReply", func(t *testing.T) {
+		pongReceived := make(chan []byte, 1)
+		onPingReply := func(ctx context.Context, payload []byte) ([]byte, bool) {
+			return append([]byte("ack "), payload...), true
+		}
+		onPongReceived := func(ctx context.Context, payload []byte) {
+			pongReceived <- bytes.Clone(payload)
+		}
+		tt, c1, c2 := newConnTest(t,
+			&websocket.DialOptions{OnPingReply: onPingReply, OnPongReceived: onPongReceived},
+			&websocket.AcceptOptions{OnPingReply: onPingReply, OnPongReceived: onPongReceived},
+		)
+
+		c1.CloseRead(tt.ctx)
+		c2.CloseRead(tt.ctx)
+
+		ctx, cancel := context.WithTimeout(tt.ctx, time.Millisecond*100)
+		defer cancel()
+		err := c1.PingWithPayload(ctx, []byte("1"))
+		var pme *websocket.PongMismatchError
+		assert.Equal(t, "errors.As", true, errors.As(err, &pme))
+		assert.Equal(t, "received", "ack 1", string(pme.Received))
+		assert.Equal(t, "pong payload", "ack 1", string(<-pongReceived))
+	})
+
+	t.Run("pong", func(t *testing.T) {
+		pongReceived := make(chan []byte, 1)
+		onPongReceived := func(ctx context.Context, payload []byte) {
+			pongReceived <- payload
+		}
+		tt, c1, c2 := newConnTest(t,
+			&websocket.DialOptions{OnPongReceived: onPongReceived},
+			&websocket.AcceptOptions{OnPongReceived: onPongReceived},
+		)
+
+		c2.CloseRead(tt.ctx)
+
+		err := c1.Pong(tt.ctx, []byte("heartbeat"))
+		assert.Success(t, err)
+
+		select {
+		case p := <-pongReceived:
+			assert.Equal(t, "pong payload", "heartbeat", string(p))
+		case <-tt.ctx.Done():
+			t.Fatal(tt.ctx.Err())
+		}
+
+		err = c1.Pong(tt.ctx, make([]byte, 126))
+		assert.Contains(t, err, "exceeds 125 bytes")
+	})
+
+	t.Run("closeCallbacks", func(t *testing.T) {
+		events := make(chan string, 4)
+		callback := func(event string) func(context.Context, websocket.StatusCode, string) {
+			return func(ctx context.Context, code websocket.StatusCode, reason string) {
+				events <- fmt.Sprintf("%v %v %v", event, code, reason)
+			}
+		}
+		tt, c1, c2 := newConnTest(t,
+			&websocket.DialOptions{
+				OnCloseReceived: callback("received"),
+				OnCloseSent:     callback("sent"),
+			}, &websocket.AcceptOptions{
+				OnCloseReceived: callback("received"),
+				OnCloseSent:     callback("sent"),
+			},
+		)
+
+		c2.CloseRead(tt.ctx)
+
+		err := c1.Close(websocket.StatusNormalClosure, "bye")
+		assert.Success(t, err)
+
+		counts := map[string]int{}
+		for range 4 {
+			select {
+			case e := <-events:
+				counts[e]++
+			case <-tt.ctx.Done():
+				t.Fatal(tt.ctx.Err())
+			}
+		}
+		assert.Equal(t, "close events", map[string]int{
+			"received StatusNormalClosure bye": 2,
+			"sent StatusNormalClosure bye":     2,
+		}, counts)
+	})
+
 	t.Run("pingReceivedPongNotReceived", func(t *testing.T) {
 		var pingReceived1, pongReceived1 bool
 		var pingReceived2, pongReceived2 bool
@@ -195,32 +454,843 @@ func TestConn(t *testing.T) {
 			}()
 		}
 
-		for range count {
-			select {
-			case err := <-errs:
-				assert.Success(t, err)
-			case <-tt.ctx.Done():
-				t.Fatal(tt.ctx.Err())
-			}
+		for range count {
+			select {
+			case err := <-errs:
+				assert.Success(t, err)
+			case <-tt.ctx.Done():
+				t.Fatal(tt.ctx.Err())
+			}
+		}
+
+		err := c1.Close(websocket.StatusNormalClosure, "")
+		assert.Success(t, err)
+	})
+
+	t.Run("concurrentWriteError", func(t *testing.T) {
+		tt, c1, _ := newConnTest(t, nil, nil)
+
+		_, err := c1.Writer(tt.ctx, websocket.MessageText)
+		assert.Success(t, err)
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+		defer cancel()
+
+		err = c1.Write(ctx, websocket.MessageText, []byte("x"))
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("unexpected error: %#v", err)
+		}
+	})
+
+	t.Run("writerInterleave", func(t *testing.T) {
+		tt, c1, c2 := newConnTest(t, nil, nil)
+
+		msgs := make(chan string, 2)
+		readErr := xsync.Go(func() error {
+			for range 2 {
+				_, b, err := c2.Read(tt.ctx)
+				if err != nil {
+					return err
+				}
+				msgs <- string(b)
+			}
+			return nil
+		})
+		c1.CloseRead(tt.ctx)
+
+		w, err := c1.Writer(tt.ctx, websocket.MessageText)
+		assert.Success(t, err)
+		_, err = w.Write([]byte("hel"))
+		assert.Success(t, err)
+
+		err = c1.Ping(tt.ctx)
+		assert.Success(t, err)
+		errs := make(chan error, 1)
+		c1.WriteAsync(websocket.MessageText, []byte("queued"), func(err error) {
+			errs <- err
+		})
+
+		_, err = w.Write([]byte("lo"))
+		assert.Success(t, err)
+		assert.Success(t, w.Close())
+		assert.Success(t, <-errs)
+		assert.Success(t, <-readErr)
+		assert.Equal(t, "message", "hello", <-msgs)
+		assert.Equal(t, "message", "queued", <-msgs)
+	})
+
+	t.Run("bufferedWriter", func(t *testing.T) {
+		tt, c1, c2 := newConnTest(t, nil, nil)
+
+		readErr := xsync.Go(func() error {
+			typ, b, err := c2.Read(tt.ctx)
+			if err != nil {
+				return err
+			}
+			assert.Equal(t, "message type", websocket.MessageText, typ)
+			assert.Equal(t, "message", "abcdef", string(b))
+			return nil
+		})
+
+		w, err := c1.BufferedWriter(tt.ctx, websocket.MessageText)
+		assert.Success(t, err)
+
+		_, err = w.Write([]byte("ab"))
+		assert.Success(t, err)
+		_, err = w.Write([]byte("c"))
+		assert.Success(t, err)
+		assert.Equal(t, "buffered", 3, w.Buffered())
+
+		err = w.Flush()
+		assert.Success(t, err)
+		assert.Equal(t, "buffered", 0, w.Buffered())
+
+		_, err = w.Write([]byte("def"))
+		assert.Success(t, err)
+		err = w.Close()
+		assert.Success(t, err)
+
+		_, err = w.Write([]byte("g"))
+		assert.Contains(t, err, "closed writer")
+		assert.ErrorIs(t, websocket.ErrWriterClosed, err)
+
+		err = <-readErr
+		assert.Success(t, err)
+		c2.CloseRead(tt.ctx)
+
+		err = c1.Close(websocket.StatusNormalClosure, "")
+		assert.Success(t, err)
+	})
+
+	t.Run("writeFragmentSize", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+		defer cancel()
+
+		c, s := wstest.Pipe(&websocket.DialOptions{
+			CompressionMode: websocket.CompressionDisabled,
+		}, &websocket.AcceptOptions{
+			CompressionMode: websocket.CompressionDisabled,
+		})
+		defer c.CloseNow()
+		defer s.CloseNow()
+
+		c.SetWriteFragmentSize(10)
+		bytesRead := s.RecordBytesRead()
+
+		msg := xrand.Bytes(95)
+		writeErr := xsync.Go(func() error {
+			return c.Write(ctx, websocket.MessageBinary, msg)
+		})
+
+		_, b, err := s.Read(ctx)
+		assert.Success(t, err)
+		assert.Equal(t, "message", msg, b)
+		assert.Success(t, <-writeErr)
+
+		// 10 masked frames with a 2 byte header and a 4 byte mask key.
+		assert.Equal(t, "bytes read", 10*6+95, *bytesRead)
+	})
+
+	t.Run("noCompress", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+		defer cancel()
+
+		c, s := wstest.Pipe(&websocket.DialOptions{
+			CompressionMode: websocket.CompressionContextTakeover,
+		}, &websocket.AcceptOptions{
+			CompressionMode: websocket.CompressionContextTakeover,
+		})
+		defer c.CloseNow()
+		defer s.CloseNow()
+
+		bytesRead := s.RecordBytesRead()
+		msg := bytes.Repeat([]byte("x"), 1000)
+
+		writeErr := xsync.Go(func() error {
+			err := c.WriteOpts(ctx, websocket.MessageBinary, msg, websocket.NoCompress)
+			if err != nil {
+				return err
+			}
+			w, err := c.WriterOpts(ctx, websocket.MessageBinary, websocket.NoCompress)
+			if err != nil {
+				return err
+			}
+			_, err = w.Write(msg)
+			if err != nil {
+				return err
+			}
+			return w.Close()
+		})
+
+		_, b, err := s.Read(ctx)
+		assert.Success(t, err)
+		assert.Equal(t, "message", msg, b)
+		// A masked frame with a 2 byte header, 2 byte extended length and 4 byte mask key.
+		assert.Equal(t, "bytes read", 8+len(msg), *bytesRead)
+
+		_, b, err = s.Read(ctx)
+		assert.Success(t, err)
+		assert.Equal(t, "message", msg, b)
+		// Plus an empty fin frame with a 2 byte header and a 4 byte mask key.
+		assert.Equal(t, "bytes read", 2*(8+len(msg))+6, *bytesRead)
+		assert.Success(t, <-writeErr)
+	})
+
+	t.Run("readerFrames", func(t *testing.T) {
+		tt, c1, c2 := newConnTest(t, nil, nil)
+
+		writeErr := xsync.Go(func() error {
+			w, err := c2.Writer(tt.ctx, websocket.MessageBinary)
+			if err != nil {
+				return err
+			}
+			for _, p := range []string{"a", "bc", "def"} {
+				_, err = w.Write([]byte(p))
+				if err != nil {
+					return err
+				}
+			}
+			return w.Close()
+		})
+
+		typ, frames, err := c1.ReaderFrames(tt.ctx)
+		assert.Success(t, err)
+		assert.Equal(t, "type", websocket.MessageBinary, typ)
+		var chunks []string
+		for p, err := range frames {
+			assert.Success(t, err)
+			chunks = append(chunks, string(p))
+		}
+		assert.Equal(t, "chunks", []string{"a", "bc", "def"}, chunks)
+		assert.Success(t, <-writeErr)
+
+		tt.goDiscardLoop(c2)
+		err = c1.Close(websocket.StatusNormalClosure, "")
+		assert.Success(t, err)
+	})
+
+	t.Run("state", func(t *testing.T) {
+		var mu sync.Mutex
+		var dialStates, acceptStates []websocket.State
+		record := func(states *[]websocket.State) func(websocket.State) {
+			return func(s websocket.State) {
+				mu.Lock()
+				defer mu.Unlock()
+				*states = append(*states, s)
+			}
+		}
+		tt, c1, c2 := newConnTest(t, &websocket.DialOptions{
+			OnStateChange: record(&dialStates),
+		}, &websocket.AcceptOptions{
+			OnStateChange: record(&acceptStates),
+		})
+		assert.Equal(t, "state", websocket.StateOpen, c1.State())
+
+		readErr := xsync.Go(func() error {
+			_, _, err := c2.Read(tt.ctx)
+			return err
+		})
+		err := c1.Close(websocket.StatusNormalClosure, "")
+		assert.Success(t, err)
+		assert.Equal(t, "close status", websocket.StatusNormalClosure, websocket.CloseStatus(<-readErr))
+		assert.Equal(t, "state", websocket.StateClosed, c1.State())
+		assert.Equal(t, "state", websocket.StateClosed, c2.State())
+
+		mu.Lock()
+		defer mu.Unlock()
+		// c1 is either side so either may have started the close handshake.
+		exp := [][]websocket.State{
+			{websocket.StateOpen, websocket.StateClosingSent, websocket.StateClosed},
+			{websocket.StateOpen, websocket.StateClosingReceived, websocket.StateClosed},
+		}
+		if !slices.Equal(exp[0], dialStates) {
+			exp[0], exp[1] = exp[1], exp[0]
+		}
+		assert.Equal(t, "states", exp, [][]websocket.State{dialStates, acceptStates})
+	})
+
+	t.Run("stateChangeClose", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+
+		var client atomic.Pointer[websocket.Conn]
+		c1, c2 := wstest.Pipe(&websocket.DialOptions{
+			OnStateChange: func(s websocket.State) {
+				if s == websocket.StateClosingReceived || s == websocket.StateClosed {
+					client.Load().CloseNow()
+				}
+			},
+		}, nil)
+		client.Store(c1)
+		defer c2.CloseNow()
+
+		readErr := xsync.Go(func() error {
+			_, _, err := c1.Read(ctx)
+			return err
+		})
+		err := c2.Close(websocket.StatusNormalClosure, "")
+		assert.Success(t, err)
+		assert.Equal(t, "close status", websocket.StatusNormalClosure, websocket.CloseStatus(<-readErr))
+		assert.Equal(t, "state", websocket.StateClosed, c1.State())
+	})
+
+	t.Run("closeNowFromCloseCallbacks", func(t *testing.T) {
+		t.Parallel()
+
+		testCases := []struct {
+			name string
+			opts func(closeNow func()) *websocket.AcceptOptions
+			peer func(ctx context.Context, c *websocket.Conn) error
+		}{
+			{
+				name: "received",
+				opts: func(closeNow func()) *websocket.AcceptOptions {
+					return &websocket.AcceptOptions{
+						OnCloseReceived: func(context.Context, websocket.StatusCode, string) { closeNow() },
+					}
+				},
+				peer: func(ctx context.Context, c *websocket.Conn) error {
+					return c.WriteCloseFrame(ctx, websocket.StatusNormalClosure, "")
+				},
+			},
+			{
+				// The close frame is sent with readMu held as the message
+				// exceeds the read limit.
+				name: "sent",
+				opts: func(closeNow func()) *websocket.AcceptOptions {
+					return &websocket.AcceptOptions{
+						OnCloseSent: func(context.Context, websocket.StatusCode, string) { closeNow() },
+					}
+				},
+				peer: func(ctx context.Context, c *websocket.Conn) error {
+					return c.Write(ctx, websocket.MessageBinary, make([]byte, 64))
+				},
+			},
+		}
+		for _, tc := range testCases {
+			t.Run(tc.name, func(t *testing.T) {
+				t.Parallel()
+
+				ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+				defer cancel()
+
+				var server atomic.Pointer[websocket.Conn]
+				c1, c2 := wstest.Pipe(nil, tc.opts(func() {
+					server.Load().CloseNow()
+				}))
+				server.Store(c2)
+				defer c1.CloseNow()
+				defer c2.CloseNow()
+				c2.SetReadLimit(16)
+
+				readErr := xsync.Go(func() error {
+					_, _, err := c2.Read(ctx)
+					return err
+				})
+				err := tc.peer(ctx, c1)
+				assert.Success(t, err)
+
+				select {
+				case err := <-readErr:
+					assert.Error(t, err)
+				case <-ctx.Done():
+					t.Fatal("Read did not return")
+				}
+				assert.Equal(t, "state", websocket.StateClosed, c2.State())
+			})
+		}
+	})
+
+	t.Run("closeCause", func(t *testing.T) {
+		tt, c1, c2 := newConnTest(t, nil, nil)
+		assert.Equal(t, "close cause", nil, c1.CloseCause())
+
+		ctx := c1.CloseRead(tt.ctx)
+		err := c2.Close(websocket.StatusGoingAway, "bye")
+		assert.Success(t, err)
+		<-ctx.Done()
+		assert.Equal(t, "close status", websocket.StatusGoingAway, websocket.CloseStatus(context.Cause(ctx)))
+		assert.Equal(t, "close status", websocket.StatusGoingAway, websocket.CloseStatus(c1.CloseCause()))
+		assert.Equal(t, "close status", websocket.StatusGoingAway, websocket.CloseStatus(c2.CloseCause()))
+	})
+
+	t.Run("closeCauseContext", func(t *testing.T) {
+		tt, c1, _ := newConnTest(t, nil, nil)
+
+		errCause := errors.New("shutting down")
+		ctx, cancel := context.WithTimeoutCause(tt.ctx, time.Millisecond*50, errCause)
+		defer cancel()
+		_, _, err := c1.Read(ctx)
+		assert.Error(t, err)
+		assert.ErrorIs(t, errCause, c1.CloseCause())
+	})
+
+	t.Run("progress", func(t *testing.T) {
+		tt, c1, c2 := newConnTest(t, nil, nil)
+
+		var written []int64
+		writeErr := xsync.Go(func() error {
+			err := c2.Write(tt.ctx, websocket.MessageText, []byte("hello"))
+			if err != nil {
+				return err
+			}
+			w, err := c2.WriterWithProgress(tt.ctx, websocket.MessageBinary, func(n int64) {
+				written = append(written, n)
+			})
+			if err != nil {
+				return err
+			}
+			for _, p := range []string{"a", "bc"} {
+				_, err = w.Write([]byte(p))
+				if err != nil {
+					return err
+				}
+			}
+			return w.Close()
+		})
+
+		type progress struct {
+			read, total int64
+		}
+		readAll := func() []progress {
+			var ps []progress
+			_, r, err := c1.ReaderWithProgress(tt.ctx, func(read, total int64) {
+				ps = append(ps, progress{read, total})
+			})
+			assert.Success(t, err)
+			_, err = io.ReadAll(r)
+			assert.Success(t, err)
+			return ps
+		}
+		assert.Equal(t, "read progress", []progress{{5, 5}}, readAll())
+		assert.Equal(t, "read progress", []progress{{1, -1}, {3, -1}}, readAll())
+		assert.Success(t, <-writeErr)
+		assert.Equal(t, "write progress", []int64{1, 3}, written)
+
+		tt.goDiscardLoop(c2)
+		err := c1.Close(websocket.StatusNormalClosure, "")
+		assert.Success(t, err)
+	})
+
+	t.Run("wireDump", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+		defer cancel()
+
+		var cdump, sdump bytes.Buffer
+		c, s := wstest.Pipe(&websocket.DialOptions{
+			WireDump: &cdump,
+		}, &websocket.AcceptOptions{
+			WireDump: &sdump,
+		})
+		defer c.CloseNow()
+		defer s.CloseNow()
+
+		writeErr := xsync.Go(func() error {
+			return c.Write(ctx, websocket.MessageText, []byte("hello"))
+		})
+		_, _, err := s.Read(ctx)
+		assert.Success(t, err)
+		assert.Success(t, <-writeErr)
+		assert.Success(t, s.CloseNow())
+		assert.Success(t, c.CloseNow())
+
+		// The masked frame written by the client is read by the server.
+		cw, _ := parseWireDump(t, cdump.Bytes())
+		_, sr := parseWireDump(t, sdump.Bytes())
+		assert.Equal(t, "frame length", 2+4+len("hello"), len(cw))
+		assert.Equal(t, "dumped bytes", cw, sr)
+	})
+
+	t.Run("stats", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+		defer cancel()
+
+		c, s := wstest.Pipe(&websocket.DialOptions{
+			CompressionMode: websocket.CompressionContextTakeover,
+		}, &websocket.AcceptOptions{
+			CompressionMode: websocket.CompressionContextTakeover,
+		})
+		defer c.CloseNow()
+		defer s.CloseNow()
+
+		msg := bytes.Repeat([]byte("x"), 1000)
+		writeErr := xsync.Go(func() error {
+			err := c.Write(ctx, websocket.MessageBinary, msg)
+			if err != nil {
+				return err
+			}
+			w, err := c.Writer(ctx, websocket.MessageBinary)
+			if err != nil {
+				return err
+			}
+			_, err = w.Write(msg)
+			if err != nil {
+				return err
+			}
+			err = w.Close()
+			if err != nil {
+				return err
+			}
+			return c.WriteOpts(ctx, websocket.MessageBinary, msg, websocket.NoCompress)
+		})
+		for range 3 {
+			_, _, err := s.Read(ctx)
+			assert.Success(t, err)
+		}
+		assert.Success(t, <-writeErr)
+
+		cs, ss := c.Stats(), s.Stats()
+		assert.Equal(t, "messages written", int64(2), cs.CompressedMessagesWritten)
+		assert.Equal(t, "messages read", int64(2), ss.CompressedMessagesRead)
+		assert.Equal(t, "uncompressed bytes written", int64(2*len(msg)), cs.UncompressedBytesWritten)
+		assert.Equal(t, "uncompressed bytes read", int64(2*len(msg)), ss.UncompressedBytesRead)
+		assert.Equal(t, "compressed bytes", cs.CompressedBytesWritten, ss.CompressedBytesRead)
+		if r := cs.CompressionRatio(); r <= 0 || r > 0.1 {
+			t.Fatalf("unexpected compression ratio %v", r)
+		}
+		assert.Equal(t, "empty ratio", 1.0, websocket.Stats{}.CompressionRatio())
+	})
+
+	t.Run("compressionDictionary", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+		defer cancel()
+
+		dict := xrand.Bytes(1024)
+		c, s := wstest.Pipe(&websocket.DialOptions{
+			CompressionMode:       websocket.CompressionNoContextTakeover,
+			CompressionLevel:      flate.BestCompression,
+			CompressionDictionary: dict,
+		}, &websocket.AcceptOptions{
+			CompressionMode:       websocket.CompressionNoContextTakeover,
+			CompressionLevel:      flate.BestCompression,
+			CompressionDictionary: dict,
+		})
+		defer c.CloseNow()
+		defer s.CloseNow()
+
+		bytesRead := s.RecordBytesRead()
+		s.SetReadLimit(-1)
+
+		writeErr := xsync.Go(func() error {
+			return c.Write(ctx, websocket.MessageBinary, dict)
+		})
+
+		_, b, err := s.Read(ctx)
+		assert.Success(t, err)
+		assert.Equal(t, "message", dict, b)
+		assert.Success(t, <-writeErr)
+
+		// The random message only compresses as it is found in the dictionary.
+		if *bytesRead > 64 {
+			t.Fatalf("expected message compressed with the dictionary but read %d bytes", *bytesRead)
+		}
+	})
+
+	t.Run("writeAsync", func(t *testing.T) {
+		tt, c1, c2 := newConnTest(t, nil, nil)
+
+		errs := make(chan error, 10)
+		for i := range 10 {
+			c1.WriteAsync(websocket.MessageText, []byte(strconv.Itoa(i)), func(err error) {
+				errs <- err
+			})
+		}
+
+		for i := range 10 {
+			_, b, err := c2.Read(tt.ctx)
+			assert.Success(t, err)
+			assert.Equal(t, "message", strconv.Itoa(i), string(b))
+			assert.Success(t, <-errs)
+		}
+
+		c1.CloseNow()
+		c1.WriteAsync(websocket.MessageText, []byte("closed"), func(err error) {
+			errs <- err
+		})
+		assert.ErrorIs(t, net.ErrClosed, <-errs)
+	})
+
+	t.Run("writeAsyncUrgent", func(t *testing.T) {
+		tt, c1, c2 := newConnTest(t, nil, nil)
+
+		msgs := make(chan string, 4)
+		readErr := xsync.Go(func() error {
+			for range 4 {
+				_, b, err := c2.Read(tt.ctx)
+				if err != nil {
+					return err
+				}
+				msgs <- string(b)
+			}
+			return nil
+		})
+
+		// Block the writing goroutine in the first callback so the
+		// following messages stay queued.
+		inDone := make(chan struct{})
+		release := make(chan struct{})
+		c1.WriteAsync(websocket.MessageText, []byte("1"), func(err error) {
+			close(inDone)
+			<-release
+		})
+		<-inDone
+
+		errs := make(chan error, 3)
+		done := func(err error) {
+			errs <- err
+		}
+		c1.WriteAsync(websocket.MessageText, []byte("2"), done)
+		c1.WriteAsync(websocket.MessageText, []byte("3"), done)
+		c1.WriteAsyncOpts(websocket.MessageText, []byte("urgent"), done, websocket.Urgent)
+		close(release)
+
+		for range 3 {
+			assert.Success(t, <-errs)
+		}
+		assert.Success(t, <-readErr)
+		for _, exp := range []string{"1", "urgent", "2", "3"} {
+			assert.Equal(t, "message", exp, <-msgs)
+		}
+	})
+
+	for _, policy := range []websocket.DropPolicy{websocket.DropNewest, websocket.DropOldest} {
+		t.Run(fmt.Sprintf("writeAsyncQueueFull/%v", policy), func(t *testing.T) {
+			tt, c1, c2 := newConnTest(t, &websocket.DialOptions{
+				WriteQueueLength: 1,
+				WriteQueuePolicy: policy,
+			}, &websocket.AcceptOptions{
+				WriteQueueLength: 1,
+				WriteQueuePolicy: policy,
+			})
+
+			msgs := make(chan string, 3)
+			readErr := xsync.Go(func() error {
+				for range 2 {
+					_, b, err := c2.Read(tt.ctx)
+					if err != nil {
+						return err
+					}
+					msgs <- string(b)
+				}
+				return nil
+			})
+
+			// Block the writing goroutine in the first callback so the
+			// following messages stay queued.
+			inDone := make(chan struct{})
+			release := make(chan struct{})
+			c1.WriteAsync(websocket.MessageText, []byte("1"), func(err error) {
+				close(inDone)
+				<-release
+			})
+			<-inDone
+
+			errs := make(chan error, 2)
+			report := func(msg string) func(error) {
+				return func(err error) {
+					if err != nil {
+						err = fmt.Errorf("%v: %w", msg, err)
+					}
+					errs <- err
+				}
+			}
+			c1.WriteAsync(websocket.MessageText, []byte("2"), report("2"))
+			c1.WriteAsync(websocket.MessageText, []byte("3"), report("3"))
+
+			dropped, written := "3", "2"
+			if policy == websocket.DropOldest {
+				dropped, written = "2", "3"
+			}
+			err := <-errs
+			assert.ErrorIs(t, websocket.ErrWriteQueueFull, err)
+			assert.Contains(t, err, dropped+":")
+
+			close(release)
+			assert.Success(t, <-errs)
+			assert.Success(t, <-readErr)
+			assert.Equal(t, "message", "1", <-msgs)
+			assert.Equal(t, "message", written, <-msgs)
+		})
+	}
+
+	t.Run("writeBackpressure", func(t *testing.T) {
+		tt, c1, c2 := newConnTest(t, &websocket.DialOptions{
+			WriteBackpressureTimeout: time.Millisecond * 50,
+		}, &websocket.AcceptOptions{
+			WriteBackpressureTimeout: time.Millisecond * 50,
+		})
+
+		// The peer is not reading so the first write blocks.
+		errs := xsync.Go(func() error {
+			return c1.Write(tt.ctx, websocket.MessageText, []byte("first"))
+		})
+		// Give the first write time to take the writer.
+		time.Sleep(time.Millisecond * 10)
+
+		err := c1.Write(tt.ctx, websocket.MessageText, []byte("second"))
+		assert.ErrorIs(t, websocket.ErrWriteBackpressure, err)
+
+		// The connection is left intact.
+		_, p, err := c2.Read(tt.ctx)
+		assert.Success(t, err)
+		assert.Equal(t, "message", "first", string(p))
+		assert.Success(t, <-errs)
+
+		for _, msg := range []string{"third", "fourth"} {
+			c1.WriteAsync(websocket.MessageText, []byte(msg), nil)
+		}
+		drained := xsync.Go(func() error {
+			return c1.Drain(tt.ctx)
+		})
+		for _, msg := range []string{"third", "fourth"} {
+			_, p, err = c2.Read(tt.ctx)
+			assert.Success(t, err)
+			assert.Equal(t, "message", msg, string(p))
+		}
+		assert.Success(t, <-drained)
+	})
+
+	t.Run("readWriteTimeout", func(t *testing.T) {
+		tt, c1, c2 := newConnTest(t, nil, nil)
+
+		errs := xsync.Go(func() error {
+			return c1.WriteTimeout(time.Second*5, websocket.MessageText, []byte("hello"))
+		})
+		typ, p, err := c2.ReadTimeout(time.Second * 5)
+		assert.Success(t, err)
+		assert.Equal(t, "type", websocket.MessageText, typ)
+		assert.Equal(t, "payload", "hello", string(p))
+		assert.Success(t, <-errs)
+
+		c1.CloseRead(tt.ctx)
+		_, _, err = c2.ReadTimeout(time.Millisecond * 10)
+		assert.ErrorIs(t, context.DeadlineExceeded, err)
+
+		err = c2.WriteTimeout(time.Second, websocket.MessageText, []byte("hello"))
+		assert.ErrorIs(t, net.ErrClosed, err)
+	})
+
+	t.Run("writeMessages", func(t *testing.T) {
+		tt, c1, c2 := newConnTest(t, &websocket.DialOptions{
+			CompressionMode: websocket.CompressionContextTakeover,
+		}, &websocket.AcceptOptions{
+			CompressionMode:      websocket.CompressionContextTakeover,
+			CompressionThreshold: 4,
+		})
+		tt.goEchoLoop(c2)
+
+		msgs := []websocket.Message{
+			{Type: websocket.MessageText, Data: []byte("a")},
+			{Type: websocket.MessageBinary, Data: []byte("compressed")},
+			{Type: websocket.MessageText, Data: []byte{}},
+			{Type: websocket.MessageText, Data: []byte(strings.Repeat("x", 1<<14))},
+		}
+		writeErrs := xsync.Go(func() error {
+			err := c1.WriteMessages(tt.ctx, msgs)
+			if err != nil {
+				return err
+			}
+			return c1.Write(tt.ctx, websocket.MessageText, []byte("after"))
+		})
+
+		for _, m := range append(msgs, websocket.Message{Type: websocket.MessageText, Data: []byte("after")}) {
+			typ, p, err := c1.Read(tt.ctx)
+			assert.Success(t, err)
+			assert.Equal(t, "type", m.Type, typ)
+			assert.Equal(t, "data", string(m.Data), string(p))
+		}
+		assert.Success(t, <-writeErrs)
+
+		err := c1.WriteMessages(tt.ctx, nil)
+		assert.Success(t, err)
+		assert.Success(t, c1.Close(websocket.StatusNormalClosure, ""))
+	})
+
+	t.Run("controlWriteTimeout", func(t *testing.T) {
+		tt, c1, _ := newConnTest(t, &websocket.DialOptions{
+			ControlWriteTimeout: time.Millisecond * 50,
+		}, &websocket.AcceptOptions{
+			ControlWriteTimeout: time.Millisecond * 50,
+		})
+
+		// c2 never reads so the ping cannot be written.
+		start := time.Now()
+		err := c1.Ping(tt.ctx)
+		assert.ErrorIs(t, context.DeadlineExceeded, err)
+		if d := time.Since(start); d > time.Second {
+			t.Fatalf("ping took %v, expected it to be bounded by the control write timeout", d)
+		}
+	})
+
+	t.Run("idleTimeout", func(t *testing.T) {
+		tt, c1, c2 := newConnTest(t, &websocket.DialOptions{
+			IdleTimeout: time.Millisecond * 100,
+		}, &websocket.AcceptOptions{
+			IdleTimeout: time.Millisecond * 100,
+		})
+
+		read := func(c *websocket.Conn) <-chan error {
+			return xsync.Go(func() error {
+				for {
+					_, _, err := c.Read(tt.ctx)
+					if err != nil {
+						return err
+					}
+				}
+			})
+		}
+		readErrs1, readErrs2 := read(c1), read(c2)
+
+		// Pings keep the connection alive past the timeout.
+		for range 4 {
+			err := c2.Ping(tt.ctx)
+			assert.Success(t, err)
+			time.Sleep(time.Millisecond * 50)
 		}
 
-		err := c1.Close(websocket.StatusNormalClosure, "")
-		assert.Success(t, err)
+		assert.Equal(t, "close status", websocket.StatusGoingAway, websocket.CloseStatus(<-readErrs1))
+		assert.Equal(t, "close status", websocket.StatusGoingAway, websocket.CloseStatus(<-readErrs2))
 	})
 
-	t.Run("concurrentWriteError", func(t *testing.T) {
-		tt, c1, _ := newConnTest(t, nil, nil)
+	t.Run("readWriteDeadline", func(t *testing.T) {
+		tt, c1, c2 := newConnTest(t, nil, nil)
 
-		_, err := c1.Writer(tt.ctx, websocket.MessageText)
+		c1.SetWriteDeadline(time.Now().Add(time.Second * 5))
+		c2.SetReadDeadline(time.Now().Add(time.Second * 5))
+		errs := xsync.Go(func() error {
+			return c1.WriteMessage(websocket.MessageText, []byte("hello"))
+		})
+		typ, p, err := c2.ReadMessage()
 		assert.Success(t, err)
+		assert.Equal(t, "type", websocket.MessageText, typ)
+		assert.Equal(t, "payload", "hello", string(p))
+		assert.Success(t, <-errs)
 
-		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
-		defer cancel()
+		c2.SetReadDeadline(time.Time{})
+		errs = xsync.Go(func() error {
+			return c1.WriteMessage(websocket.MessageText, []byte("world"))
+		})
+		_, p, err = c2.ReadMessage()
+		assert.Success(t, err)
+		assert.Equal(t, "payload", "world", string(p))
+		assert.Success(t, <-errs)
 
-		err = c1.Write(ctx, websocket.MessageText, []byte("x"))
-		if !errors.Is(err, context.DeadlineExceeded) {
-			t.Fatalf("unexpected error: %#v", err)
-		}
+		c1.CloseRead(tt.ctx)
+		c2.SetReadDeadline(time.Now().Add(time.Millisecond * 10))
+		_, _, err = c2.ReadMessage()
+		assert.ErrorIs(t, context.DeadlineExceeded, err)
 	})
 
 	t.Run("netConn", func(t *testing.T) {
@@ -316,6 +1386,44 @@ func TestConn(t *testing.T) {
 		assert.Equal(t, "read msg", s, string(b))
 	})
 
+	t.Run("netConn/preserveFraming", func(t *testing.T) {
+		tt, c1, c2 := newConnTest(t, nil, nil)
+
+		n1 := websocket.NetConn(tt.ctx, c1, websocket.MessageBinary, websocket.PreserveFraming)
+		n2 := websocket.NetConn(tt.ctx, c2, websocket.MessageBinary)
+
+		errs := xsync.Go(func() error {
+			for _, msg := range []string{"hello", "", "world", "too long"} {
+				_, err := n2.Write([]byte(msg))
+				if err != nil {
+					return err
+				}
+			}
+			return n2.Close()
+		})
+
+		b := make([]byte, 5)
+		for _, exp := range []string{"hello", "world"} {
+			n, err := n1.Read(b)
+			assert.Success(t, err)
+			assert.Equal(t, "read msg", exp, string(b[:n]))
+		}
+
+		n, err := n1.Read(b)
+		assert.ErrorIs(t, io.ErrShortBuffer, err)
+		assert.Equal(t, "truncated msg", "too l", string(b[:n]))
+
+		_, err = n1.Read(b)
+		assert.Equal(t, "read error", err, io.EOF)
+
+		select {
+		case err := <-errs:
+			assert.Success(t, err)
+		case <-tt.ctx.Done():
+			t.Fatal(tt.ctx.Err())
+		}
+	})
+
 	t.Run("netConn/pastDeadline", func(t *testing.T) {
 		tt, c1, c2 := newConnTest(t, nil, nil)
 
@@ -357,6 +1465,66 @@ func TestConn(t *testing.T) {
 		assert.Success(t, err)
 	})
 
+	t.Run("wsjsonReadOptions", func(t *testing.T) {
+		tt, c1, c2 := newConnTest(t, nil, nil)
+
+		c1.SetReadLimit(16)
+		_ = c2.CloseRead(tt.ctx)
+		exp := strings.Repeat("x", 4096)
+		werr := xsync.Go(func() error {
+			for range 2 {
+				err := wsjson.Write(tt.ctx, c2, exp)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+
+		var act string
+		err := wsjson.ReadWithOptions(tt.ctx, c1, &act, &wsjson.ReadOptions{
+			MaxSize:    8192,
+			BufferSize: 4098,
+		})
+		assert.Success(t, err)
+		assert.Equal(t, "read msg", exp, act)
+
+		// The limit of the connection still applies to other messages.
+		err = wsjson.Read(tt.ctx, c1, &act)
+		assert.ErrorIs(t, websocket.ErrMessageTooBig, err)
+
+		_ = c2.CloseNow()
+		<-werr
+	})
+
+	t.Run("wsjsonBinary", func(t *testing.T) {
+		tt, c1, c2 := newConnTest(t, nil, nil)
+
+		err := wsjson.WriteOpts(tt.ctx, c1, json.RawMessage("\"\xff\""), wsjson.ValidateUTF8)
+		assert.ErrorIs(t, wsjson.ErrInvalidUTF8, err)
+
+		werr := xsync.Go(func() error {
+			return wsjson.WriteBinary(tt.ctx, c1, map[string]int{"a": 1})
+		})
+		var act map[string]int
+		err = wsjson.ReadBinary(tt.ctx, c2, &act)
+		assert.Success(t, err)
+		assert.Equal(t, "read msg", map[string]int{"a": 1}, act)
+		assert.Success(t, <-werr)
+
+		werr = xsync.Go(func() error {
+			err := wsjson.WriteOpts(tt.ctx, c1, "hello", wsjson.ValidateUTF8)
+			if err != nil {
+				return err
+			}
+			_, _, err = c1.Read(tt.ctx)
+			return err
+		})
+		err = wsjson.ReadBinary(tt.ctx, c2, &act)
+		assert.Contains(t, err, "expected binary message")
+		assert.Equal(t, "close status", websocket.StatusUnsupportedData, websocket.CloseStatus(<-werr))
+	})
+
 	t.Run("HTTPClient.Timeout", func(t *testing.T) {
 		tt, c1, c2 := newConnTest(t, &websocket.DialOptions{
 			HTTPClient: &http.Client{Timeout: time.Second * 5},
@@ -397,8 +1565,8 @@ func TestConn(t *testing.T) {
 		assert.Success(t, err2)
 		err1 = c1.CloseNow()
 		err2 = c2.CloseNow()
-		assert.ErrorIs(t, websocket.ErrClosed, err1)
-		assert.ErrorIs(t, websocket.ErrClosed, err2)
+		assert.ErrorIs(t, websocket.ErrConnClosed, err1)
+		assert.ErrorIs(t, websocket.ErrConnClosed, err2)
 	})
 
 	t.Run("MidReadClose", func(t *testing.T) {
@@ -435,13 +1603,414 @@ func TestConn(t *testing.T) {
 
 		_, _, err := c1.Read(tt.ctx)
 		assert.ErrorIs(t, websocket.ErrMessageTooBig, err)
-		assert.Contains(t, err, "read limited at 1025 bytes")
+		assert.Contains(t, err, "MessageText of at least 4096 bytes read limited at 1024 bytes")
+		var tooBig *websocket.MessageTooBigError
+		assert.Equal(t, "MessageTooBigError", true, errors.As(err, &tooBig))
+		assert.Equal(t, "error", websocket.MessageTooBigError{
+			MessageType: websocket.MessageText,
+			Limit:       1024,
+			Read:        1025,
+			Size:        4096,
+		}, *tooBig)
+
+		_ = c2.CloseNow()
+		<-writeDone
+	})
+
+	t.Run("WaitCloseOnError", func(t *testing.T) {
+		tt, c1, c2 := newConnTest(t, &websocket.DialOptions{
+			WaitCloseOnError: true,
+		}, &websocket.AcceptOptions{
+			WaitCloseOnError: true,
+		})
+
+		c1.SetReadLimit(1024)
+		ctx := c2.CloseRead(tt.ctx)
+
+		writeDone := xsync.Go(func() error {
+			return c2.Write(tt.ctx, websocket.MessageText, make([]byte, 4096))
+		})
+
+		// The read returns once c2 echoed the close frame.
+		_, _, err := c1.Read(tt.ctx)
+		assert.ErrorIs(t, websocket.ErrMessageTooBig, err)
+		<-ctx.Done()
+		assert.ErrorIs(t, net.ErrClosed, c1.CloseNow())
+
+		<-writeDone
+	})
+
+	t.Run("ReaderWithLimit", func(t *testing.T) {
+		tt, c1, c2 := newConnTest(t, nil, nil)
+
+		c1.SetReadLimit(16)
+		_ = c2.CloseRead(tt.ctx)
+
+		writeDone := xsync.Go(func() error {
+			for _, n := range []int{4096, 4096, 64} {
+				err := c2.Write(tt.ctx, websocket.MessageBinary, make([]byte, n))
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+
+		for _, limit := range []int64{4096, -1} {
+			_, r, err := c1.ReaderWithLimit(tt.ctx, limit)
+			assert.Success(t, err)
+			b, err := io.ReadAll(r)
+			assert.Success(t, err)
+			assert.Equal(t, "message length", 4096, len(b))
+		}
+
+		// The override does not outlive the message.
+		_, _, err := c1.Read(tt.ctx)
+		assert.ErrorIs(t, websocket.ErrMessageTooBig, err)
+		assert.Contains(t, err, "read limited at 16 bytes")
+
+		_ = c2.CloseNow()
+		<-writeDone
+	})
+
+	t.Run("SetReadLimitMidMessage", func(t *testing.T) {
+		tt, c1, c2 := newConnTest(t, nil, nil)
+
+		c1.SetReadLimit(16)
+		_ = c2.CloseRead(tt.ctx)
+
+		writeDone := xsync.Go(func() error {
+			for range 2 {
+				err := c2.Write(tt.ctx, websocket.MessageBinary, make([]byte, 4096))
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+
+		_, r, err := c1.Reader(tt.ctx)
+		assert.Success(t, err)
+		_, err = io.ReadFull(r, make([]byte, 8))
+		assert.Success(t, err)
+		c1.SetReadLimit(4096)
+		b, err := io.ReadAll(r)
+		assert.Success(t, err)
+		assert.Equal(t, "message length", 4096-8, len(b))
+
+		_, r, err = c1.Reader(tt.ctx)
+		assert.Success(t, err)
+		_, err = io.ReadFull(r, make([]byte, 8))
+		assert.Success(t, err)
+		c1.SetReadLimit(4)
+		_, err = r.Read(make([]byte, 8))
+		var mtbe *websocket.MessageTooBigError
+		assert.Equal(t, "errors.As", true, errors.As(err, &mtbe))
+		assert.Equal(t, "limit", int64(4), mtbe.Limit)
+		assert.Equal(t, "read", int64(8), mtbe.Read)
+		assert.Equal(t, "size", int64(4096), mtbe.Size)
 
 		_ = c2.CloseNow()
 		<-writeDone
 	})
 }
 
+func TestCloseWrite(t *testing.T) {
+	tt, c1, c2 := newConnTest(t, nil, nil)
+
+	writeErr := xsync.Go(func() error {
+		return c2.Write(tt.ctx, websocket.MessageText, []byte("pending"))
+	})
+	closeErr := xsync.Go(func() error {
+		return c1.CloseWrite(websocket.StatusNormalClosure, "done")
+	})
+
+	_, b, err := c1.Read(tt.ctx)
+	assert.Success(t, err)
+	assert.Equal(t, "msg", "pending", string(b))
+	assert.Success(t, <-writeErr)
+
+	readErr := xsync.Go(func() error {
+		_, _, err := c2.Read(tt.ctx)
+		return err
+	})
+	assert.Success(t, <-closeErr)
+
+	err = c1.Write(tt.ctx, websocket.MessageText, []byte("x"))
+	assert.ErrorIs(t, net.ErrClosed, err)
+
+	_, _, err = c1.Read(tt.ctx)
+	assert.Equal(t, "close status", websocket.StatusNormalClosure, websocket.CloseStatus(err))
+	err = <-readErr
+	assert.Equal(t, "close status", websocket.StatusNormalClosure, websocket.CloseStatus(err))
+}
+
+func TestWriteCloseFrame(t *testing.T) {
+	t.Parallel()
+
+	t.Run("success", func(t *testing.T) {
+		tt, c1, c2 := newConnTest(t, nil, nil)
+
+		readErr := xsync.Go(func() error {
+			_, _, err := c2.Read(tt.ctx)
+			return err
+		})
+		err := c1.WriteCloseFrame(tt.ctx, websocket.StatusGoingAway, "draining")
+		assert.Success(t, err)
+
+		err = c1.Write(tt.ctx, websocket.MessageText, []byte("x"))
+		assert.ErrorIs(t, net.ErrClosed, err)
+		_, _, err = c1.Read(tt.ctx)
+		assert.Equal(t, "close status", websocket.StatusGoingAway, websocket.CloseStatus(err))
+		err = <-readErr
+		assert.Equal(t, "close status", websocket.StatusGoingAway, websocket.CloseStatus(err))
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		tt, c1, _ := newConnTest(t, nil, nil)
+
+		ctx, cancel := context.WithTimeout(tt.ctx, time.Millisecond*50)
+		defer cancel()
+		err := c1.WriteCloseFrame(ctx, websocket.StatusNormalClosure, "")
+		assert.ErrorIs(t, context.DeadlineExceeded, err)
+	})
+}
+
+func TestCloseWithContext(t *testing.T) {
+	_, c1, c2 := newConnTest(t, nil, nil)
+	defer c2.CloseNow()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+
+	start := time.Now()
+	err := c1.CloseWithContext(ctx, websocket.StatusNormalClosure, "")
+	assert.ErrorIs(t, context.DeadlineExceeded, err)
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("close took %v, expected it to be bounded by the context", d)
+	}
+}
+
+func TestEmulatedPing(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	client, server := wstest.Pipe(nil, &websocket.AcceptOptions{
+		EmulatedPing: true,
+	})
+	defer client.CloseNow()
+	defer server.CloseNow()
+
+	type readResult struct {
+		typ websocket.MessageType
+		p   []byte
+		err error
+	}
+	reads := make(chan readResult, 1)
+	var progress []int64
+	go func() {
+		typ, r, err := server.ReaderWithProgress(ctx, func(read, total int64) {
+			progress = append(progress, read, total)
+		})
+		if err != nil {
+			reads <- readResult{err: err}
+			return
+		}
+		p, err := io.ReadAll(r)
+		reads <- readResult{typ, p, err}
+	}()
+
+	ping := append(append([]byte(nil), websocket.EmulatedPingPrefix...), "1"...)
+	err := client.Write(ctx, websocket.MessageBinary, ping)
+	assert.Success(t, err)
+
+	typ, p, err := client.Read(ctx)
+	assert.Success(t, err)
+	assert.Equal(t, "pong type", websocket.MessageBinary, typ)
+	assert.Equal(t, "pong", append(append([]byte(nil), websocket.EmulatedPongPrefix...), "1"...), p)
+
+	err = client.Write(ctx, websocket.MessageBinary, []byte("hello"))
+	assert.Success(t, err)
+
+	r := <-reads
+	assert.Success(t, r.err)
+	assert.Equal(t, "message type", websocket.MessageBinary, r.typ)
+	assert.Equal(t, "message", []byte("hello"), r.p)
+	// The ping is not reported.
+	assert.Equal(t, "progress", []int64{5, 5}, progress)
+}
+
+func TestListener(t *testing.T) {
+	t.Parallel()
+
+	l := websocket.NewListener(websocket.MessageBinary, nil)
+	defer l.Close()
+	s := httptest.NewServer(l)
+	defer s.Close()
+
+	errs := xsync.Go(func() error {
+		nc, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		defer nc.Close()
+		_, err = io.CopyN(nc, nc, 5)
+		return err
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+	c, _, err := websocket.Dial(ctx, s.URL, nil)
+	assert.Success(t, err)
+	defer c.CloseNow()
+
+	err = c.Write(ctx, websocket.MessageBinary, []byte("hello"))
+	assert.Success(t, err)
+	_, p, err := c.Read(ctx)
+	assert.Success(t, err)
+	assert.Equal(t, "echo", []byte("hello"), p)
+
+	c.CloseRead(ctx)
+	assert.Success(t, <-errs)
+
+	err = l.Close()
+	assert.Success(t, err)
+	_, err = l.Accept()
+	assert.ErrorIs(t, net.ErrClosed, err)
+}
+
+func TestCloseReceived(t *testing.T) {
+	tt, c1, c2 := newConnTest(t, nil, nil)
+
+	_, ok := c1.CloseReceived()
+	assert.Equal(t, "close received", false, ok)
+
+	c2.CloseRead(tt.ctx)
+	err := c1.Close(websocket.StatusGoingAway, "shutting down")
+	assert.Success(t, err)
+
+	for _, c := range []*websocket.Conn{c1, c2} {
+		ce, ok := c.CloseReceived()
+		assert.Equal(t, "close received", true, ok)
+		assert.Equal(t, "close error", &websocket.CloseError{
+			Code:   websocket.StatusGoingAway,
+			Reason: "shutting down",
+		}, ce)
+	}
+}
+
+func TestHandshakeRequest(t *testing.T) {
+	t.Parallel()
+
+	c1, c2 := wstest.Pipe(&websocket.DialOptions{
+		HTTPHeader: http.Header{"Authorization": {"Bearer token"}},
+	}, nil)
+	defer c1.CloseNow()
+	defer c2.CloseNow()
+
+	for _, c := range []*websocket.Conn{c1, c2} {
+		r := c.HandshakeRequest()
+		assert.Equal(t, "authorization", "Bearer token", r.Header.Get("Authorization"))
+		assert.Equal(t, "host", "example.com", r.Host)
+		assert.Equal(t, "body", http.NoBody, r.Body)
+	}
+}
+
+func TestStrictUTF8(t *testing.T) {
+	tt, c1, c2 := newConnTest(t, &websocket.DialOptions{
+		StrictUTF8: true,
+	}, &websocket.AcceptOptions{
+		StrictUTF8: true,
+	})
+
+	writeErr := xsync.Go(func() error {
+		err := c1.Write(tt.ctx, websocket.MessageText, []byte("κόσμε"))
+		if err != nil {
+			return err
+		}
+		return c1.Write(tt.ctx, websocket.MessageBinary, []byte("\xff"))
+	})
+	_, b, err := c2.Read(tt.ctx)
+	assert.Success(t, err)
+	assert.Equal(t, "msg", "κόσμε", string(b))
+	_, _, err = c2.Read(tt.ctx)
+	assert.Success(t, err)
+	assert.Success(t, <-writeErr)
+
+	readErr := xsync.Go(func() error {
+		// The invalid rune is split across frames.
+		w, err := c1.Writer(tt.ctx, websocket.MessageText)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write([]byte("hello \xe4"))
+		if err != nil {
+			return err
+		}
+		_, err = w.Write([]byte("\xb8\x96 \xff"))
+		if err != nil {
+			return err
+		}
+		err = w.Close()
+		if err != nil {
+			return err
+		}
+
+		_, _, err = c1.Read(tt.ctx)
+		return err
+	})
+
+	_, _, err = c2.Read(tt.ctx)
+	assert.Contains(t, err, "received invalid UTF-8 in text message")
+	c2.CloseNow()
+
+	err = <-readErr
+	assert.Equal(t, "close status", websocket.StatusInvalidFramePayloadData, websocket.CloseStatus(err))
+}
+
+func TestConnLogger(t *testing.T) {
+	var buf syncBuffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	tt, c1, c2 := newConnTest(t, &websocket.DialOptions{
+		Logger: logger,
+	}, &websocket.AcceptOptions{
+		Logger: logger,
+	})
+
+	c2.CloseRead(tt.ctx)
+
+	err := c1.Close(websocket.StatusNormalClosure, "bye")
+	assert.Success(t, err)
+
+	c2.CloseNow()
+
+	logs := buf.String()
+	assert.Contains(t, logs, "accepted WebSocket connection")
+	assert.Contains(t, logs, "dialed WebSocket")
+	assert.Contains(t, logs, `msg="sent close frame" code=StatusNormalClosure reason=bye`)
+	assert.Contains(t, logs, `msg="received close frame" code=StatusNormalClosure reason=bye`)
+}
+
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
 func TestWasm(t *testing.T) {
 	t.Parallel()
 	if os.Getenv("CI") == "" {
@@ -452,6 +2021,7 @@ func TestWasm(t *testing.T) {
 		err := echoServer(w, r, &websocket.AcceptOptions{
 			Subprotocols:       []string{"echo"},
 			InsecureSkipVerify: true,
+			EmulatedPing:       true,
 		})
 		if err != nil {
 			t.Error(err)
@@ -680,6 +2250,28 @@ func echoServer(w http.ResponseWriter, r *http.Request, opts *websocket.AcceptOp
 	return assertCloseStatus(websocket.StatusNormalClosure, err)
 }
 
+// parseWireDump returns the bytes written and read in the records of a
+// WireDump.
+func parseWireDump(tb testing.TB, b []byte) (written, read []byte) {
+	for len(b) > 0 {
+		if len(b) < 13 {
+			tb.Fatalf("truncated record header: %x", b)
+		}
+		n := int(binary.BigEndian.Uint32(b[9:13]))
+		p := b[13 : 13+n]
+		switch b[0] {
+		case websocket.WireDumpWrite:
+			written = append(written, p...)
+		case websocket.WireDumpRead:
+			read = append(read, p...)
+		default:
+			tb.Fatalf("unexpected direction %q", b[0])
+		}
+		b = b[13+n:]
+	}
+	return written, read
+}
+
 func assertEcho(tb testing.TB, ctx context.Context, c *websocket.Conn) {
 	exp := xrand.String(xrand.Int(131072))
 
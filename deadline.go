@@ -0,0 +1,54 @@
+package websocket
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// SetReadDeadline sets the deadline of ReadMessage. The zero value means no
+// deadline. It does not affect the methods taking a context.
+func (c *Conn) SetReadDeadline(t time.Time) {
+	storeDeadline(&c.readDeadline, t)
+}
+
+// SetWriteDeadline sets the deadline of WriteMessage. The zero value means no
+// deadline. It does not affect the methods taking a context.
+func (c *Conn) SetWriteDeadline(t time.Time) {
+	storeDeadline(&c.writeDeadline, t)
+}
+
+// ReadMessage is like Read but bounded by the deadline set with
+// SetReadDeadline instead of a context, to ease porting code where passing
+// a context to every call is impractical.
+//
+// If the deadline expires, the connection is closed and an error wrapping
+// context.DeadlineExceeded is returned.
+func (c *Conn) ReadMessage() (MessageType, []byte, error) {
+	d := c.readDeadline.Load()
+	if d == nil {
+		return c.Read(context.Background())
+	}
+	return c.ReadTimeout(time.Until(*d))
+}
+
+// WriteMessage is like Write but bounded by the deadline set with
+// SetWriteDeadline instead of a context.
+//
+// If the deadline expires, the connection is closed and an error wrapping
+// context.DeadlineExceeded is returned.
+func (c *Conn) WriteMessage(typ MessageType, p []byte) error {
+	d := c.writeDeadline.Load()
+	if d == nil {
+		return c.Write(context.Background(), typ, p)
+	}
+	return c.WriteTimeout(time.Until(*d), typ, p)
+}
+
+func storeDeadline(p *atomic.Pointer[time.Time], t time.Time) {
+	if t.IsZero() {
+		p.Store(nil)
+		return
+	}
+	p.Store(&t)
+}
@@ -7,11 +7,17 @@ import (
 	"bytes"
 	"context"
 	"crypto/rand"
+	"crypto/tls"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
+	"net"
 	"net/http"
+	"net/http/httptrace"
 	"net/url"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -27,15 +33,87 @@ type DialOptions struct {
 	HTTPClient *http.Client
 
 	// HTTPHeader specifies the HTTP headers included in the handshake request.
+	//
+	// When Proxy is set, a Proxy-Authorization header is sent to the proxy
+	// in the CONNECT request instead.
 	HTTPHeader http.Header
 
+	// AuthToken optionally returns a bearer token sent as the Authorization
+	// header, overriding any set in HTTPHeader. It is called on every dial
+	// with the same options so that reconnects, e.g. of a wssession.Session,
+	// send a fresh token when the previous one expired.
+	AuthToken func(ctx context.Context) (string, error)
+
+	// Proxy optionally overrides the proxy of HTTPClient's transport for this dial.
+	// The transport must be an *http.Transport, which is the default.
+	//
+	// The returned URL's scheme may be http, https, socks5 or socks5h.
+	// Credentials in the URL's user info are sent as the Proxy-Authorization
+	// header or as the SOCKS5 username and password.
+	// See http.Transport.Proxy for details and http.ProxyURL for a helper.
+	//
+	// Connections through http and https proxies are tunneled with CONNECT
+	// for ws URLs too, as forward proxies usually drop the Upgrade header
+	// of the requests they forward.
+	Proxy func(*http.Request) (*url.URL, error)
+
+	// TLSConfig optionally overrides the TLS configuration of HTTPClient's transport
+	// for this dial, e.g. to trust a custom CA or set the ServerName.
+	// The transport must be an *http.Transport, which is the default.
+	TLSConfig *tls.Config
+
+	// NetDialContext optionally overrides how HTTPClient's transport opens network
+	// connections for this dial.
+	// The transport must be an *http.Transport, which is the default.
+	NetDialContext func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	// Rand optionally overrides crypto/rand.Reader as the source of the
+	// Sec-WebSocket-Key and of the masking keys of frames, e.g. for
+	// deterministic tests or a hardened RNG. It is read by every write so it
+	// must be safe for concurrent use if shared between connections.
+	//
+	// Masking keys must be unpredictable to the application to protect
+	// intermediaries from cache poisoning, see RFC 6455 section 10.3.
+	Rand io.Reader
+
+	// HandshakeTimeout bounds the time spent on the opening handshake in addition
+	// to the context passed to Dial. Unlike HTTPClient.Timeout it does not affect
+	// other requests made with the client.
+	//
+	// It is a convenience so that the context passed to Dial does not have to
+	// be derived just for the handshake. Neither affects the returned connection.
+	HandshakeTimeout time.Duration
+
+	// MaxRedirects is the number of redirects of the handshake request that
+	// Dial follows. Location URLs with the ws and wss schemes are requested
+	// over http and https. Defaults to 10, like http.Client.
+	//
+	// If negative, redirects are not followed and a redirect response fails
+	// the dial with a *HandshakeError holding its Location header.
+	MaxRedirects int
+
 	// Host optionally overrides the Host HTTP header to send. If empty, the value
 	// of URL.Host will be used.
+	//
+	// For wss URLs, Host is also the TLS server name unless TLSConfig sets
+	// ServerName so that dialing a load balancer by IP address verifies the
+	// certificate of the virtual host. This requires HTTPClient's transport
+	// to be an *http.Transport, otherwise only the header is overridden.
 	Host string
 
-	// Subprotocols lists the WebSocket subprotocols to negotiate with the server.
+	// Query is appended to the query of the URL, after any parameters the
+	// URL already has. Values are escaped so tokens with special characters
+	// can be passed as is.
+	Query url.Values
+
+	// Subprotocols lists the WebSocket subprotocols to negotiate with the server
+	// in order of preference.
 	Subprotocols []string
 
+	// SubprotocolRequired fails the dial if the server does not select one of
+	// Subprotocols instead of silently falling back to the default protocol.
+	SubprotocolRequired bool
+
 	// CompressionMode controls the compression mode.
 	// Defaults to CompressionDisabled.
 	//
@@ -48,6 +126,60 @@ type DialOptions struct {
 	// for CompressionContextTakeover.
 	CompressionThreshold int
 
+	// CompressionLevel is the flate compression level, from flate.HuffmanOnly
+	// to flate.BestCompression. Defaults to flate.BestSpeed, including when set
+	// to flate.NoCompression as it is the zero value. Use CompressionDisabled
+	// to send messages uncompressed.
+	//
+	// Higher levels improve the compression ratio at the cost of CPU time.
+	CompressionLevel int
+
+	// CompressionDictionary is an optional preset dictionary for compressing and
+	// decompressing messages without context takeover. Protocols with known
+	// message shapes can use one to improve the compression ratio of small messages.
+	//
+	// It is not negotiated so both peers must be configured with the same
+	// dictionary. It is ignored with context takeover as the previous messages
+	// serve as the dictionary.
+	CompressionDictionary []byte
+
+	// CompressionBackend optionally replaces compress/flate as the DEFLATE
+	// implementation, e.g. with github.com/klauspost/compress/flate.
+	CompressionBackend CompressionBackend
+
+	// StrictUTF8 enables validation of text messages and close reasons as required
+	// by RFC 6455. When invalid UTF-8 is received, the read returns an error and the
+	// connection is closed with StatusInvalidFramePayloadData.
+	//
+	// Validation is incremental so messages are still streamed but every byte of
+	// every text message is inspected, which adds measurable CPU overhead.
+	// It is disabled by default as most applications validate their payloads
+	// while decoding them anyway.
+	StrictUTF8 bool
+
+	// ConcurrentReads allows Read and ReadTimeout to be called from multiple
+	// goroutines, e.g. by a pool of workers. Each call reads a whole message
+	// and calls are served one at a time in the order they block, which is
+	// first in first out in practice but not guaranteed.
+	//
+	// Reader must not be called while Read calls are in progress.
+	// SetReadLimit applies to the messages whose reads start after it
+	// returns and a message exceeding the limit fails the connection for
+	// all readers.
+	ConcurrentReads bool
+
+	// RejectMaskedFrames closes the connection with StatusProtocolError when
+	// the server sends a masked frame, as RFC 6455 requires of clients.
+	// By default masked frames from the server are unmasked and accepted.
+	RejectMaskedFrames bool
+
+	// EmulatedPing makes Conn.Ping in Wasm send an emulated ping that servers
+	// accepting with AcceptOptions.EmulatedPing respond to, as browsers cannot
+	// send ping frames.
+	//
+	// It has no effect outside of Wasm where Conn.Ping sends a ping frame.
+	EmulatedPing bool
+
 	// OnPingReceived is an optional callback invoked synchronously when a ping frame is received.
 	//
 	// The payload contains the application data of the ping frame.
@@ -55,6 +187,16 @@ type DialOptions struct {
 	// To avoid blocking, any expensive processing should be performed asynchronously using a goroutine.
 	OnPingReceived func(ctx context.Context, payload []byte) bool
 
+	// OnPingReply is like OnPingReceived but also returns the payload of the
+	// pong, which must be at most 125 bytes. It allows protocols to piggyback
+	// data such as sequence numbers on pings and their pongs. If set,
+	// OnPingReceived is not called.
+	//
+	// A peer pinging with Ping or PingWithPayload fails with PongMismatchError
+	// when the payload changes so it should receive the pong with
+	// OnPongReceived instead.
+	OnPingReply func(ctx context.Context, payload []byte) (pong []byte, respond bool)
+
 	// OnPongReceived is an optional callback invoked synchronously when a pong frame is received.
 	//
 	// The payload contains the application data of the pong frame.
@@ -63,6 +205,117 @@ type DialOptions struct {
 	// Unlike OnPingReceived, this callback does not return a value because a pong frame
 	// is a response to a ping and does not trigger any further frame transmission.
 	OnPongReceived func(ctx context.Context, payload []byte)
+
+	// OnCloseReceived is an optional callback invoked synchronously when a close frame
+	// is received, once the close frame is echoed.
+	//
+	// It allows recording why the peer disconnected in one place instead of
+	// inspecting the error at every Read site.
+	// It is called without locks held so it may call methods of the Conn, e.g. CloseNow.
+	// To avoid blocking, any expensive processing should be performed asynchronously using a goroutine.
+	OnCloseReceived func(ctx context.Context, code StatusCode, reason string)
+
+	// OnCloseSent is an optional callback invoked synchronously after a close frame
+	// is written, whether initiating the close handshake or echoing the peer's.
+	// Like OnCloseReceived, it is called without locks held.
+	// To avoid blocking, any expensive processing should be performed asynchronously using a goroutine.
+	OnCloseSent func(ctx context.Context, code StatusCode, reason string)
+
+	// OnStateChange is an optional callback invoked with the new state of the
+	// connection on each transition, starting with StateOpen once the handshake
+	// completed. See Conn.State.
+	//
+	// It is called without locks held so it may call methods of the Conn, e.g.
+	// CloseNow. Calls are serialized and in the order of the transitions, so a
+	// transition may be reported by another goroutine already calling it.
+	// To avoid blocking, any expensive processing should be performed asynchronously using a goroutine.
+	OnStateChange func(s State)
+
+	// OnReservedFrame is an optional callback invoked synchronously when a frame
+	// with a reserved opcode (0x3-0x7 or 0xB-0xF) is received, to prototype
+	// extensions. The payload is unmasked.
+	// If the callback is nil or returns false, the connection fails with
+	// StatusProtocolError.
+	//
+	// Frames with reserved opcodes must not be fragmented. Payloads of the
+	// non-control opcodes 0x3-0x7 are bounded by the read limit, or 32768 bytes
+	// if it is disabled, and payloads of the control opcodes 0xB-0xF by the
+	// 125 byte limit of control frames.
+	OnReservedFrame func(ctx context.Context, opcode int, payload []byte) bool
+
+	// Extensions are offered to the server in order, after
+	// permessage-deflate. Extensions the server accepts transform every data
+	// message of the connection. See Extension.
+	Extensions []Extension
+
+	// WriteQueueLength is the number of messages Conn.WriteAsync can queue.
+	// Defaults to 64.
+	WriteQueueLength int
+
+	// WriteQueuePolicy selects the message dropped when Conn.WriteAsync is
+	// called with a full queue. Defaults to DropNewest.
+	WriteQueuePolicy DropPolicy
+
+	// WriteBackpressureTimeout, if set, bounds how long a write waits for the
+	// previous message to be written before failing with ErrWriteBackpressure,
+	// which lets servers shed load without closing the connection.
+	//
+	// Only the wait behind another write is bounded. A write already blocked
+	// because the peer is not reading is not, as abandoning a partially
+	// written frame would corrupt the connection. Bound it with the context
+	// instead, which closes the connection when it expires.
+	WriteBackpressureTimeout time.Duration
+
+	// WaitCloseOnError makes a read that fails the connection, e.g. on a
+	// protocol error or a message exceeding the read limit, wait up to 5s for
+	// the peer's close frame like Close does before closing the connection.
+	// Otherwise the close frame is sent and the connection is left for the
+	// application to close, which may drop the close frame before a slow peer
+	// reads it.
+	WaitCloseOnError bool
+
+	// ControlWriteTimeout bounds writing a control frame, e.g. the pong
+	// answering a ping or the close frame of Close. Defaults to 5s. Shrink it
+	// to detect stuck peers sooner or extend it for links with a high RTT.
+	ControlWriteTimeout time.Duration
+
+	// IdleTimeout, if set, closes the connection with StatusGoingAway when
+	// nothing, not even a pong, was received from the peer for the duration.
+	// If the peer does not answer the close frame within another IdleTimeout,
+	// the connection is closed without completing the handshake. Combined
+	// with periodic pings it reaps connections to dead peers.
+	//
+	// Frames are only received while reading so keep reading, e.g. with
+	// CloseRead.
+	IdleTimeout time.Duration
+
+	// Clock, if set, is the source of time of the timeouts of the connection
+	// in place of the system clock. It is meant for tests, see Clock.
+	Clock Clock
+
+	// WireDump, if set, receives a copy of the raw bytes read from and
+	// written to the connection after the handshake, decrypted if TLS is
+	// used, to debug interoperability issues at the wire level.
+	//
+	// Each read and write is dumped as a record of a direction byte,
+	// WireDumpRead or WireDumpWrite, the time in nanoseconds since the Unix
+	// epoch as a big endian uint64, the length of the bytes as a big endian
+	// uint32 and the bytes. Records are written atomically so WireDump may be
+	// shared by connections but they cannot be told apart.
+	//
+	// Errors writing to WireDump are ignored. Writes are synchronous so a slow
+	// WireDump slows down the connection.
+	WireDump io.Writer
+
+	// Metrics, if set, collects metrics about the connection.
+	Metrics Metrics
+
+	// Logger is an optional logger for connection lifecycle events.
+	//
+	// Handshake results and negotiated extensions, close frames sent and received,
+	// and connections failed due to protocol errors are logged.
+	// Successful events are logged at the debug level and failures at the warn level.
+	Logger *slog.Logger
 }
 
 func (opts *DialOptions) cloneWithDefaults(ctx context.Context) (context.Context, context.CancelFunc, *DialOptions) {
@@ -75,19 +328,35 @@ func (opts *DialOptions) cloneWithDefaults(ctx context.Context) (context.Context
 	if o.HTTPClient == nil {
 		o.HTTPClient = http.DefaultClient
 	}
+	timeout := o.HandshakeTimeout
 	if o.HTTPClient.Timeout > 0 {
-		ctx, cancel = context.WithTimeout(ctx, o.HTTPClient.Timeout)
+		if timeout <= 0 || o.HTTPClient.Timeout < timeout {
+			timeout = o.HTTPClient.Timeout
+		}
 
 		newClient := *o.HTTPClient
 		newClient.Timeout = 0
 		o.HTTPClient = &newClient
 	}
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	}
 	if o.HTTPHeader == nil {
 		o.HTTPHeader = http.Header{}
 	}
+	maxRedirects := o.MaxRedirects
+	if maxRedirects == 0 {
+		maxRedirects = 10
+	}
 	newClient := *o.HTTPClient
 	oldCheckRedirect := o.HTTPClient.CheckRedirect
 	newClient.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if maxRedirects < 0 {
+			return http.ErrUseLastResponse
+		}
+		if len(via) > maxRedirects {
+			return fmt.Errorf("stopped after %d redirects", maxRedirects)
+		}
 		switch req.URL.Scheme {
 		case "ws":
 			req.URL.Scheme = "http"
@@ -104,6 +373,196 @@ func (opts *DialOptions) cloneWithDefaults(ctx context.Context) (context.Context
 	return ctx, cancel, &o
 }
 
+// transport returns a clone of HTTPClient's transport with the transport
+// options applied or nil if none are set.
+func (opts *DialOptions) transport() (*http.Transport, error) {
+	serverName := opts.tlsServerName()
+	onlyServerName := opts.Proxy == nil && opts.TLSConfig == nil && opts.NetDialContext == nil
+	if onlyServerName && serverName == "" {
+		return nil, nil
+	}
+
+	rt := opts.HTTPClient.Transport
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	t, ok := rt.(*http.Transport)
+	if !ok {
+		if onlyServerName {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("HTTPClient.Transport must be an *http.Transport to apply transport options but got %T", rt)
+	}
+	t = t.Clone()
+
+	if opts.Proxy != nil {
+		t.Proxy = opts.Proxy
+		if pa := opts.HTTPHeader.Get("Proxy-Authorization"); pa != "" {
+			t.ProxyConnectHeader = t.ProxyConnectHeader.Clone()
+			if t.ProxyConnectHeader == nil {
+				t.ProxyConnectHeader = http.Header{}
+			}
+			t.ProxyConnectHeader.Set("Proxy-Authorization", pa)
+		}
+	}
+	if opts.TLSConfig != nil {
+		t.TLSClientConfig = opts.TLSConfig.Clone()
+	}
+	if serverName != "" && (t.TLSClientConfig == nil || t.TLSClientConfig.ServerName == "") {
+		if opts.TLSConfig == nil {
+			t.TLSClientConfig = t.TLSClientConfig.Clone()
+		}
+		if t.TLSClientConfig == nil {
+			t.TLSClientConfig = &tls.Config{}
+		}
+		t.TLSClientConfig.ServerName = serverName
+	}
+	if opts.NetDialContext != nil {
+		t.DialContext = opts.NetDialContext
+		// DialTLSContext would otherwise bypass NetDialContext for wss.
+		t.DialTLSContext = nil
+	}
+	if opts.Proxy != nil {
+		tunnelHTTP(t, opts.Proxy)
+	}
+	return t, nil
+}
+
+// tunnelHTTP makes t tunnel http requests through http and https proxies
+// with CONNECT, as it does for https requests, instead of forwarding them.
+func tunnelHTTP(t *http.Transport, proxy func(*http.Request) (*url.URL, error)) {
+	var mu sync.Mutex
+	tunnels := make(map[string]*url.URL)
+	t.Proxy = func(r *http.Request) (*url.URL, error) {
+		pu, err := proxy(r)
+		if err != nil || pu == nil || r.URL.Scheme != "http" || (pu.Scheme != "http" && pu.Scheme != "https") {
+			return pu, err
+		}
+		addr := r.URL.Host
+		if r.URL.Port() == "" {
+			addr = net.JoinHostPort(r.URL.Hostname(), "80")
+		}
+		mu.Lock()
+		tunnels[addr] = pu
+		mu.Unlock()
+		// Dial directly so that DialContext can open the tunnel.
+		return nil, nil
+	}
+
+	dial := t.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	t.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		mu.Lock()
+		pu := tunnels[addr]
+		mu.Unlock()
+		if pu == nil {
+			return dial(ctx, network, addr)
+		}
+		return dialTunnel(ctx, t, dial, pu, addr)
+	}
+}
+
+// dialTunnel opens a CONNECT tunnel to addr through the proxy at pu.
+func dialTunnel(ctx context.Context, t *http.Transport, dial func(ctx context.Context, network, addr string) (net.Conn, error), pu *url.URL, addr string) (_ net.Conn, err error) {
+	defer errd.Wrap(&err, "failed to dial proxy tunnel")
+
+	paddr := pu.Host
+	if pu.Port() == "" {
+		port := "80"
+		if pu.Scheme == "https" {
+			port = "443"
+		}
+		paddr = net.JoinHostPort(pu.Hostname(), port)
+	}
+	c, err := dial(ctx, "tcp", paddr)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			c.Close()
+		}
+	}()
+
+	if pu.Scheme == "https" {
+		cfg := t.TLSClientConfig.Clone()
+		if cfg == nil {
+			cfg = &tls.Config{}
+		}
+		cfg.ServerName = pu.Hostname()
+		tc := tls.Client(c, cfg)
+		err = tc.HandshakeContext(ctx)
+		if err != nil {
+			return nil, err
+		}
+		c = tc
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: t.ProxyConnectHeader.Clone(),
+	}
+	if req.Header == nil {
+		req.Header = http.Header{}
+	}
+	if pu.User != nil {
+		pass, _ := pu.User.Password()
+		req.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(pu.User.Username()+":"+pass)))
+	}
+
+	stop := context.AfterFunc(ctx, func() {
+		c.Close()
+	})
+	err = req.Write(c)
+	var resp *http.Response
+	br := bufio.NewReader(c)
+	if err == nil {
+		resp, err = http.ReadResponse(br, req)
+	}
+	if !stop() {
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("proxy responded with status %v", resp.Status)
+	}
+	if br.Buffered() > 0 {
+		b, _ := br.Peek(br.Buffered())
+		return &tunnelConn{Conn: c, r: io.MultiReader(bytes.NewReader(b), c)}, nil
+	}
+	return c, nil
+}
+
+// tunnelConn is a tunnel with bytes the proxy sent after its CONNECT
+// response.
+type tunnelConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *tunnelConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// tlsServerName returns the TLS server name implied by Host or an empty
+// string if Host is empty or an IP address.
+func (opts *DialOptions) tlsServerName() string {
+	host := opts.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if net.ParseIP(host) != nil {
+		return ""
+	}
+	return host
+}
+
 // Dial performs a WebSocket handshake on url.
 //
 // The response is the WebSocket handshake response from the server.
@@ -118,19 +577,65 @@ func (opts *DialOptions) cloneWithDefaults(ctx context.Context) (context.Context
 //
 // URLs with http/https schemes will work and are interpreted as ws/wss.
 func Dial(ctx context.Context, u string, opts *DialOptions) (*Conn, *http.Response, error) {
-	return dial(ctx, u, opts, nil)
+	return dial(ctx, u, opts)
 }
 
-func dial(ctx context.Context, urls string, opts *DialOptions, rand io.Reader) (_ *Conn, _ *http.Response, err error) {
+// DialUnix is like Dial but connects to the server listening on the unix
+// socket at socketPath. requestPath is the path and query of the handshake
+// request, e.g. "/ws?room=1".
+//
+// The Host header defaults to localhost. opts.NetDialContext is ignored.
+func DialUnix(ctx context.Context, socketPath, requestPath string, opts *DialOptions) (*Conn, *http.Response, error) {
+	var o DialOptions
+	if opts != nil {
+		o = *opts
+	}
+	o.NetDialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, "unix", socketPath)
+	}
+	if !strings.HasPrefix(requestPath, "/") {
+		requestPath = "/" + requestPath
+	}
+	return Dial(ctx, "ws://localhost"+requestPath, &o)
+}
+
+func dial(ctx context.Context, urls string, opts *DialOptions) (_ *Conn, _ *http.Response, err error) {
 	defer errd.Wrap(&err, "failed to WebSocket dial")
 
+	start := time.Now()
 	var cancel context.CancelFunc
 	ctx, cancel, opts = opts.cloneWithDefaults(ctx)
 	if cancel != nil {
 		defer cancel()
 	}
+	defer func() {
+		if err != nil && opts.Logger != nil {
+			opts.Logger.Warn("failed to dial WebSocket", "url", urls, "error", err)
+		}
+	}()
+
+	err = validateCompressionLevel(opts.CompressionLevel)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = validateExtensions(opts.Extensions)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	t, err := opts.transport()
+	if err != nil {
+		return nil, nil, err
+	}
+	if t != nil {
+		// The handshake connection is never reused so any idle
+		// connections left behind by a failed handshake are closed.
+		defer t.CloseIdleConnections()
+		opts.HTTPClient.Transport = t
+	}
 
-	secWebSocketKey, err := secWebSocketKey(rand)
+	secWebSocketKey, err := secWebSocketKey(opts.Rand)
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to generate Sec-WebSocket-Key: %w", err)
 	}
@@ -140,7 +645,16 @@ func dial(ctx context.Context, urls string, opts *DialOptions, rand io.Reader) (
 		copts = opts.CompressionMode.opts()
 	}
 
-	resp, err := handshakeRequest(ctx, urls, opts, copts, secWebSocketKey)
+	// The response body hides the underlying connection so it is
+	// recorded for UnderlyingConn.
+	var netConn net.Conn
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			netConn = info.Conn
+		},
+	}
+
+	resp, err := handshakeRequest(ctx, urls, opts, copts, secWebSocketKey, trace)
 	if err != nil {
 		return nil, resp, err
 	}
@@ -159,37 +673,87 @@ func dial(ctx context.Context, urls string, opts *DialOptions, rand io.Reader) (
 			b, _ := io.ReadAll(r)
 			respBody.Close()
 			resp.Body = io.NopCloser(bytes.NewReader(b))
+
+			var he *HandshakeError
+			if errors.As(err, &he) {
+				he.Body = b
+			}
 		}
 	}()
 
-	copts, err = verifyServerResponse(opts, copts, secWebSocketKey, resp)
+	copts, exts, err := verifyServerResponse(opts, copts, secWebSocketKey, resp)
 	if err != nil {
-		return nil, resp, err
+		return nil, resp, newHandshakeError(resp, err)
+	}
+	if copts != nil {
+		copts.setFlateParams(opts.CompressionLevel, opts.CompressionDictionary, opts.CompressionBackend)
 	}
 
 	rwc, ok := respBody.(io.ReadWriteCloser)
 	if !ok {
 		return nil, resp, fmt.Errorf("response body is not a io.ReadWriteCloser: %T", respBody)
 	}
+	if opts.WireDump != nil {
+		rwc = newWireDump(rwc, opts.WireDump)
+	}
+
+	if opts.Logger != nil {
+		opts.Logger.Debug("dialed WebSocket",
+			"url", urls,
+			"subprotocol", resp.Header.Get("Sec-WebSocket-Protocol"),
+			"extensions", resp.Header.Get("Sec-WebSocket-Extensions"),
+		)
+	}
+
+	hr := resp.Request.Clone(context.Background())
+	hr.Body = http.NoBody
+
+	if opts.Metrics != nil {
+		opts.Metrics.ObserveHandshakeDuration(time.Since(start))
+	}
 
 	return newConn(connConfig{
-		subprotocol:    resp.Header.Get("Sec-WebSocket-Protocol"),
-		rwc:            rwc,
-		client:         true,
-		copts:          copts,
-		flateThreshold: opts.CompressionThreshold,
-		onPingReceived: opts.OnPingReceived,
-		onPongReceived: opts.OnPongReceived,
-		br:             getBufioReader(rwc),
-		bw:             getBufioWriter(rwc),
+		subprotocol:              resp.Header.Get("Sec-WebSocket-Protocol"),
+		extHeader:                resp.Header.Get("Sec-WebSocket-Extensions"),
+		handshakeReq:             hr,
+		rwc:                      rwc,
+		netConn:                  netConn,
+		rand:                     opts.Rand,
+		tlsState:                 resp.TLS,
+		client:                   true,
+		copts:                    copts,
+		extensions:               exts,
+		flateThreshold:           opts.CompressionThreshold,
+		strictUTF8:               opts.StrictUTF8,
+		concurrentReads:          opts.ConcurrentReads,
+		rejectMaskedFrames:       opts.RejectMaskedFrames,
+		onPingReceived:           opts.OnPingReceived,
+		onPingReply:              opts.OnPingReply,
+		onPongReceived:           opts.OnPongReceived,
+		onCloseReceived:          opts.OnCloseReceived,
+		onCloseSent:              opts.OnCloseSent,
+		onStateChange:            opts.OnStateChange,
+		onReservedFrame:          opts.OnReservedFrame,
+		writeQueueLength:         opts.WriteQueueLength,
+		writeQueuePolicy:         opts.WriteQueuePolicy,
+		writeBackpressureTimeout: opts.WriteBackpressureTimeout,
+		waitCloseOnError:         opts.WaitCloseOnError,
+		controlWriteTimeout:      opts.ControlWriteTimeout,
+		idleTimeout:              opts.IdleTimeout,
+		clock:                    opts.Clock,
+		logger:                   opts.Logger,
+		metrics:                  opts.Metrics,
+		br:                       getBufioReader(rwc),
+		bw:                       getBufioWriter(rwc),
 	}), resp, nil
 }
 
-func handshakeRequest(ctx context.Context, urls string, opts *DialOptions, copts *compressionOptions, secWebSocketKey string) (*http.Response, error) {
+func handshakeRequest(ctx context.Context, urls string, opts *DialOptions, copts *compressionOptions, secWebSocketKey string, trace *httptrace.ClientTrace) (*http.Response, error) {
 	u, err := url.Parse(urls)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse url: %w", err)
 	}
+	addQuery(u, opts.Query)
 
 	switch u.Scheme {
 	case "ws":
@@ -205,10 +769,22 @@ func handshakeRequest(ctx context.Context, urls string, opts *DialOptions, copts
 	if err != nil {
 		return nil, fmt.Errorf("failed to create new http request: %w", err)
 	}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
 	if len(opts.Host) > 0 {
 		req.Host = opts.Host
 	}
 	req.Header = opts.HTTPHeader.Clone()
+	if opts.AuthToken != nil {
+		token, err := opts.AuthToken(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get auth token: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if opts.Proxy != nil {
+		// Sent in the CONNECT request instead so the server never sees it.
+		req.Header.Del("Proxy-Authorization")
+	}
 	req.Header.Set("Connection", "Upgrade")
 	req.Header.Set("Upgrade", "websocket")
 	req.Header.Set("Sec-WebSocket-Version", "13")
@@ -216,14 +792,23 @@ func handshakeRequest(ctx context.Context, urls string, opts *DialOptions, copts
 	if len(opts.Subprotocols) > 0 {
 		req.Header.Set("Sec-WebSocket-Protocol", strings.Join(opts.Subprotocols, ","))
 	}
+	var offers []string
 	if copts != nil {
-		req.Header.Set("Sec-WebSocket-Extensions", copts.String())
+		offers = append(offers, copts.offers()...)
+	}
+	offers = append(offers, offerExtensions(opts.Extensions)...)
+	if len(offers) > 0 {
+		req.Header.Set("Sec-WebSocket-Extensions", strings.Join(offers, ", "))
 	}
 
 	resp, err := opts.HTTPClient.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to send handshake request: %w", err)
 	}
+	if resp.Request == nil {
+		// Custom transports may not set it.
+		resp.Request = req
+	}
 	return resp, nil
 }
 
@@ -234,42 +819,59 @@ func secWebSocketKey(rr io.Reader) (string, error) {
 	b := make([]byte, 16)
 	_, err := io.ReadFull(rr, b)
 	if err != nil {
-		return "", fmt.Errorf("failed to read random data from rand.Reader: %w", err)
+		return "", fmt.Errorf("failed to read random data: %w", err)
 	}
 	return base64.StdEncoding.EncodeToString(b), nil
 }
 
-func verifyServerResponse(opts *DialOptions, copts *compressionOptions, secWebSocketKey string, resp *http.Response) (*compressionOptions, error) {
+func verifyServerResponse(opts *DialOptions, copts *compressionOptions, secWebSocketKey string, resp *http.Response) (*compressionOptions, []negotiatedExtension, error) {
 	if resp.StatusCode != http.StatusSwitchingProtocols {
-		return nil, fmt.Errorf("expected handshake response status code %v but got %v", http.StatusSwitchingProtocols, resp.StatusCode)
+		// RFC 6455 4.4 rejects unsupported versions with 426 or 400, other
+		// responses, e.g. from an auth proxy, may echo the header.
+		versionRejected := resp.StatusCode == http.StatusUpgradeRequired || resp.StatusCode == http.StatusBadRequest
+		if versions := headerTokens(resp.Header, "Sec-WebSocket-Version"); versionRejected && len(versions) > 0 {
+			return nil, nil, &VersionError{Requested: "13", Supported: versions}
+		}
+		return nil, nil, fmt.Errorf("expected handshake response status code %v but got %v", http.StatusSwitchingProtocols, resp.StatusCode)
 	}
 
 	if !headerContainsTokenIgnoreCase(resp.Header, "Connection", "Upgrade") {
-		return nil, fmt.Errorf("WebSocket protocol violation: Connection header %q does not contain Upgrade", resp.Header.Get("Connection"))
+		return nil, nil, fmt.Errorf("%w: Connection header %q does not contain Upgrade", ErrProtocolViolation, resp.Header.Get("Connection"))
 	}
 
 	if !headerContainsTokenIgnoreCase(resp.Header, "Upgrade", "WebSocket") {
-		return nil, fmt.Errorf("WebSocket protocol violation: Upgrade header %q does not contain websocket", resp.Header.Get("Upgrade"))
+		return nil, nil, fmt.Errorf("%w: Upgrade header %q does not contain websocket", ErrProtocolViolation, resp.Header.Get("Upgrade"))
 	}
 
 	if resp.Header.Get("Sec-WebSocket-Accept") != secWebSocketAccept(secWebSocketKey) {
-		return nil, fmt.Errorf("WebSocket protocol violation: invalid Sec-WebSocket-Accept %q, key %q",
+		return nil, nil, fmt.Errorf("%w: invalid Sec-WebSocket-Accept %q, key %q", ErrProtocolViolation,
 			resp.Header.Get("Sec-WebSocket-Accept"),
 			secWebSocketKey,
 		)
 	}
 
-	err := verifySubprotocol(opts.Subprotocols, resp)
+	err := verifySubprotocol(opts.Subprotocols, opts.SubprotocolRequired, resp)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	return verifyServerExtensions(copts, resp.Header)
+	negotiated, rest, err := acceptedExtensions(opts.Extensions, websocketExtensions(resp.Header))
+	if err != nil {
+		return nil, nil, err
+	}
+	copts, err = verifyServerExtensions(copts, rest)
+	if err != nil {
+		return nil, nil, err
+	}
+	return copts, negotiated, nil
 }
 
-func verifySubprotocol(subprotos []string, resp *http.Response) error {
+func verifySubprotocol(subprotos []string, required bool, resp *http.Response) error {
 	proto := resp.Header.Get("Sec-WebSocket-Protocol")
 	if proto == "" {
+		if required && len(subprotos) > 0 {
+			return fmt.Errorf("server did not select any of the subprotocols %q", subprotos)
+		}
 		return nil
 	}
 
@@ -279,18 +881,17 @@ func verifySubprotocol(subprotos []string, resp *http.Response) error {
 		}
 	}
 
-	return fmt.Errorf("WebSocket protocol violation: unexpected Sec-WebSocket-Protocol from server: %q", proto)
+	return fmt.Errorf("%w: unexpected Sec-WebSocket-Protocol from server: %q", ErrProtocolViolation, proto)
 }
 
-func verifyServerExtensions(copts *compressionOptions, h http.Header) (*compressionOptions, error) {
-	exts := websocketExtensions(h)
+func verifyServerExtensions(copts *compressionOptions, exts []websocketExtension) (*compressionOptions, error) {
 	if len(exts) == 0 {
 		return nil, nil
 	}
 
 	ext := exts[0]
 	if ext.name != "permessage-deflate" || len(exts) > 1 || copts == nil {
-		return nil, fmt.Errorf("WebSocket protcol violation: unsupported extensions from server: %+v", exts[1:])
+		return nil, fmt.Errorf("%w: unsupported extensions from server: %+v", ErrProtocolViolation, exts[1:])
 	}
 
 	_copts := *copts
@@ -309,8 +910,18 @@ func verifyServerExtensions(copts *compressionOptions, h http.Header) (*compress
 			// We can't adjust the deflate window, but decoding with a larger window is acceptable.
 			continue
 		}
+		if bits, ok := strings.CutPrefix(p, "client_max_window_bits="); ok {
+			// Accepts the offer with client_max_window_bits. We compress with
+			// a 32 KiB window so with a smaller one we only decompress.
+			n, err := strconv.Atoi(bits)
+			if err != nil || n < 8 || n > 15 {
+				return nil, fmt.Errorf("%w: invalid permessage-deflate parameter: %q", ErrProtocolViolation, p)
+			}
+			copts.uncompressedWrites = n < 15
+			continue
+		}
 
-		return nil, fmt.Errorf("unsupported permessage-deflate parameter: %q", p)
+		return nil, fmt.Errorf("%w: unsupported permessage-deflate parameter: %q", ErrProtocolViolation, p)
 	}
 
 	return copts, nil
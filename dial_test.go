@@ -3,15 +3,26 @@
 package websocket_test
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
 	"io"
+	"log"
 	"maps"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -31,7 +42,6 @@ func TestBadDials(t *testing.T) {
 			name   string
 			url    string
 			opts   *websocket.DialOptions
-			rand   util.ReaderFunc
 			nilCtx bool
 		}{
 			{
@@ -48,8 +58,10 @@ func TestBadDials(t *testing.T) {
 			},
 			{
 				name: "badReader",
-				rand: func(p []byte) (int, error) {
-					return 0, io.EOF
+				opts: &websocket.DialOptions{
+					Rand: util.ReaderFunc(func(p []byte) (int, error) {
+						return 0, io.EOF
+					}),
 				},
 			},
 			{
@@ -57,6 +69,14 @@ func TestBadDials(t *testing.T) {
 				url:    "http://localhost",
 				nilCtx: true,
 			},
+			{
+				name: "badCompressionLevel",
+				url:  "http://localhost",
+				opts: &websocket.DialOptions{
+					CompressionMode:  websocket.CompressionContextTakeover,
+					CompressionLevel: 10,
+				},
+			},
 		}
 
 		for _, tc := range testCases {
@@ -71,11 +91,7 @@ func TestBadDials(t *testing.T) {
 					defer cancel()
 				}
 
-				if tc.rand == nil {
-					tc.rand = rand.Reader.Read
-				}
-
-				_, _, err := websocket.ExportedDial(ctx, tc.url, tc.opts, tc.rand)
+				_, _, err := websocket.Dial(ctx, tc.url, tc.opts)
 				assert.Error(t, err)
 			})
 		}
@@ -95,6 +111,75 @@ func TestBadDials(t *testing.T) {
 			}),
 		})
 		assert.Contains(t, err, "failed to WebSocket dial: expected handshake response status code 101 but got 0")
+		assert.ErrorIs(t, websocket.ErrBadHandshake, err)
+	})
+
+	t.Run("handshakeError", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+
+		_, resp, err := websocket.Dial(ctx, "ws://example.com", &websocket.DialOptions{
+			HTTPClient: mockHTTPClient(func(*http.Request) (*http.Response, error) {
+				h := http.Header{}
+				h.Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				h.Set("Set-Cookie", "session=secret")
+				return &http.Response{
+					StatusCode: http.StatusUnauthorized,
+					Header:     h,
+					Body:       io.NopCloser(strings.NewReader(strings.Repeat("x", 2048))),
+				}, nil
+			}),
+		})
+		var he *websocket.HandshakeError
+		assert.Equal(t, "errors.As", true, errors.As(err, &he))
+		assert.Equal(t, "status code", http.StatusUnauthorized, he.StatusCode)
+		assert.Equal(t, "header", http.Header{
+			"Www-Authenticate": {`Bearer error="invalid_token"`},
+		}, he.Header)
+		assert.Equal(t, "body", strings.Repeat("x", 1024), string(he.Body))
+		assert.Equal(t, "response status code", http.StatusUnauthorized, resp.StatusCode)
+	})
+
+	t.Run("versionError", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+
+		_, _, err := websocket.Dial(ctx, "ws://example.com", &websocket.DialOptions{
+			HTTPClient: mockHTTPClient(func(*http.Request) (*http.Response, error) {
+				h := http.Header{}
+				h.Set("Sec-WebSocket-Version", "14, 15")
+				return &http.Response{
+					StatusCode: http.StatusUpgradeRequired,
+					Header:     h,
+					Body:       io.NopCloser(strings.NewReader("")),
+				}, nil
+			}),
+		})
+		var verr *websocket.VersionError
+		assert.Equal(t, "errors.As", true, errors.As(err, &verr))
+		assert.Equal(t, "supported versions", []string{"14", "15"}, verr.Supported)
+		assert.ErrorIs(t, websocket.ErrBadHandshake, err)
+
+		// An auth proxy echoing the header.
+		_, _, err = websocket.Dial(ctx, "ws://example.com", &websocket.DialOptions{
+			HTTPClient: mockHTTPClient(func(*http.Request) (*http.Response, error) {
+				h := http.Header{}
+				h.Set("Sec-WebSocket-Version", "13")
+				return &http.Response{
+					StatusCode: http.StatusForbidden,
+					Header:     h,
+					Body:       io.NopCloser(strings.NewReader("")),
+				}, nil
+			}),
+		})
+		assert.Equal(t, "errors.As", false, errors.As(err, &verr))
+		var herr *websocket.HandshakeError
+		assert.Equal(t, "errors.As", true, errors.As(err, &herr))
+		assert.Equal(t, "status code", http.StatusForbidden, herr.StatusCode)
 	})
 
 	t.Run("badBody", func(t *testing.T) {
@@ -174,6 +259,182 @@ func Test_verifyHostOverride(t *testing.T) {
 	}
 }
 
+func TestDialQuery(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name  string
+		url   string
+		query url.Values
+		exp   string
+	}{
+		{
+			name: "none",
+			url:  "ws://example.com/path?token=x%2By",
+			exp:  "token=x%2By",
+		},
+		{
+			name:  "noQuery",
+			url:   "ws://example.com/path",
+			query: url.Values{"token": {"a b&c=d"}},
+			exp:   "token=a+b%26c%3Dd",
+		},
+		{
+			name:  "merged",
+			url:   "ws://example.com/path?token=x%2By&a",
+			query: url.Values{"token": {"z"}, "b": {"1", "2"}},
+			exp:   "token=x%2By&a&b=1&b=2&token=z",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+			defer cancel()
+
+			_, _, err := websocket.Dial(ctx, tc.url, &websocket.DialOptions{
+				Query: tc.query,
+				HTTPClient: mockHTTPClient(func(r *http.Request) (*http.Response, error) {
+					assert.Equal(t, "path", "/path", r.URL.Path)
+					assert.Equal(t, "query", tc.exp, r.URL.RawQuery)
+					return nil, errors.New("done")
+				}),
+			})
+			assert.Contains(t, err, "done")
+		})
+	}
+}
+
+func TestDialAuthToken(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	var tokens []string
+	opts := &websocket.DialOptions{
+		HTTPHeader: http.Header{"Authorization": {"Basic stale"}},
+		AuthToken: func(context.Context) (string, error) {
+			return fmt.Sprintf("token%d", len(tokens)), nil
+		},
+		HTTPClient: mockHTTPClient(func(r *http.Request) (*http.Response, error) {
+			tokens = append(tokens, r.Header.Get("Authorization"))
+			return nil, errors.New("done")
+		}),
+	}
+	for range 2 {
+		_, _, err := websocket.Dial(ctx, "ws://example.com", opts)
+		assert.Contains(t, err, "done")
+	}
+	assert.Equal(t, "tokens", []string{"Bearer token0", "Bearer token1"}, tokens)
+
+	opts.AuthToken = func(context.Context) (string, error) {
+		return "", errors.New("expired")
+	}
+	_, _, err := websocket.Dial(ctx, "ws://example.com", opts)
+	assert.Contains(t, err, "failed to get auth token: expired")
+	assert.Equal(t, "dials", 2, len(tokens))
+}
+
+func TestDialRand(t *testing.T) {
+	t.Parallel()
+
+	keys := make(chan string, 1)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keys <- r.Header.Get("Sec-WebSocket-Key")
+		err := echoServer(w, r, nil)
+		assert.Success(t, err)
+	}))
+	defer s.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	var n atomic.Int64
+	c, _, err := websocket.Dial(ctx, s.URL, &websocket.DialOptions{
+		Rand: util.ReaderFunc(func(p []byte) (int, error) {
+			n.Add(int64(len(p)))
+			for i := range p {
+				p[i] = 1
+			}
+			return len(p), nil
+		}),
+	})
+	assert.Success(t, err)
+	assert.Equal(t, "key", base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 16)), <-keys)
+
+	assertEcho(t, ctx, c)
+	// The masking key of the echoed message.
+	assert.Equal(t, "random bytes read", int64(16+4), n.Load())
+	assertClose(t, c)
+}
+
+func TestDialHostOverrideTLS(t *testing.T) {
+	t.Parallel()
+
+	type handshake struct {
+		host, serverName string
+	}
+	handshakes := make(chan handshake, 1)
+	s := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handshakes <- handshake{r.Host, r.TLS.ServerName}
+		c, err := websocket.Accept(w, r, nil)
+		if err != nil {
+			return
+		}
+		c.Close(websocket.StatusNormalClosure, "")
+	}))
+	// The unknownHost handshake error is expected.
+	s.Config.ErrorLog = log.New(io.Discard, "", 0)
+	s.StartTLS()
+	defer s.Close()
+
+	// The server certificate is valid for example.com and 127.0.0.1.
+	u := strings.Replace(s.URL, "https", "wss", 1)
+
+	t.Run("serverName", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+
+		c, _, err := websocket.Dial(ctx, u, &websocket.DialOptions{
+			HTTPClient: s.Client(),
+			Host:       "example.com",
+		})
+		assert.Success(t, err)
+		defer c.CloseNow()
+		assert.Equal(t, "handshake", handshake{"example.com", "example.com"}, <-handshakes)
+	})
+
+	t.Run("tlsConfigServerName", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+
+		tlsConfig := s.Client().Transport.(*http.Transport).TLSClientConfig.Clone()
+		tlsConfig.ServerName = "example.com"
+		c, _, err := websocket.Dial(ctx, u, &websocket.DialOptions{
+			TLSConfig: tlsConfig,
+			Host:      "example.net:443",
+		})
+		assert.Success(t, err)
+		defer c.CloseNow()
+		assert.Equal(t, "handshake", handshake{"example.net:443", "example.com"}, <-handshakes)
+	})
+
+	t.Run("unknownHost", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+
+		_, _, err := websocket.Dial(ctx, u, &websocket.DialOptions{
+			HTTPClient: s.Client(),
+			Host:       "example.net",
+		})
+		var certErr *tls.CertificateVerificationError
+		assert.Equal(t, "certificate verification error", true, errors.As(err, &certErr))
+	})
+}
+
 type mockBody struct {
 	*bytes.Buffer
 }
@@ -187,8 +448,10 @@ func Test_verifyServerHandshake(t *testing.T) {
 
 	testCases := []struct {
 		name     string
+		dialOpts *websocket.DialOptions
 		response func(w http.ResponseWriter)
 		success  bool
+		errIs    error
 	}{
 		{
 			name: "badStatus",
@@ -243,6 +506,7 @@ func Test_verifyServerHandshake(t *testing.T) {
 				w.WriteHeader(http.StatusSwitchingProtocols)
 			},
 			success: false,
+			errIs:   websocket.ErrProtocolViolation,
 		},
 		{
 			name: "unsupportedDeflateParam",
@@ -253,6 +517,74 @@ func Test_verifyServerHandshake(t *testing.T) {
 				w.WriteHeader(http.StatusSwitchingProtocols)
 			},
 			success: false,
+			errIs:   websocket.ErrProtocolViolation,
+		},
+		{
+			name: "deflateClientMaxWindowBits",
+			dialOpts: &websocket.DialOptions{
+				CompressionMode: websocket.CompressionContextTakeover,
+			},
+			response: func(w http.ResponseWriter) {
+				w.Header().Set("Connection", "Upgrade")
+				w.Header().Set("Upgrade", "websocket")
+				w.Header().Set("Sec-WebSocket-Extensions", "permessage-deflate; client_max_window_bits=15")
+				w.WriteHeader(http.StatusSwitchingProtocols)
+			},
+			success: true,
+		},
+		{
+			name: "deflateSmallClientMaxWindowBits",
+			dialOpts: &websocket.DialOptions{
+				CompressionMode: websocket.CompressionContextTakeover,
+			},
+			response: func(w http.ResponseWriter) {
+				w.Header().Set("Connection", "Upgrade")
+				w.Header().Set("Upgrade", "websocket")
+				w.Header().Set("Sec-WebSocket-Extensions", "permessage-deflate; client_max_window_bits=10")
+				w.WriteHeader(http.StatusSwitchingProtocols)
+			},
+			success: true,
+		},
+		{
+			name: "deflateInvalidClientMaxWindowBits",
+			dialOpts: &websocket.DialOptions{
+				CompressionMode: websocket.CompressionContextTakeover,
+			},
+			response: func(w http.ResponseWriter) {
+				w.Header().Set("Connection", "Upgrade")
+				w.Header().Set("Upgrade", "websocket")
+				w.Header().Set("Sec-WebSocket-Extensions", "permessage-deflate; client_max_window_bits=16")
+				w.WriteHeader(http.StatusSwitchingProtocols)
+			},
+			success: false,
+			errIs:   websocket.ErrProtocolViolation,
+		},
+		{
+			name: "subprotocolRequired",
+			dialOpts: &websocket.DialOptions{
+				Subprotocols:        []string{"echo"},
+				SubprotocolRequired: true,
+			},
+			response: func(w http.ResponseWriter) {
+				w.Header().Set("Connection", "Upgrade")
+				w.Header().Set("Upgrade", "websocket")
+				w.WriteHeader(http.StatusSwitchingProtocols)
+			},
+			success: false,
+		},
+		{
+			name: "subprotocolRequiredSelected",
+			dialOpts: &websocket.DialOptions{
+				Subprotocols:        []string{"echo"},
+				SubprotocolRequired: true,
+			},
+			response: func(w http.ResponseWriter) {
+				w.Header().Set("Connection", "Upgrade")
+				w.Header().Set("Upgrade", "websocket")
+				w.Header().Set("Sec-WebSocket-Protocol", "echo")
+				w.WriteHeader(http.StatusSwitchingProtocols)
+			},
+			success: true,
 		},
 		{
 			name: "success",
@@ -283,15 +615,21 @@ func Test_verifyServerHandshake(t *testing.T) {
 				resp.Header.Set("Sec-WebSocket-Accept", websocket.SecWebSocketAccept(key))
 			}
 
-			opts := &websocket.DialOptions{
-				Subprotocols: strings.Split(r.Header.Get("Sec-WebSocket-Protocol"), ","),
+			opts := tc.dialOpts
+			if opts == nil {
+				opts = &websocket.DialOptions{
+					Subprotocols: strings.Split(r.Header.Get("Sec-WebSocket-Protocol"), ","),
+				}
 			}
-			_, err = websocket.VerifyServerResponse(opts, websocket.CompressionModeOpts(opts.CompressionMode), key, resp)
+			_, _, err = websocket.VerifyServerResponse(opts, websocket.CompressionModeOpts(opts.CompressionMode), key, resp)
 			if tc.success {
 				assert.Success(t, err)
 			} else {
 				assert.Error(t, err)
 			}
+			if tc.errIs != nil {
+				assert.ErrorIs(t, tc.errIs, err)
+			}
 		})
 	}
 }
@@ -308,6 +646,76 @@ func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
 	return f(r)
 }
 
+func TestDialCompressionOffers(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	var offers string
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	c, _, err := websocket.Dial(ctx, "ws://example.com", &websocket.DialOptions{
+		CompressionMode: websocket.CompressionNoContextTakeover,
+		HTTPClient: mockHTTPClient(func(r *http.Request) (*http.Response, error) {
+			offers = r.Header.Get("Sec-WebSocket-Extensions")
+			h := http.Header{}
+			h.Set("Connection", "Upgrade")
+			h.Set("Upgrade", "websocket")
+			h.Set("Sec-WebSocket-Accept", websocket.SecWebSocketAccept(r.Header.Get("Sec-WebSocket-Key")))
+			h.Set("Sec-WebSocket-Extensions", "permessage-deflate; client_no_context_takeover; server_no_context_takeover")
+			return &http.Response{
+				StatusCode: http.StatusSwitchingProtocols,
+				Header:     h,
+				Body:       c1,
+			}, nil
+		}),
+	})
+	assert.Success(t, err)
+	defer c.CloseNow()
+
+	assert.Equal(t, "offers", "permessage-deflate; client_no_context_takeover; server_no_context_takeover; client_max_window_bits, "+
+		"permessage-deflate; client_no_context_takeover; server_no_context_takeover", offers)
+}
+
+func TestDialSmallClientWindow(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	c, _, err := websocket.Dial(ctx, "ws://example.com", &websocket.DialOptions{
+		CompressionMode: websocket.CompressionContextTakeover,
+		HTTPClient: mockHTTPClient(func(r *http.Request) (*http.Response, error) {
+			h := http.Header{}
+			h.Set("Connection", "Upgrade")
+			h.Set("Upgrade", "websocket")
+			h.Set("Sec-WebSocket-Accept", websocket.SecWebSocketAccept(r.Header.Get("Sec-WebSocket-Key")))
+			h.Set("Sec-WebSocket-Extensions", "permessage-deflate; client_max_window_bits=10")
+			return &http.Response{
+				StatusCode: http.StatusSwitchingProtocols,
+				Header:     h,
+				Body:       c1,
+			}, nil
+		}),
+	})
+	assert.Success(t, err)
+	defer c.CloseNow()
+
+	errs := xsync.Go(func() error {
+		return c.Write(ctx, websocket.MessageText, bytes.Repeat([]byte("a"), 1024))
+	})
+	// The header with a 16 bit length and mask key, then the payload.
+	b := make([]byte, 2+2+4+1024)
+	_, err = io.ReadFull(c2, b)
+	assert.Success(t, err)
+	assert.Success(t, <-errs)
+	// FIN and the text opcode without RSV1, so uncompressed.
+	assert.Equal(t, "first byte", byte(0x81), b[0])
+}
+
 func TestDialRedirect(t *testing.T) {
 	t.Parallel()
 
@@ -333,6 +741,72 @@ func TestDialRedirect(t *testing.T) {
 	assert.Contains(t, err, "failed to WebSocket dial: WebSocket protocol violation: Upgrade header \"meow\" does not contain websocket")
 }
 
+func TestDialMaxRedirects(t *testing.T) {
+	t.Parallel()
+
+	// redirects redirects /0 to /1 and so on up to /n.
+	redirects := func(n int) *http.Client {
+		return mockHTTPClient(func(r *http.Request) (*http.Response, error) {
+			resp := &http.Response{
+				Header: http.Header{},
+				Body:   http.NoBody,
+			}
+			i, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/"))
+			if i < n {
+				resp.Header.Set("Location", fmt.Sprintf("ws://example.com/%d", i+1))
+				resp.StatusCode = http.StatusFound
+				return resp, nil
+			}
+			resp.Header.Set("Connection", "Upgrade")
+			resp.Header.Set("Upgrade", "meow")
+			resp.StatusCode = http.StatusSwitchingProtocols
+			return resp, nil
+		})
+	}
+
+	testCases := []struct {
+		name         string
+		redirects    int
+		maxRedirects int
+		err          string
+	}{
+		{name: "default", redirects: 10, err: "does not contain websocket"},
+		{name: "defaultExceeded", redirects: 11, err: "stopped after 10 redirects"},
+		{name: "max", redirects: 2, maxRedirects: 2, err: "does not contain websocket"},
+		{name: "maxExceeded", redirects: 3, maxRedirects: 2, err: "stopped after 2 redirects"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+			defer cancel()
+
+			_, _, err := websocket.Dial(ctx, "ws://example.com/0", &websocket.DialOptions{
+				HTTPClient:   redirects(tc.redirects),
+				MaxRedirects: tc.maxRedirects,
+			})
+			assert.Contains(t, err, tc.err)
+		})
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+
+		_, _, err := websocket.Dial(ctx, "ws://example.com/0", &websocket.DialOptions{
+			HTTPClient:   redirects(1),
+			MaxRedirects: -1,
+		})
+		var he *websocket.HandshakeError
+		assert.Equal(t, "errors.As", true, errors.As(err, &he))
+		assert.Equal(t, "status code", http.StatusFound, he.StatusCode)
+		assert.Equal(t, "location", "ws://example.com/1", he.Header.Get("Location"))
+	})
+}
+
 type forwardProxy struct {
 	hc *http.Client
 }
@@ -347,6 +821,37 @@ func (fc *forwardProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), time.Second*10)
 	defer cancel()
 
+	if r.Method == http.MethodConnect {
+		sc, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		defer sc.Close()
+		w.WriteHeader(http.StatusOK)
+		c, brw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		brw.Flush()
+
+		errc1 := xsync.Go(func() error {
+			_, err := io.Copy(c, sc)
+			return err
+		})
+		errc2 := xsync.Go(func() error {
+			_, err := io.Copy(sc, brw)
+			return err
+		})
+		select {
+		case <-errc1:
+		case <-errc2:
+		case <-ctx.Done():
+		}
+		return
+	}
+
 	r = r.WithContext(ctx)
 	r.RequestURI = ""
 	resp, err := fc.hc.Do(r)
@@ -416,3 +921,294 @@ func TestDialViaProxy(t *testing.T) {
 	assertEcho(t, ctx, c)
 	assertClose(t, c)
 }
+
+func TestDialProxy(t *testing.T) {
+	t.Parallel()
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := echoServer(w, r, nil)
+		assert.Success(t, err)
+	}))
+	defer s.Close()
+
+	t.Run("http", func(t *testing.T) {
+		var proxyMethod, proxyAuth string
+		fp := newForwardProxy()
+		ps := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			proxyMethod = r.Method
+			proxyAuth = r.Header.Get("Proxy-Authorization")
+			fp.ServeHTTP(w, r)
+		}))
+		defer ps.Close()
+
+		psu, err := url.Parse(ps.URL)
+		assert.Success(t, err)
+		psu.User = url.UserPassword("user", "pass")
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+		// ws URLs are tunneled too.
+		c, _, err := websocket.Dial(ctx, strings.Replace(s.URL, "http://", "ws://", 1), &websocket.DialOptions{
+			Proxy: http.ProxyURL(psu),
+		})
+		assert.Success(t, err)
+		assert.Equal(t, "proxy method", http.MethodConnect, proxyMethod)
+		assert.Equal(t, "proxy authorization", "Basic dXNlcjpwYXNz", proxyAuth)
+
+		assertEcho(t, ctx, c)
+		assertClose(t, c)
+	})
+
+	t.Run("socks5", func(t *testing.T) {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		assert.Success(t, err)
+		defer l.Close()
+		errs := xsync.Go(func() error {
+			return serveSOCKS5(l, "user", "pass")
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+		c, _, err := websocket.Dial(ctx, s.URL, &websocket.DialOptions{
+			Proxy: http.ProxyURL(&url.URL{
+				Scheme: "socks5",
+				User:   url.UserPassword("user", "pass"),
+				Host:   l.Addr().String(),
+			}),
+		})
+		assert.Success(t, err)
+
+		assertEcho(t, ctx, c)
+		assertClose(t, c)
+		assert.Success(t, <-errs)
+	})
+
+	t.Run("badTransport", func(t *testing.T) {
+		_, _, err := websocket.Dial(context.Background(), s.URL, &websocket.DialOptions{
+			HTTPClient: mockHTTPClient(func(r *http.Request) (*http.Response, error) {
+				return nil, errors.New("unreachable")
+			}),
+			Proxy: http.ProxyFromEnvironment,
+		})
+		assert.Contains(t, err, "HTTPClient.Transport must be an *http.Transport to apply transport options")
+	})
+}
+
+// serveSOCKS5 serves a single SOCKS5 CONNECT request authenticated with
+// the given username and password.
+func serveSOCKS5(l net.Listener, user, pass string) error {
+	c, err := l.Accept()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	br := bufio.NewReader(c)
+
+	read := func(n int) []byte {
+		b := make([]byte, n)
+		if err == nil {
+			_, err = io.ReadFull(br, b)
+		}
+		return b
+	}
+
+	greeting := read(2)
+	read(int(greeting[1]))
+	if err != nil {
+		return err
+	}
+	_, err = c.Write([]byte{5, 2})
+	if err != nil {
+		return err
+	}
+
+	read(1)
+	gotUser := string(read(int(read(1)[0])))
+	gotPass := string(read(int(read(1)[0])))
+	if err != nil {
+		return err
+	}
+	if gotUser != user || gotPass != pass {
+		c.Write([]byte{1, 1})
+		return errors.New("invalid SOCKS5 credentials")
+	}
+	_, err = c.Write([]byte{1, 0})
+	if err != nil {
+		return err
+	}
+
+	req := read(4)
+	var host string
+	switch req[3] {
+	case 1:
+		host = net.IP(read(4)).String()
+	case 3:
+		host = string(read(int(read(1)[0])))
+	default:
+		return errors.New("unsupported SOCKS5 address type")
+	}
+	port := read(2)
+	if err != nil {
+		return err
+	}
+
+	target, err := net.Dial("tcp", net.JoinHostPort(host, fmt.Sprint(int(port[0])<<8|int(port[1]))))
+	if err != nil {
+		return err
+	}
+	defer target.Close()
+	_, err = c.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+	if err != nil {
+		return err
+	}
+
+	errc1 := xsync.Go(func() error {
+		_, err := io.Copy(target, br)
+		return err
+	})
+	errc2 := xsync.Go(func() error {
+		_, err := io.Copy(c, target)
+		return err
+	})
+	select {
+	case <-errc1:
+	case <-errc2:
+	}
+	c.Close()
+	target.Close()
+	return nil
+}
+
+func TestDialTransportOptions(t *testing.T) {
+	t.Parallel()
+
+	s := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := echoServer(w, r, nil)
+		assert.Success(t, err)
+	}))
+	defer s.Close()
+
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(s.Certificate())
+
+	var dialed string
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+	c, _, err := websocket.Dial(ctx, s.URL, &websocket.DialOptions{
+		TLSConfig: &tls.Config{
+			RootCAs: rootCAs,
+		},
+		NetDialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			dialed = addr
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		},
+	})
+	assert.Success(t, err)
+	assert.Equal(t, "dialed address", s.Listener.Addr().String(), dialed)
+
+	assertEcho(t, ctx, c)
+	assertClose(t, c)
+}
+
+func TestDialUnix(t *testing.T) {
+	t.Parallel()
+
+	socketPath := filepath.Join(t.TempDir(), "ws.sock")
+	l, err := net.Listen("unix", socketPath)
+	assert.Success(t, err)
+	s := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "request URI", "/ws?room=1", r.RequestURI)
+			err := echoServer(w, r, nil)
+			assert.Success(t, err)
+		}),
+	}
+	go s.Serve(l)
+	defer s.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+	c, _, err := websocket.DialUnix(ctx, socketPath, "ws?room=1", nil)
+	assert.Success(t, err)
+
+	assertEcho(t, ctx, c)
+	assertClose(t, c)
+}
+
+func TestDialHandshakeTimeout(t *testing.T) {
+	t.Parallel()
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer s.Close()
+
+	start := time.Now()
+	_, _, err := websocket.Dial(context.Background(), s.URL, &websocket.DialOptions{
+		HandshakeTimeout: time.Millisecond * 100,
+	})
+	assert.ErrorIs(t, context.DeadlineExceeded, err)
+	if d := time.Since(start); d > time.Second*5 {
+		t.Fatalf("dial took %v, expected it to be bounded by the handshake timeout", d)
+	}
+}
+
+func TestDialNetConnAddr(t *testing.T) {
+	t.Parallel()
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := echoServer(w, r, nil)
+		assert.Success(t, err)
+	}))
+	defer s.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+	c, _, err := websocket.Dial(ctx, s.URL, nil)
+	assert.Success(t, err)
+
+	nc := websocket.NetConn(ctx, c, websocket.MessageBinary)
+	assert.Equal(t, "remote addr", s.Listener.Addr().String(), nc.RemoteAddr().String())
+	assert.Equal(t, "local addr network", "tcp", nc.LocalAddr().Network())
+	_, ok := c.TLSConnectionState()
+	assert.Equal(t, "TLS", false, ok)
+
+	assertClose(t, c)
+}
+
+func TestDialUnderlyingConn(t *testing.T) {
+	t.Parallel()
+
+	s := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := websocket.Accept(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer c.CloseNow()
+		_, ok := c.UnderlyingConn().(*tls.Conn)
+		assert.Equal(t, "server *tls.Conn", true, ok)
+		_, ok = c.TLSConnectionState()
+		assert.Equal(t, "server TLS", true, ok)
+		c.Read(r.Context())
+	}))
+	defer s.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+	c, _, err := websocket.Dial(ctx, s.URL, &websocket.DialOptions{
+		HTTPClient: s.Client(),
+	})
+	assert.Success(t, err)
+
+	tc, ok := c.UnderlyingConn().(*tls.Conn)
+	assert.Equal(t, "client *tls.Conn", true, ok)
+	assert.Equal(t, "handshake complete", true, tc.ConnectionState().HandshakeComplete)
+	assert.Equal(t, "remote addr", s.Listener.Addr().String(), tc.RemoteAddr().String())
+
+	state, ok := c.TLSConnectionState()
+	assert.Equal(t, "TLS", true, ok)
+	assert.Equal(t, "peer certificate", s.Certificate(), state.PeerCertificates[0])
+
+	assertClose(t, c)
+}
@@ -26,8 +26,16 @@
 // Some important caveats to be aware of:
 //
 //   - Accept always errors out
-//   - Conn.Ping is no-op
+//   - Conn.Ping is no-op unless DialOptions.EmulatedPing is set
 //   - Conn.CloseNow is Close(StatusGoingAway, "")
+//   - Conn.HandshakeRequest returns nil
+//   - Conn.Buffered is only available in Wasm
 //   - HTTPClient, HTTPHeader and CompressionMode in DialOptions are no-op
 //   - *http.Response from Dial is &http.Response{} with a 101 status code on success
+//
+// # WASI
+//
+// The regular implementation also compiles for GOOS=wasip1 and with TinyGo.
+// As WASI preview 1 cannot open network connections by itself, set
+// DialOptions.NetDialContext to a dialer provided by the host runtime.
 package websocket // import "github.com/coder/websocket"
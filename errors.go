@@ -2,7 +2,220 @@ package websocket
 
 import (
 	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
 )
 
+var (
+	// ErrBadHandshake is matched with errors.Is by errors of Dial and Accept
+	// caused by an invalid opening handshake. They are a *HandshakeError.
+	ErrBadHandshake = errors.New("websocket: bad handshake")
+
+	// ErrProtocolViolation is matched with errors.Is by errors caused by the
+	// peer violating the WebSocket protocol, in the handshake or in frames.
+	ErrProtocolViolation = errors.New("WebSocket protocol violation")
+
+	// ErrReadLimitExceeded is an alias of ErrMessageTooBig.
+	ErrReadLimitExceeded = ErrMessageTooBig
+
+	// ErrWriterClosed is matched with errors.Is by errors of writes to a
+	// closed message writer.
+	ErrWriterClosed = errors.New("cannot use closed writer")
+
+	// ErrConnClosed is matched with errors.Is by errors of operations on a
+	// closed connection. It is net.ErrClosed.
+	ErrConnClosed = net.ErrClosed
+)
+
+// HandshakeError is returned by Dial and Accept when the opening handshake
+// is invalid, e.g. when the server responds with a status other than 101.
+// It matches ErrBadHandshake with errors.Is.
+type HandshakeError struct {
+	// StatusCode is the status code of the handshake response.
+	StatusCode int
+	// Header holds the response headers that explain a rejection:
+	// Content-Type, Location, Retry-After, Sec-WebSocket-Version and
+	// WWW-Authenticate. It is nil for Accept.
+	Header http.Header
+	// Body is the first 1 KB of the response body. It is nil for Accept.
+	Body []byte
+	// Err describes why the handshake is invalid.
+	Err error
+}
+
+// handshakeErrorHeaders are the response headers kept by HandshakeError.
+var handshakeErrorHeaders = []string{
+	"Content-Type",
+	"Location",
+	"Retry-After",
+	"Sec-WebSocket-Version",
+	"WWW-Authenticate",
+}
+
+func newHandshakeError(resp *http.Response, err error) *HandshakeError {
+	e := &HandshakeError{
+		StatusCode: resp.StatusCode,
+		Header:     http.Header{},
+		Err:        err,
+	}
+	for _, k := range handshakeErrorHeaders {
+		if v := resp.Header.Values(k); len(v) > 0 {
+			e.Header[http.CanonicalHeaderKey(k)] = v
+		}
+	}
+	return e
+}
+
+func (e *HandshakeError) Error() string {
+	return e.Err.Error()
+}
+
+// Is reports whether target is ErrBadHandshake.
+func (e *HandshakeError) Is(target error) bool {
+	return target == ErrBadHandshake
+}
+
+func (e *HandshakeError) Unwrap() error {
+	return e.Err
+}
+
+// ProtocolError is returned by reads when the peer sent a frame violating the
+// WebSocket protocol, e.g. with reserved bits or an unknown opcode. It holds
+// the fields of the frame header to identify broken peers from logs.
+// It matches ErrProtocolViolation with errors.Is.
+type ProtocolError struct {
+	// Err describes the violation. It is sent as the reason of the close
+	// frame failing the connection.
+	Err error
+
+	Fin           bool
+	RSV1          bool
+	RSV2          bool
+	RSV3          bool
+	Opcode        int
+	Masked        bool
+	PayloadLength int64
+}
+
+func (e *ProtocolError) Error() string {
+	return fmt.Sprintf("%v: %v (frame fin=%v rsv1=%v rsv2=%v rsv3=%v opcode=%d masked=%v length=%d)",
+		ErrProtocolViolation, e.Err, e.Fin, e.RSV1, e.RSV2, e.RSV3, e.Opcode, e.Masked, e.PayloadLength)
+}
+
+func (e *ProtocolError) Unwrap() []error {
+	return []error{ErrProtocolViolation, e.Err}
+}
+
+// VersionError is returned when the client and server have no common version
+// of the WebSocket protocol. This package only supports version 13.
+//
+// Dial returns it, wrapped in a *HandshakeError, when the server rejects the
+// handshake with 426 Upgrade Required or 400 Bad Request and a
+// Sec-WebSocket-Version header. Accept returns it when the
+// client requests another version, after rejecting the handshake with 426
+// Upgrade Required and a Sec-WebSocket-Version header.
+type VersionError struct {
+	// Requested is the version requested by the client.
+	Requested string
+	// Supported are the versions supported by the server.
+	Supported []string
+}
+
+func (e *VersionError) Error() string {
+	return fmt.Sprintf("unsupported WebSocket protocol version %q, supported versions: %s", e.Requested, strings.Join(e.Supported, ", "))
+}
+
+// HeaderError is returned by Accept when a WebSocket header of the
+// handshake request is missing, repeated or malformed. The handshake is
+// rejected with 400 Bad Request and the error as the response body.
+// It matches ErrProtocolViolation with errors.Is.
+//
+// Rejecting ambiguous headers prevents a reverse proxy and the server from
+// disagreeing on the handshake, e.g. on which of two keys is used.
+type HeaderError struct {
+	// Header is the name of the header, e.g. "Sec-WebSocket-Key".
+	Header string
+	// Value is the invalid value. It is empty if the header is missing or
+	// repeated.
+	Value string
+	// Reason describes why the header is invalid.
+	Reason string
+}
+
+func (e *HeaderError) Error() string {
+	if e.Value == "" {
+		return fmt.Sprintf("%v: %s %s", ErrProtocolViolation, e.Header, e.Reason)
+	}
+	return fmt.Sprintf("%v: invalid %s %q: %s", ErrProtocolViolation, e.Header, e.Value, e.Reason)
+}
+
+func (e *HeaderError) Unwrap() error {
+	return ErrProtocolViolation
+}
+
 // ErrMessageTooBig is returned when a message exceeds the read limit.
+// Reads return it as a *MessageTooBigError.
 var ErrMessageTooBig = errors.New("websocket: message too big")
+
+// MessageTooBigError is returned when a message exceeds the read limit.
+// It matches ErrMessageTooBig with errors.Is.
+type MessageTooBigError struct {
+	// MessageType is the type of the message.
+	MessageType MessageType
+	// Limit is the read limit of the message.
+	Limit int64
+	// Read is the number of bytes of the message read before the limit was
+	// exceeded. It is the size of the message if it is known up front,
+	// e.g. in Wasm.
+	Read int64
+	// Size is the size of the message declared by the frame headers received
+	// when the limit was exceeded. It is a lower bound if more frames of the
+	// message follow and 0 if unknown, e.g. as the message is compressed.
+	Size int64
+}
+
+func (e *MessageTooBigError) Error() string {
+	if e.Size > 0 {
+		return fmt.Sprintf("%v: %v of at least %d bytes read limited at %d bytes", ErrMessageTooBig, e.MessageType, e.Size, e.Limit)
+	}
+	return fmt.Sprintf("%v: %v read limited at %d bytes", ErrMessageTooBig, e.MessageType, e.Limit)
+}
+
+// Is reports whether target is ErrMessageTooBig.
+func (e *MessageTooBigError) Is(target error) bool {
+	return target == ErrMessageTooBig
+}
+
+// ErrWriteQueueFull is passed to the callback of a message dropped by
+// Conn.WriteAsync because the write queue is full.
+var ErrWriteQueueFull = errors.New("websocket: write queue full")
+
+// ErrWriteBackpressure is returned by writes that waited longer than
+// WriteBackpressureTimeout for the previous message to be written.
+// The connection remains usable. It is not returned by the blocked write
+// itself, see WriteBackpressureTimeout.
+var ErrWriteBackpressure = errors.New("websocket: write backpressure")
+
+// PongMismatchError is returned by Conn.PingWithPayload when the context expires
+// after the peer responded with a pong whose payload does not match the ping's.
+//
+// As unsolicited pongs are allowed, a mismatched pong does not fail the ping
+// right away.
+type PongMismatchError struct {
+	// Expected is the payload of the ping.
+	Expected []byte
+	// Received is the payload of the last mismatched pong.
+	Received []byte
+	// Err is the context error.
+	Err error
+}
+
+func (e *PongMismatchError) Error() string {
+	return fmt.Sprintf("received pong with payload %q instead of %q: %v", e.Received, e.Expected, e.Err)
+}
+
+func (e *PongMismatchError) Unwrap() error {
+	return e.Err
+}
@@ -3,8 +3,6 @@
 package websocket
 
 import (
-	"net"
-
 	"github.com/coder/websocket/internal/util"
 )
 
@@ -27,10 +25,9 @@ func (c *Conn) RecordBytesRead() *int {
 	return &bytesRead
 }
 
-var ErrClosed = net.ErrClosed
-
 var (
-	ExportedDial         = dial
+	EmulatedPingPrefix   = emulatedPingPrefix
+	EmulatedPongPrefix   = emulatedPongPrefix
 	SecWebSocketAccept   = secWebSocketAccept
 	SecWebSocketKey      = secWebSocketKey
 	VerifyServerResponse = verifyServerResponse
@@ -0,0 +1,211 @@
+//go:build !js
+
+package websocket
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// RSV is a reserved bit of the frame header claimed by an Extension.
+type RSV byte
+
+// RSV1 is used by permessage-deflate so extensions may only claim RSV2 and
+// RSV3.
+const (
+	RSV2 RSV = 1 << 5
+	RSV3 RSV = 1 << 4
+)
+
+// Extension is a WebSocket extension transforming the payloads of messages,
+// negotiated with the Sec-WebSocket-Extensions header.
+// See DialOptions.Extensions and AcceptOptions.Extensions.
+//
+// Each data message is transformed by every negotiated extension, which
+// sets its RSV bits on the first frame of the message. Extensions run in
+// the order they are registered when writing and permessage-deflate, if
+// negotiated, compresses their output.
+type Extension interface {
+	// Name returns the extension token, e.g. "x-encrypt".
+	Name() string
+
+	// RSV returns the RSV bits the extension claims, RSV2, RSV3 or both.
+	RSV() RSV
+
+	// Offer returns the parameters of the client's offer.
+	Offer() []string
+
+	// Accept is called on the server with the parameters offered by the
+	// client. It returns the parameters of the response and the transform
+	// for the connection or ok false to decline the offer.
+	Accept(params []string) (resp []string, t ExtensionTransform, ok bool)
+
+	// Accepted is called on the client with the parameters of the server's
+	// response. It returns the transform for the connection or an error
+	// failing the handshake.
+	Accepted(params []string) (ExtensionTransform, error)
+}
+
+// ExtensionTransform transforms the messages of a single connection.
+type ExtensionTransform interface {
+	// Writer returns a writer transforming a message into w. Close is
+	// called once the whole message is written and must not close w.
+	Writer(w io.Writer) io.WriteCloser
+
+	// Reader returns a reader reversing the transform of a message read
+	// from r. It must not read from r until its own Read is called.
+	Reader(r io.Reader) io.Reader
+}
+
+type negotiatedExtension struct {
+	rsv RSV
+	t   ExtensionTransform
+}
+
+func validateExtensions(exts []Extension) error {
+	var claimed RSV
+	names := make(map[string]bool, len(exts))
+	for _, ext := range exts {
+		rsv := ext.RSV()
+		switch {
+		case ext.Name() == "" || ext.Name() == "permessage-deflate":
+			return fmt.Errorf("invalid extension name %q", ext.Name())
+		case names[ext.Name()]:
+			return fmt.Errorf("extension %q registered twice", ext.Name())
+		case rsv == 0 || rsv&^(RSV2|RSV3) != 0:
+			return fmt.Errorf("extension %q must claim RSV2, RSV3 or both but claimed %#x", ext.Name(), rsv)
+		case claimed&rsv != 0:
+			return fmt.Errorf("extension %q claims RSV bits %#x already claimed", ext.Name(), rsv)
+		}
+		names[ext.Name()] = true
+		claimed |= rsv
+	}
+	return nil
+}
+
+func formatExtension(name string, params []string) string {
+	return strings.Join(append([]string{name}, params...), "; ")
+}
+
+// offerExtensions returns the Sec-WebSocket-Extensions offers of exts.
+func offerExtensions(exts []Extension) []string {
+	offers := make([]string, 0, len(exts))
+	for _, ext := range exts {
+		offers = append(offers, formatExtension(ext.Name(), ext.Offer()))
+	}
+	return offers
+}
+
+// acceptExtensions accepts the offers of the registered extensions in the
+// order they are registered. It returns the Sec-WebSocket-Extensions
+// responses and the negotiated extensions.
+func acceptExtensions(exts []Extension, offers []websocketExtension) ([]string, []negotiatedExtension) {
+	var resp []string
+	var negotiated []negotiatedExtension
+	for _, ext := range exts {
+		for _, offer := range offers {
+			if offer.name != ext.Name() {
+				continue
+			}
+			params, t, ok := ext.Accept(offer.params)
+			if ok {
+				resp = append(resp, formatExtension(ext.Name(), params))
+				negotiated = append(negotiated, negotiatedExtension{rsv: ext.RSV(), t: t})
+				break
+			}
+		}
+	}
+	return resp, negotiated
+}
+
+// acceptedExtensions negotiates the registered extensions in the server's
+// response and returns the other extensions of the response.
+func acceptedExtensions(exts []Extension, resp []websocketExtension) ([]negotiatedExtension, []websocketExtension, error) {
+	var negotiated []negotiatedExtension
+	var rest []websocketExtension
+	seen := make(map[string]bool)
+	for _, r := range resp {
+		i := extensionIndex(exts, r.name)
+		if i < 0 {
+			rest = append(rest, r)
+			continue
+		}
+		if seen[r.name] {
+			return nil, nil, fmt.Errorf("%w: extension %q accepted twice", ErrProtocolViolation, r.name)
+		}
+		seen[r.name] = true
+
+		t, err := exts[i].Accepted(r.params)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to negotiate extension %q: %w", r.name, err)
+		}
+		negotiated = append(negotiated, negotiatedExtension{rsv: exts[i].RSV(), t: t})
+	}
+	return negotiated, rest, nil
+}
+
+func extensionIndex(exts []Extension, name string) int {
+	for i, ext := range exts {
+		if ext.Name() == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// extensionReader wraps r with the transforms of the negotiated extensions
+// whose bits are set in rsv.
+func (c *Conn) extensionReader(r io.Reader, rsv RSV) io.Reader {
+	for i := len(c.extensions) - 1; i >= 0; i-- {
+		if rsv&c.extensions[i].rsv != 0 {
+			r = c.extensions[i].t.Reader(r)
+		}
+	}
+	return r
+}
+
+// extensionWriter writes a message through the negotiated extensions.
+type extensionWriter struct {
+	mw      *msgWriter
+	w       io.Writer
+	closers []io.Closer
+}
+
+func (c *Conn) extensionWriter(mw *msgWriter) io.WriteCloser {
+	ew := &extensionWriter{mw: mw}
+	var w io.Writer = mw
+	for i := len(c.extensions) - 1; i >= 0; i-- {
+		wc := c.extensions[i].t.Writer(w)
+		ew.closers = append(ew.closers, wc)
+		w = wc
+	}
+	ew.w = w
+	return ew
+}
+
+func (ew *extensionWriter) Write(p []byte) (int, error) {
+	return ew.w.Write(p)
+}
+
+// Close closes the transforms, outermost first, and then the message.
+func (ew *extensionWriter) Close() error {
+	var errs []error
+	for i := len(ew.closers) - 1; i >= 0; i-- {
+		errs = append(errs, ew.closers[i].Close())
+	}
+	errs = append(errs, ew.mw.Close())
+	return errors.Join(errs...)
+}
+
+func (c *Conn) writeExtended(ctx context.Context, typ MessageType, p []byte, opts []WriteOption) (int, error) {
+	mw, err := c.writer(ctx, typ, opts...)
+	if err != nil {
+		return 0, err
+	}
+	w := c.extensionWriter(mw)
+	n, err := w.Write(p)
+	return n, errors.Join(err, w.Close())
+}
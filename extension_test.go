@@ -0,0 +1,188 @@
+//go:build !js
+
+package websocket_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/internal/test/assert"
+	"github.com/coder/websocket/websockettest"
+)
+
+// prefixExtension prefixes every message with its prefix parameter so that
+// messages only round trip if both peers apply the transform.
+type prefixExtension struct {
+	name   string
+	rsv    websocket.RSV
+	prefix string
+}
+
+func (e prefixExtension) Name() string       { return e.name }
+func (e prefixExtension) RSV() websocket.RSV { return e.rsv }
+func (e prefixExtension) Offer() []string    { return []string{"prefix=" + e.prefix} }
+
+func (e prefixExtension) Accept(params []string) ([]string, websocket.ExtensionTransform, bool) {
+	if len(params) != 1 || params[0] != "prefix="+e.prefix {
+		return nil, nil, false
+	}
+	return params, e, true
+}
+
+func (e prefixExtension) Accepted(params []string) (websocket.ExtensionTransform, error) {
+	if len(params) != 1 || params[0] != "prefix="+e.prefix {
+		return nil, fmt.Errorf("unexpected params %q", params)
+	}
+	return e, nil
+}
+
+func (e prefixExtension) Writer(w io.Writer) io.WriteCloser {
+	return &prefixWriter{w: w, prefix: e.prefix}
+}
+
+func (e prefixExtension) Reader(r io.Reader) io.Reader {
+	return &prefixReader{r: r, prefix: e.prefix}
+}
+
+type prefixReader struct {
+	r      io.Reader
+	prefix string
+	read   bool
+}
+
+func (r *prefixReader) Read(p []byte) (int, error) {
+	if !r.read {
+		r.read = true
+		b := make([]byte, len(r.prefix))
+		_, err := io.ReadFull(r.r, b)
+		if err != nil || string(b) != r.prefix {
+			return 0, fmt.Errorf("message is missing prefix %q: %v", r.prefix, err)
+		}
+	}
+	return r.r.Read(p)
+}
+
+type prefixWriter struct {
+	w      io.Writer
+	prefix string
+	buf    bytes.Buffer
+}
+
+func (w *prefixWriter) Write(p []byte) (int, error) {
+	return w.buf.Write(p)
+}
+
+func (w *prefixWriter) Close() error {
+	_, err := w.w.Write(append([]byte(w.prefix), w.buf.Bytes()...))
+	return err
+}
+
+func TestExtensions(t *testing.T) {
+	t.Parallel()
+
+	exts := []websocket.Extension{
+		prefixExtension{name: "x-a", rsv: websocket.RSV2, prefix: "a"},
+		prefixExtension{name: "x-b", rsv: websocket.RSV3, prefix: "b"},
+	}
+
+	for name, mode := range map[string]websocket.CompressionMode{
+		"disabled":        websocket.CompressionDisabled,
+		"contextTakeover": websocket.CompressionContextTakeover,
+	} {
+		t.Run(name, func(t *testing.T) {
+			tt, c1, c2 := newConnTest(t, &websocket.DialOptions{
+				CompressionMode: mode,
+				Extensions:      exts,
+			}, &websocket.AcceptOptions{
+				CompressionMode:      mode,
+				CompressionThreshold: 1,
+				Extensions:           exts,
+			})
+			tt.goEchoLoop(c2)
+			assert.Contains(t, c1.Extensions(), "x-a; prefix=a, x-b; prefix=b")
+
+			msg := []byte("hello world")
+			err := c1.Write(tt.ctx, websocket.MessageText, msg)
+			assert.Success(t, err)
+			_, p, err := c1.Read(tt.ctx)
+			assert.Success(t, err)
+			assert.Equal(t, "message", string(msg), string(p))
+
+			w, err := c1.Writer(tt.ctx, websocket.MessageBinary)
+			assert.Success(t, err)
+			_, err = w.Write(msg[:5])
+			assert.Success(t, err)
+			_, err = w.Write(msg[5:])
+			assert.Success(t, err)
+			assert.Success(t, w.Close())
+			_, p, err = c1.Read(tt.ctx)
+			assert.Success(t, err)
+			assert.Equal(t, "message", string(msg), string(p))
+
+			err = c1.Write(tt.ctx, websocket.MessageText, msg)
+			assert.Success(t, err)
+			var progressed int64
+			_, r, err := c1.ReaderWithProgress(tt.ctx, func(read, total int64) {
+				progressed = read
+			})
+			assert.Success(t, err)
+			p, err = io.ReadAll(r)
+			assert.Success(t, err)
+			assert.Equal(t, "message", string(msg), string(p))
+			assert.Equal(t, "progressed", true, progressed > 0)
+
+			bw, err := c1.BufferedWriter(tt.ctx, websocket.MessageBinary)
+			assert.Success(t, err)
+			_, err = bw.Write(msg)
+			assert.Success(t, err)
+			assert.Success(t, bw.Flush())
+			assert.Success(t, bw.Close())
+			_, p, err = c1.Read(tt.ctx)
+			assert.Success(t, err)
+			assert.Equal(t, "message", string(msg), string(p))
+
+			assert.Success(t, c1.Close(websocket.StatusNormalClosure, ""))
+		})
+	}
+
+	t.Run("declined", func(t *testing.T) {
+		tt, c1, c2 := newConnTest(t, &websocket.DialOptions{
+			Extensions: exts,
+		}, &websocket.AcceptOptions{
+			Extensions: exts[1:],
+		})
+		tt.goEchoLoop(c2)
+
+		err := c1.Write(tt.ctx, websocket.MessageText, []byte("hello"))
+		assert.Success(t, err)
+		_, p, err := c1.Read(tt.ctx)
+		assert.Success(t, err)
+		assert.Equal(t, "message", "hello", string(p))
+		assert.Success(t, c1.Close(websocket.StatusNormalClosure, ""))
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		t.Parallel()
+
+		invalid := [][]websocket.Extension{
+			{prefixExtension{name: "x-a", rsv: 1 << 6}},
+			{prefixExtension{name: "x-a"}},
+			{prefixExtension{name: "permessage-deflate", rsv: websocket.RSV2}},
+			{exts[0], prefixExtension{name: "x-c", rsv: websocket.RSV2 | websocket.RSV3}},
+			{exts[0], exts[0]},
+		}
+		for _, exts := range invalid {
+			_, _, err := websockettest.Pipe(&websockettest.Options{
+				DialOptions: &websocket.DialOptions{Extensions: exts},
+			})
+			assert.Error(t, err)
+			_, _, err = websockettest.Pipe(&websockettest.Options{
+				AcceptOptions: &websocket.AcceptOptions{Extensions: exts},
+			})
+			assert.Error(t, err)
+		}
+	})
+}
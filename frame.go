@@ -47,6 +47,18 @@ type header struct {
 	maskKey uint32
 }
 
+// extRSV returns the RSV2 and RSV3 bits of h.
+func (h header) extRSV() RSV {
+	var rsv RSV
+	if h.rsv2 {
+		rsv |= RSV2
+	}
+	if h.rsv3 {
+		rsv |= RSV3
+	}
+	return rsv
+}
+
 // readFrameHeader reads a header from the reader.
 // See https://tools.ietf.org/html/rfc6455#section-5.2.
 func readFrameHeader(r *bufio.Reader, readBuf []byte) (h header, err error) {
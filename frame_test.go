@@ -5,14 +5,20 @@ package websocket
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/binary"
+	"errors"
+	"io"
 	"math/bits"
 	"math/rand"
+	"net"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/coder/websocket/internal/test/assert"
+	"github.com/coder/websocket/internal/xsync"
 )
 
 func TestHeader(t *testing.T) {
@@ -104,3 +110,221 @@ func Test_mask(t *testing.T) {
 	expKey32 := bits.RotateLeft32(key32, -8)
 	assert.Equal(t, "key32", expKey32, gotKey32)
 }
+
+// TestWriteVectored verifies that large unmasked frames written around the
+// bufio.Writer arrive intact and leave the buffer usable for later frames.
+func TestWriteVectored(t *testing.T) {
+	t.Parallel()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Success(t, err)
+	defer l.Close()
+
+	clientConn, err := net.Dial("tcp", l.Addr().String())
+	assert.Success(t, err)
+	defer clientConn.Close()
+
+	serverConn, err := l.Accept()
+	assert.Success(t, err)
+	defer serverConn.Close()
+
+	c := newConn(connConfig{
+		rwc: serverConn,
+		br:  bufio.NewReader(serverConn),
+		bw:  bufio.NewWriterSize(serverConn, 4096),
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	large := bytes.Repeat([]byte("x"), 1<<16)
+	writeDone := make(chan error, 1)
+	go func() {
+		err := c.Write(ctx, MessageBinary, large)
+		if err == nil {
+			err = c.Write(ctx, MessageText, []byte("small"))
+		}
+		writeDone <- err
+	}()
+
+	r := bufio.NewReader(clientConn)
+	readBuf := make([]byte, 8)
+	for _, exp := range [][]byte{large, []byte("small")} {
+		h, err := readFrameHeader(r, readBuf)
+		assert.Success(t, err)
+		assert.Equal(t, "payload length", int64(len(exp)), h.payloadLength)
+
+		p := make([]byte, h.payloadLength)
+		_, err = io.ReadFull(r, p)
+		assert.Success(t, err)
+		assert.Equal(t, "payload", true, bytes.Equal(exp, p))
+	}
+	assert.Success(t, <-writeDone)
+}
+
+func TestMasking(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name          string
+		masked        bool
+		client        bool
+		rejectMasked  bool
+		allowUnmasked bool
+		success       bool
+	}{
+		{name: "maskedToServer", masked: true, client: false, success: true},
+		{name: "unmaskedToServer", masked: false, client: false, success: false},
+		{name: "unmaskedToServerAllowed", masked: false, client: false, allowUnmasked: true, success: true},
+		{name: "unmaskedToClient", masked: false, client: true, success: true},
+		{name: "maskedToClient", masked: true, client: true, success: true},
+		{name: "maskedToClientRejected", masked: true, client: true, rejectMasked: true, success: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+			defer cancel()
+
+			rwc1, rwc2 := net.Pipe()
+			defer rwc1.Close()
+			defer rwc2.Close()
+
+			// Clients mask the frames they write.
+			w := newConn(connConfig{
+				rwc:    rwc1,
+				client: tc.masked,
+				br:     bufio.NewReader(rwc1),
+				bw:     bufio.NewWriterSize(rwc1, 4096),
+			})
+			defer w.CloseNow()
+			r := newConn(connConfig{
+				rwc:                 rwc2,
+				client:              tc.client,
+				rejectMaskedFrames:  tc.rejectMasked,
+				allowUnmaskedFrames: tc.allowUnmasked,
+				br:                  bufio.NewReader(rwc2),
+				bw:                  bufio.NewWriterSize(rwc2, 4096),
+			})
+			defer r.CloseNow()
+
+			w.CloseRead(ctx)
+			writeErrs := xsync.Go(func() error {
+				return w.Write(ctx, MessageText, []byte("hello"))
+			})
+
+			_, p, err := r.Read(ctx)
+			assert.Success(t, <-writeErrs)
+			if !tc.success {
+				assert.Contains(t, err, "frame from")
+				assert.ErrorIs(t, ErrProtocolViolation, err)
+				return
+			}
+			assert.Success(t, err)
+			assert.Equal(t, "message", "hello", string(p))
+		})
+	}
+}
+
+func TestReservedOpcodes(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name    string
+		opcode  opcode
+		payload string
+		handled bool
+		success bool
+	}{
+		{name: "data", opcode: 0x3, payload: "ext", handled: true, success: true},
+		{name: "control", opcode: 0xb, payload: "ext", handled: true, success: true},
+		{name: "controlTooLong", opcode: 0xb, payload: strings.Repeat("x", 126), handled: true, success: false},
+		{name: "unhandled", opcode: 0x7, payload: "ext", handled: false, success: false},
+		{name: "noCallback", opcode: 0xf, payload: "ext", success: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+			defer cancel()
+
+			rwc1, rwc2 := net.Pipe()
+			defer rwc1.Close()
+			defer rwc2.Close()
+
+			var got []byte
+			cfg := connConfig{
+				rwc:    rwc2,
+				client: true,
+				br:     bufio.NewReader(rwc2),
+				bw:     bufio.NewWriterSize(rwc2, 4096),
+			}
+			if tc.name != "noCallback" {
+				cfg.onReservedFrame = func(ctx context.Context, opcode int, payload []byte) bool {
+					assert.Equal(t, "opcode", int(tc.opcode), opcode)
+					got = payload
+					return tc.handled
+				}
+			}
+			c := newConn(cfg)
+			defer c.CloseNow()
+
+			writeErrs := xsync.Go(func() error {
+				bw := bufio.NewWriter(rwc1)
+				for _, f := range []struct {
+					op opcode
+					p  string
+				}{{tc.opcode, tc.payload}, {opText, "hello"}} {
+					err := writeFrameHeader(header{fin: true, opcode: f.op, payloadLength: int64(len(f.p))}, bw, make([]byte, 8))
+					if err != nil {
+						return err
+					}
+					bw.WriteString(f.p)
+				}
+				err := bw.Flush()
+				// Drain the close frame sent on failure.
+				io.Copy(io.Discard, rwc1)
+				return err
+			})
+
+			_, p, err := c.Read(ctx)
+			c.CloseNow()
+			rwc1.Close()
+			if !tc.success {
+				assert.Contains(t, err, "opcode")
+				assert.ErrorIs(t, ErrProtocolViolation, err)
+				var pe *ProtocolError
+				assert.Equal(t, "errors.As", true, errors.As(err, &pe))
+				assert.Equal(t, "opcode", int(tc.opcode), pe.Opcode)
+				assert.Equal(t, "payload length", int64(len(tc.payload)), pe.PayloadLength)
+				<-writeErrs
+				return
+			}
+			assert.Success(t, err)
+			assert.Success(t, <-writeErrs)
+			assert.Equal(t, "message", "hello", string(p))
+			assert.Equal(t, "reserved payload", tc.payload, string(got))
+		})
+	}
+}
+
+func FuzzReadFrameHeader(f *testing.F) {
+	f.Add([]byte{0x81, 0x05})
+	f.Add([]byte{0x82, 0xfe, 0x01, 0x00, 0x01, 0x02, 0x03, 0x04})
+	f.Add([]byte{0x88, 0x7f, 0, 0, 0, 0, 0, 1, 0, 0})
+	f.Add([]byte{0x7f, 0xff, 0x80, 0, 0, 0, 0, 0, 0, 0})
+	f.Add([]byte{0x89})
+
+	f.Fuzz(func(t *testing.T, p []byte) {
+		h, err := readFrameHeader(bufio.NewReader(bytes.NewReader(p)), make([]byte, 8))
+		if err != nil {
+			return
+		}
+		// Any header that parses must survive a round trip.
+		testHeader(t, h)
+	})
+}
@@ -0,0 +1,46 @@
+package websocket
+
+import (
+	"context"
+	"io"
+	"iter"
+)
+
+// readerFramesChunk is the maximum size of the chunks yielded by
+// ReaderFrames.
+const readerFramesChunk = 32 << 10
+
+// ReaderFrames is like Reader but returns an iterator yielding the payload
+// of the message as it arrives instead of an io.Reader, e.g. to report the
+// progress of a large upload or to enforce a policy per frame.
+//
+// Each chunk is the payload of a frame, split into chunks of at most 32 KiB.
+// Compressed messages are yielded as they are decompressed so their chunks
+// do not match frames. In Wasm, the browser only delivers whole messages.
+//
+// A chunk is only valid until the next iteration. An error ends the
+// iteration. The message must be read to completion like with Reader so
+// stopping the iteration early requires closing the connection.
+func (c *Conn) ReaderFrames(ctx context.Context) (MessageType, iter.Seq2[[]byte, error], error) {
+	typ, r, err := c.Reader(ctx)
+	if err != nil {
+		return 0, nil, err
+	}
+	return typ, func(yield func([]byte, error) bool) {
+		b := make([]byte, readerFramesChunk)
+		for {
+			n, err := r.Read(b)
+			if n > 0 && !yield(b[:n], nil) {
+				return
+			}
+			// Only an unwrapped io.EOF marks the end of the message.
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+		}
+	}, nil
+}
@@ -15,10 +15,10 @@ type rwUnwrapper interface {
 // matching the behavior of http.ResponseController. If the Hijacker
 // interface is not found, it returns false.
 //
-// Since the http.ResponseController is not available in Go 1.19, and
-// does not support checking the presence of the Hijacker interface,
-// this function is used to provide a consistent way to check for the
-// Hijacker interface across Go versions.
+// http.ResponseController does not support checking the presence of the
+// Hijacker interface so this function is used to fail the handshake before
+// the response is written. The connection is then hijacked with
+// http.ResponseController.
 func hijacker(rw http.ResponseWriter) (http.Hijacker, bool) {
 	for {
 		switch t := rw.(type) {
@@ -0,0 +1,45 @@
+// Package echo implements the echo loop of cmd/websocket-echo, which the
+// tests use as their peer too.
+package echo
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/coder/websocket"
+)
+
+// Loop echoes every message received on c until an error occurs.
+// Each message has a minute to be echoed.
+func Loop(ctx context.Context, c *websocket.Conn) error {
+	b := make([]byte, 32<<10)
+	for {
+		err := echo(ctx, c, b)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func echo(ctx context.Context, c *websocket.Conn, b []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	typ, r, err := c.Reader(ctx)
+	if err != nil {
+		return err
+	}
+
+	w, err := c.Writer(ctx, typ)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.CopyBuffer(w, r, b)
+	if err != nil {
+		return err
+	}
+
+	return w.Close()
+}
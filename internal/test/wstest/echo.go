@@ -4,16 +4,16 @@ import (
 	"bytes"
 	"context"
 	"fmt"
-	"io"
 	"time"
 
 	"github.com/coder/websocket"
+	"github.com/coder/websocket/internal/echo"
 	"github.com/coder/websocket/internal/test/xrand"
 	"github.com/coder/websocket/internal/xsync"
 )
 
-// EchoLoop echos every msg received from c until an error
-// occurs or the context expires.
+// EchoLoop echos every msg received from c with the echo loop of
+// cmd/websocket-echo until an error occurs or the context expires.
 // The read limit is set to 1 << 30.
 func EchoLoop(ctx context.Context, c *websocket.Conn) error {
 	defer c.Close(websocket.StatusInternalError, "")
@@ -23,28 +23,7 @@ func EchoLoop(ctx context.Context, c *websocket.Conn) error {
 	ctx, cancel := context.WithTimeout(ctx, time.Minute*5)
 	defer cancel()
 
-	b := make([]byte, 32<<10)
-	for {
-		typ, r, err := c.Reader(ctx)
-		if err != nil {
-			return err
-		}
-
-		w, err := c.Writer(ctx, typ)
-		if err != nil {
-			return err
-		}
-
-		_, err = io.CopyBuffer(w, r, b)
-		if err != nil {
-			return err
-		}
-
-		err = w.Close()
-		if err != nil {
-			return err
-		}
-	}
+	return echo.Loop(ctx, c)
 }
 
 // Echo writes a message and ensures the same is sent back on c.
@@ -0,0 +1,138 @@
+package thirdparty
+
+import (
+	"context"
+	"io"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/flate"
+
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/internal/test/assert"
+	"github.com/coder/websocket/internal/test/wstest"
+)
+
+type klauspostFlate struct{}
+
+func (klauspostFlate) NewWriter(w io.Writer, level int, dict []byte) (websocket.FlateWriter, error) {
+	return flate.NewWriterDict(w, level, dict)
+}
+
+func (klauspostFlate) NewReader(r io.Reader, dict []byte) websocket.FlateReader {
+	return flate.NewReaderDict(r, dict).(websocket.FlateReader)
+}
+
+// countingBackend counts the readers and writers created by klauspostFlate.
+type countingBackend struct {
+	klauspostFlate
+	writers atomic.Int64
+	readers atomic.Int64
+}
+
+func (cb *countingBackend) NewWriter(w io.Writer, level int, dict []byte) (websocket.FlateWriter, error) {
+	cb.writers.Add(1)
+	return cb.klauspostFlate.NewWriter(w, level, dict)
+}
+
+func (cb *countingBackend) NewReader(r io.Reader, dict []byte) websocket.FlateReader {
+	cb.readers.Add(1)
+	return cb.klauspostFlate.NewReader(r, dict)
+}
+
+var compressionBackends = []struct {
+	name    string
+	backend websocket.CompressionBackend
+}{
+	{
+		name: "stdlib",
+	},
+	{
+		name:    "klauspost",
+		backend: klauspostFlate{},
+	},
+}
+
+func newCompressionPipe(mode websocket.CompressionMode, backend websocket.CompressionBackend) (c1, c2 *websocket.Conn) {
+	return wstest.Pipe(&websocket.DialOptions{
+		CompressionMode:    mode,
+		CompressionBackend: backend,
+	}, &websocket.AcceptOptions{
+		CompressionMode:    mode,
+		CompressionBackend: backend,
+	})
+}
+
+func TestCompressionBackend(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+
+	for _, mode := range []websocket.CompressionMode{websocket.CompressionContextTakeover, websocket.CompressionNoContextTakeover} {
+		cb := &countingBackend{}
+		c1, c2 := newCompressionPipe(mode, cb)
+		defer c1.CloseNow()
+		defer c2.CloseNow()
+
+		msg := strings.Repeat("klauspost", 1000)
+		for range 3 {
+			errs := make(chan error, 1)
+			go func() {
+				errs <- c1.Write(ctx, websocket.MessageText, []byte(msg))
+			}()
+
+			_, b, err := c2.Read(ctx)
+			assert.Success(t, err)
+			assert.Equal(t, "message", msg, string(b))
+			assert.Success(t, <-errs)
+		}
+
+		if cb.writers.Load() == 0 || cb.readers.Load() == 0 {
+			t.Fatalf("expected backend to be used for mode %v: %d writers and %d readers", mode, cb.writers.Load(), cb.readers.Load())
+		}
+	}
+}
+
+func BenchmarkCompressionBackend(b *testing.B) {
+	msg := []byte(strings.Repeat(`{"type":"event","data":"value"}`, 128))
+
+	for _, bc := range compressionBackends {
+		b.Run(bc.name, func(b *testing.B) {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			defer cancel()
+
+			c1, c2 := newCompressionPipe(websocket.CompressionContextTakeover, bc.backend)
+			defer c1.CloseNow()
+			defer c2.CloseNow()
+
+			errs := make(chan error, 1)
+			go func() {
+				for range b.N {
+					err := c1.Write(ctx, websocket.MessageText, msg)
+					if err != nil {
+						errs <- err
+						return
+					}
+				}
+				errs <- nil
+			}()
+
+			b.SetBytes(int64(len(msg)))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for range b.N {
+				_, _, err := c2.Read(ctx)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+			err := <-errs
+			if err != nil {
+				b.Fatal(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+//go:build js
+
+package wsjs
+
+import (
+	"errors"
+	"io"
+	"syscall/js"
+)
+
+// Blob is a wrapper around a javascript Blob received as binary message data.
+type Blob struct {
+	v js.Value
+}
+
+// Size returns the size of the blob in bytes.
+func (b Blob) Size() int {
+	return b.v.Get("size").Int()
+}
+
+// Reader returns a reader for the contents of the blob.
+// It streams the contents with Blob.stream when the browser supports it
+// and otherwise reads the whole blob at once.
+func (b Blob) Reader() io.Reader {
+	return &blobReader{b: b}
+}
+
+type blobReader struct {
+	b      Blob
+	reader js.Value
+	buf    []byte
+	done   bool
+}
+
+func (r *blobReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		err := r.next()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// next reads the next chunk of the blob into buf.
+func (r *blobReader) next() error {
+	if r.b.v.Get("stream").Type() != js.TypeFunction {
+		v, err := await(r.b.v.Call("arrayBuffer"))
+		if err != nil {
+			return err
+		}
+		r.buf = extractArrayBuffer(v)
+		r.done = true
+		return nil
+	}
+
+	if r.reader.IsUndefined() {
+		r.reader = r.b.v.Call("stream").Call("getReader")
+	}
+	v, err := await(r.reader.Call("read"))
+	if err != nil {
+		return err
+	}
+	if v.Get("done").Bool() {
+		r.done = true
+		return nil
+	}
+	chunk := v.Get("value")
+	r.buf = make([]byte, chunk.Length())
+	js.CopyBytesToGo(r.buf, chunk)
+	return nil
+}
+
+// await blocks until the promise settles. It must not be called
+// from a javascript callback.
+func await(promise js.Value) (js.Value, error) {
+	resultc := make(chan js.Value, 1)
+	errc := make(chan error, 1)
+
+	onResolve := js.FuncOf(func(this js.Value, args []js.Value) any {
+		resultc <- args[0]
+		return nil
+	})
+	defer onResolve.Release()
+	onReject := js.FuncOf(func(this js.Value, args []js.Value) any {
+		errc <- errors.New(args[0].Call("toString").String())
+		return nil
+	})
+	defer onReject.Release()
+
+	promise.Call("then", onResolve, onReject)
+
+	select {
+	case v := <-resultc:
+		return v, nil
+	case err := <-errc:
+		return js.Undefined(), err
+	}
+}
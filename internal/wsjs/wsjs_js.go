@@ -42,7 +42,7 @@ func New(url string, protocols []string) (c WebSocket, err error) {
 		v: js.Global().Get("WebSocket").New(url, jsProtocols),
 	}
 
-	c.setBinaryType("arraybuffer")
+	c.SetBinaryType("arraybuffer")
 
 	return c, nil
 }
@@ -52,7 +52,10 @@ type WebSocket struct {
 	v js.Value
 }
 
-func (c WebSocket) setBinaryType(typ string) {
+// SetBinaryType sets the type of binary messages to "arraybuffer", the default,
+// or "blob". Binary MessageEvent data is a []byte for the former and a Blob for
+// the latter.
+func (c WebSocket) SetBinaryType(typ string) {
 	c.v.Set("binaryType", string(typ))
 }
 
@@ -96,7 +99,7 @@ func (c WebSocket) OnError(fn func(e js.Value)) (remove func()) {
 
 // MessageEvent is the type passed to a message handler.
 type MessageEvent struct {
-	// string or []byte.
+	// string, []byte or Blob.
 	Data any
 
 	// There are more fields to the interface but we don't use them.
@@ -108,11 +111,14 @@ func (c WebSocket) OnMessage(fn func(m MessageEvent)) (remove func()) {
 	return c.addEventListener("message", func(e js.Value) {
 		var data any
 
-		arrayBuffer := e.Get("data")
-		if arrayBuffer.Type() == js.TypeString {
-			data = arrayBuffer.String()
-		} else {
-			data = extractArrayBuffer(arrayBuffer)
+		v := e.Get("data")
+		switch {
+		case v.Type() == js.TypeString:
+			data = v.String()
+		case v.InstanceOf(js.Global().Get("ArrayBuffer")):
+			data = extractArrayBuffer(v)
+		default:
+			data = Blob{v: v}
 		}
 
 		me := MessageEvent{
@@ -127,6 +133,23 @@ func (c WebSocket) Subprotocol() string {
 	return c.v.Get("protocol").String()
 }
 
+// Extensions returns the WebSocket extensions in use.
+func (c WebSocket) Extensions() string {
+	return c.v.Get("extensions").String()
+}
+
+// BufferedAmount returns the number of bytes queued by send
+// but not yet transmitted to the network.
+func (c WebSocket) BufferedAmount() int {
+	return c.v.Get("bufferedAmount").Int()
+}
+
+// ReadyState returns the readyState of the WebSocket: 0 for CONNECTING,
+// 1 for OPEN, 2 for CLOSING and 3 for CLOSED.
+func (c WebSocket) ReadyState() int {
+	return c.v.Get("readyState").Int()
+}
+
 // OnOpen registers a function to be called when the WebSocket is opened.
 func (c WebSocket) OnOpen(fn func(e js.Value)) (remove func()) {
 	return c.addEventListener("open", fn)
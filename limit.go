@@ -0,0 +1,94 @@
+//go:build !js
+
+package websocket
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// AcceptLimiter limits the number of open connections and the rate at which
+// connections are accepted. Share one between the Accept calls of an endpoint
+// with AcceptOptions.Limiter.
+//
+// Handshakes over the limits are rejected with 503 Service Unavailable and
+// a Retry-After header.
+//
+// The fields must not be modified once the limiter is in use.
+type AcceptLimiter struct {
+	// MaxConns is the maximum number of open connections.
+	// Zero means no limit.
+	MaxConns int
+
+	// Rate is the number of connections accepted per second.
+	// Zero means no limit.
+	Rate float64
+
+	// Burst is the number of connections that may be accepted at once
+	// when Rate is set. Defaults to 1.
+	Burst int
+
+	// RetryAfter is the delay suggested to rejected clients.
+	// Defaults to 1s.
+	RetryAfter time.Duration
+
+	mu     sync.Mutex
+	conns  int
+	tokens float64
+	last   time.Time
+}
+
+// Conns returns the number of open connections accepted through l.
+func (l *AcceptLimiter) Conns() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.conns
+}
+
+// acquire reserves a connection slot. It returns a RejectionError if either
+// limit is exceeded.
+func (l *AcceptLimiter) acquire(now time.Time) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.MaxConns > 0 && l.conns >= l.MaxConns {
+		return l.reject()
+	}
+
+	if l.Rate > 0 {
+		burst := float64(max(l.Burst, 1))
+		if l.last.IsZero() {
+			l.tokens = burst
+		} else {
+			l.tokens = min(burst, l.tokens+now.Sub(l.last).Seconds()*l.Rate)
+		}
+		l.last = now
+		if l.tokens < 1 {
+			return l.reject()
+		}
+		l.tokens--
+	}
+
+	l.conns++
+	return nil
+}
+
+func (l *AcceptLimiter) release() {
+	l.mu.Lock()
+	l.conns--
+	l.mu.Unlock()
+}
+
+func (l *AcceptLimiter) reject() error {
+	retryAfter := l.RetryAfter
+	if retryAfter <= 0 {
+		retryAfter = time.Second
+	}
+	secs := int((retryAfter + time.Second - 1) / time.Second)
+	return RejectionError{
+		StatusCode: http.StatusServiceUnavailable,
+		Header:     http.Header{"Retry-After": {strconv.Itoa(secs)}},
+	}
+}
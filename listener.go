@@ -0,0 +1,88 @@
+//go:build !js
+
+package websocket
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// Listener is a net.Listener of the WebSocket connections accepted by its
+// ServeHTTP method. Every connection is converted to a net.Conn with NetConn.
+//
+// It allows serving any library that consumes a net.Listener, such as gRPC or
+// an SSH server, behind a WebSocket endpoint.
+type Listener struct {
+	msgType MessageType
+	opts    *AcceptOptions
+
+	conns     chan net.Conn
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+var _ net.Listener = &Listener{}
+
+// NewListener returns a Listener that accepts WebSocket connections with opts
+// and converts them with NetConn using msgType.
+//
+// Register it as the handler of the endpoint to listen on.
+func NewListener(msgType MessageType, opts *AcceptOptions) *Listener {
+	return &Listener{
+		msgType: msgType,
+		opts:    opts,
+		conns:   make(chan net.Conn),
+		closed:  make(chan struct{}),
+	}
+}
+
+// ServeHTTP accepts the WebSocket connection and waits for it to be returned
+// from Accept. If the listener is closed first, the connection is closed with
+// StatusGoingAway.
+func (l *Listener) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	select {
+	case <-l.closed:
+		http.Error(w, "listener closed", http.StatusServiceUnavailable)
+		return
+	default:
+	}
+
+	c, err := Accept(w, r, l.opts)
+	if err != nil {
+		return
+	}
+
+	// The connection outlives the request so its context is not used.
+	nc := NetConn(context.Background(), c, l.msgType)
+	select {
+	case l.conns <- nc:
+	case <-l.closed:
+		c.Close(StatusGoingAway, "listener closed")
+	}
+}
+
+// Accept waits for and returns the next WebSocket connection.
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case nc := <-l.conns:
+		return nc, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close stops the listener. Already accepted connections are not closed.
+func (l *Listener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.closed)
+	})
+	return nil
+}
+
+// Addr returns a mock address as the listener is not bound to one.
+// It gives "websocket" for Network() and "websocket/unknown-addr" for String().
+func (l *Listener) Addr() net.Addr {
+	return websocketAddr{}
+}
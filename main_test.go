@@ -1,6 +1,7 @@
 package websocket_test
 
 import (
+	"flag"
 	"fmt"
 	"os"
 	"runtime"
@@ -20,6 +21,10 @@ func goroutineStacks() []byte {
 
 func TestMain(m *testing.M) {
 	code := m.Run()
+	// The fuzzing engine keeps its own goroutines running.
+	if flag.Lookup("test.fuzz").Value.String() != "" {
+		os.Exit(code)
+	}
 	if runtime.GOOS != "js" && runtime.NumGoroutine() != 1 ||
 		runtime.GOOS == "js" && runtime.NumGoroutine() != 2 {
 		fmt.Fprintf(os.Stderr, "goroutine leak detected, expected 1 but got %d goroutines\n", runtime.NumGoroutine())
@@ -1,3 +1,5 @@
+//go:build !tinygo
+
 #include "textflag.h"
 
 // func maskAsm(b *byte, len int, key uint32)
@@ -1,3 +1,5 @@
+//go:build !tinygo
+
 #include "textflag.h"
 
 // func maskAsm(b *byte, len int, key uint32)
@@ -6,8 +8,8 @@ TEXT ·maskAsm(SB), NOSPLIT, $0-28
 	// R1 = len
 	// R3 = key (uint32)
 	// R2 = uint64(key)<<32 | uint64(key)
-	MOVD  b_ptr+0(FP), R0
-	MOVD  b_len+8(FP), R1
+	MOVD  b+0(FP), R0
+	MOVD  len+8(FP), R1
 	MOVWU key+16(FP), R3
 	MOVD  R3, R2
 	ORR   R2<<32, R2, R2
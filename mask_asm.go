@@ -1,16 +1,12 @@
-//go:build amd64 || arm64
+//go:build (amd64 || arm64) && !tinygo
 
 package websocket
 
 func mask(b []byte, key uint32) uint32 {
-	// TODO: Will enable in v1.9.0.
-	return maskGo(b, key)
-	/*
-		if len(b) > 0 {
-			return maskAsm(&b[0], len(b), key)
-		}
-		return key
-	*/
+	if len(b) > 0 {
+		return maskAsm(&b[0], len(b), key)
+	}
+	return key
 }
 
 // @nhooyr: I am not confident that the amd64 or the arm64 implementations of this
@@ -22,5 +18,4 @@ func mask(b []byte, key uint32) uint32 {
 // See https://github.com/nhooyr/websocket/pull/326#issuecomment-1771138049
 //
 //go:noescape
-//lint:ignore U1000 disabled till v1.9.0
 func maskAsm(b *byte, len int, key uint32) uint32
@@ -49,9 +49,14 @@ func testMask(t *testing.T, name string, fn func(b []byte, key uint32) uint32) {
 			n, err := rand.Int(rand.Reader, big.NewInt(1<<16))
 			assert.Success(t, err)
 
-			b := make([]byte, 1+n.Int64())
+			// Offset the slice so unaligned input is covered too.
+			off, err := rand.Int(rand.Reader, big.NewInt(8))
+			assert.Success(t, err)
+
+			b := make([]byte, 1+n.Int64()+off.Int64())
 			_, err = rand.Read(b)
 			assert.Success(t, err)
+			b = b[off.Int64():]
 
 			b2 := make([]byte, len(b))
 			copy(b2, b)
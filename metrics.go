@@ -0,0 +1,30 @@
+//go:build !js
+
+package websocket
+
+import "time"
+
+// Metrics collects connection metrics. Set it with DialOptions.Metrics or
+// AcceptOptions.Metrics to instrument every connection without wrapping it.
+// See the wsmetrics subpackage for expvar and Prometheus implementations.
+//
+// Methods are called synchronously from the read and write paths so they
+// must be fast and safe for concurrent use.
+type Metrics interface {
+	// IncMessagesRead is called with the size of each data message read
+	// to completion. The size is after decompression.
+	IncMessagesRead(size int)
+
+	// IncMessagesWritten is called with the size of each data message
+	// written. The size is before compression.
+	IncMessagesWritten(size int)
+
+	// IncClose is called once per connection when it closes with the status
+	// code of the close frame received from the peer or StatusAbnormalClosure
+	// if none was.
+	IncClose(code StatusCode)
+
+	// ObserveHandshakeDuration is called with the duration of each successful
+	// opening handshake.
+	ObserveHandshakeDuration(d time.Duration)
+}
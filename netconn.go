@@ -33,11 +33,11 @@ import (
 // where only the reading/writing goroutines are interrupted but the connection
 // is kept alive.
 //
-// The Addr methods will return the real addresses for connections obtained
-// from websocket.Accept. But for connections obtained from websocket.Dial, a mock net.Addr
-// will be returned that gives "websocket" for Network() and "websocket/unknown-addr" for
-// String(). This is because websocket.Dial only exposes a io.ReadWriteCloser instead of the
-// full net.Conn to us.
+// The Addr methods will return the real addresses of the underlying connection.
+// For connections obtained from websocket.Dial, they are recorded with an
+// httptrace.ClientTrace during the handshake. If the HTTPClient's transport does
+// not report the connection, a mock net.Addr will be returned that gives
+// "websocket" for Network() and "websocket/unknown-addr" for String().
 //
 // When running as WASM, the Addr methods will always return the mock address described above.
 //
@@ -45,7 +45,10 @@ import (
 // io.EOF when reading.
 //
 // Furthermore, the ReadLimit is set to -1 to disable it.
-func NetConn(ctx context.Context, c *Conn, msgType MessageType) net.Conn {
+//
+// By default the net.Conn is a byte stream and message boundaries are not
+// visible to readers. Pass PreserveFraming to tunnel datagram style protocols.
+func NetConn(ctx context.Context, c *Conn, msgType MessageType, opts ...NetConnOption) net.Conn {
 	c.SetReadLimit(-1)
 
 	nc := &netConn{
@@ -54,6 +57,12 @@ func NetConn(ctx context.Context, c *Conn, msgType MessageType) net.Conn {
 		readMu:  newMu(c),
 		writeMu: newMu(c),
 	}
+	for _, opt := range opts {
+		switch opt {
+		case PreserveFraming:
+			nc.preserveFraming = true
+		}
+	}
 
 	nc.writeCtx, nc.writeCancel = context.WithCancel(ctx)
 	nc.readCtx, nc.readCancel = context.WithCancel(ctx)
@@ -93,9 +102,24 @@ func NetConn(ctx context.Context, c *Conn, msgType MessageType) net.Conn {
 	return nc
 }
 
+// NetConnOption is an option for NetConn.
+type NetConnOption int
+
+const (
+	// PreserveFraming makes every Read on the net.Conn return exactly one message.
+	// If the message does not fit in the buffer passed to Read, the rest of it
+	// is discarded and io.ErrShortBuffer is returned. Empty messages are skipped.
+	//
+	// Every Write is always sent as one message but the peer's Writes may not
+	// map to messages, e.g. if the peer uses a Writer or a NetConn without
+	// PreserveFraming. There is no coalescing of Writes either way.
+	PreserveFraming NetConnOption = iota + 1
+)
+
 type netConn struct {
-	c       *Conn
-	msgType MessageType
+	c               *Conn
+	msgType         MessageType
+	preserveFraming bool
 
 	writeTimer   *time.Timer
 	writeMu      *mu
@@ -141,8 +165,19 @@ func (nc *netConn) Read(p []byte) (int, error) {
 	nc.readMu.forceLock()
 	defer nc.readMu.unlock()
 
+	read := nc.read
+	if nc.preserveFraming {
+		read = nc.readMessage
+	}
 	for {
-		n, err := nc.read(p)
+		if nc.readExpired.Load() == 1 {
+			return 0, fmt.Errorf("failed to read: %w", context.DeadlineExceeded)
+		}
+		if nc.readEOFed {
+			return 0, io.EOF
+		}
+
+		n, err := read(p)
 		if err != nil {
 			return n, err
 		}
@@ -154,27 +189,9 @@ func (nc *netConn) Read(p []byte) (int, error) {
 }
 
 func (nc *netConn) read(p []byte) (int, error) {
-	if nc.readExpired.Load() == 1 {
-		return 0, fmt.Errorf("failed to read: %w", context.DeadlineExceeded)
-	}
-
-	if nc.readEOFed {
-		return 0, io.EOF
-	}
-
 	if nc.reader == nil {
-		typ, r, err := nc.c.Reader(nc.readCtx)
+		r, err := nc.nextReader()
 		if err != nil {
-			switch CloseStatus(err) {
-			case StatusNormalClosure, StatusGoingAway:
-				nc.readEOFed = true
-				return 0, io.EOF
-			}
-			return 0, err
-		}
-		if typ != nc.msgType {
-			err := fmt.Errorf("unexpected frame type read (expected %v): %v", nc.msgType, typ)
-			nc.c.Close(StatusUnsupportedData, err.Error())
 			return 0, err
 		}
 		nc.reader = r
@@ -188,6 +205,49 @@ func (nc *netConn) read(p []byte) (int, error) {
 	return n, err
 }
 
+// readMessage reads an entire message into p.
+func (nc *netConn) readMessage(p []byte) (int, error) {
+	r, err := nc.nextReader()
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := io.ReadFull(r, p)
+	switch err {
+	case io.EOF, io.ErrUnexpectedEOF:
+		return n, nil
+	case nil:
+		discarded, err := io.Copy(io.Discard, r)
+		if err != nil {
+			return n, err
+		}
+		if discarded > 0 {
+			return n, io.ErrShortBuffer
+		}
+		return n, nil
+	default:
+		return n, err
+	}
+}
+
+func (nc *netConn) nextReader() (io.Reader, error) {
+	typ, r, err := nc.c.Reader(nc.readCtx)
+	if err != nil {
+		switch CloseStatus(err) {
+		case StatusNormalClosure, StatusGoingAway:
+			nc.readEOFed = true
+			return nil, io.EOF
+		}
+		return nil, err
+	}
+	if typ != nc.msgType {
+		err := fmt.Errorf("unexpected frame type read (expected %v): %v", nc.msgType, typ)
+		nc.c.Close(StatusUnsupportedData, err.Error())
+		return nil, err
+	}
+	return r, nil
+}
+
 type websocketAddr struct{}
 
 func (a websocketAddr) Network() string {
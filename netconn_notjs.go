@@ -5,14 +5,14 @@ package websocket
 import "net"
 
 func (nc *netConn) RemoteAddr() net.Addr {
-	if unc, ok := nc.c.rwc.(net.Conn); ok {
+	if unc := nc.c.UnderlyingConn(); unc != nil {
 		return unc.RemoteAddr()
 	}
 	return websocketAddr{}
 }
 
 func (nc *netConn) LocalAddr() net.Addr {
-	if unc, ok := nc.c.rwc.(net.Conn); ok {
+	if unc := nc.c.UnderlyingConn(); unc != nil {
 		return unc.LocalAddr()
 	}
 	return websocketAddr{}
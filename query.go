@@ -0,0 +1,15 @@
+package websocket
+
+import "net/url"
+
+// addQuery appends the encoding of q to the query of u, preserving the
+// existing query as is.
+func addQuery(u *url.URL, q url.Values) {
+	if len(q) == 0 {
+		return
+	}
+	if u.RawQuery != "" {
+		u.RawQuery += "&"
+	}
+	u.RawQuery += q.Encode()
+}
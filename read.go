@@ -4,14 +4,18 @@ package websocket
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
+	"math"
 	"net"
 	"strings"
 	"sync/atomic"
 	"time"
+	"unicode/utf8"
 
 	"github.com/coder/websocket/internal/errd"
 	"github.com/coder/websocket/internal/util"
@@ -33,12 +37,86 @@ import (
 // See https://github.com/nhooyr/websocket/issues/87#issue-451703332
 // Most users should not need this.
 func (c *Conn) Reader(ctx context.Context) (MessageType, io.Reader, error) {
-	return c.reader(ctx)
+	return c.readerLimit(ctx, followReadLimit, nil)
+}
+
+// ReaderWithProgress is like Reader but calls progress with the number of
+// bytes of the message read so far after each read, e.g. to show the
+// progress of a large download. total is the size of the message if it is
+// known upfront, i.e. the message is a single uncompressed frame, and -1
+// otherwise.
+func (c *Conn) ReaderWithProgress(ctx context.Context, progress func(read, total int64)) (MessageType, io.Reader, error) {
+	return c.readerLimit(ctx, followReadLimit, progress)
+}
+
+// ReaderWithLimit is like Reader but limits the message to n bytes instead
+// of the limit set with SetReadLimit, e.g. to allow a large upload while
+// keeping a small limit for all other messages. Set n to -1 to disable the
+// limit for the message.
+func (c *Conn) ReaderWithLimit(ctx context.Context, n int64) (MessageType, io.Reader, error) {
+	return c.readerLimit(ctx, readLimit(n), nil)
+}
+
+func (c *Conn) readerLimit(ctx context.Context, limit int64, progress func(read, total int64)) (MessageType, io.Reader, error) {
+	for {
+		typ, r, err := c.reader(ctx, limit, progress)
+		if err != nil || !c.emulatedPing {
+			return typ, r, err
+		}
+
+		r, err = c.handleEmulatedPing(ctx, typ, r)
+		if err != nil {
+			return 0, nil, err
+		}
+		if r != nil {
+			return typ, r, nil
+		}
+	}
+}
+
+// handleEmulatedPing responds to the message if it is an emulated ping and
+// returns nil. Otherwise it returns a reader for the message.
+func (c *Conn) handleEmulatedPing(ctx context.Context, typ MessageType, r io.Reader) (io.Reader, error) {
+	if typ != MessageBinary || !c.msgReader.fin || c.msgReader.payloadLength > maxControlPayload {
+		return r, nil
+	}
+
+	// Progress is only reported once the message is known not to be a ping.
+	progress, total := c.msgReader.progress, c.msgReader.total
+	c.msgReader.progress = nil
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(b, emulatedPingPrefix) {
+		if progress != nil && len(b) > 0 {
+			progress(int64(len(b)), total)
+		}
+		return bytes.NewReader(b), nil
+	}
+
+	pong, ok, err := c.pongPayload(ctx, b[len(emulatedPingPrefix):])
+	if !ok {
+		return nil, err
+	}
+	err = c.Write(ctx, MessageBinary, append(emulatedPongPrefix[:len(emulatedPongPrefix):len(emulatedPongPrefix)], pong...))
+	if err != nil {
+		return nil, fmt.Errorf("failed to respond to emulated ping: %w", err)
+	}
+	return nil, nil
 }
 
 // Read is a convenience method around Reader to read a single message
 // from the connection.
 func (c *Conn) Read(ctx context.Context) (MessageType, []byte, error) {
+	if c.concurrentReads {
+		err := c.readMsgMu.lock(ctx)
+		if err != nil {
+			return 0, nil, fmt.Errorf("failed to read: %w", err)
+		}
+		defer c.readMsgMu.unlock()
+	}
+
 	typ, r, err := c.Reader(ctx)
 	if err != nil {
 		return 0, nil, err
@@ -48,6 +126,21 @@ func (c *Conn) Read(ctx context.Context) (MessageType, []byte, error) {
 	return typ, b, err
 }
 
+// ReadTimeout is like Read but bounded by timeout instead of a context.
+// It avoids allocating a context per message which matters at high message
+// rates.
+//
+// If the timeout expires, the connection is closed and an error wrapping
+// context.DeadlineExceeded is returned.
+func (c *Conn) ReadTimeout(timeout time.Duration) (MessageType, []byte, error) {
+	dt := c.startDeadlineTimer(timeout)
+	typ, p, err := c.Read(context.Background())
+	if dt.stop() && err != nil {
+		return 0, nil, fmt.Errorf("failed to read: %w", context.DeadlineExceeded)
+	}
+	return typ, p, err
+}
+
 // CloseRead starts a goroutine to read from the connection until it is closed
 // or a data message is received.
 //
@@ -55,53 +148,125 @@ func (c *Conn) Read(ctx context.Context) (MessageType, []byte, error) {
 // The returned context will be cancelled when the connection is closed.
 //
 // If a data message is received, the connection will be closed with StatusPolicyViolation.
+// Once the connection is closed, context.Cause of the returned context is
+// its CloseCause.
 //
 // Call CloseRead when you do not expect to read any more messages.
 // Since it actively reads from the connection, it will ensure that ping, pong and close
 // frames are responded to. This means c.Ping and c.Close will still work as expected.
 //
-// This function is idempotent.
+// This function is idempotent. Call ResumeRead to read messages again.
 func (c *Conn) CloseRead(ctx context.Context) context.Context {
 	c.closeReadMu.Lock()
 	ctx2 := c.closeReadCtx
 	if ctx2 != nil {
+		c.closeReadResume = false
 		c.closeReadMu.Unlock()
 		return ctx2
 	}
-	ctx, cancel := context.WithCancel(ctx)
+	ctx, cancel := context.WithCancelCause(ctx)
+	done := make(chan struct{})
 	c.closeReadCtx = ctx
-	c.closeReadDone = make(chan struct{})
+	c.closeReadDone = done
 	c.closeReadMu.Unlock()
 
 	go func() {
-		defer close(c.closeReadDone)
-		defer cancel()
-		defer c.close()
-		_, _, err := c.Reader(ctx)
+		defer close(done)
+		defer func() {
+			cancel(c.CloseCause())
+		}()
+		resumed, err := c.closeRead(ctx)
+		if resumed {
+			return
+		}
 		if err == nil {
 			c.Close(StatusPolicyViolation, "unexpected data message")
+		} else {
+			c.setCloseCause(err)
 		}
+		c.close()
 	}()
 	return ctx
 }
 
+// ResumeRead undoes CloseRead for protocols that alternate between write
+// only and interactive phases. Instead of failing the connection, the
+// CloseRead goroutine hands the next data message to Reader and Read and
+// exits, cancelling the context returned by CloseRead. Control frames are
+// still handled until then. Reader and Read may be called right away and
+// wait for the message.
+//
+// Call CloseRead again to undo ResumeRead. It does nothing if CloseRead
+// was not called.
+func (c *Conn) ResumeRead() {
+	c.closeReadMu.Lock()
+	defer c.closeReadMu.Unlock()
+	if c.closeReadCtx != nil {
+		c.closeReadResume = true
+	}
+}
+
+// closeRead reads until a data frame is received. It reports whether the
+// frame was handed to Reader as ResumeRead was called.
+func (c *Conn) closeRead(ctx context.Context) (resumed bool, err error) {
+	// Frames, e.g. the peer's close frame, are read with readMu held.
+	defer c.runCallbacks()
+	err = c.readMu.lock(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer c.readMu.unlock()
+
+	if !c.msgReader.fin {
+		return false, errors.New("previous message not read to completion")
+	}
+
+	h, err := c.readLoop(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	c.closeReadMu.Lock()
+	defer c.closeReadMu.Unlock()
+	if !c.closeReadResume {
+		return false, nil
+	}
+	c.readPending = &h
+	c.closeReadCtx = nil
+	c.closeReadResume = false
+	return true, nil
+}
+
 // SetReadLimit sets the max number of bytes to read for a single message.
 // It applies to the Reader and Read methods.
 //
 // By default, the connection has a message read limit of 32768 bytes.
 //
+// It may be called while a message is being read, e.g. to raise the limit
+// once the peer authenticated. The new limit applies to the rest of the
+// message immediately, counting the bytes already read. Messages read with
+// ReaderWithLimit keep their own limit.
+//
 // When the limit is hit, reads return an error wrapping ErrMessageTooBig and
 // the connection is closed with StatusMessageTooBig.
 //
 // Set to -1 to disable.
 func (c *Conn) SetReadLimit(n int64) {
+	c.msgReader.limitReader.limit.Store(readLimit(n))
+}
+
+// followReadLimit is the limit of a message that follows the limit set with
+// SetReadLimit, even if it changes while the message is read.
+const followReadLimit = math.MinInt64
+
+// readLimit returns the limit of the limitReader for a read limit of n.
+func readLimit(n int64) int64 {
 	if n >= 0 {
 		// We read one more byte than the limit in case
 		// there is a fin frame that needs to be read.
 		n++
 	}
-
-	c.msgReader.limitReader.limit.Store(n)
+	return n
 }
 
 const defaultReadLimit = 32768
@@ -129,9 +294,9 @@ func (mr *msgReader) resetFlate() {
 	}
 
 	if mr.flateContextTakeover() {
-		mr.flateReader = getFlateReader(mr.flateBufio, mr.dict.buf)
+		mr.flateReader = getFlateReader(mr.c.copts.flateBackend(), mr.flateBufio, mr.dict.buf)
 	} else {
-		mr.flateReader = getFlateReader(mr.flateBufio, nil)
+		mr.flateReader = getFlateReader(mr.c.copts.flateBackend(), mr.flateBufio, mr.c.copts.dict)
 	}
 	mr.limitReader.r = mr.flateReader
 	mr.flateTail.Reset(deflateMessageTail)
@@ -139,7 +304,7 @@ func (mr *msgReader) resetFlate() {
 
 func (mr *msgReader) putFlateReader() {
 	if mr.flateReader != nil {
-		putFlateReader(mr.flateReader)
+		putFlateReader(mr.c.copts.flateBackend(), mr.flateReader)
 		mr.flateReader = nil
 	}
 }
@@ -180,6 +345,17 @@ func (c *Conn) readRSV1Illegal(h header) bool {
 	return false
 }
 
+// readExtRSVIllegal reports whether h has RSV2 or RSV3 set without a
+// negotiated extension claiming them.
+func (c *Conn) readExtRSVIllegal(h header) bool {
+	rsv := h.extRSV()
+	if rsv == 0 {
+		return false
+	}
+	// Extension bits are only allowed on data frames beginning messages.
+	return rsv&^c.extRSV != 0 || h.opcode != opText && h.opcode != opBinary
+}
+
 func (c *Conn) readLoop(ctx context.Context) (header, error) {
 	for {
 		h, err := c.readFrameHeader(ctx)
@@ -187,14 +363,15 @@ func (c *Conn) readLoop(ctx context.Context) (header, error) {
 			return header{}, err
 		}
 
-		if h.rsv1 && c.readRSV1Illegal(h) || h.rsv2 || h.rsv3 {
-			err := fmt.Errorf("received header with unexpected rsv bits set: %v:%v:%v", h.rsv1, h.rsv2, h.rsv3)
-			c.writeError(StatusProtocolError, err)
-			return header{}, err
+		if h.rsv1 && c.readRSV1Illegal(h) || c.readExtRSVIllegal(h) {
+			return header{}, c.failFrame(h, errors.New("received header with unexpected rsv bits set"))
 		}
 
-		if !c.client && !h.masked {
-			return header{}, errors.New("received unmasked frame from client")
+		if !c.client && !h.masked && !c.allowUnmasked {
+			return header{}, c.failFrame(h, errors.New("received unmasked frame from client, see AcceptOptions.AllowUnmaskedFrames"))
+		}
+		if c.client && h.masked && c.rejectMasked {
+			return header{}, c.failFrame(h, errors.New("received masked frame from server"))
 		}
 
 		switch h.opcode {
@@ -210,9 +387,10 @@ func (c *Conn) readLoop(ctx context.Context) (header, error) {
 		case opContinuation, opText, opBinary:
 			return h, nil
 		default:
-			err := fmt.Errorf("received unknown opcode %v", h.opcode)
-			c.writeError(StatusProtocolError, err)
-			return header{}, err
+			err = c.handleReserved(ctx, h)
+			if err != nil {
+				return header{}, err
+			}
 		}
 	}
 }
@@ -268,6 +446,8 @@ func (c *Conn) readFrameHeader(ctx context.Context) (_ header, err error) {
 	if err != nil {
 		return header{}, err
 	}
+	c.readFrameLeft = h.payloadLength
+	c.resetIdleTimer()
 
 	return h, nil
 }
@@ -280,27 +460,80 @@ func (c *Conn) readFramePayload(ctx context.Context, p []byte) (_ int, err error
 	defer c.finishRead(ctx, &err, timeoutSet)
 
 	n, err := io.ReadFull(c.br, p)
+	c.readFrameLeft -= int64(n)
 	if err != nil {
 		return n, fmt.Errorf("failed to read frame payload: %w", err)
 	}
+	c.resetIdleTimer()
 
 	return n, nil
 }
 
+func (c *Conn) resetIdleTimer() {
+	if c.idleTimer != nil {
+		c.idleTimer.Reset(c.idleTimeout)
+	}
+}
+
+// handleReserved passes a frame with a reserved opcode to OnReservedFrame.
+func (c *Conn) handleReserved(ctx context.Context, h header) error {
+	if c.onReservedFrame == nil {
+		return c.failFrame(h, fmt.Errorf("received unknown opcode %v", h.opcode))
+	}
+
+	limit := c.msgReader.limitReader.limit.Load() - 1
+	if limit < 0 {
+		limit = defaultReadLimit
+	}
+	if h.opcode > opPong {
+		limit = maxControlPayload
+	}
+	if !h.fin || h.payloadLength > limit {
+		return c.failFrame(h, fmt.Errorf("received invalid frame with reserved opcode %v", h.opcode))
+	}
+
+	b := make([]byte, h.payloadLength)
+	_, err := c.readFramePayload(ctx, b)
+	if err != nil {
+		return err
+	}
+	if h.masked {
+		mask(b, h.maskKey)
+	}
+
+	if !c.onReservedFrame(ctx, int(h.opcode), b) {
+		return c.failFrame(h, fmt.Errorf("received unhandled opcode %v", h.opcode))
+	}
+	return nil
+}
+
+// pongPayload returns the payload of the pong answering a ping with payload p
+// or false if no pong should be sent.
+func (c *Conn) pongPayload(ctx context.Context, p []byte) ([]byte, bool, error) {
+	switch {
+	case c.onPingReply != nil:
+		pong, ok := c.onPingReply(ctx, p)
+		if ok && len(pong) > maxControlPayload {
+			return nil, false, fmt.Errorf("pong payload of %d bytes from OnPingReply exceeds %d bytes", len(pong), maxControlPayload)
+		}
+		return pong, ok, nil
+	case c.onPingReceived != nil:
+		return p, c.onPingReceived(ctx, p), nil
+	default:
+		return p, true, nil
+	}
+}
+
 func (c *Conn) handleControl(ctx context.Context, h header) (err error) {
 	if h.payloadLength < 0 || h.payloadLength > maxControlPayload {
-		err := fmt.Errorf("received control frame payload with invalid length: %d", h.payloadLength)
-		c.writeError(StatusProtocolError, err)
-		return err
+		return c.failFrame(h, errors.New("received control frame payload with invalid length"))
 	}
 
 	if !h.fin {
-		err := errors.New("received fragmented control frame")
-		c.writeError(StatusProtocolError, err)
-		return err
+		return c.failFrame(h, errors.New("received fragmented control frame"))
 	}
 
-	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	ctx, cancel := c.withTimeout(ctx, c.controlTimeout())
 	defer cancel()
 
 	b := c.readControlBuf[:h.payloadLength]
@@ -315,22 +548,26 @@ func (c *Conn) handleControl(ctx context.Context, h header) (err error) {
 
 	switch h.opcode {
 	case opPing:
-		if c.onPingReceived != nil {
-			if !c.onPingReceived(ctx, b) {
-				return nil
-			}
+		pong, ok, err := c.pongPayload(ctx, b)
+		if !ok {
+			return err
 		}
-		return c.writeControl(ctx, opPong, b)
+		return c.writeControl(ctx, opPong, pong)
 	case opPong:
 		if c.onPongReceived != nil {
 			c.onPongReceived(ctx, b)
 		}
 		c.activePingsMu.Lock()
-		pong, ok := c.activePings[string(b)]
+		ap, ok := c.activePings[string(b)]
+		if !ok {
+			for _, ap := range c.activePings {
+				ap.unmatched = append([]byte(nil), b...)
+			}
+		}
 		c.activePingsMu.Unlock()
 		if ok {
 			select {
-			case pong <- struct{}{}:
+			case ap.pong <- struct{}{}:
 			default:
 			}
 		}
@@ -341,23 +578,33 @@ func (c *Conn) handleControl(ctx context.Context, h header) (err error) {
 
 	ce, err := parseClosePayload(b)
 	if err != nil {
-		err = fmt.Errorf("received invalid close payload: %w", err)
-		c.writeError(StatusProtocolError, err)
+		return c.failProtocol(fmt.Errorf("received invalid close payload: %w", err))
+	}
+	if c.strictUTF8 && !utf8.ValidString(ce.Reason) {
+		err = errors.New("received invalid UTF-8 in close reason")
+		c.writeError(StatusInvalidFramePayloadData, err)
 		return err
 	}
 
+	c.log(slog.LevelDebug, "received close frame", "code", ce.Code, "reason", ce.Reason)
+	if c.onCloseReceived != nil {
+		c.queueCallback(func() { c.onCloseReceived(ctx, ce.Code, ce.Reason) })
+	}
+
 	err = fmt.Errorf("received close frame: %w", ce)
+	c.setCloseCause(err)
 	c.closeStateMu.Lock()
 	c.closeReceivedErr = err
 	closeSent := c.closeSentErr != nil
 	c.closeStateMu.Unlock()
+	c.setState(StateClosingReceived)
 
 	// Only unlock readMu if this connection is being closed becaue
 	// c.close will try to acquire the readMu lock. We unlock for
 	// writeClose as well because it may also call c.close.
 	if !closeSent {
 		c.readMu.unlock()
-		_ = c.writeClose(ce.Code, ce.Reason)
+		_ = c.writeClose(context.Background(), ce.Code, ce.Reason)
 	}
 	if !c.casClosing() {
 		c.readMu.unlock()
@@ -366,9 +613,10 @@ func (c *Conn) handleControl(ctx context.Context, h header) (err error) {
 	return err
 }
 
-func (c *Conn) reader(ctx context.Context) (_ MessageType, _ io.Reader, err error) {
+func (c *Conn) reader(ctx context.Context, limit int64, progress func(read, total int64)) (_ MessageType, _ io.Reader, err error) {
 	defer errd.Wrap(&err, "failed to get reader")
 
+	defer c.runCallbacks()
 	err = c.readMu.lock(ctx)
 	if err != nil {
 		return 0, nil, err
@@ -379,19 +627,26 @@ func (c *Conn) reader(ctx context.Context) (_ MessageType, _ io.Reader, err erro
 		return 0, nil, errors.New("previous message not read to completion")
 	}
 
-	h, err := c.readLoop(ctx)
-	if err != nil {
-		return 0, nil, err
+	var h header
+	if c.readPending != nil {
+		h, c.readPending = *c.readPending, nil
+	} else {
+		h, err = c.readLoop(ctx)
+		if err != nil {
+			return 0, nil, err
+		}
 	}
 
 	if h.opcode == opContinuation {
-		err := errors.New("received continuation frame without text or binary frame")
-		c.writeError(StatusProtocolError, err)
-		return 0, nil, err
+		return 0, nil, c.failFrame(h, errors.New("received continuation frame without text or binary frame"))
 	}
 
-	c.msgReader.reset(ctx, h)
+	// Set before the reader is wrapped as wrappers may read right away.
+	c.msgReader.reset(ctx, h, limit, progress)
 
+	if h.extRSV() != 0 {
+		return MessageType(h.opcode), c.extensionReader(c.msgReader, h.extRSV()), nil
+	}
 	return MessageType(h.opcode), c.msgReader, nil
 }
 
@@ -400,24 +655,46 @@ type msgReader struct {
 
 	ctx         context.Context
 	flate       bool
-	flateReader io.Reader
+	flateReader FlateReader
 	flateBufio  *bufio.Reader
 	flateTail   strings.Reader
 	limitReader *limitReader
 	dict        *slidingWindow
 
+	typ          MessageType
+	validateUTF8 bool
+	utf8         utf8Validator
+
 	fin           bool
 	payloadLength int64
+	masked        bool
 	maskKey       uint32
 
+	// size is the number of bytes of the message read so far, reported to
+	// Metrics at EOF.
+	size int
+	// total is the size of the message if known upfront and -1 otherwise.
+	total int64
+	// progress is called with size and total, see ReaderWithProgress.
+	progress func(read, total int64)
+
 	// util.ReaderFunc(mr.Read) to avoid continuous allocations.
 	readFunc util.ReaderFunc
 }
 
-func (mr *msgReader) reset(ctx context.Context, h header) {
+func (mr *msgReader) reset(ctx context.Context, h header, limit int64, progress func(read, total int64)) {
 	mr.ctx = ctx
 	mr.flate = h.rsv1
-	mr.limitReader.reset(mr.readFunc)
+	mr.limitReader.reset(mr.readFunc, limit)
+	mr.typ = MessageType(h.opcode)
+	mr.validateUTF8 = mr.c.strictUTF8 && h.opcode == opText
+	mr.utf8.reset()
+	mr.size = 0
+	mr.total = -1
+	if h.fin && !h.rsv1 && h.extRSV() == 0 {
+		mr.total = h.payloadLength
+	}
+	mr.progress = progress
 
 	if mr.flate {
 		mr.resetFlate()
@@ -429,10 +706,12 @@ func (mr *msgReader) reset(ctx context.Context, h header) {
 func (mr *msgReader) setFrame(h header) {
 	mr.fin = h.fin
 	mr.payloadLength = h.payloadLength
+	mr.masked = h.masked
 	mr.maskKey = h.maskKey
 }
 
 func (mr *msgReader) Read(p []byte) (n int, err error) {
+	defer mr.c.runCallbacks()
 	err = mr.c.readMu.lock(mr.ctx)
 	if err != nil {
 		return 0, fmt.Errorf("failed to read: %w", err)
@@ -440,12 +719,37 @@ func (mr *msgReader) Read(p []byte) (n int, err error) {
 	defer mr.c.readMu.unlock()
 
 	n, err = mr.limitReader.Read(p)
+	mr.size += n
+	if mr.progress != nil && n > 0 {
+		mr.progress(int64(mr.size), mr.total)
+	}
+	if mr.flate {
+		mr.c.stats.uncompressedRead.Add(int64(n))
+	}
 	if mr.flate && mr.flateContextTakeover() {
 		p = p[:n]
 		mr.dict.write(p)
 	}
-	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) && mr.fin && mr.flate {
+	if mr.validateUTF8 && !mr.utf8.write(p[:n]) {
+		return n, mr.invalidUTF8()
+	}
+	// Only an unwrapped io.EOF marks the end of the message. A wrapped one is
+	// from the connection closing mid message.
+	if err == io.EOF || errors.Is(err, io.ErrUnexpectedEOF) && mr.fin && mr.flate {
 		mr.putFlateReader()
+		if mr.validateUTF8 && !mr.utf8.done() {
+			return n, mr.invalidUTF8()
+		}
+		if mr.size >= 0 {
+			if mr.flate {
+				mr.c.stats.messagesRead.Add(1)
+			}
+			if mr.c.metrics != nil {
+				mr.c.metrics.IncMessagesRead(mr.size)
+			}
+			// Only report once if read past EOF.
+			mr.size = -1
+		}
 		return n, io.EOF
 	}
 	if err != nil {
@@ -454,6 +758,12 @@ func (mr *msgReader) Read(p []byte) (n int, err error) {
 	return n, nil
 }
 
+func (mr *msgReader) invalidUTF8() error {
+	err := errors.New("received invalid UTF-8 in text message")
+	mr.c.writeError(StatusInvalidFramePayloadData, err)
+	return fmt.Errorf("failed to read: %w", err)
+}
+
 func (mr *msgReader) read(p []byte) (int, error) {
 	for {
 		if mr.payloadLength == 0 {
@@ -469,9 +779,7 @@ func (mr *msgReader) read(p []byte) (int, error) {
 				return 0, err
 			}
 			if h.opcode != opContinuation {
-				err := errors.New("received new data message without finishing the previous message")
-				mr.c.writeError(StatusProtocolError, err)
-				return 0, err
+				return 0, mr.c.failFrame(h, errors.New("received new data message without finishing the previous message"))
 			}
 			mr.setFrame(h)
 
@@ -483,13 +791,16 @@ func (mr *msgReader) read(p []byte) (int, error) {
 		}
 
 		n, err := mr.c.readFramePayload(mr.ctx, p)
+		if mr.flate {
+			mr.c.stats.compressedRead.Add(int64(n))
+		}
 		if err != nil {
 			return n, err
 		}
 
 		mr.payloadLength -= int64(n)
 
-		if !mr.c.client {
+		if mr.masked {
 			mr.maskKey = mask(p, mr.maskKey)
 		}
 
@@ -501,7 +812,11 @@ type limitReader struct {
 	c     *Conn
 	r     io.Reader
 	limit atomic.Int64
-	n     int64
+	// max is the limit of the current message or followReadLimit to use
+	// limit.
+	max int64
+	// n is the number of bytes of the current message read.
+	n int64
 }
 
 func newLimitReader(c *Conn, r io.Reader, limit int64) *limitReader {
@@ -509,33 +824,44 @@ func newLimitReader(c *Conn, r io.Reader, limit int64) *limitReader {
 		c: c,
 	}
 	lr.limit.Store(limit)
-	lr.reset(r)
+	lr.reset(r, followReadLimit)
 	return lr
 }
 
-func (lr *limitReader) reset(r io.Reader) {
-	lr.n = lr.limit.Load()
+func (lr *limitReader) reset(r io.Reader, limit int64) {
+	lr.max = limit
+	lr.n = 0
 	lr.r = r
 }
 
 func (lr *limitReader) Read(p []byte) (int, error) {
-	if lr.n < 0 {
+	max := lr.max
+	if max == followReadLimit {
+		max = lr.limit.Load()
+	}
+	if max < 0 {
 		return lr.r.Read(p)
 	}
 
-	if lr.n == 0 {
-		reason := fmt.Errorf("read limited at %d bytes", lr.limit.Load())
+	if lr.n >= max {
+		reason := fmt.Errorf("read limited at %d bytes", max)
 		lr.c.writeError(StatusMessageTooBig, reason)
-		return 0, fmt.Errorf("%w: %v", ErrMessageTooBig, reason)
+		mtbe := &MessageTooBigError{
+			MessageType: lr.c.msgReader.typ,
+			Limit:       max - 1,
+			Read:        lr.n,
+		}
+		if !lr.c.msgReader.flate {
+			// The rest of the frame is not read yet.
+			mtbe.Size = lr.n + lr.c.msgReader.payloadLength
+		}
+		return 0, mtbe
 	}
 
-	if int64(len(p)) > lr.n {
-		p = p[:lr.n]
+	if int64(len(p)) > max-lr.n {
+		p = p[:max-lr.n]
 	}
 	n, err := lr.r.Read(p)
-	lr.n -= int64(n)
-	if lr.n < 0 {
-		lr.n = 0
-	}
+	lr.n += int64(n)
 	return n, err
 }
@@ -0,0 +1,43 @@
+package websocket
+
+import "strconv"
+
+// State is the state of a connection in its lifecycle, modeled after the
+// readyState of browser WebSockets. See Conn.State.
+type State int
+
+// State constants.
+const (
+	// StateConnecting is the state of a connection whose opening handshake
+	// is in progress. Conn.State never returns it as Dial and Accept return
+	// once the handshake completed.
+	StateConnecting State = iota
+	// StateOpen is the state of a connection that may send and receive
+	// messages.
+	StateOpen
+	// StateClosingSent is the state of a connection that sent a close frame
+	// and waits for the peer's.
+	StateClosingSent
+	// StateClosingReceived is the state of a connection that received the
+	// peer's close frame before sending one.
+	StateClosingReceived
+	// StateClosed is the state of a closed connection.
+	StateClosed
+)
+
+func (s State) String() string {
+	switch s {
+	case StateConnecting:
+		return "StateConnecting"
+	case StateOpen:
+		return "StateOpen"
+	case StateClosingSent:
+		return "StateClosingSent"
+	case StateClosingReceived:
+		return "StateClosingReceived"
+	case StateClosed:
+		return "StateClosed"
+	default:
+		return "State(" + strconv.Itoa(int(s)) + ")"
+	}
+}
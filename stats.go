@@ -0,0 +1,68 @@
+//go:build !js
+
+package websocket
+
+import "sync/atomic"
+
+// Stats holds statistics about the messages compressed with
+// permessage-deflate on a connection. Use them to decide whether compression,
+// and CompressionContextTakeover in particular, is worth its memory for a
+// workload.
+//
+// Messages below the CompressionThreshold or written with NoCompress are not
+// compressed and not counted.
+type Stats struct {
+	// CompressedMessagesRead and CompressedMessagesWritten count the
+	// compressed data messages read to completion and written.
+	CompressedMessagesRead    int64
+	CompressedMessagesWritten int64
+
+	// UncompressedBytesRead and UncompressedBytesWritten are the sizes of
+	// the compressed messages before compression.
+	UncompressedBytesRead    int64
+	UncompressedBytesWritten int64
+
+	// CompressedBytesRead and CompressedBytesWritten are the sizes of the
+	// compressed messages on the wire, without frame headers.
+	CompressedBytesRead    int64
+	CompressedBytesWritten int64
+}
+
+// CompressionRatio returns the size of the compressed messages on the wire
+// relative to their uncompressed size in both directions, e.g. 0.25 if they
+// compressed to a quarter. It returns 1 if no bytes were compressed.
+func (s Stats) CompressionRatio() float64 {
+	uncompressed := s.UncompressedBytesRead + s.UncompressedBytesWritten
+	if uncompressed == 0 {
+		return 1
+	}
+	return float64(s.CompressedBytesRead+s.CompressedBytesWritten) / float64(uncompressed)
+}
+
+// Stats returns the compression statistics of the connection.
+func (c *Conn) Stats() Stats {
+	return Stats{
+		CompressedMessagesRead:    c.stats.messagesRead.Load(),
+		CompressedMessagesWritten: c.stats.messagesWritten.Load(),
+		UncompressedBytesRead:     c.stats.uncompressedRead.Load(),
+		UncompressedBytesWritten:  c.stats.uncompressedWritten.Load(),
+		CompressedBytesRead:       c.stats.compressedRead.Load(),
+		CompressedBytesWritten:    c.stats.compressedWritten.Load(),
+	}
+}
+
+type connStats struct {
+	messagesRead        atomic.Int64
+	messagesWritten     atomic.Int64
+	uncompressedRead    atomic.Int64
+	uncompressedWritten atomic.Int64
+	compressedRead      atomic.Int64
+	compressedWritten   atomic.Int64
+}
+
+// messageWritten records a compressed message of size bytes before
+// compression.
+func (s *connStats) messageWritten(size int) {
+	s.messagesWritten.Add(1)
+	s.uncompressedWritten.Add(int64(size))
+}
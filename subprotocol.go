@@ -0,0 +1,30 @@
+package websocket
+
+import (
+	"strconv"
+	"strings"
+)
+
+// ParseSubprotocolVersion parses a versioned subprotocol of the form
+// "name.vN" such as "chat.v2". ok is false if p is not of that form.
+func ParseSubprotocolVersion(p string) (name string, version int, ok bool) {
+	i := strings.LastIndex(p, ".v")
+	if i <= 0 {
+		return "", 0, false
+	}
+	v := p[i+2:]
+	if v == "" || strings.TrimLeft(v, "0123456789") != "" {
+		return "", 0, false
+	}
+	version, err := strconv.Atoi(v)
+	if err != nil {
+		return "", 0, false
+	}
+	return p[:i], version, true
+}
+
+// SubprotocolVersion parses the negotiated subprotocol with
+// ParseSubprotocolVersion.
+func (c *Conn) SubprotocolVersion() (name string, version int, ok bool) {
+	return ParseSubprotocolVersion(c.Subprotocol())
+}
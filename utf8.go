@@ -0,0 +1,54 @@
+//go:build !js
+
+package websocket
+
+import (
+	"unicode/utf8"
+)
+
+// utf8Validator incrementally validates UTF-8 split across
+// arbitrary chunk boundaries.
+type utf8Validator struct {
+	partial [utf8.UTFMax]byte
+	n       int
+}
+
+func (v *utf8Validator) reset() {
+	v.n = 0
+}
+
+// write reports whether p is valid UTF-8 given the bytes written before it.
+// An incomplete rune at the end of p is held until the next write.
+func (v *utf8Validator) write(p []byte) bool {
+	for v.n > 0 && len(p) > 0 {
+		v.partial[v.n] = p[0]
+		v.n++
+		p = p[1:]
+		if utf8.FullRune(v.partial[:v.n]) {
+			r, size := utf8.DecodeRune(v.partial[:v.n])
+			if r == utf8.RuneError && size == 1 {
+				return false
+			}
+			v.n = 0
+		}
+	}
+
+	// Find the start of the last rune to check whether it is complete.
+	i := len(p) - 1
+	for ; i >= 0 && i >= len(p)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(p[i]) {
+			break
+		}
+	}
+	if i >= 0 && !utf8.FullRune(p[i:]) {
+		v.n = copy(v.partial[:], p[i:])
+		p = p[:i]
+	}
+
+	return utf8.Valid(p)
+}
+
+// done reports whether the bytes written so far ended on a rune boundary.
+func (v *utf8Validator) done() bool {
+	return v.n == 0
+}
@@ -0,0 +1,64 @@
+//go:build !js
+
+package websocket
+
+import (
+	"testing"
+	"unicode/utf8"
+
+	"github.com/coder/websocket/internal/test/assert"
+	"github.com/coder/websocket/internal/test/xrand"
+)
+
+func Test_utf8Validator(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name string
+		s    string
+	}{
+		{name: "empty", s: ""},
+		{name: "ascii", s: "hello world"},
+		{name: "multibyte", s: "κόσμε 世界 🌍"},
+		{name: "invalidStart", s: "hello \xff world"},
+		{name: "truncated", s: "hello \xe4\xb8"},
+		{name: "overlong", s: "\xc0\xaf"},
+		{name: "surrogate", s: "\xed\xa0\x80"},
+		{name: "badContinuation", s: "\xe4\x41\x96"},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			exp := utf8.ValidString(tc.s)
+			b := []byte(tc.s)
+
+			// Every split point must give the same result.
+			for i := 0; i <= len(b); i++ {
+				for j := i; j <= len(b); j++ {
+					var v utf8Validator
+					ok := v.write(b[:i]) && v.write(b[i:j]) && v.write(b[j:]) && v.done()
+					assert.Equal(t, "valid", exp, ok)
+				}
+			}
+		})
+	}
+
+	t.Run("random", func(t *testing.T) {
+		t.Parallel()
+
+		for range 1000 {
+			b := xrand.Bytes(xrand.Int(64))
+			var v utf8Validator
+			ok := true
+			for p := b; len(p) > 0 && ok; {
+				n := min(len(p), 1+xrand.Int(8))
+				ok = v.write(p[:n])
+				p = p[n:]
+			}
+			assert.Equal(t, "valid", utf8.Valid(b), ok && v.done())
+		}
+	})
+}
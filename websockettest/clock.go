@@ -0,0 +1,83 @@
+//go:build !js
+
+package websockettest
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/coder/websocket"
+)
+
+// FakeClock is a websocket.Clock that only advances when Advance is called
+// so that tests can expire the timeouts of a connection without sleeping.
+// Set it as DialOptions.Clock and AcceptOptions.Clock.
+//
+// The zero value is ready to use.
+type FakeClock struct {
+	mu     sync.Mutex
+	now    time.Duration
+	timers []*fakeTimer
+}
+
+var _ websocket.Clock = &FakeClock{}
+
+// AfterFunc implements websocket.Clock.
+func (c *FakeClock) AfterFunc(d time.Duration, f func()) websocket.ClockTimer {
+	t := &fakeTimer{c: c, f: f}
+	t.Reset(d)
+	return t
+}
+
+// Advance moves the clock forward by d and calls the functions of the timers
+// that expired, each in its own goroutine.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now += d
+	var expired []*fakeTimer
+	c.timers = slices.DeleteFunc(c.timers, func(t *fakeTimer) bool {
+		if t.when > c.now {
+			return false
+		}
+		expired = append(expired, t)
+		return true
+	})
+	c.mu.Unlock()
+
+	for _, t := range expired {
+		go t.f()
+	}
+}
+
+type fakeTimer struct {
+	c    *FakeClock
+	f    func()
+	when time.Duration
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.c.mu.Lock()
+	defer t.c.mu.Unlock()
+	return t.stop()
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.c.mu.Lock()
+	defer t.c.mu.Unlock()
+	active := t.stop()
+	t.when = t.c.now + d
+	t.c.timers = append(t.c.timers, t)
+	return active
+}
+
+// stop removes t from the timers of the clock. It must be called with the
+// clock's mu held.
+func (t *fakeTimer) stop() bool {
+	i := slices.Index(t.c.timers, t)
+	if i < 0 {
+		return false
+	}
+	t.c.timers = slices.Delete(t.c.timers, i, i+1)
+	return true
+}
@@ -0,0 +1,26 @@
+//go:build !js
+
+package websockettest
+
+import (
+	"testing"
+
+	"github.com/coder/websocket"
+)
+
+// AssertCloseStatus fails tb unless err is from a connection closed with
+// the status code exp.
+//
+// websocket.StatusAbnormalClosure matches any error other than a close
+// error, such as from a transport closed without a close frame.
+func AssertCloseStatus(tb testing.TB, err error, exp websocket.StatusCode) {
+	tb.Helper()
+
+	got := websocket.CloseStatus(err)
+	if got == -1 && err != nil {
+		got = websocket.StatusAbnormalClosure
+	}
+	if got != exp {
+		tb.Fatalf("expected close status %v but got %v: %v", exp, got, err)
+	}
+}
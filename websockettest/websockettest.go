@@ -0,0 +1,315 @@
+//go:build !js
+
+// Package websockettest provides an in memory transport for testing code
+// built on this module without real sockets.
+//
+// Pipe connects a client and server Conn analogous to net.Pipe. Options can
+// delay, drop and truncate frames in transit and sever the transport to
+// exercise timeouts, retries and other error paths. FakeClock expires the
+// timeouts of a connection without sleeping.
+package websockettest // import "github.com/coder/websocket/websockettest"
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"time"
+
+	"github.com/coder/websocket"
+)
+
+// Options configures Pipe.
+type Options struct {
+	// DialOptions and AcceptOptions configure the client and server ends,
+	// including the compression mode each end negotiates.
+	// DialOptions.HTTPClient is ignored.
+	DialOptions   *websocket.DialOptions
+	AcceptOptions *websocket.AcceptOptions
+
+	// Latency delays the delivery of each frame in both directions.
+	Latency time.Duration
+
+	// DropRate is the probability in [0, 1] that a data message is dropped
+	// in transit. Control frames are never dropped.
+	//
+	// Dropping a message compressed with context takeover corrupts the
+	// compression state of the peer so combine drops with
+	// websocket.CompressionNoContextTakeover or no compression.
+	DropRate float64
+
+	// MaxWriteSize, if positive, splits frames into writes of at most
+	// MaxWriteSize bytes so that peers see partial reads.
+	MaxWriteSize int
+
+	// TruncateRate is the probability in [0, 1] that a frame is cut short in
+	// transit. The transport is closed after the truncated frame as if the
+	// connection was lost mid message.
+	TruncateRate float64
+
+	// DisconnectAfter, if positive, closes the transport after DisconnectAfter
+	// frames were delivered in either direction, e.g. between the frames of
+	// a fragmented message.
+	DisconnectAfter int
+
+	// Seed seeds the random source deciding drops and truncations so that
+	// runs with the same Seed inject the same faults.
+	Seed uint64
+}
+
+// Pipe returns a client and server Conn connected in memory.
+//
+// Closing either end closes the transport. Both ends must be closed to
+// release all resources.
+func Pipe(opts *Options) (client, server *websocket.Conn, err error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	if opts.DropRate < 0 || opts.DropRate > 1 {
+		return nil, nil, fmt.Errorf("websockettest: DropRate %v is not in [0, 1]", opts.DropRate)
+	}
+	if opts.TruncateRate < 0 || opts.TruncateRate > 1 {
+		return nil, nil, fmt.Errorf("websockettest: TruncateRate %v is not in [0, 1]", opts.TruncateRate)
+	}
+
+	var acceptErr error
+	tt := transport{
+		opts: opts,
+		h: func(w http.ResponseWriter, r *http.Request) {
+			server, acceptErr = websocket.Accept(w, r, opts.AcceptOptions)
+		},
+	}
+
+	dialOpts := websocket.DialOptions{}
+	if opts.DialOptions != nil {
+		dialOpts = *opts.DialOptions
+	}
+	dialOpts.HTTPClient = &http.Client{
+		Transport: tt,
+	}
+
+	client, _, err = websocket.Dial(context.Background(), "ws://example.com", &dialOpts)
+	if acceptErr != nil {
+		err = acceptErr
+	}
+	if err != nil {
+		if server != nil {
+			server.CloseNow()
+		}
+		if client != nil {
+			client.CloseNow()
+		}
+		return nil, nil, fmt.Errorf("websockettest: failed to connect pipe: %w", err)
+	}
+	return client, server, nil
+}
+
+type transport struct {
+	opts *Options
+	h    http.HandlerFunc
+}
+
+func (t transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	clientConn, serverConn := t.conns()
+
+	hj := hijacker{
+		ResponseRecorder: httptest.NewRecorder(),
+		serverConn:       serverConn,
+	}
+
+	t.h.ServeHTTP(hj, r)
+
+	resp := hj.ResponseRecorder.Result()
+	if resp.StatusCode == http.StatusSwitchingProtocols {
+		resp.Body = clientConn
+	} else {
+		clientConn.Close()
+		serverConn.Close()
+	}
+	return resp, nil
+}
+
+// conns returns the client and server ends of the transport, relaying
+// between them through links when faults are injected.
+func (t transport) conns() (clientConn, serverConn net.Conn) {
+	o := t.opts
+	if o.Latency <= 0 && o.DropRate == 0 && o.MaxWriteSize <= 0 && o.TruncateRate == 0 && o.DisconnectAfter <= 0 {
+		return net.Pipe()
+	}
+
+	clientConn, clientRelay := net.Pipe()
+	serverRelay, serverConn := net.Pipe()
+	delivered := new(atomic.Int64)
+	go newLink(clientRelay, serverRelay, o, delivered, 0).run()
+	go newLink(serverRelay, clientRelay, o, delivered, 1).run()
+	return clientConn, serverConn
+}
+
+type hijacker struct {
+	*httptest.ResponseRecorder
+	serverConn net.Conn
+}
+
+var _ http.Hijacker = hijacker{}
+
+func (hj hijacker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return hj.serverConn, bufio.NewReadWriter(bufio.NewReader(hj.serverConn), bufio.NewWriter(hj.serverConn)), nil
+}
+
+// link relays frames in one direction, injecting faults.
+type link struct {
+	src, dst net.Conn
+	opts     *Options
+	rand     *rand.Rand
+
+	// delivered counts the frames delivered by both links of a transport.
+	delivered *atomic.Int64
+
+	// dropping is whether the frames of the current data message are dropped.
+	dropping bool
+}
+
+func newLink(src, dst net.Conn, opts *Options, delivered *atomic.Int64, stream uint64) *link {
+	return &link{
+		src:       src,
+		dst:       dst,
+		opts:      opts,
+		rand:      rand.New(rand.NewPCG(opts.Seed, stream)),
+		delivered: delivered,
+	}
+}
+
+type frame struct {
+	p   []byte
+	due time.Time
+
+	// truncated is whether only the first cut bytes of p are delivered.
+	truncated bool
+	cut       int
+}
+
+func (l *link) run() {
+	frames := make(chan frame, 128)
+	go func() {
+		defer close(frames)
+		br := bufio.NewReader(l.src)
+		for {
+			f, err := l.readFrame(br)
+			if err != nil {
+				return
+			}
+			if f.p != nil {
+				frames <- f
+			}
+		}
+	}()
+	defer func() {
+		l.src.Close()
+		l.dst.Close()
+		for range frames {
+		}
+	}()
+
+	for f := range frames {
+		time.Sleep(time.Until(f.due))
+		p := f.p
+		if f.truncated {
+			p = p[:f.cut]
+		}
+		err := l.write(p)
+		if err != nil || f.truncated {
+			return
+		}
+		n := l.delivered.Add(1)
+		if l.opts.DisconnectAfter > 0 && n >= int64(l.opts.DisconnectAfter) {
+			return
+		}
+	}
+}
+
+func (l *link) write(p []byte) error {
+	for len(p) > 0 {
+		n := len(p)
+		if l.opts.MaxWriteSize > 0 {
+			n = min(n, l.opts.MaxWriteSize)
+		}
+		_, err := l.dst.Write(p[:n])
+		if err != nil {
+			return err
+		}
+		p = p[n:]
+	}
+	return nil
+}
+
+// readFrame reads the next frame from br. The returned frame has a nil
+// payload if it was dropped.
+func (l *link) readFrame(br *bufio.Reader) (frame, error) {
+	h := make([]byte, 2, 14)
+	_, err := io.ReadFull(br, h)
+	if err != nil {
+		return frame{}, err
+	}
+
+	n := int(h[1] & 0x7f)
+	switch n {
+	case 126:
+		n = 2
+	case 127:
+		n = 8
+	default:
+		n = 0
+	}
+	if h[1]&0x80 != 0 {
+		n += 4
+	}
+	h = h[:2+n]
+	_, err = io.ReadFull(br, h[2:])
+	if err != nil {
+		return frame{}, err
+	}
+
+	var payloadLength uint64
+	switch h[1] & 0x7f {
+	case 126:
+		payloadLength = uint64(binary.BigEndian.Uint16(h[2:]))
+	case 127:
+		payloadLength = binary.BigEndian.Uint64(h[2:])
+	default:
+		payloadLength = uint64(h[1] & 0x7f)
+	}
+	if payloadLength > 1<<31 {
+		return frame{}, errors.New("frame too large")
+	}
+
+	p := make([]byte, len(h)+int(payloadLength))
+	copy(p, h)
+	_, err = io.ReadFull(br, p[len(h):])
+	if err != nil {
+		return frame{}, err
+	}
+
+	f := frame{p: p, due: time.Now().Add(l.opts.Latency)}
+	if l.opts.TruncateRate > 0 && l.rand.Float64() < l.opts.TruncateRate {
+		f.truncated = true
+		f.cut = l.rand.IntN(len(p))
+	}
+	switch opcode := h[0] & 0xf; {
+	case opcode >= 0x8:
+		// Control frames are never dropped.
+		return f, nil
+	case opcode != 0x0:
+		l.dropping = l.opts.DropRate > 0 && l.rand.Float64() < l.opts.DropRate
+	}
+	if l.dropping {
+		f.p = nil
+	}
+	return f, nil
+}
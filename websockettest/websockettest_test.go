@@ -0,0 +1,210 @@
+//go:build !js
+
+package websockettest_test
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/internal/test/assert"
+	"github.com/coder/websocket/internal/xsync"
+	"github.com/coder/websocket/websockettest"
+)
+
+func TestPipe(t *testing.T) {
+	t.Parallel()
+
+	t.Run("latency", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+
+		const latency = time.Millisecond * 50
+		c, s, err := websockettest.Pipe(&websockettest.Options{
+			DialOptions: &websocket.DialOptions{
+				CompressionMode: websocket.CompressionContextTakeover,
+			},
+			AcceptOptions: &websocket.AcceptOptions{
+				CompressionMode: websocket.CompressionContextTakeover,
+			},
+			Latency: latency,
+		})
+		assert.Success(t, err)
+		defer c.CloseNow()
+		defer s.CloseNow()
+
+		start := time.Now()
+		err = c.Write(ctx, websocket.MessageText, []byte("hello"))
+		assert.Success(t, err)
+		_, p, err := s.Read(ctx)
+		assert.Success(t, err)
+		assert.Equal(t, "message", "hello", string(p))
+		if d := time.Since(start); d < latency {
+			t.Fatalf("message delivered after %v, expected at least %v", d, latency)
+		}
+	})
+
+	t.Run("drops", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+
+		c, s, err := websockettest.Pipe(&websockettest.Options{
+			DropRate: 1,
+		})
+		assert.Success(t, err)
+		defer c.CloseNow()
+		defer s.CloseNow()
+
+		read := make(chan struct{})
+		readErrs := xsync.Go(func() error {
+			_, _, err := s.Read(ctx)
+			close(read)
+			return err
+		})
+
+		c.CloseRead(ctx)
+		err = c.Write(ctx, websocket.MessageText, []byte("dropped"))
+		assert.Success(t, err)
+
+		// The pong arriving means the message was dropped as frames are
+		// delivered in order.
+		err = c.Ping(ctx)
+		assert.Success(t, err)
+		select {
+		case <-read:
+			t.Fatal("message was delivered")
+		default:
+		}
+
+		err = c.Close(websocket.StatusNormalClosure, "")
+		assert.Success(t, err)
+		err = <-readErrs
+		assert.Equal(t, "close status", websocket.StatusNormalClosure, websocket.CloseStatus(err))
+	})
+
+	t.Run("partialWrites", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+
+		c, s, err := websockettest.Pipe(&websockettest.Options{
+			MaxWriteSize: 1,
+		})
+		assert.Success(t, err)
+		defer c.CloseNow()
+		defer s.CloseNow()
+
+		msg := make([]byte, 1000)
+		err = c.Write(ctx, websocket.MessageBinary, msg)
+		assert.Success(t, err)
+		_, p, err := s.Read(ctx)
+		assert.Success(t, err)
+		assert.Equal(t, "message", msg, p)
+
+		c.CloseRead(ctx)
+		closeErrs := xsync.Go(func() error {
+			return c.Close(websocket.StatusGoingAway, "")
+		})
+		_, _, err = s.Read(ctx)
+		websockettest.AssertCloseStatus(t, err, websocket.StatusGoingAway)
+		assert.Success(t, <-closeErrs)
+	})
+
+	t.Run("truncate", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+
+		c, s, err := websockettest.Pipe(&websockettest.Options{
+			TruncateRate: 1,
+		})
+		assert.Success(t, err)
+		defer c.CloseNow()
+		defer s.CloseNow()
+
+		err = c.Write(ctx, websocket.MessageText, []byte("truncated"))
+		assert.Success(t, err)
+		_, _, err = s.Read(ctx)
+		websockettest.AssertCloseStatus(t, err, websocket.StatusAbnormalClosure)
+	})
+
+	t.Run("disconnectMidMessage", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+
+		c, s, err := websockettest.Pipe(&websockettest.Options{
+			DisconnectAfter: 1,
+		})
+		assert.Success(t, err)
+		defer c.CloseNow()
+		defer s.CloseNow()
+
+		w, err := c.Writer(ctx, websocket.MessageText)
+		assert.Success(t, err)
+		// Each write is sent as a frame.
+		_, err = w.Write([]byte("first"))
+		assert.Success(t, err)
+		w.Write([]byte("second"))
+		w.Close()
+
+		_, r, err := s.Reader(ctx)
+		assert.Success(t, err)
+		_, err = io.ReadAll(r)
+		websockettest.AssertCloseStatus(t, err, websocket.StatusAbnormalClosure)
+	})
+
+	t.Run("invalidDropRate", func(t *testing.T) {
+		t.Parallel()
+
+		_, _, err := websockettest.Pipe(&websockettest.Options{
+			DropRate: 2,
+		})
+		assert.Contains(t, err, "DropRate")
+	})
+}
+
+func TestFakeClock(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	var clock websockettest.FakeClock
+	c, s, err := websockettest.Pipe(&websockettest.Options{
+		DialOptions: &websocket.DialOptions{
+			IdleTimeout: time.Hour,
+			Clock:       &clock,
+		},
+	})
+	assert.Success(t, err)
+	defer c.CloseNow()
+	defer s.CloseNow()
+
+	readErrs := xsync.Go(func() error {
+		_, _, err := c.Read(ctx)
+		return err
+	})
+	clock.Advance(time.Minute)
+	err = s.Write(ctx, websocket.MessageText, []byte("hello"))
+	assert.Success(t, err)
+	assert.Success(t, <-readErrs)
+
+	readErrs = xsync.Go(func() error {
+		_, _, err := c.Read(ctx)
+		return err
+	})
+	clock.Advance(time.Hour)
+	_, _, err = s.Read(ctx)
+	websockettest.AssertCloseStatus(t, err, websocket.StatusGoingAway)
+	websockettest.AssertCloseStatus(t, <-readErrs, websocket.StatusGoingAway)
+}
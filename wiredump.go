@@ -0,0 +1,61 @@
+//go:build !js
+
+package websocket
+
+import (
+	"encoding/binary"
+	"io"
+	"sync"
+	"time"
+)
+
+// Directions of the records of a wire dump, see DialOptions.WireDump.
+const (
+	WireDumpRead  byte = 'r'
+	WireDumpWrite byte = 'w'
+)
+
+// wireDump tees the bytes read from and written to a connection to w as
+// length prefixed records.
+type wireDump struct {
+	io.ReadWriteCloser
+
+	mu  sync.Mutex
+	w   io.Writer
+	buf []byte
+}
+
+func newWireDump(rwc io.ReadWriteCloser, w io.Writer) *wireDump {
+	return &wireDump{
+		ReadWriteCloser: rwc,
+		w:               w,
+	}
+}
+
+func (d *wireDump) Read(p []byte) (int, error) {
+	n, err := d.ReadWriteCloser.Read(p)
+	d.dump(WireDumpRead, p[:n])
+	return n, err
+}
+
+func (d *wireDump) Write(p []byte) (int, error) {
+	n, err := d.ReadWriteCloser.Write(p)
+	d.dump(WireDumpWrite, p[:n])
+	return n, err
+}
+
+// dump writes a record of p. Errors are ignored so that a failing dump does
+// not fail the connection.
+func (d *wireDump) dump(dir byte, p []byte) {
+	if len(p) == 0 {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.buf = append(d.buf[:0], dir)
+	d.buf = binary.BigEndian.AppendUint64(d.buf, uint64(time.Now().UnixNano()))
+	d.buf = binary.BigEndian.AppendUint32(d.buf, uint32(len(p)))
+	d.buf = append(d.buf, p...)
+	_, _ = d.w.Write(d.buf)
+}
@@ -4,14 +4,15 @@ package websocket
 
 import (
 	"bufio"
-	"compress/flate"
+	"bytes"
 	"context"
-	"crypto/rand"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net"
+	"slices"
 	"time"
 
 	"github.com/coder/websocket/internal/bpool"
@@ -26,11 +27,48 @@ import (
 //
 // Only one writer can be open at a time, multiple calls will block until the previous writer
 // is closed.
+//
+// Write, and thus the other messages, also block until the writer is closed
+// as RFC 6455 forbids interleaving the frames of data messages. Calling Write
+// in the goroutine holding the writer deadlocks until its context expires.
+// Use WriteAsync instead to queue a message behind the writer. Control
+// frames such as pings are written between the frames of the writer.
 func (c *Conn) Writer(ctx context.Context, typ MessageType) (io.WriteCloser, error) {
 	w, err := c.writer(ctx, typ)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get writer: %w", err)
 	}
+	if len(c.extensions) > 0 {
+		return c.extensionWriter(w), nil
+	}
+	return w, nil
+}
+
+// WriterWithProgress is like Writer but calls progress with the number of
+// bytes of the message written so far after each write, e.g. to show the
+// progress of a large upload. Bytes buffered by compression count as
+// written.
+func (c *Conn) WriterWithProgress(ctx context.Context, typ MessageType, progress func(written int64)) (io.WriteCloser, error) {
+	w, err := c.writer(ctx, typ)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get writer: %w", err)
+	}
+	w.progress = progress
+	if len(c.extensions) > 0 {
+		return c.extensionWriter(w), nil
+	}
+	return w, nil
+}
+
+// WriterOpts is like Writer but applies the given options to the message.
+func (c *Conn) WriterOpts(ctx context.Context, typ MessageType, opts ...WriteOption) (io.WriteCloser, error) {
+	w, err := c.writer(ctx, typ, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get writer: %w", err)
+	}
+	if len(c.extensions) > 0 {
+		return c.extensionWriter(w), nil
+	}
 	return w, nil
 }
 
@@ -48,6 +86,330 @@ func (c *Conn) Write(ctx context.Context, typ MessageType, p []byte) error {
 	return nil
 }
 
+// WriteTimeout is like Write but bounded by timeout instead of a context.
+// It avoids allocating a context per message which matters at high message
+// rates.
+//
+// If the timeout expires, the connection is closed and an error wrapping
+// context.DeadlineExceeded is returned.
+func (c *Conn) WriteTimeout(timeout time.Duration, typ MessageType, p []byte) error {
+	dt := c.startDeadlineTimer(timeout)
+	err := c.Write(context.Background(), typ, p)
+	if dt.stop() && err != nil {
+		return fmt.Errorf("failed to write msg: %w", context.DeadlineExceeded)
+	}
+	return err
+}
+
+// WriteMessages writes msgs in order, flushing the connection once after the
+// last message instead of after each, to reduce the overhead of writing many
+// small messages at once. Other writes wait until all msgs are written.
+//
+// It stops at the first error, which fails the connection like an error
+// of Write.
+func (c *Conn) WriteMessages(ctx context.Context, msgs []Message) error {
+	err := c.writeMessages(ctx, msgs)
+	if err != nil {
+		return fmt.Errorf("failed to write msgs: %w", err)
+	}
+	return nil
+}
+
+// WriteOpts is like Write but applies the given options to the message.
+func (c *Conn) WriteOpts(ctx context.Context, typ MessageType, p []byte, opts ...WriteOption) error {
+	_, err := c.write(ctx, typ, p, opts...)
+	if err != nil {
+		return fmt.Errorf("failed to write msg: %w", err)
+	}
+	return nil
+}
+
+// WriteAsync queues a message to be written by a per connection goroutine and
+// returns immediately. done, if not nil, is called with the result of the write.
+// It is called from the writing goroutine or, when the message is dropped,
+// from WriteAsync itself. It must not block nor close the connection as closing
+// waits for the writing goroutine to exit. Use a goroutine to close on errors.
+//
+// p must not be modified until done is called.
+//
+// When the queue of WriteQueueLength messages is full, the message selected by
+// WriteQueuePolicy is dropped with ErrWriteQueueFull. Queued messages are
+// dropped with net.ErrClosed when the connection closes.
+//
+// The goroutine is started on the first call so connections that never call
+// WriteAsync do not pay for it. Messages are written with Write and so may
+// interleave with other messages but never within one.
+func (c *Conn) WriteAsync(typ MessageType, p []byte, done func(error)) {
+	c.WriteAsyncOpts(typ, p, done)
+}
+
+// WriteAsyncOpts is like WriteAsync but applies the given options to the
+// message. Messages with Urgent are queued separately, in a queue of the
+// same length, and written before the other queued messages.
+func (c *Conn) WriteAsyncOpts(typ MessageType, p []byte, done func(error), opts ...WriteOption) {
+	if done == nil {
+		done = func(error) {}
+	}
+	w := asyncWrite{typ: typ, p: p, opts: opts, done: done}
+
+	c.writeQueueMu.Lock()
+	if c.writeQueueClosed || c.isClosed() {
+		c.writeQueueMu.Unlock()
+		done(net.ErrClosed)
+		return
+	}
+	if c.writeQueue == nil {
+		n := c.writeQueueLength
+		if n <= 0 {
+			n = 64
+		}
+		c.writeQueue = make(chan asyncWrite, n)
+		c.writeQueueUrgent = make(chan asyncWrite, n)
+		c.writeLoopDone = make(chan struct{})
+		go c.writeLoop()
+	}
+
+	queue := c.writeQueue
+	if slices.Contains(opts, Urgent) {
+		queue = c.writeQueueUrgent
+	}
+	var dropped asyncWrite
+	select {
+	case queue <- w:
+	default:
+		dropped = w
+		if c.writeQueuePolicy == DropOldest {
+			select {
+			case dropped = <-queue:
+			default:
+				// The writing goroutine took the oldest message.
+				dropped = asyncWrite{}
+			}
+			queue <- w
+		}
+	}
+	c.writeQueueMu.Unlock()
+
+	if dropped.done != nil {
+		dropped.done(ErrWriteQueueFull)
+	}
+}
+
+// DropPolicy selects the message dropped by Conn.WriteAsync when
+// the write queue is full.
+type DropPolicy int
+
+const (
+	// DropNewest drops the message passed to WriteAsync.
+	DropNewest DropPolicy = iota
+
+	// DropOldest drops the oldest queued message to make room, which
+	// suits messages that supersede the previous ones such as state updates.
+	DropOldest
+)
+
+type asyncWrite struct {
+	typ  MessageType
+	p    []byte
+	opts []WriteOption
+	done func(error)
+
+	// drain marks the message queued by Drain. It is not written.
+	drain bool
+}
+
+// Drain blocks until the messages queued by WriteAsync before the call and the
+// message being written, if any, have been written to the connection.
+//
+// Use it to wait for a slow peer to catch up after ErrWriteBackpressure.
+func (c *Conn) Drain(ctx context.Context) error {
+	drained := make(chan struct{})
+	w := asyncWrite{drain: true, done: func(error) { close(drained) }}
+
+	c.writeQueueMu.Lock()
+	if c.writeQueue != nil && !c.writeQueueClosed {
+		select {
+		case c.writeQueue <- w:
+		case <-c.closed:
+			close(drained)
+		case <-ctx.Done():
+			c.writeQueueMu.Unlock()
+			return fmt.Errorf("failed to drain: %w", ctx.Err())
+		}
+	} else {
+		close(drained)
+	}
+	c.writeQueueMu.Unlock()
+
+	select {
+	case <-drained:
+	case <-c.closed:
+	case <-ctx.Done():
+		return fmt.Errorf("failed to drain: %w", ctx.Err())
+	}
+
+	err := c.msgWriter.mu.lock(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to drain: %w", err)
+	}
+	c.msgWriter.mu.unlock()
+	return nil
+}
+
+// writeLoop writes the messages queued by WriteAsync until the connection closes.
+func (c *Conn) writeLoop() {
+	defer close(c.writeLoopDone)
+
+	for {
+		var w asyncWrite
+		select {
+		case w = <-c.writeQueueUrgent:
+		default:
+			select {
+			case w = <-c.writeQueueUrgent:
+			case w = <-c.writeQueue:
+			case <-c.closed:
+				c.writeQueueMu.Lock()
+				c.writeQueueClosed = true
+				c.writeQueueMu.Unlock()
+
+				// No message can be queued anymore.
+				for {
+					select {
+					case w := <-c.writeQueueUrgent:
+						w.done(net.ErrClosed)
+					case w := <-c.writeQueue:
+						w.done(net.ErrClosed)
+					default:
+						return
+					}
+				}
+			}
+		}
+
+		if w.drain {
+			w.done(nil)
+		} else {
+			w.done(c.WriteOpts(context.Background(), w.typ, w.p, w.opts...))
+		}
+	}
+}
+
+// WriteOption is an option for a single message passed to
+// WriteOpts, WriterOpts or WriteAsyncOpts.
+type WriteOption int
+
+const (
+	// NoCompress writes the message without compression even if
+	// permessage-deflate was negotiated and the message is above
+	// CompressionThreshold. Use it for payloads that are already compressed,
+	// such as images, to save the CPU time of compressing them again.
+	NoCompress WriteOption = iota + 1
+
+	// Urgent writes a message queued with WriteAsyncOpts before the queued
+	// messages without it, e.g. heartbeats and acks behind bulk updates.
+	//
+	// A message already being written is never interrupted as RFC 6455
+	// forbids interleaving the frames of data messages. Control frames are
+	// written between its frames, see SetWriteFragmentSize.
+	Urgent
+)
+
+// SetWriteFragmentSize sets the max payload size of the data frames written by
+// Write and Writer. Larger payloads are split into multiple frames of at most
+// n bytes, which helps with intermediaries and peers that cannot handle large
+// frames.
+//
+// By default, messages are not split beyond the writes to Writer.
+//
+// Set to 0 to disable.
+func (c *Conn) SetWriteFragmentSize(n int) {
+	c.writeFragmentSize.Store(int64(max(n, 0)))
+}
+
+// BufferedWriter is like Writer but the returned writer holds written data
+// until Flush or Close is called. This allows many small writes to be sent as
+// a single frame with the caller deciding when frames hit the wire.
+//
+// Data that is never flushed is sent as the final frame by Close.
+func (c *Conn) BufferedWriter(ctx context.Context, typ MessageType) (*MessageWriter, error) {
+	w, err := c.writer(ctx, typ)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get writer: %w", err)
+	}
+	mw := &MessageWriter{
+		mw:  w,
+		buf: bpool.Get(),
+	}
+	if len(c.extensions) > 0 {
+		mw.ew = c.extensionWriter(w)
+	}
+	return mw, nil
+}
+
+// MessageWriter writes a single message. See Conn.BufferedWriter.
+type MessageWriter struct {
+	mw *msgWriter
+	// ew transforms the message if extensions were negotiated.
+	ew     io.WriteCloser
+	buf    *bytes.Buffer
+	closed bool
+}
+
+// Write buffers p until the next Flush or Close.
+func (w *MessageWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, fmt.Errorf("failed to write: %w", ErrWriterClosed)
+	}
+	return w.buf.Write(p)
+}
+
+// Buffered returns the number of bytes written but not yet flushed.
+func (w *MessageWriter) Buffered() int {
+	if w.closed {
+		return 0
+	}
+	return w.buf.Len()
+}
+
+// Flush writes the buffered data to the connection as a frame.
+// It does nothing if no data is buffered.
+func (w *MessageWriter) Flush() error {
+	if w.closed {
+		return fmt.Errorf("failed to flush: %w", ErrWriterClosed)
+	}
+	if w.buf.Len() == 0 {
+		return nil
+	}
+
+	var err error
+	if w.ew != nil {
+		_, err = w.ew.Write(w.buf.Bytes())
+	} else {
+		_, err = w.mw.Write(w.buf.Bytes())
+	}
+	w.buf.Reset()
+	if err != nil {
+		return fmt.Errorf("failed to flush: %w", err)
+	}
+	return nil
+}
+
+// Close writes the buffered data as the final frame of the message.
+func (w *MessageWriter) Close() error {
+	if w.closed {
+		return fmt.Errorf("failed to close writer: %w", ErrWriterClosed)
+	}
+	w.closed = true
+	defer bpool.Put(w.buf)
+
+	if w.ew != nil {
+		_, err := w.ew.Write(w.buf.Bytes())
+		return errors.Join(err, w.ew.Close())
+	}
+	return w.mw.closeWith(w.buf.Bytes())
+}
+
 type msgWriter struct {
 	c *Conn
 
@@ -55,12 +417,18 @@ type msgWriter struct {
 	writeMu *mu
 	closed  bool
 
-	ctx    context.Context
-	opcode opcode
-	flate  bool
+	ctx        context.Context
+	opcode     opcode
+	flate      bool
+	noCompress bool
+	// size is the number of bytes of the message written so far,
+	// reported to Metrics on close.
+	size int
+	// progress is called with size, see WriterWithProgress.
+	progress func(written int64)
 
 	trimWriter  *trimLastFourBytesWriter
-	flateWriter *flate.Writer
+	flateWriter FlateWriter
 }
 
 func newMsgWriter(c *Conn) *msgWriter {
@@ -72,7 +440,7 @@ func newMsgWriter(c *Conn) *msgWriter {
 	return mw
 }
 
-func (mw *msgWriter) ensureFlate() {
+func (mw *msgWriter) ensureFlate() error {
 	if mw.trimWriter == nil {
 		mw.trimWriter = &trimLastFourBytesWriter{
 			w: util.WriterFunc(mw.write),
@@ -80,9 +448,14 @@ func (mw *msgWriter) ensureFlate() {
 	}
 
 	if mw.flateWriter == nil {
-		mw.flateWriter = getFlateWriter(mw.trimWriter)
+		fw, err := getFlateWriter(mw.flatePoolKey(), mw.trimWriter, mw.flateDict())
+		if err != nil {
+			return fmt.Errorf("failed to create flate writer: %w", err)
+		}
+		mw.flateWriter = fw
 	}
 	mw.flate = true
+	return nil
 }
 
 func (mw *msgWriter) flateContextTakeover() bool {
@@ -92,47 +465,141 @@ func (mw *msgWriter) flateContextTakeover() bool {
 	return !mw.c.copts.serverNoContextTakeover
 }
 
-func (c *Conn) writer(ctx context.Context, typ MessageType) (io.WriteCloser, error) {
-	err := c.msgWriter.reset(ctx, typ)
+// flateDict returns the preset dictionary of the flate.Writer.
+// It is only used without context takeover.
+func (mw *msgWriter) flateDict() []byte {
+	if mw.flateContextTakeover() {
+		return nil
+	}
+	return mw.c.copts.dict
+}
+
+// flatePoolKey returns the key of the pool of the flate.Writer.
+func (mw *msgWriter) flatePoolKey() flatePoolKey {
+	key := flatePoolKey{
+		backend: mw.c.copts.flateBackend(),
+		level:   mw.c.copts.flateLevel(),
+	}
+	if !mw.flateContextTakeover() {
+		key.dict = mw.c.copts.dictKey
+	}
+	return key
+}
+
+func (c *Conn) writer(ctx context.Context, typ MessageType, opts ...WriteOption) (*msgWriter, error) {
+	err := c.msgWriter.reset(ctx, typ, opts)
 	if err != nil {
 		return nil, err
 	}
 	return c.msgWriter, nil
 }
 
-func (c *Conn) write(ctx context.Context, typ MessageType, p []byte) (int, error) {
-	err := c.msgWriter.reset(ctx, typ)
+func (c *Conn) write(ctx context.Context, typ MessageType, p []byte, opts ...WriteOption) (int, error) {
+	if len(c.extensions) > 0 {
+		return c.writeExtended(ctx, typ, p, opts)
+	}
+
+	err := c.msgWriter.reset(ctx, typ, opts)
 	if err != nil {
 		return 0, err
 	}
 	defer c.msgWriter.mu.unlock()
 
-	if !c.flate() || len(p) < c.flateThreshold {
-		return c.writeFrame(ctx, true, false, c.msgWriter.opcode, p)
+	return c.writeMessage(ctx, p)
+}
+
+func (c *Conn) writeMessages(ctx context.Context, msgs []Message) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+	if len(c.extensions) > 0 {
+		for _, m := range msgs {
+			_, err := c.writeExtended(ctx, m.Type, m.Data, nil)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
 	}
 
-	return c.msgWriter.writeCompressedFrame(ctx, p)
+	err := c.msgWriter.mu.lockTimeout(ctx, c.writeBackpressureTimeout)
+	if err != nil {
+		return err
+	}
+	defer c.msgWriter.mu.unlock()
+
+	c.writeBatch = true
+	defer func() {
+		c.writeBatch = false
+	}()
+	for i, m := range msgs {
+		if i == len(msgs)-1 {
+			// The last message flushes the batch.
+			c.writeBatch = false
+		}
+		c.msgWriter.start(ctx, m.Type, nil)
+		_, err = c.writeMessage(ctx, m.Data)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeMessage writes p as the message started by msgWriter.reset.
+func (c *Conn) writeMessage(ctx context.Context, p []byte) (int, error) {
+	var n int
+	var err error
+	if !c.msgWriter.compress(len(p)) {
+		n, err = c.writeFragments(ctx, true, false, c.msgWriter.opcode, p)
+	} else {
+		n, err = c.msgWriter.writeCompressedFrame(ctx, p)
+	}
+	if err == nil && c.msgWriter.flate {
+		c.stats.messageWritten(len(p))
+	}
+	if err == nil && c.metrics != nil {
+		c.metrics.IncMessagesWritten(len(p))
+	}
+	return n, err
 }
 
-func (mw *msgWriter) reset(ctx context.Context, typ MessageType) error {
-	err := mw.mu.lock(ctx)
+func (mw *msgWriter) reset(ctx context.Context, typ MessageType, opts []WriteOption) error {
+	err := mw.mu.lockTimeout(ctx, mw.c.writeBackpressureTimeout)
 	if err != nil {
 		return err
 	}
+	mw.start(ctx, typ, opts)
+	return nil
+}
 
+// start starts a message. mw.mu must be held.
+func (mw *msgWriter) start(ctx context.Context, typ MessageType, opts []WriteOption) {
 	mw.ctx = ctx
 	mw.opcode = opcode(typ)
 	mw.flate = false
 	mw.closed = false
+	mw.noCompress = false
+	mw.size = 0
+	mw.progress = nil
+	for _, opt := range opts {
+		switch opt {
+		case NoCompress:
+			mw.noCompress = true
+		}
+	}
 
 	mw.trimWriter.reset()
+}
 
-	return nil
+// compress reports whether a message starting with n bytes is compressed.
+func (mw *msgWriter) compress(n int) bool {
+	return mw.c.flate() && !mw.c.copts.uncompressedWrites && !mw.noCompress && n >= mw.c.flateThreshold
 }
 
 func (mw *msgWriter) putFlateWriter() {
 	if mw.flateWriter != nil {
-		putFlateWriter(mw.flateWriter)
+		putFlateWriter(mw.flatePoolKey(), mw.flateWriter)
 		mw.flateWriter = nil
 	}
 }
@@ -146,10 +613,13 @@ func (mw *msgWriter) writeCompressedFrame(ctx context.Context, p []byte) (int, e
 	defer mw.writeMu.unlock()
 
 	if mw.closed {
-		return 0, errors.New("cannot use closed writer")
+		return 0, ErrWriterClosed
 	}
 
-	mw.ensureFlate()
+	err = mw.ensureFlate()
+	if err != nil {
+		return 0, err
+	}
 
 	buf := bpool.Get()
 	defer bpool.Put(buf)
@@ -180,7 +650,7 @@ func (mw *msgWriter) writeCompressedFrame(ctx context.Context, p []byte) (int, e
 
 	mw.closed = true
 
-	_, err = mw.c.writeFrame(ctx, true, true, mw.opcode, buf.Bytes())
+	_, err = mw.c.writeFragments(ctx, true, true, mw.opcode, buf.Bytes())
 	if err != nil {
 		return 0, err
 	}
@@ -188,7 +658,7 @@ func (mw *msgWriter) writeCompressedFrame(ctx context.Context, p []byte) (int, e
 }
 
 // Write writes the given bytes to the WebSocket connection.
-func (mw *msgWriter) Write(p []byte) (_ int, err error) {
+func (mw *msgWriter) Write(p []byte) (n int, err error) {
 	err = mw.writeMu.lock(mw.ctx)
 	if err != nil {
 		return 0, fmt.Errorf("failed to write: %w", err)
@@ -196,7 +666,7 @@ func (mw *msgWriter) Write(p []byte) (_ int, err error) {
 	defer mw.writeMu.unlock()
 
 	if mw.closed {
-		return 0, errors.New("cannot use closed writer")
+		return 0, ErrWriterClosed
 	}
 
 	defer func() {
@@ -205,23 +675,29 @@ func (mw *msgWriter) Write(p []byte) (_ int, err error) {
 		}
 	}()
 
-	if mw.c.flate() {
-		// Only enables flate if the length crosses the
-		// threshold on the first frame
-		if mw.opcode != opContinuation && len(p) >= mw.c.flateThreshold {
-			mw.ensureFlate()
+	// Only enables flate if the length crosses the
+	// threshold on the first frame
+	if mw.opcode != opContinuation && mw.compress(len(p)) {
+		err = mw.ensureFlate()
+		if err != nil {
+			return 0, err
 		}
 	}
 
 	if mw.flate {
-		return mw.flateWriter.Write(p)
+		n, err = mw.flateWriter.Write(p)
+	} else {
+		n, err = mw.write(p)
 	}
-
-	return mw.write(p)
+	mw.size += n
+	if mw.progress != nil && n > 0 {
+		mw.progress(int64(mw.size))
+	}
+	return n, err
 }
 
 func (mw *msgWriter) write(p []byte) (int, error) {
-	n, err := mw.c.writeFrame(mw.ctx, false, mw.flate, mw.opcode, p)
+	n, err := mw.c.writeFragments(mw.ctx, false, mw.flate, mw.opcode, p)
 	if err != nil {
 		return n, fmt.Errorf("failed to write data frame: %w", err)
 	}
@@ -230,7 +706,12 @@ func (mw *msgWriter) write(p []byte) (int, error) {
 }
 
 // Close flushes the frame to the connection.
-func (mw *msgWriter) Close() (err error) {
+func (mw *msgWriter) Close() error {
+	return mw.closeWith(nil)
+}
+
+// closeWith writes p as the final frame of the message and closes the writer.
+func (mw *msgWriter) closeWith(p []byte) (err error) {
 	defer errd.Wrap(&err, "failed to close writer")
 
 	err = mw.writeMu.lock(mw.ctx)
@@ -240,24 +721,48 @@ func (mw *msgWriter) Close() (err error) {
 	defer mw.writeMu.unlock()
 
 	if mw.closed {
-		return errors.New("writer already closed")
+		return ErrWriterClosed
 	}
 	mw.closed = true
+	mw.size += len(p)
+
+	if mw.opcode != opContinuation && mw.compress(len(p)) {
+		err = mw.ensureFlate()
+		if err != nil {
+			return err
+		}
+	}
 
 	if mw.flate {
+		if len(p) > 0 {
+			_, err = mw.flateWriter.Write(p)
+			if err != nil {
+				return fmt.Errorf("failed to compress: %w", err)
+			}
+			p = nil
+		}
 		err = mw.flateWriter.Flush()
 		if err != nil {
 			return fmt.Errorf("failed to flush flate: %w", err)
 		}
 	}
 
-	_, err = mw.c.writeFrame(mw.ctx, true, mw.flate, mw.opcode, nil)
+	_, err = mw.c.writeFragments(mw.ctx, true, mw.flate, mw.opcode, p)
 	if err != nil {
 		return fmt.Errorf("failed to write fin frame: %w", err)
 	}
 
-	if mw.flate && !mw.flateContextTakeover() {
-		mw.putFlateWriter()
+	if mw.flate {
+		mw.c.stats.messageWritten(mw.size)
+		if !mw.flateContextTakeover() {
+			mw.putFlateWriter()
+		}
+	}
+	if mw.c.metrics != nil {
+		mw.c.metrics.IncMessagesWritten(mw.size)
+	}
+	if mw.progress != nil && len(p) > 0 {
+		mw.progress(int64(mw.size))
 	}
 	mw.mu.unlock()
 	return nil
@@ -273,8 +778,16 @@ func (mw *msgWriter) close() {
 	mw.putFlateWriter()
 }
 
+// controlTimeout returns the timeout of writing a control frame.
+func (c *Conn) controlTimeout() time.Duration {
+	if c.controlWriteTimeout > 0 {
+		return c.controlWriteTimeout
+	}
+	return time.Second * 5
+}
+
 func (c *Conn) writeControl(ctx context.Context, opcode opcode, p []byte) error {
-	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	ctx, cancel := c.withTimeout(ctx, c.controlTimeout())
 	defer cancel()
 
 	_, err := c.writeFrame(ctx, true, false, opcode, p)
@@ -284,6 +797,26 @@ func (c *Conn) writeControl(ctx context.Context, opcode opcode, p []byte) error
 	return nil
 }
 
+// writeFragments writes p as data frames of at most the write fragment size.
+// Only the last frame has fin set to fin.
+func (c *Conn) writeFragments(ctx context.Context, fin bool, flate bool, opcode opcode, p []byte) (int, error) {
+	size := int(c.writeFragmentSize.Load())
+
+	var n int
+	for size > 0 && len(p) > size {
+		n2, err := c.writeFrame(ctx, false, flate, opcode, p[:size])
+		n += n2
+		if err != nil {
+			return n, err
+		}
+		p = p[size:]
+		opcode = opContinuation
+	}
+
+	n2, err := c.writeFrame(ctx, fin, flate, opcode, p)
+	return n + n2, err
+}
+
 // writeFrame handles all writes to the connection.
 func (c *Conn) writeFrame(ctx context.Context, fin bool, flate bool, opcode opcode, p []byte) (_ int, err error) {
 	err = c.writeFrameMu.lock(ctx)
@@ -321,6 +854,10 @@ func (c *Conn) writeFrame(ctx context.Context, fin bool, flate bool, opcode opco
 	if c.setupWriteTimeout(ctx) {
 		defer c.clearWriteTimeout()
 	}
+	if opcode == opClose {
+		// Before the write so the peer's reply cannot be observed first.
+		c.setState(StateClosingSent)
+	}
 
 	c.writeHeader.fin = fin
 	c.writeHeader.opcode = opcode
@@ -328,7 +865,7 @@ func (c *Conn) writeFrame(ctx context.Context, fin bool, flate bool, opcode opco
 
 	if c.client {
 		c.writeHeader.masked = true
-		_, err = io.ReadFull(rand.Reader, c.writeHeaderBuf[:4])
+		_, err = io.ReadFull(c.rand, c.writeHeaderBuf[:4])
 		if err != nil {
 			return 0, fmt.Errorf("failed to generate masking key: %w", err)
 		}
@@ -336,8 +873,12 @@ func (c *Conn) writeFrame(ctx context.Context, fin bool, flate bool, opcode opco
 	}
 
 	c.writeHeader.rsv1 = false
-	if flate && (opcode == opText || opcode == opBinary) {
-		c.writeHeader.rsv1 = true
+	c.writeHeader.rsv2 = false
+	c.writeHeader.rsv3 = false
+	if opcode == opText || opcode == opBinary {
+		c.writeHeader.rsv1 = flate
+		c.writeHeader.rsv2 = c.extRSV&RSV2 != 0
+		c.writeHeader.rsv3 = c.extRSV&RSV3 != 0
 	}
 
 	err = writeFrameHeader(c.writeHeader, c.bw, c.writeHeaderBuf[:])
@@ -346,11 +887,17 @@ func (c *Conn) writeFrame(ctx context.Context, fin bool, flate bool, opcode opco
 	}
 
 	n, err := c.writeFramePayload(p)
+	if flate {
+		c.stats.compressedWritten.Add(int64(n))
+	}
 	if err != nil {
 		return n, err
 	}
 
-	if c.writeHeader.fin {
+	// writeBatch is protected by msgWriter.mu, held while writing
+	// data frames.
+	dataFrame := opcode == opText || opcode == opBinary || opcode == opContinuation
+	if c.writeHeader.fin && !(dataFrame && c.writeBatch) {
 		err = c.bw.Flush()
 		if err != nil {
 			return n, fmt.Errorf("failed to flush: %w", err)
@@ -376,6 +923,9 @@ func (c *Conn) writeFramePayload(p []byte) (n int, err error) {
 	defer errd.Wrap(&err, "failed to write frame payload")
 
 	if !c.writeHeader.masked {
+		if len(p) > c.bw.Available() && c.writeBuf != nil {
+			return c.writeVectored(p)
+		}
 		return c.bw.Write(p)
 	}
 
@@ -408,6 +958,18 @@ func (c *Conn) writeFramePayload(p []byte) (n int, err error) {
 	return n, nil
 }
 
+// writeVectored writes the buffered frame header followed by p directly to the
+// connection. On connections that support it, such as *net.TCPConn, this is a
+// single writev(2) and p is never copied into the buffer.
+func (c *Conn) writeVectored(p []byte) (int, error) {
+	buffered := c.bw.Buffered()
+	bufs := net.Buffers{c.writeBuf[:buffered], p}
+	n, err := bufs.WriteTo(c.rwc)
+	// Discard the header we just wrote from the buffer.
+	c.bw.Reset(c.rwc)
+	return max(int(n)-buffered, 0), err
+}
+
 // extractBufioWriterBuf grabs the []byte backing a *bufio.Writer
 // and returns it.
 func extractBufioWriterBuf(bw *bufio.Writer, w io.Writer) []byte {
@@ -425,6 +987,40 @@ func extractBufioWriterBuf(bw *bufio.Writer, w io.Writer) []byte {
 	return writeBuf
 }
 
+// failProtocol fails the connection with StatusProtocolError and returns err
+// wrapped with ErrProtocolViolation.
+func (c *Conn) failProtocol(err error) error {
+	c.writeError(StatusProtocolError, err)
+	return fmt.Errorf("%w: %w", ErrProtocolViolation, err)
+}
+
+// failFrame is like failProtocol but returns a *ProtocolError with the fields
+// of h.
+func (c *Conn) failFrame(h header, err error) error {
+	pe := &ProtocolError{
+		Err:           err,
+		Fin:           h.fin,
+		RSV1:          h.rsv1,
+		RSV2:          h.rsv2,
+		RSV3:          h.rsv3,
+		Opcode:        int(h.opcode),
+		Masked:        h.masked,
+		PayloadLength: h.payloadLength,
+	}
+	c.writeError(StatusProtocolError, pe)
+	return pe
+}
+
 func (c *Conn) writeError(code StatusCode, err error) {
-	c.writeClose(code, err.Error())
+	c.log(slog.LevelWarn, "failing WebSocket connection", "code", code, "error", err)
+	c.setCloseCause(err)
+	reason := err.Error()
+	if pe, ok := err.(*ProtocolError); ok {
+		// The frame header is only meant for logs.
+		reason = pe.Err.Error()
+	}
+	c.writeClose(context.Background(), code, reason)
+	if c.waitCloseOnError && !c.closing.Load() {
+		c.waitCloseError()
+	}
 }
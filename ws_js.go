@@ -3,17 +3,21 @@ package websocket // import "github.com/coder/websocket"
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
+	"net/url"
 	"reflect"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"syscall/js"
+	"time"
 
 	"github.com/coder/websocket/internal/bpool"
 	"github.com/coder/websocket/internal/wsjs"
@@ -47,8 +51,14 @@ type Conn struct {
 	// read limit for a message in bytes.
 	msgReadLimit atomic.Int64
 
-	closeReadMu  sync.Mutex
-	closeReadCtx context.Context
+	// readDeadline and writeDeadline bound ReadMessage and WriteMessage.
+	// nil means no deadline.
+	readDeadline  atomic.Pointer[time.Time]
+	writeDeadline atomic.Pointer[time.Time]
+
+	closeReadMu     sync.Mutex
+	closeReadCtx    context.Context
+	closeReadResume chan struct{}
 
 	closingMu     sync.Mutex
 	closeOnce     sync.Once
@@ -56,6 +66,7 @@ type Conn struct {
 	closeErrOnce  sync.Once
 	closeErr      error
 	closeWasClean bool
+	closeReceived CloseError
 
 	releaseOnClose   func()
 	releaseOnError   func()
@@ -64,8 +75,22 @@ type Conn struct {
 	readSignal chan struct{}
 	readBufMu  sync.Mutex
 	readBuf    []wsjs.MessageEvent
+
+	writeHighWaterMark int
+
+	emulatedPing  bool
+	pingCounter   atomic.Int64
+	activePingsMu sync.Mutex
+	activePings   map[string]chan<- struct{}
 }
 
+// emulatedPingPrefix and emulatedPongPrefix start the binary messages used to
+// emulate ping and pong frames. See DialOptions.EmulatedPing.
+var (
+	emulatedPingPrefix = []byte("\x00websocket-ping\x00")
+	emulatedPongPrefix = []byte("\x00websocket-pong\x00")
+)
+
 func (c *Conn) close(err error, wasClean bool) {
 	c.closeOnce.Do(func() {
 		runtime.SetFinalizer(c, nil)
@@ -82,6 +107,7 @@ func (c *Conn) close(err error, wasClean bool) {
 func (c *Conn) init() {
 	c.closed = make(chan struct{})
 	c.readSignal = make(chan struct{}, 1)
+	c.activePings = make(map[string]chan<- struct{})
 
 	c.msgReadLimit.Store(32768)
 
@@ -90,6 +116,10 @@ func (c *Conn) init() {
 			Code:   StatusCode(e.Code),
 			Reason: e.Reason,
 		}
+		if e.WasClean {
+			// The close event carries the code and reason of the peer's close frame.
+			c.closeReceived = err
+		}
 		// We do not know if we sent or received this close as
 		// its possible the browser triggered it without us
 		// explicitly sending it.
@@ -106,6 +136,13 @@ func (c *Conn) init() {
 	})
 
 	c.releaseOnMessage = c.ws.OnMessage(func(e wsjs.MessageEvent) {
+		if c.emulatedPing {
+			if p, ok := e.Data.([]byte); ok && bytes.HasPrefix(p, emulatedPongPrefix) {
+				c.handleEmulatedPong(p[len(emulatedPongPrefix):])
+				return
+			}
+		}
+
 		c.readBufMu.Lock()
 		defer c.readBufMu.Unlock()
 
@@ -131,6 +168,32 @@ func (c *Conn) closeWithInternal() {
 // Read attempts to read a message from the connection.
 // The maximum time spent waiting is bounded by the context.
 func (c *Conn) Read(ctx context.Context) (MessageType, []byte, error) {
+	typ, data, err := c.readMessage(ctx, c.msgReadLimit.Load())
+	if err != nil {
+		return 0, nil, err
+	}
+
+	b, ok := data.(wsjs.Blob)
+	if !ok {
+		return typ, data.([]byte), nil
+	}
+	p, err := io.ReadAll(b.Reader())
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to read: %w", err)
+	}
+	return typ, p, nil
+}
+
+// ReadTimeout is like Read but bounded by timeout instead of a context.
+func (c *Conn) ReadTimeout(timeout time.Duration) (MessageType, []byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return c.Read(ctx)
+}
+
+// readMessage returns the data of the next message as a []byte
+// or as a wsjs.Blob if DialOptions.StreamBinaryMessages is set.
+func (c *Conn) readMessage(ctx context.Context, readLimit int64) (MessageType, any, error) {
 	c.closeReadMu.Lock()
 	closedRead := c.closeReadCtx != nil
 	c.closeReadMu.Unlock()
@@ -138,20 +201,31 @@ func (c *Conn) Read(ctx context.Context) (MessageType, []byte, error) {
 		return 0, nil, errors.New("WebSocket connection read closed")
 	}
 
-	typ, p, err := c.read(ctx)
+	typ, data, err := c.read(ctx)
 	if err != nil {
 		return 0, nil, fmt.Errorf("failed to read: %w", err)
 	}
-	readLimit := c.msgReadLimit.Load()
-	if readLimit >= 0 && int64(len(p)) > readLimit {
-		reason := fmt.Errorf("read limited at %d bytes", c.msgReadLimit.Load())
+	var size int
+	switch data := data.(type) {
+	case []byte:
+		size = len(data)
+	case wsjs.Blob:
+		size = data.Size()
+	}
+	if readLimit >= 0 && int64(size) > readLimit {
+		reason := fmt.Errorf("read limited at %d bytes", readLimit)
 		c.Close(StatusMessageTooBig, reason.Error())
-		return 0, nil, fmt.Errorf("%w: %v", ErrMessageTooBig, reason)
+		return 0, nil, &MessageTooBigError{
+			MessageType: typ,
+			Limit:       readLimit,
+			Read:        int64(size),
+			Size:        int64(size),
+		}
 	}
-	return typ, p, nil
+	return typ, data, nil
 }
 
-func (c *Conn) read(ctx context.Context) (MessageType, []byte, error) {
+func (c *Conn) read(ctx context.Context) (MessageType, any, error) {
 	select {
 	case <-ctx.Done():
 		c.Close(StatusPolicyViolation, "read timed out")
@@ -181,22 +255,96 @@ func (c *Conn) read(ctx context.Context) (MessageType, []byte, error) {
 	switch p := me.Data.(type) {
 	case string:
 		return MessageText, []byte(p), nil
-	case []byte:
+	case []byte, wsjs.Blob:
 		return MessageBinary, p, nil
 	default:
 		panic("websocket: unexpected data type from wsjs OnMessage: " + reflect.TypeOf(me.Data).String())
 	}
 }
 
-// Ping is mocked out for Wasm.
+// Ping is a no-op in Wasm unless DialOptions.EmulatedPing is set, in which
+// case it sends an emulated ping and waits for the emulated pong.
+// Unlike with ping frames, no concurrent Reader call is required.
 func (c *Conn) Ping(ctx context.Context) error {
+	return c.ping(ctx, strconv.FormatInt(c.pingCounter.Add(1), 10))
+}
+
+// PingWithPayload is like Ping but sends the given payload.
+// Pongs with a mismatched payload are not detected in Wasm.
+func (c *Conn) PingWithPayload(ctx context.Context, payload []byte) error {
+	return c.ping(ctx, string(payload))
+}
+
+// Pong is a no-op in Wasm unless DialOptions.EmulatedPing is set, in which
+// case it sends an unsolicited emulated pong.
+func (c *Conn) Pong(ctx context.Context, payload []byte) error {
+	if !c.emulatedPing {
+		return nil
+	}
+
+	err := c.write(MessageBinary, append(emulatedPongPrefix[:len(emulatedPongPrefix):len(emulatedPongPrefix)], payload...))
+	if err != nil {
+		return fmt.Errorf("failed to pong: %w", err)
+	}
 	return nil
 }
 
+func (c *Conn) ping(ctx context.Context, p string) error {
+	if !c.emulatedPing {
+		return nil
+	}
+
+	pong := make(chan struct{}, 1)
+
+	c.activePingsMu.Lock()
+	c.activePings[p] = pong
+	c.activePingsMu.Unlock()
+
+	defer func() {
+		c.activePingsMu.Lock()
+		delete(c.activePings, p)
+		c.activePingsMu.Unlock()
+	}()
+
+	err := c.write(MessageBinary, append(emulatedPingPrefix[:len(emulatedPingPrefix):len(emulatedPingPrefix)], p...))
+	if err != nil {
+		return fmt.Errorf("failed to ping: %w", err)
+	}
+
+	select {
+	case <-c.closed:
+		return net.ErrClosed
+	case <-ctx.Done():
+		return fmt.Errorf("failed to wait for pong: %w", ctx.Err())
+	case <-pong:
+		return nil
+	}
+}
+
+func (c *Conn) handleEmulatedPong(p []byte) {
+	c.activePingsMu.Lock()
+	defer c.activePingsMu.Unlock()
+
+	pong, ok := c.activePings[string(p)]
+	if ok {
+		select {
+		case pong <- struct{}{}:
+		default:
+		}
+	}
+}
+
 // Write writes a message of the given type to the connection.
-// Always non blocking.
+//
+// It is non blocking unless DialOptions.WriteHighWaterMark is set,
+// in which case it first waits for Buffered to drop below it.
 func (c *Conn) Write(ctx context.Context, typ MessageType, p []byte) error {
-	err := c.write(typ, p)
+	err := c.waitBuffered(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to write: %w", err)
+	}
+
+	err = c.write(typ, p)
 	if err != nil {
 		// Have to ensure the WebSocket is closed after a write error
 		// to match the Go API. It can only error if the message type
@@ -210,6 +358,68 @@ func (c *Conn) Write(ctx context.Context, typ MessageType, p []byte) error {
 	return nil
 }
 
+// WriteMessages writes msgs in order with Write. It stops at the first error.
+func (c *Conn) WriteMessages(ctx context.Context, msgs []Message) error {
+	for _, m := range msgs {
+		err := c.Write(ctx, m.Type, m.Data)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteTimeout is like Write but bounded by timeout instead of a context.
+func (c *Conn) WriteTimeout(timeout time.Duration, typ MessageType, p []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return c.Write(ctx, typ, p)
+}
+
+// Buffered returns the number of bytes of written messages that the browser
+// has queued but not yet transmitted. See bufferedAmount on the browser's WebSocket.
+func (c *Conn) Buffered() int {
+	return c.ws.BufferedAmount()
+}
+
+// Drain blocks until the browser has transmitted all queued data.
+func (c *Conn) Drain(ctx context.Context) error {
+	// The browser does not signal when bufferedAmount decreases.
+	t := time.NewTicker(time.Millisecond * 10)
+	defer t.Stop()
+	for c.Buffered() > 0 {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("failed to drain: %w", ctx.Err())
+		case <-c.closed:
+			return net.ErrClosed
+		case <-t.C:
+		}
+	}
+	return nil
+}
+
+func (c *Conn) waitBuffered(ctx context.Context) error {
+	if c.writeHighWaterMark <= 0 || c.Buffered() < c.writeHighWaterMark {
+		return nil
+	}
+
+	// The browser does not signal when bufferedAmount decreases.
+	t := time.NewTicker(time.Millisecond * 10)
+	defer t.Stop()
+	for c.Buffered() >= c.writeHighWaterMark {
+		select {
+		case <-ctx.Done():
+			c.Close(StatusPolicyViolation, "write timed out")
+			return ctx.Err()
+		case <-c.closed:
+			return net.ErrClosed
+		case <-t.C:
+		}
+	}
+	return nil
+}
+
 func (c *Conn) write(typ MessageType, p []byte) error {
 	if c.isClosed() {
 		return net.ErrClosed
@@ -229,13 +439,75 @@ func (c *Conn) write(typ MessageType, p []byte) error {
 // or the connection is closed.
 // It thus performs the full WebSocket close handshake.
 func (c *Conn) Close(code StatusCode, reason string) error {
-	err := c.exportedClose(code, reason)
+	return c.CloseWithContext(context.Background(), code, reason)
+}
+
+// CloseWithContext is like Close but returns the context error if ctx
+// expires before the peer responds.
+func (c *Conn) CloseWithContext(ctx context.Context, code StatusCode, reason string) error {
+	err := c.exportedClose(ctx, code, reason)
 	if err != nil {
 		return fmt.Errorf("failed to close WebSocket: %w", err)
 	}
 	return nil
 }
 
+// CloseWrite starts the close handshake without waiting for it to complete.
+// Messages received before the peer's close frame can still be read.
+func (c *Conn) CloseWrite(code StatusCode, reason string) error {
+	c.closingMu.Lock()
+	defer c.closingMu.Unlock()
+
+	if c.isClosed() {
+		return net.ErrClosed
+	}
+
+	c.setCloseErr(fmt.Errorf("sent close: %w", CloseError{
+		Code:   code,
+		Reason: reason,
+	}))
+	err := c.ws.Close(int(code), reason)
+	if err != nil {
+		return fmt.Errorf("failed to close WebSocket for writing: %w", err)
+	}
+	return nil
+}
+
+// WriteCloseFrame is like CloseWrite. ctx is ignored as the browser sends
+// the close frame asynchronously.
+func (c *Conn) WriteCloseFrame(ctx context.Context, code StatusCode, reason string) error {
+	return c.CloseWrite(code, reason)
+}
+
+// State returns the state of the connection from the readyState of the
+// browser WebSocket. The browser does not tell which peer started the close
+// handshake so StateClosingSent is returned while closing.
+func (c *Conn) State() State {
+	if c.isClosed() {
+		return StateClosed
+	}
+	switch c.ws.ReadyState() {
+	case 0:
+		return StateConnecting
+	case 1:
+		return StateOpen
+	case 2:
+		return StateClosingSent
+	default:
+		return StateClosed
+	}
+}
+
+// CloseReceived returns the status code and reason of the close frame received
+// from the peer once the connection has been cleanly closed.
+func (c *Conn) CloseReceived() (*CloseError, bool) {
+	if !c.isClosed() || !c.closeWasClean {
+		return nil, false
+	}
+	ce := c.closeReceived
+	return &ce, true
+}
+
 // CloseNow closes the WebSocket connection without attempting a close handshake.
 // Use when you do not want the overhead of the close handshake.
 //
@@ -245,7 +517,7 @@ func (c *Conn) CloseNow() error {
 	return c.Close(StatusGoingAway, "")
 }
 
-func (c *Conn) exportedClose(code StatusCode, reason string) error {
+func (c *Conn) exportedClose(ctx context.Context, code StatusCode, reason string) error {
 	c.closingMu.Lock()
 	defer c.closingMu.Unlock()
 
@@ -264,7 +536,11 @@ func (c *Conn) exportedClose(code StatusCode, reason string) error {
 		return err
 	}
 
-	<-c.closed
+	select {
+	case <-c.closed:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 	if !c.closeWasClean {
 		return c.closeErr
 	}
@@ -277,10 +553,63 @@ func (c *Conn) Subprotocol() string {
 	return c.ws.Subprotocol()
 }
 
+// Extensions returns the extensions the browser negotiated.
+func (c *Conn) Extensions() string {
+	return c.ws.Extensions()
+}
+
+// HandshakeRequest always returns nil in Wasm as the browser performs
+// the handshake.
+func (c *Conn) HandshakeRequest() *http.Request {
+	return nil
+}
+
+// UnderlyingConn always returns nil in Wasm as the browser owns the
+// connection.
+func (c *Conn) UnderlyingConn() net.Conn {
+	return nil
+}
+
+// TLSConnectionState always returns false in Wasm as the browser does not
+// expose the TLS state.
+func (c *Conn) TLSConnectionState() (*tls.ConnectionState, bool) {
+	return nil, false
+}
+
 // DialOptions represents the options available to pass to Dial.
 type DialOptions struct {
-	// Subprotocols lists the subprotocols to negotiate with the server.
+	// Query is appended to the query of the URL, after any parameters the
+	// URL already has. Values are escaped so tokens with special characters
+	// can be passed as is.
+	Query url.Values
+
+	// Subprotocols lists the subprotocols to negotiate with the server
+	// in order of preference.
 	Subprotocols []string
+
+	// SubprotocolRequired fails the dial if the server does not select one of
+	// Subprotocols instead of silently falling back to the default protocol.
+	SubprotocolRequired bool
+
+	// HandshakeTimeout bounds the time spent waiting for the connection to open
+	// in addition to the context passed to Dial.
+	HandshakeTimeout time.Duration
+
+	// EmulatedPing makes Ping send an emulated ping, a small binary message with a
+	// reserved prefix, as browsers cannot send ping frames. The server must
+	// respond to it, which it does when accepting with AcceptOptions.EmulatedPing.
+	EmulatedPing bool
+
+	// StreamBinaryMessages receives binary messages as blobs so that Reader can
+	// stream them instead of holding them in memory. Read still returns the
+	// entire message. It cannot be combined with EmulatedPing.
+	StreamBinaryMessages bool
+
+	// WriteHighWaterMark makes writes wait until Buffered is below it so that
+	// large uploads cannot exhaust memory. The wait is bounded by the context
+	// passed to the write.
+	// Defaults to 0 which disables waiting.
+	WriteHighWaterMark int
 }
 
 // Dial creates a new WebSocket connection to the given url with the given options.
@@ -295,21 +624,45 @@ func Dial(ctx context.Context, url string, opts *DialOptions) (*Conn, *http.Resp
 	return c, resp, nil
 }
 
-func dial(ctx context.Context, url string, opts *DialOptions) (*Conn, *http.Response, error) {
+func dial(ctx context.Context, urls string, opts *DialOptions) (*Conn, *http.Response, error) {
 	if opts == nil {
 		opts = &DialOptions{}
 	}
 
-	url = strings.Replace(url, "http://", "ws://", 1)
-	url = strings.Replace(url, "https://", "wss://", 1)
+	if opts.HandshakeTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.HandshakeTimeout)
+		defer cancel()
+	}
+
+	urls = strings.Replace(urls, "http://", "ws://", 1)
+	urls = strings.Replace(urls, "https://", "wss://", 1)
 
-	ws, err := wsjs.New(url, opts.Subprotocols)
+	if len(opts.Query) > 0 {
+		u, err := url.Parse(urls)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to parse url: %w", err)
+		}
+		addQuery(u, opts.Query)
+		urls = u.String()
+	}
+
+	if opts.StreamBinaryMessages && opts.EmulatedPing {
+		return nil, nil, errors.New("StreamBinaryMessages cannot be combined with EmulatedPing")
+	}
+
+	ws, err := wsjs.New(urls, opts.Subprotocols)
 	if err != nil {
 		return nil, nil, err
 	}
+	if opts.StreamBinaryMessages {
+		ws.SetBinaryType("blob")
+	}
 
 	c := &Conn{
-		ws: ws,
+		ws:                 ws,
+		writeHighWaterMark: opts.WriteHighWaterMark,
+		emulatedPing:       opts.EmulatedPing,
 	}
 	c.init()
 
@@ -324,6 +677,10 @@ func dial(ctx context.Context, url string, opts *DialOptions) (*Conn, *http.Resp
 		c.Close(StatusPolicyViolation, "dial timed out")
 		return nil, nil, ctx.Err()
 	case <-opench:
+		if opts.SubprotocolRequired && len(opts.Subprotocols) > 0 && c.Subprotocol() == "" {
+			c.Close(StatusProtocolError, "no subprotocol selected")
+			return nil, nil, fmt.Errorf("server did not select any of the subprotocols %q", opts.Subprotocols)
+		}
 		return c, &http.Response{
 			StatusCode: http.StatusSwitchingProtocols,
 		}, nil
@@ -334,12 +691,59 @@ func dial(ctx context.Context, url string, opts *DialOptions) (*Conn, *http.Resp
 
 // Reader attempts to read a message from the connection.
 // The maximum time spent waiting is bounded by the context.
+//
+// Binary messages are streamed from the browser if DialOptions.StreamBinaryMessages
+// is set. Otherwise the entire message is already in memory.
 func (c *Conn) Reader(ctx context.Context) (MessageType, io.Reader, error) {
-	typ, p, err := c.Read(ctx)
+	return c.ReaderWithLimit(ctx, c.msgReadLimit.Load())
+}
+
+// ReaderWithLimit is like Reader but limits the message to n bytes instead
+// of the limit set with SetReadLimit. Set n to -1 to disable the limit for
+// the message.
+func (c *Conn) ReaderWithLimit(ctx context.Context, n int64) (MessageType, io.Reader, error) {
+	typ, data, err := c.readMessage(ctx, n)
+	if err != nil {
+		return 0, nil, err
+	}
+	if b, ok := data.(wsjs.Blob); ok {
+		return typ, b.Reader(), nil
+	}
+	return typ, bytes.NewReader(data.([]byte)), nil
+}
+
+// ReaderWithProgress is like Reader but calls progress with the number of
+// bytes of the message read so far after each read. total is the size of
+// the message if it is already in memory and -1 otherwise.
+func (c *Conn) ReaderWithProgress(ctx context.Context, progress func(read, total int64)) (MessageType, io.Reader, error) {
+	typ, data, err := c.readMessage(ctx, c.msgReadLimit.Load())
 	if err != nil {
 		return 0, nil, err
 	}
-	return typ, bytes.NewReader(p), nil
+	pr := &progressReader{progress: progress, total: -1}
+	if b, ok := data.(wsjs.Blob); ok {
+		pr.r = b.Reader()
+	} else {
+		pr.r = bytes.NewReader(data.([]byte))
+		pr.total = int64(len(data.([]byte)))
+	}
+	return typ, pr, nil
+}
+
+type progressReader struct {
+	r        io.Reader
+	read     int64
+	total    int64
+	progress func(read, total int64)
+}
+
+func (pr *progressReader) Read(p []byte) (int, error) {
+	n, err := pr.r.Read(p)
+	if n > 0 {
+		pr.read += int64(n)
+		pr.progress(pr.read, pr.total)
+	}
+	return n, err
 }
 
 // Writer returns a writer to write a WebSocket data message to the connection.
@@ -354,6 +758,101 @@ func (c *Conn) Writer(ctx context.Context, typ MessageType) (io.WriteCloser, err
 	}, nil
 }
 
+// WriterWithProgress is like Writer but calls progress with the size of
+// the message once it is sent on Close.
+func (c *Conn) WriterWithProgress(ctx context.Context, typ MessageType, progress func(written int64)) (io.WriteCloser, error) {
+	return &writer{
+		c:        c,
+		ctx:      ctx,
+		typ:      typ,
+		b:        bpool.Get(),
+		progress: progress,
+	}, nil
+}
+
+// BufferedWriter is like Writer as messages are always written in a single
+// frame in Wasm. Flush is a no-op.
+func (c *Conn) BufferedWriter(ctx context.Context, typ MessageType) (*MessageWriter, error) {
+	return &MessageWriter{
+		w: &writer{
+			c:   c,
+			ctx: ctx,
+			typ: typ,
+			b:   bpool.Get(),
+		},
+	}, nil
+}
+
+// MessageWriter writes a single message. See Conn.BufferedWriter.
+type MessageWriter struct {
+	w *writer
+}
+
+// Write buffers p until Close.
+func (w *MessageWriter) Write(p []byte) (int, error) {
+	return w.w.Write(p)
+}
+
+// Buffered returns the number of bytes written but not yet sent.
+func (w *MessageWriter) Buffered() int {
+	if w.w.closed {
+		return 0
+	}
+	return w.w.b.Len()
+}
+
+// Flush is a no-op in Wasm as the message is sent on Close.
+func (w *MessageWriter) Flush() error {
+	if w.w.closed {
+		return fmt.Errorf("failed to flush: %w", ErrWriterClosed)
+	}
+	return nil
+}
+
+// Close writes the message to the connection.
+func (w *MessageWriter) Close() error {
+	return w.w.Close()
+}
+
+// WriteAsync writes the message and calls done, if not nil, with the result.
+// The message is written synchronously in Wasm as the browser already
+// queues outgoing messages.
+func (c *Conn) WriteAsync(typ MessageType, p []byte, done func(error)) {
+	err := c.Write(context.Background(), typ, p)
+	if done != nil {
+		done(err)
+	}
+}
+
+// WriteAsyncOpts is like WriteAsync. The options have no effect in Wasm.
+func (c *Conn) WriteAsyncOpts(typ MessageType, p []byte, done func(error), opts ...WriteOption) {
+	c.WriteAsync(typ, p, done)
+}
+
+// WriterOpts is like Writer. The options have no effect in Wasm
+// as the browser decides whether to compress.
+func (c *Conn) WriterOpts(ctx context.Context, typ MessageType, opts ...WriteOption) (io.WriteCloser, error) {
+	return c.Writer(ctx, typ)
+}
+
+// WriteOpts is like Write. The options have no effect in Wasm
+// as the browser decides whether to compress.
+func (c *Conn) WriteOpts(ctx context.Context, typ MessageType, p []byte, opts ...WriteOption) error {
+	return c.Write(ctx, typ, p)
+}
+
+// WriteOption is an option for a single message passed to
+// WriteOpts, WriterOpts or WriteAsyncOpts.
+type WriteOption int
+
+const (
+	// NoCompress writes the message without compression.
+	NoCompress WriteOption = iota + 1
+
+	// Urgent has no effect in Wasm as messages are written synchronously.
+	Urgent
+)
+
 type writer struct {
 	closed bool
 
@@ -361,12 +860,13 @@ type writer struct {
 	ctx context.Context
 	typ MessageType
 
-	b *bytes.Buffer
+	b        *bytes.Buffer
+	progress func(written int64)
 }
 
 func (w *writer) Write(p []byte) (int, error) {
 	if w.closed {
-		return 0, errors.New("cannot write to closed writer")
+		return 0, fmt.Errorf("cannot write: %w", ErrWriterClosed)
 	}
 	n, err := w.b.Write(p)
 	if err != nil {
@@ -377,7 +877,7 @@ func (w *writer) Write(p []byte) (int, error) {
 
 func (w *writer) Close() error {
 	if w.closed {
-		return errors.New("cannot close closed writer")
+		return fmt.Errorf("cannot close: %w", ErrWriterClosed)
 	}
 	w.closed = true
 	defer bpool.Put(w.b)
@@ -386,6 +886,9 @@ func (w *writer) Close() error {
 	if err != nil {
 		return fmt.Errorf("failed to close writer: %w", err)
 	}
+	if w.progress != nil && w.b.Len() > 0 {
+		w.progress(int64(w.b.Len()))
+	}
 	return nil
 }
 
@@ -397,26 +900,76 @@ func (c *Conn) CloseRead(ctx context.Context) context.Context {
 		c.closeReadMu.Unlock()
 		return ctx2
 	}
-	ctx, cancel := context.WithCancel(ctx)
+	ctx, cancel := context.WithCancelCause(ctx)
+	resume := make(chan struct{})
 	c.closeReadCtx = ctx
+	c.closeReadResume = resume
 	c.closeReadMu.Unlock()
 
 	go func() {
-		defer cancel()
-		defer c.CloseNow()
-		_, _, err := c.read(ctx)
-		if err != nil {
+		defer func() {
+			cancel(c.CloseCause())
+		}()
+		select {
+		case <-resume:
+			return
+		case <-c.readSignal:
+			c.closeReadMu.Lock()
+			resumed := c.closeReadResume != resume
+			if !resumed {
+				// The connection is failed so ResumeRead must not reset.
+				c.closeReadResume = nil
+			}
+			c.closeReadMu.Unlock()
+			if resumed {
+				// Leave the message to Read.
+				select {
+				case c.readSignal <- struct{}{}:
+				default:
+				}
+				return
+			}
 			c.Close(StatusPolicyViolation, "unexpected data message")
+		case <-ctx.Done():
+			c.Close(StatusPolicyViolation, "read timed out")
+		case <-c.closed:
 		}
+		c.CloseNow()
 	}()
 	return ctx
 }
 
+// ResumeRead implements *Conn.ResumeRead for wasm. As the browser handles
+// control frames, reading resumes immediately.
+func (c *Conn) ResumeRead() {
+	c.closeReadMu.Lock()
+	defer c.closeReadMu.Unlock()
+	if c.closeReadResume != nil {
+		close(c.closeReadResume)
+		c.closeReadResume = nil
+		c.closeReadCtx = nil
+	}
+}
+
+// SetWriteFragmentSize is a no-op in Wasm as the browser decides how
+// messages are framed.
+func (c *Conn) SetWriteFragmentSize(n int) {}
+
 // SetReadLimit implements *Conn.SetReadLimit for wasm.
 func (c *Conn) SetReadLimit(n int64) {
 	c.msgReadLimit.Store(n)
 }
 
+// CloseCause implements *Conn.CloseCause for wasm. The browser only reports
+// the close event so the cause is the peer's close frame or the error that
+// failed the connection.
+func (c *Conn) CloseCause() error {
+	if !c.isClosed() {
+		return nil
+	}
+	return c.closeErr
+}
+
 func (c *Conn) setCloseErr(err error) {
 	c.closeErrOnce.Do(func() {
 		c.closeErr = fmt.Errorf("WebSocket closed: %w", err)
@@ -561,6 +1114,12 @@ const (
 	MessageBinary
 )
 
+// Message is a data message written by Conn.WriteMessages.
+type Message struct {
+	Type MessageType
+	Data []byte
+}
+
 type mu struct {
 	c  *Conn
 	ch chan struct{}
@@ -1,7 +1,9 @@
 package websocket_test
 
 import (
+	"bytes"
 	"context"
+	"io"
 	"net/http"
 	"os"
 	"testing"
@@ -20,6 +22,7 @@ func TestWasm(t *testing.T) {
 
 	c, resp, err := websocket.Dial(ctx, os.Getenv("WS_ECHO_SERVER_URL"), &websocket.DialOptions{
 		Subprotocols: []string{"echo"},
+		EmulatedPing: true,
 	})
 	assert.Success(t, err)
 	defer c.Close(websocket.StatusInternalError, "")
@@ -33,6 +36,9 @@ func TestWasm(t *testing.T) {
 		assert.Success(t, err)
 	}
 
+	err = c.Ping(ctx)
+	assert.Success(t, err)
+
 	err = c.Close(websocket.StatusNormalClosure, "")
 	assert.Success(t, err)
 }
@@ -52,3 +58,56 @@ func TestWasmDialTimeout(t *testing.T) {
 		t.Fatal("wasm context dial timeout is not working", time.Since(beforeDial))
 	}
 }
+
+func TestWasmWriteHighWaterMark(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	c, _, err := websocket.Dial(ctx, os.Getenv("WS_ECHO_SERVER_URL"), &websocket.DialOptions{
+		Subprotocols:       []string{"echo"},
+		WriteHighWaterMark: 1,
+	})
+	assert.Success(t, err)
+	defer c.Close(websocket.StatusInternalError, "")
+
+	c.SetReadLimit(65536)
+	for range 10 {
+		err = wstest.Echo(ctx, c, 65536)
+		assert.Success(t, err)
+	}
+	assert.Equal(t, "buffered", 0, c.Buffered())
+
+	err = c.Close(websocket.StatusNormalClosure, "")
+	assert.Success(t, err)
+}
+
+func TestWasmStreamBinaryMessages(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	c, _, err := websocket.Dial(ctx, os.Getenv("WS_ECHO_SERVER_URL"), &websocket.DialOptions{
+		Subprotocols:         []string{"echo"},
+		StreamBinaryMessages: true,
+	})
+	assert.Success(t, err)
+	defer c.Close(websocket.StatusInternalError, "")
+
+	p := bytes.Repeat([]byte("x"), 65536)
+	err = c.Write(ctx, websocket.MessageBinary, p)
+	assert.Success(t, err)
+
+	c.SetReadLimit(65536)
+	typ, r, err := c.Reader(ctx)
+	assert.Success(t, err)
+	assert.Equal(t, "message type", websocket.MessageBinary, typ)
+	b, err := io.ReadAll(r)
+	assert.Success(t, err)
+	assert.Equal(t, "message", p, b)
+
+	err = c.Close(websocket.StatusNormalClosure, "")
+	assert.Success(t, err)
+}
@@ -0,0 +1,103 @@
+// Package wschecksum verifies the integrity of binary messages end to end with
+// a CRC-32C trailer, for deployments where buggy middleboxes were observed to
+// corrupt messages despite TCP and TLS.
+//
+// Checksums are negotiated with a subprotocol suffix: a peer offering "chat"
+// with checksums offers "chat+crc32c". Offer and accept the subprotocols
+// returned by Subprotocols and wrap the connection with Wrap.
+package wschecksum // import "github.com/coder/websocket/wschecksum"
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"strings"
+
+	"github.com/coder/websocket"
+)
+
+// Suffix is appended to subprotocols to negotiate checksums.
+const Suffix = "+crc32c"
+
+// ErrChecksum is returned by reads of a binary message whose trailer does not
+// match its payload.
+var ErrChecksum = errors.New("wschecksum: checksum mismatch")
+
+var table = crc32.MakeTable(crc32.Castagnoli)
+
+// Subprotocols returns each of protos with Suffix followed by protos itself,
+// to pass as DialOptions.Subprotocols or AcceptOptions.Subprotocols so that
+// checksums are used if both peers support them.
+func Subprotocols(protos ...string) []string {
+	s := make([]string, 0, len(protos)*2)
+	for _, p := range protos {
+		s = append(s, p+Suffix)
+	}
+	return append(s, protos...)
+}
+
+// Conn is a connection that appends a checksum to the binary messages it
+// writes with Write and verifies the checksum of the binary messages it reads
+// with Read if checksums were negotiated. Text messages and the other methods
+// of websocket.Conn, e.g. Reader and Writer, are not checksummed.
+type Conn struct {
+	*websocket.Conn
+
+	enabled bool
+}
+
+// Wrap wraps c, enabling checksums if the negotiated subprotocol ends with
+// Suffix.
+func Wrap(c *websocket.Conn) *Conn {
+	return &Conn{
+		Conn:    c,
+		enabled: strings.HasSuffix(c.Subprotocol(), Suffix),
+	}
+}
+
+// Enabled reports whether checksums were negotiated.
+func (c *Conn) Enabled() bool {
+	return c.enabled
+}
+
+// Subprotocol returns the negotiated subprotocol without Suffix.
+func (c *Conn) Subprotocol() string {
+	return strings.TrimSuffix(c.Conn.Subprotocol(), Suffix)
+}
+
+// Write is like websocket.Conn.Write but appends the checksum of p to binary
+// messages.
+func (c *Conn) Write(ctx context.Context, typ websocket.MessageType, p []byte) error {
+	if !c.enabled || typ != websocket.MessageBinary {
+		return c.Conn.Write(ctx, typ, p)
+	}
+	b := make([]byte, len(p), len(p)+4)
+	copy(b, p)
+	b = binary.BigEndian.AppendUint32(b, crc32.Checksum(p, table))
+	return c.Conn.Write(ctx, typ, b)
+}
+
+// Read is like websocket.Conn.Read but verifies and strips the checksum of
+// binary messages. On a mismatch, the connection is closed with
+// StatusInvalidFramePayloadData and ErrChecksum is returned.
+func (c *Conn) Read(ctx context.Context) (websocket.MessageType, []byte, error) {
+	typ, p, err := c.Conn.Read(ctx)
+	if err != nil || !c.enabled || typ != websocket.MessageBinary {
+		return typ, p, err
+	}
+	if len(p) < 4 {
+		return 0, nil, c.fail(fmt.Errorf("%w: message of %d bytes is missing its checksum", ErrChecksum, len(p)))
+	}
+	i := len(p) - 4
+	if crc32.Checksum(p[:i], table) != binary.BigEndian.Uint32(p[i:]) {
+		return 0, nil, c.fail(ErrChecksum)
+	}
+	return typ, p[:i], nil
+}
+
+func (c *Conn) fail(err error) error {
+	c.Conn.Close(websocket.StatusInvalidFramePayloadData, "checksum mismatch")
+	return err
+}
@@ -0,0 +1,89 @@
+//go:build !js
+
+package wschecksum_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/internal/test/assert"
+	"github.com/coder/websocket/internal/xsync"
+	"github.com/coder/websocket/websockettest"
+	"github.com/coder/websocket/wschecksum"
+)
+
+func pipe(t *testing.T, client, server []string) (context.Context, *wschecksum.Conn, *wschecksum.Conn) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	t.Cleanup(cancel)
+
+	c, s, err := websockettest.Pipe(&websockettest.Options{
+		DialOptions:   &websocket.DialOptions{Subprotocols: client},
+		AcceptOptions: &websocket.AcceptOptions{Subprotocols: server},
+	})
+	assert.Success(t, err)
+	t.Cleanup(func() {
+		c.CloseNow()
+		s.CloseNow()
+	})
+	return ctx, wschecksum.Wrap(c), wschecksum.Wrap(s)
+}
+
+func TestConn(t *testing.T) {
+	t.Parallel()
+
+	t.Run("negotiated", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, c, s := pipe(t, wschecksum.Subprotocols("chat"), wschecksum.Subprotocols("chat"))
+		assert.Equal(t, "enabled", true, c.Enabled())
+		assert.Equal(t, "enabled", true, s.Enabled())
+		assert.Equal(t, "subprotocol", "chat", c.Subprotocol())
+
+		for _, typ := range []websocket.MessageType{websocket.MessageBinary, websocket.MessageText} {
+			errs := xsync.Go(func() error {
+				return c.Write(ctx, typ, []byte("hello"))
+			})
+			gotTyp, p, err := s.Read(ctx)
+			assert.Success(t, err)
+			assert.Success(t, <-errs)
+			assert.Equal(t, "type", typ, gotTyp)
+			assert.Equal(t, "message", "hello", string(p))
+		}
+	})
+
+	t.Run("declined", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, c, s := pipe(t, wschecksum.Subprotocols("chat"), []string{"chat"})
+		assert.Equal(t, "enabled", false, c.Enabled())
+
+		errs := xsync.Go(func() error {
+			return c.Write(ctx, websocket.MessageBinary, []byte("hello"))
+		})
+		_, p, err := s.Conn.Read(ctx)
+		assert.Success(t, err)
+		assert.Success(t, <-errs)
+		assert.Equal(t, "message", "hello", string(p))
+	})
+
+	t.Run("mismatch", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, c, s := pipe(t, wschecksum.Subprotocols("chat"), wschecksum.Subprotocols("chat"))
+
+		readErrs := xsync.Go(func() error {
+			err := c.Conn.Write(ctx, websocket.MessageBinary, []byte("corrupted"))
+			if err != nil {
+				return err
+			}
+			_, _, err = c.Read(ctx)
+			return err
+		})
+		_, _, err := s.Read(ctx)
+		assert.ErrorIs(t, wschecksum.ErrChecksum, err)
+		err = <-readErrs
+		assert.Equal(t, "close status", websocket.StatusInvalidFramePayloadData, websocket.CloseStatus(err))
+	})
+}
@@ -0,0 +1,83 @@
+//go:build !js
+
+package wscompat
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/coder/websocket"
+)
+
+// Dialer dials WebSocket servers like gorilla's Dialer.
+type Dialer struct {
+	// NetDialContext optionally overrides how network connections are
+	// opened.
+	NetDialContext func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	// Proxy optionally returns the proxy of the request, see
+	// websocket.DialOptions.Proxy. The proxy of http.DefaultTransport is
+	// used if nil.
+	Proxy func(*http.Request) (*url.URL, error)
+
+	// TLSClientConfig optionally configures TLS for wss URLs.
+	TLSClientConfig *tls.Config
+
+	// HandshakeTimeout bounds the opening handshake. Zero means no timeout.
+	HandshakeTimeout time.Duration
+
+	// ReadBufferSize and WriteBufferSize are ignored. Buffers are pooled.
+	ReadBufferSize, WriteBufferSize int
+
+	// Subprotocols lists the subprotocols offered to the server in order of
+	// preference.
+	Subprotocols []string
+
+	// EnableCompression offers permessage-deflate without context takeover.
+	EnableCompression bool
+}
+
+// DefaultDialer is a Dialer with a handshake timeout of 45 seconds, like
+// gorilla's.
+var DefaultDialer = &Dialer{
+	HandshakeTimeout: 45 * time.Second,
+}
+
+// Dial is DialContext with context.Background.
+func (d *Dialer) Dial(urlStr string, requestHeader http.Header) (*Conn, *http.Response, error) {
+	return d.DialContext(context.Background(), urlStr, requestHeader)
+}
+
+// DialContext dials the WebSocket server at urlStr with the headers in
+// requestHeader, which may be nil.
+//
+// If the server rejects the handshake, the response is returned with the
+// error and its body holds the start of the response body.
+func (d *Dialer) DialContext(ctx context.Context, urlStr string, requestHeader http.Header) (*Conn, *http.Response, error) {
+	opts := &websocket.DialOptions{
+		HTTPHeader:       requestHeader,
+		NetDialContext:   d.NetDialContext,
+		Proxy:            d.Proxy,
+		TLSConfig:        d.TLSClientConfig,
+		HandshakeTimeout: d.HandshakeTimeout,
+		Subprotocols:     d.Subprotocols,
+	}
+	if d.EnableCompression {
+		opts.CompressionMode = websocket.CompressionNoContextTakeover
+	}
+
+	c := &Conn{}
+	opts.OnPingReceived, opts.OnPongReceived = c.callbacks()
+
+	wc, resp, err := websocket.Dial(ctx, urlStr, opts)
+	if err != nil {
+		return nil, resp, fmt.Errorf("failed to dial: %w", err)
+	}
+	c.c = wc
+	return c, resp, nil
+}
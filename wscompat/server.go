@@ -0,0 +1,83 @@
+//go:build !js
+
+package wscompat
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/coder/websocket"
+)
+
+// Upgrader upgrades HTTP requests to WebSocket connections like gorilla's
+// Upgrader.
+type Upgrader struct {
+	// ReadBufferSize and WriteBufferSize are ignored. Buffers are pooled.
+	ReadBufferSize, WriteBufferSize int
+
+	// Subprotocols lists the subprotocols supported by the server in order
+	// of preference.
+	Subprotocols []string
+
+	// CheckOrigin returns whether the origin of the request is allowed.
+	// If nil, only requests from the request host or without an Origin
+	// header are allowed.
+	CheckOrigin func(r *http.Request) bool
+
+	// EnableCompression negotiates permessage-deflate without context
+	// takeover if the client offers it.
+	EnableCompression bool
+}
+
+// Upgrade upgrades the request to a WebSocket connection. responseHeader
+// is included in the response, e.g. to set cookies, and may be nil.
+//
+// If the upgrade fails, an HTTP error response has been written.
+func (u *Upgrader) Upgrade(w http.ResponseWriter, r *http.Request, responseHeader http.Header) (*Conn, error) {
+	opts := &websocket.AcceptOptions{
+		Subprotocols: u.Subprotocols,
+	}
+	if u.CheckOrigin != nil {
+		if !u.CheckOrigin(r) {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return nil, errors.New("failed to upgrade: request origin not allowed by Upgrader.CheckOrigin")
+		}
+		opts.InsecureSkipVerify = true
+	}
+	if u.EnableCompression {
+		opts.CompressionMode = websocket.CompressionNoContextTakeover
+	}
+
+	c := &Conn{}
+	opts.OnPingReceived, opts.OnPongReceived = c.callbacks()
+
+	for k, v := range responseHeader {
+		w.Header()[k] = v
+	}
+	wc, err := websocket.Accept(w, r, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to upgrade: %w", err)
+	}
+	c.c = wc
+	return c, nil
+}
+
+// IsWebSocketUpgrade reports whether the client requested an upgrade to
+// the WebSocket protocol.
+func IsWebSocketUpgrade(r *http.Request) bool {
+	return headerContainsToken(r.Header, "Connection", "upgrade") &&
+		headerContainsToken(r.Header, "Upgrade", "websocket")
+}
+
+func headerContainsToken(h http.Header, key, token string) bool {
+	for _, v := range h.Values(key) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
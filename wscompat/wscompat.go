@@ -0,0 +1,404 @@
+//go:build !js
+
+// Package wscompat provides the API of github.com/gorilla/websocket on top of
+// this module to ease migrating from it.
+//
+// Conn mirrors gorilla's Conn with deadlines instead of contexts, message
+// types as ints and handlers for ping and pong frames. Upgrader and Dialer
+// mirror the handshake APIs. Code can be migrated one call site at a time by
+// replacing the import and using Conn.Unwrap to reach the underlying
+// websocket.Conn.
+//
+// Some behavior differs from gorilla:
+//   - An expired read or write deadline closes the connection. gorilla
+//     leaves it unusable as well.
+//   - Close frames from the peer are echoed automatically.
+//   - WriteControl with PingMessage waits for the pong until the deadline.
+package wscompat // import "github.com/coder/websocket/wscompat"
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
+)
+
+// Message types, with the values of the WebSocket opcodes like in gorilla.
+const (
+	TextMessage   = 1
+	BinaryMessage = 2
+	CloseMessage  = 8
+	PingMessage   = 9
+	PongMessage   = 10
+)
+
+// Close codes, see websocket.StatusCode.
+const (
+	CloseNormalClosure           = int(websocket.StatusNormalClosure)
+	CloseGoingAway               = int(websocket.StatusGoingAway)
+	CloseProtocolError           = int(websocket.StatusProtocolError)
+	CloseUnsupportedData         = int(websocket.StatusUnsupportedData)
+	CloseNoStatusReceived        = int(websocket.StatusNoStatusRcvd)
+	CloseAbnormalClosure         = int(websocket.StatusAbnormalClosure)
+	CloseInvalidFramePayloadData = int(websocket.StatusInvalidFramePayloadData)
+	ClosePolicyViolation         = int(websocket.StatusPolicyViolation)
+	CloseMessageTooBig           = int(websocket.StatusMessageTooBig)
+	CloseMandatoryExtension      = int(websocket.StatusMandatoryExtension)
+	CloseInternalServerErr       = int(websocket.StatusInternalError)
+	CloseServiceRestart          = int(websocket.StatusServiceRestart)
+	CloseTryAgainLater           = int(websocket.StatusTryAgainLater)
+	CloseTLSHandshake            = int(websocket.StatusTLSHandshake)
+)
+
+// FormatCloseMessage formats closeCode and text as the payload of a close
+// message for WriteControl and WriteMessage.
+func FormatCloseMessage(closeCode int, text string) []byte {
+	if closeCode == CloseNoStatusReceived {
+		return []byte{}
+	}
+	b := make([]byte, 2+len(text))
+	binary.BigEndian.PutUint16(b, uint16(closeCode))
+	copy(b[2:], text)
+	return b
+}
+
+// IsCloseError reports whether err is a close error with one of codes.
+func IsCloseError(err error, codes ...int) bool {
+	code := websocket.CloseStatus(err)
+	return code != -1 && slices.Contains(codes, int(code))
+}
+
+// IsUnexpectedCloseError reports whether err is a close error with a code
+// not in expectedCodes.
+func IsUnexpectedCloseError(err error, expectedCodes ...int) bool {
+	code := websocket.CloseStatus(err)
+	return code != -1 && !slices.Contains(expectedCodes, int(code))
+}
+
+// Conn is a WebSocket connection with the API of gorilla's Conn.
+//
+// Like in gorilla, at most one goroutine may read and one may write
+// concurrently. Close and WriteControl may be called concurrently with all
+// other methods.
+type Conn struct {
+	c *websocket.Conn
+
+	mu            sync.Mutex
+	readDeadline  time.Time
+	writeDeadline time.Time
+	pingHandler   func(appData string) error
+	pongHandler   func(appData string) error
+	handlerErr    error
+
+	// readCancel cancels the context of the read in progress or of the
+	// reader returned by NextReader.
+	readCancel context.CancelFunc
+	// readTimer cancels the same context once the read deadline expires.
+	// SetReadDeadline resets it so that a deadline extended during a read,
+	// e.g. by a pong handler, applies to that read.
+	readTimer *time.Timer
+}
+
+// callbacks returns the ping and pong callbacks of c for the options of its
+// handshake.
+func (c *Conn) callbacks() (onPing func(context.Context, []byte) bool, onPong func(context.Context, []byte)) {
+	onPing = func(ctx context.Context, payload []byte) bool {
+		c.mu.Lock()
+		h := c.pingHandler
+		c.mu.Unlock()
+		if h == nil {
+			return true
+		}
+		c.handle(h(string(payload)))
+		return false
+	}
+	onPong = func(ctx context.Context, payload []byte) {
+		c.mu.Lock()
+		h := c.pongHandler
+		c.mu.Unlock()
+		if h != nil {
+			c.handle(h(string(payload)))
+		}
+	}
+	return onPing, onPong
+}
+
+// handle closes the connection if a handler returned an error so that the
+// read in progress returns it.
+func (c *Conn) handle(err error) {
+	if err == nil {
+		return
+	}
+	c.mu.Lock()
+	if c.handlerErr == nil {
+		c.handlerErr = err
+	}
+	c.mu.Unlock()
+	// CloseNow waits for the read calling the handler to return.
+	go c.c.CloseNow()
+}
+
+// Unwrap returns the underlying connection.
+func (c *Conn) Unwrap() *websocket.Conn {
+	return c.c
+}
+
+// Subprotocol returns the negotiated subprotocol.
+func (c *Conn) Subprotocol() string {
+	return c.c.Subprotocol()
+}
+
+// Close closes the connection without sending a close frame. Send one first
+// with WriteControl to close the connection cleanly.
+func (c *Conn) Close() error {
+	return c.c.CloseNow()
+}
+
+// SetReadDeadline sets the deadline of reads. The zero value means no
+// deadline. The connection is closed when a read exceeds it.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readDeadline = t
+	c.resetReadTimer()
+	return nil
+}
+
+// resetReadTimer arms readTimer for the read deadline. c.mu must be held.
+func (c *Conn) resetReadTimer() {
+	if c.readTimer == nil {
+		return
+	}
+	if c.readDeadline.IsZero() {
+		c.readTimer.Stop()
+		return
+	}
+	c.readTimer.Reset(max(time.Until(c.readDeadline), 1))
+}
+
+// SetWriteDeadline sets the deadline of writes. The zero value means no
+// deadline. The connection is closed when a write exceeds it.
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.writeDeadline = t
+	c.mu.Unlock()
+	return nil
+}
+
+// SetReadLimit sets the maximum size of a message read from the peer.
+func (c *Conn) SetReadLimit(limit int64) {
+	c.c.SetReadLimit(limit)
+}
+
+// SetPingHandler sets the handler for ping messages. The default handler,
+// used if h is nil, sends a pong. A custom handler must send the pong
+// itself, e.g. with WriteControl.
+//
+// Handlers are called from the read methods. If h returns an error, the
+// connection is closed and the read returns the error.
+func (c *Conn) SetPingHandler(h func(appData string) error) {
+	c.mu.Lock()
+	c.pingHandler = h
+	c.mu.Unlock()
+}
+
+// SetPongHandler sets the handler for pong messages, e.g. to extend the read
+// deadline. The default handler does nothing.
+//
+// Handlers are called from the read methods. If h returns an error, the
+// connection is closed and the read returns the error.
+func (c *Conn) SetPongHandler(h func(appData string) error) {
+	c.mu.Lock()
+	c.pongHandler = h
+	c.mu.Unlock()
+}
+
+func deadlineContext(t time.Time) (context.Context, context.CancelFunc) {
+	if t.IsZero() {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithDeadline(context.Background(), t)
+}
+
+func (c *Conn) readContext() (context.Context, context.CancelFunc) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.readCancel != nil {
+		c.readCancel()
+	}
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	t := time.AfterFunc(math.MaxInt64, func() {
+		cancel(context.DeadlineExceeded)
+	})
+	c.readTimer = t
+	c.readCancel = func() {
+		t.Stop()
+		cancel(context.Canceled)
+	}
+	c.resetReadTimer()
+	return ctx, c.readCancel
+}
+
+func (c *Conn) writeContext() (context.Context, context.CancelFunc) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return deadlineContext(c.writeDeadline)
+}
+
+// readErr returns the error of a handler in place of err.
+func (c *Conn) readErr(err error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.handlerErr != nil {
+		return c.handlerErr
+	}
+	return err
+}
+
+// NextReader returns the type of the next data message and a reader for it.
+// The reader is valid until the next call to NextReader or ReadMessage.
+func (c *Conn) NextReader() (messageType int, r io.Reader, err error) {
+	ctx, cancel := c.readContext()
+	typ, r, err := c.c.Reader(ctx)
+	if err != nil {
+		cancel()
+		return 0, nil, c.readErr(err)
+	}
+	return int(typ), r, nil
+}
+
+// ReadMessage reads the next data message.
+func (c *Conn) ReadMessage() (messageType int, p []byte, err error) {
+	ctx, cancel := c.readContext()
+	defer cancel()
+	typ, p, err := c.c.Read(ctx)
+	if err != nil {
+		return 0, nil, c.readErr(err)
+	}
+	return int(typ), p, nil
+}
+
+// ReadJSON reads the next message and unmarshals it as JSON into v.
+func (c *Conn) ReadJSON(v any) error {
+	ctx, cancel := c.readContext()
+	defer cancel()
+	err := wsjson.Read(ctx, c.c, v)
+	if err != nil {
+		return c.readErr(err)
+	}
+	return nil
+}
+
+func dataMessageType(messageType int) (websocket.MessageType, error) {
+	switch messageType {
+	case TextMessage:
+		return websocket.MessageText, nil
+	case BinaryMessage:
+		return websocket.MessageBinary, nil
+	default:
+		return 0, fmt.Errorf("unexpected data message type %d", messageType)
+	}
+}
+
+// NextWriter returns a writer for a message of messageType, which must be
+// TextMessage or BinaryMessage. The message is sent when the writer is
+// closed.
+func (c *Conn) NextWriter(messageType int) (io.WriteCloser, error) {
+	typ, err := dataMessageType(messageType)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := c.writeContext()
+	w, err := c.c.Writer(ctx, typ)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return &writer{WriteCloser: w, cancel: cancel}, nil
+}
+
+type writer struct {
+	io.WriteCloser
+	cancel context.CancelFunc
+}
+
+func (w *writer) Close() error {
+	defer w.cancel()
+	return w.WriteCloser.Close()
+}
+
+// WriteMessage writes a message of messageType. Control messages are
+// written with WriteControl and the write deadline.
+func (c *Conn) WriteMessage(messageType int, data []byte) error {
+	switch messageType {
+	case CloseMessage, PingMessage, PongMessage:
+		c.mu.Lock()
+		deadline := c.writeDeadline
+		c.mu.Unlock()
+		return c.WriteControl(messageType, data, deadline)
+	}
+
+	typ, err := dataMessageType(messageType)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := c.writeContext()
+	defer cancel()
+	return c.c.Write(ctx, typ, data)
+}
+
+// WriteJSON writes v as a JSON text message.
+func (c *Conn) WriteJSON(v any) error {
+	ctx, cancel := c.writeContext()
+	defer cancel()
+	return wsjson.Write(ctx, c.c, v)
+}
+
+// WriteControl writes a control message with the given deadline. A zero
+// deadline means no deadline.
+//
+// A close message, e.g. from FormatCloseMessage, starts the close handshake.
+// Further data writes fail and reads return the peer's close frame as a
+// close error. A ping message waits for the peer's pong so a reader must
+// be running concurrently.
+func (c *Conn) WriteControl(messageType int, data []byte, deadline time.Time) error {
+	ctx, cancel := deadlineContext(deadline)
+	defer cancel()
+
+	switch messageType {
+	case CloseMessage:
+		code, reason, err := parseCloseMessage(data)
+		if err != nil {
+			return err
+		}
+		return c.c.WriteCloseFrame(ctx, code, reason)
+	case PingMessage:
+		return c.c.PingWithPayload(ctx, data)
+	case PongMessage:
+		return c.c.Pong(ctx, data)
+	default:
+		return fmt.Errorf("unexpected control message type %d", messageType)
+	}
+}
+
+func parseCloseMessage(p []byte) (websocket.StatusCode, string, error) {
+	switch {
+	case len(p) == 0:
+		return websocket.StatusNoStatusRcvd, "", nil
+	case len(p) < 2:
+		return 0, "", errors.New("close message payload must be empty or at least 2 bytes")
+	case !utf8.Valid(p[2:]):
+		return 0, "", errors.New("close message reason must be UTF-8")
+	}
+	return websocket.StatusCode(binary.BigEndian.Uint16(p)), string(p[2:]), nil
+}
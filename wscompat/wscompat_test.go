@@ -0,0 +1,188 @@
+//go:build !js
+
+package wscompat_test
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket/internal/test/assert"
+	"github.com/coder/websocket/internal/xsync"
+	"github.com/coder/websocket/wscompat"
+)
+
+func echo(t *testing.T, upgrader *wscompat.Upgrader) string {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := upgrader.Upgrade(w, r, http.Header{"X-Echo": {"true"}})
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		for {
+			typ, r, err := c.NextReader()
+			if err != nil {
+				return
+			}
+			w, err := c.NextWriter(typ)
+			if err != nil {
+				return
+			}
+			_, err = io.Copy(w, r)
+			if err != nil {
+				return
+			}
+			err = w.Close()
+			if err != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(s.Close)
+	return strings.Replace(s.URL, "http", "ws", 1)
+}
+
+func TestConn(t *testing.T) {
+	t.Parallel()
+
+	u := echo(t, &wscompat.Upgrader{EnableCompression: true})
+
+	c, resp, err := wscompat.DefaultDialer.Dial(u, nil)
+	assert.Success(t, err)
+	defer c.Close()
+	assert.Equal(t, "response header", "true", resp.Header.Get("X-Echo"))
+
+	err = c.WriteMessage(wscompat.TextMessage, []byte("hello"))
+	assert.Success(t, err)
+	typ, p, err := c.ReadMessage()
+	assert.Success(t, err)
+	assert.Equal(t, "type", wscompat.TextMessage, typ)
+	assert.Equal(t, "message", "hello", string(p))
+
+	err = c.WriteJSON(map[string]int{"a": 1})
+	assert.Success(t, err)
+	var v map[string]int
+	err = c.ReadJSON(&v)
+	assert.Success(t, err)
+	assert.Equal(t, "json", map[string]int{"a": 1}, v)
+
+	pongs := make(chan string, 1)
+	c.SetPongHandler(func(appData string) error {
+		pongs <- appData
+		return nil
+	})
+	readErrs := xsync.Go(func() error {
+		_, _, err := c.ReadMessage()
+		return err
+	})
+	err = c.WriteControl(wscompat.PingMessage, []byte("ping"), time.Now().Add(time.Second*5))
+	assert.Success(t, err)
+	assert.Equal(t, "pong", "ping", <-pongs)
+
+	err = c.WriteControl(wscompat.CloseMessage, wscompat.FormatCloseMessage(wscompat.CloseNormalClosure, "bye"), time.Time{})
+	assert.Success(t, err)
+	err = <-readErrs
+	assert.Equal(t, "close error", true, wscompat.IsCloseError(err, wscompat.CloseNormalClosure))
+	assert.Equal(t, "unexpected close error", false, wscompat.IsUnexpectedCloseError(err, wscompat.CloseNormalClosure))
+}
+
+func TestConnHandlerError(t *testing.T) {
+	t.Parallel()
+
+	u := echo(t, &wscompat.Upgrader{})
+
+	c, _, err := wscompat.DefaultDialer.Dial(u, nil)
+	assert.Success(t, err)
+	defer c.Close()
+
+	errPong := errors.New("pong")
+	c.SetPongHandler(func(string) error {
+		return errPong
+	})
+	readErrs := xsync.Go(func() error {
+		_, _, err := c.ReadMessage()
+		return err
+	})
+	c.WriteControl(wscompat.PingMessage, nil, time.Now().Add(time.Second*5))
+	assert.ErrorIs(t, errPong, <-readErrs)
+}
+
+func TestConnReadDeadline(t *testing.T) {
+	t.Parallel()
+
+	u := echo(t, &wscompat.Upgrader{})
+
+	c, _, err := wscompat.DefaultDialer.Dial(u, nil)
+	assert.Success(t, err)
+	defer c.Close()
+
+	err = c.SetReadDeadline(time.Now().Add(time.Millisecond * 50))
+	assert.Success(t, err)
+	_, _, err = c.ReadMessage()
+	assert.Error(t, err)
+
+	err = c.WriteMessage(wscompat.TextMessage, []byte("hello"))
+	assert.Error(t, err)
+}
+
+func TestConnReadDeadlinePong(t *testing.T) {
+	t.Parallel()
+
+	u := echo(t, &wscompat.Upgrader{})
+
+	c, _, err := wscompat.DefaultDialer.Dial(u, nil)
+	assert.Success(t, err)
+	defer c.Close()
+
+	// The keepalive suggested by gorilla's documentation.
+	const pongWait = time.Millisecond * 200
+	c.SetReadDeadline(time.Now().Add(pongWait))
+	c.SetPongHandler(func(string) error {
+		c.SetReadDeadline(time.Now().Add(pongWait))
+		return nil
+	})
+	type result struct {
+		p   []byte
+		err error
+	}
+	results := make(chan result, 1)
+	go func() {
+		_, p, err := c.ReadMessage()
+		results <- result{p, err}
+	}()
+
+	// Outlive pongWait several times with only pongs received.
+	for range 10 {
+		err = c.WriteControl(wscompat.PingMessage, nil, time.Now().Add(time.Second*5))
+		assert.Success(t, err)
+		time.Sleep(pongWait / 4)
+	}
+
+	err = c.WriteMessage(wscompat.TextMessage, []byte("hello"))
+	assert.Success(t, err)
+	r := <-results
+	assert.Success(t, r.err)
+	assert.Equal(t, "message", "hello", string(r.p))
+}
+
+func TestUpgraderCheckOrigin(t *testing.T) {
+	t.Parallel()
+
+	u := echo(t, &wscompat.Upgrader{
+		CheckOrigin: func(r *http.Request) bool {
+			return r.Header.Get("Origin") == "https://example.com"
+		},
+	})
+
+	c, _, err := wscompat.DefaultDialer.Dial(u, http.Header{"Origin": {"https://example.com"}})
+	assert.Success(t, err)
+	c.Close()
+
+	_, resp, err := wscompat.DefaultDialer.Dial(u, http.Header{"Origin": {"https://evil.com"}})
+	assert.Error(t, err)
+	assert.Equal(t, "status code", http.StatusForbidden, resp.StatusCode)
+}
@@ -0,0 +1,274 @@
+// Package wsgraphql serves GraphQL operations over the graphql-transport-ws
+// subprotocol.
+//
+// It implements the message lifecycle of the protocol and leaves executing
+// operations to Options.Execute so it works with any GraphQL implementation.
+//
+// See https://github.com/enisdenjo/graphql-ws/blob/master/PROTOCOL.md
+package wsgraphql // import "github.com/coder/websocket/wsgraphql"
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/coder/websocket"
+)
+
+// Subprotocol is the name of the subprotocol. Pass it in
+// websocket.AcceptOptions.Subprotocols.
+const Subprotocol = "graphql-transport-ws"
+
+// Close codes defined by the protocol.
+const (
+	StatusBadRequest                websocket.StatusCode = 4400
+	StatusUnauthorized              websocket.StatusCode = 4401
+	StatusForbidden                 websocket.StatusCode = 4403
+	StatusSubprotocolNotAcceptable  websocket.StatusCode = 4406
+	StatusConnectionInitTimeout     websocket.StatusCode = 4408
+	StatusSubscriberAlreadyExists   websocket.StatusCode = 4409
+	StatusTooManyInitialiseRequests websocket.StatusCode = 4429
+)
+
+// Request is the payload of a subscribe message.
+type Request struct {
+	OperationName string          `json:"operationName,omitempty"`
+	Query         string          `json:"query"`
+	Variables     json.RawMessage `json:"variables,omitempty"`
+	Extensions    json.RawMessage `json:"extensions,omitempty"`
+}
+
+// Options configures Serve.
+type Options struct {
+	// OnConnect is called with the payload of the connection_init message.
+	// The returned value, if not nil, is sent as the payload of connection_ack.
+	// Returning an error closes the connection with StatusForbidden.
+	OnConnect func(ctx context.Context, payload json.RawMessage) (any, error)
+
+	// Execute runs an operation, calling send with each result to deliver
+	// it in a next message. Queries and mutations send once and subscriptions
+	// until ctx is canceled, which happens when the client completes the
+	// operation or the connection closes.
+	//
+	// Returning nil completes the operation. Returning an error sends it to
+	// the client in an error message.
+	//
+	// Execute is required and runs in its own goroutine per operation.
+	Execute func(ctx context.Context, r *Request, send func(result any) error) error
+
+	// ConnectionInitTimeout bounds how long the client may take to send
+	// connection_init. Defaults to 3s.
+	ConnectionInitTimeout time.Duration
+}
+
+type message struct {
+	ID      string          `json:"id,omitempty"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// Serve runs the protocol on c until the connection closes or ctx expires.
+// It closes c on protocol violations.
+//
+// Serve waits for running operations to return before it returns.
+func Serve(ctx context.Context, c *websocket.Conn, opts *Options) error {
+	if opts == nil || opts.Execute == nil {
+		return errors.New("wsgraphql: Options.Execute is required")
+	}
+	if c.Subprotocol() != Subprotocol {
+		c.Close(StatusSubprotocolNotAcceptable, "Subprotocol not acceptable")
+		return fmt.Errorf("wsgraphql: unexpected subprotocol %q", c.Subprotocol())
+	}
+
+	timeout := opts.ConnectionInitTimeout
+	if timeout <= 0 {
+		timeout = time.Second * 3
+	}
+
+	s := &server{
+		c:    c,
+		opts: opts,
+		ops:  make(map[string]context.CancelFunc),
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer func() {
+		cancel()
+		s.wg.Wait()
+	}()
+
+	t := time.AfterFunc(timeout, func() {
+		if !s.isAcked() {
+			c.Close(StatusConnectionInitTimeout, "Connection initialisation timeout")
+		}
+	})
+	defer t.Stop()
+
+	for {
+		_, p, err := c.Read(ctx)
+		if err != nil {
+			return err
+		}
+		var m message
+		err = json.Unmarshal(p, &m)
+		if err != nil {
+			c.Close(StatusBadRequest, "Invalid message received")
+			return fmt.Errorf("failed to unmarshal message: %w", err)
+		}
+		err = s.handle(ctx, m)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+type server struct {
+	c    *websocket.Conn
+	opts *Options
+	wg   sync.WaitGroup
+
+	mu    sync.Mutex
+	inits int
+	acked bool
+	ops   map[string]context.CancelFunc
+}
+
+func (s *server) isAcked() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.acked
+}
+
+func (s *server) handle(ctx context.Context, m message) error {
+	switch m.Type {
+	case "connection_init":
+		return s.connectionInit(ctx, m)
+	case "ping":
+		return s.write(ctx, message{Type: "pong"})
+	case "pong":
+		return nil
+	case "subscribe":
+		return s.subscribe(ctx, m)
+	case "complete":
+		s.mu.Lock()
+		cancel := s.ops[m.ID]
+		delete(s.ops, m.ID)
+		s.mu.Unlock()
+		if cancel != nil {
+			cancel()
+		}
+		return nil
+	default:
+		s.c.Close(StatusBadRequest, "Invalid message received")
+		return fmt.Errorf("unexpected message type %q", m.Type)
+	}
+}
+
+func (s *server) connectionInit(ctx context.Context, m message) error {
+	s.mu.Lock()
+	s.inits++
+	inits := s.inits
+	s.mu.Unlock()
+	if inits > 1 {
+		s.c.Close(StatusTooManyInitialiseRequests, "Too many initialisation requests")
+		return errors.New("received connection_init more than once")
+	}
+
+	var payload any
+	if s.opts.OnConnect != nil {
+		var err error
+		payload, err = s.opts.OnConnect(ctx, m.Payload)
+		if err != nil {
+			s.c.Close(StatusForbidden, "Forbidden")
+			return fmt.Errorf("failed to connect: %w", err)
+		}
+	}
+
+	ack := message{Type: "connection_ack"}
+	if payload != nil {
+		p, err := json.Marshal(payload)
+		if err != nil {
+			return fmt.Errorf("failed to marshal connection_ack payload: %w", err)
+		}
+		ack.Payload = p
+	}
+
+	s.mu.Lock()
+	s.acked = true
+	s.mu.Unlock()
+	return s.write(ctx, ack)
+}
+
+func (s *server) subscribe(ctx context.Context, m message) error {
+	var r Request
+	err := json.Unmarshal(m.Payload, &r)
+	if err == nil && m.ID == "" {
+		err = errors.New("missing id")
+	}
+	if err != nil {
+		s.c.Close(StatusBadRequest, "Invalid message received")
+		return fmt.Errorf("invalid subscribe message: %w", err)
+	}
+
+	s.mu.Lock()
+	if !s.acked {
+		s.mu.Unlock()
+		s.c.Close(StatusUnauthorized, "Unauthorized")
+		return errors.New("received subscribe before connection_ack")
+	}
+	if _, ok := s.ops[m.ID]; ok {
+		s.mu.Unlock()
+		s.c.Close(StatusSubscriberAlreadyExists, fmt.Sprintf("Subscriber for %v already exists", m.ID))
+		return fmt.Errorf("received duplicate subscribe for %q", m.ID)
+	}
+	opCtx, cancel := context.WithCancel(ctx)
+	s.ops[m.ID] = cancel
+	s.mu.Unlock()
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer cancel()
+
+		err := s.opts.Execute(opCtx, &r, func(result any) error {
+			p, err := json.Marshal(result)
+			if err != nil {
+				return fmt.Errorf("failed to marshal result: %w", err)
+			}
+			// Writing with opCtx would close the connection if the
+			// operation was completed mid write.
+			if opCtx.Err() != nil {
+				return opCtx.Err()
+			}
+			return s.write(ctx, message{ID: m.ID, Type: "next", Payload: p})
+		})
+
+		s.mu.Lock()
+		_, active := s.ops[m.ID]
+		delete(s.ops, m.ID)
+		s.mu.Unlock()
+		if !active || ctx.Err() != nil {
+			// Completed by the client or the connection is gone.
+			return
+		}
+
+		if err != nil {
+			p, _ := json.Marshal([]map[string]string{{"message": err.Error()}})
+			s.write(ctx, message{ID: m.ID, Type: "error", Payload: p})
+			return
+		}
+		s.write(ctx, message{ID: m.ID, Type: "complete"})
+	}()
+	return nil
+}
+
+func (s *server) write(ctx context.Context, m message) error {
+	p, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("failed to marshal message: %w", err)
+	}
+	return s.c.Write(ctx, websocket.MessageText, p)
+}
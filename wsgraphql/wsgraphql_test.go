@@ -0,0 +1,137 @@
+//go:build !js
+
+package wsgraphql_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/internal/test/assert"
+	"github.com/coder/websocket/internal/test/wstest"
+	"github.com/coder/websocket/internal/xsync"
+	"github.com/coder/websocket/wsgraphql"
+	"github.com/coder/websocket/wsjson"
+)
+
+type message struct {
+	ID      string          `json:"id,omitempty"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+func TestServe(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	c1, c2 := wstest.Pipe(&websocket.DialOptions{
+		Subprotocols: []string{wsgraphql.Subprotocol},
+	}, &websocket.AcceptOptions{
+		Subprotocols: []string{wsgraphql.Subprotocol},
+	})
+	defer c2.CloseNow()
+	defer c1.CloseNow()
+
+	completed := make(chan struct{})
+	errs := xsync.Go(func() error {
+		return wsgraphql.Serve(ctx, c2, &wsgraphql.Options{
+			OnConnect: func(ctx context.Context, payload json.RawMessage) (any, error) {
+				if string(payload) != `{"token":"secret"}` {
+					return nil, errors.New("bad token")
+				}
+				return map[string]bool{"ok": true}, nil
+			},
+			Execute: func(ctx context.Context, r *wsgraphql.Request, send func(any) error) error {
+				switch r.Query {
+				case "query":
+					return send(map[string]any{"data": 1})
+				case "fail":
+					return errors.New("boom")
+				}
+				// A subscription that runs until completed by the client.
+				err := send(map[string]any{"data": 2})
+				if err != nil {
+					return err
+				}
+				<-ctx.Done()
+				close(completed)
+				return nil
+			},
+		})
+	})
+
+	write := func(m message) {
+		t.Helper()
+		assert.Success(t, wsjson.Write(ctx, c1, m))
+	}
+	read := func() message {
+		t.Helper()
+		var m message
+		assert.Success(t, wsjson.Read(ctx, c1, &m))
+		return m
+	}
+
+	write(message{Type: "connection_init", Payload: json.RawMessage(`{"token":"secret"}`)})
+	m := read()
+	assert.Equal(t, "type", "connection_ack", m.Type)
+	assert.Equal(t, "payload", `{"ok":true}`, string(m.Payload))
+
+	write(message{Type: "ping"})
+	assert.Equal(t, "type", "pong", read().Type)
+
+	write(message{ID: "1", Type: "subscribe", Payload: json.RawMessage(`{"query":"query"}`)})
+	m = read()
+	assert.Equal(t, "next", message{ID: "1", Type: "next", Payload: json.RawMessage(`{"data":1}`)}, m)
+	assert.Equal(t, "complete", message{ID: "1", Type: "complete"}, read())
+
+	write(message{ID: "2", Type: "subscribe", Payload: json.RawMessage(`{"query":"fail"}`)})
+	assert.Equal(t, "error", message{ID: "2", Type: "error", Payload: json.RawMessage(`[{"message":"boom"}]`)}, read())
+
+	write(message{ID: "3", Type: "subscribe", Payload: json.RawMessage(`{"query":"subscription"}`)})
+	assert.Equal(t, "next", message{ID: "3", Type: "next", Payload: json.RawMessage(`{"data":2}`)}, read())
+	write(message{ID: "3", Type: "complete"})
+	select {
+	case <-completed:
+	case <-ctx.Done():
+		t.Fatal(ctx.Err())
+	}
+
+	write(message{Type: "connection_init"})
+	_, _, err := c1.Read(ctx)
+	assert.Equal(t, "close status", wsgraphql.StatusTooManyInitialiseRequests, websocket.CloseStatus(err))
+	assert.Error(t, <-errs)
+}
+
+func TestServeUnauthorized(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	c1, c2 := wstest.Pipe(&websocket.DialOptions{
+		Subprotocols: []string{wsgraphql.Subprotocol},
+	}, &websocket.AcceptOptions{
+		Subprotocols: []string{wsgraphql.Subprotocol},
+	})
+	defer c2.CloseNow()
+	defer c1.CloseNow()
+
+	errs := xsync.Go(func() error {
+		return wsgraphql.Serve(ctx, c2, &wsgraphql.Options{
+			Execute: func(ctx context.Context, r *wsgraphql.Request, send func(any) error) error {
+				return nil
+			},
+		})
+	})
+
+	err := wsjson.Write(ctx, c1, message{ID: "1", Type: "subscribe", Payload: json.RawMessage(`{"query":"query"}`)})
+	assert.Success(t, err)
+	_, _, err = c1.Read(ctx)
+	assert.Equal(t, "close status", wsgraphql.StatusUnauthorized, websocket.CloseStatus(err))
+	assert.Error(t, <-errs)
+}
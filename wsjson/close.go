@@ -0,0 +1,46 @@
+package wsjson
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// MaxCloseReason is the maximum length of the reason of a close frame, as
+// its payload is limited to 125 bytes including the 2 byte status code.
+const MaxCloseReason = 123
+
+// CloseReason is a structured close reason, encoded as JSON in the reason of
+// a close frame for APIs that need more than a status code to explain why a
+// connection was closed.
+type CloseReason struct {
+	// Code is an application specific error code, e.g. "rate_limited".
+	Code string `json:"code,omitempty"`
+	// Message is a human readable description.
+	Message string `json:"message,omitempty"`
+	// RetryAfter is the number of seconds to wait before reconnecting.
+	RetryAfter int `json:"retryAfter,omitempty"`
+}
+
+// EncodeCloseReason encodes r as a close reason to pass to Conn.Close.
+// An error is returned if it exceeds MaxCloseReason bytes.
+func EncodeCloseReason(r CloseReason) (string, error) {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal close reason: %w", err)
+	}
+	if len(b) > MaxCloseReason {
+		return "", fmt.Errorf("close reason is %d bytes but must be at most %d: %s", len(b), MaxCloseReason, b)
+	}
+	return string(b), nil
+}
+
+// DecodeCloseReason decodes a close reason encoded by EncodeCloseReason,
+// e.g. the Reason of a websocket.CloseError.
+func DecodeCloseReason(reason string) (CloseReason, error) {
+	var r CloseReason
+	err := json.Unmarshal([]byte(reason), &r)
+	if err != nil {
+		return CloseReason{}, fmt.Errorf("failed to unmarshal close reason: %w", err)
+	}
+	return r, nil
+}
@@ -0,0 +1,148 @@
+package wsjson
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/coder/websocket"
+)
+
+// ErrUnknownType is returned for messages whose type has no handler in a
+// Router.
+var ErrUnknownType = errors.New("no handler for message type")
+
+// Envelope is the JSON message dispatched by Router, e.g.
+// {"type": "chat", "data": {"text": "hi"}}.
+type Envelope struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+// HandlerFunc handles the data of a message routed by Router.
+type HandlerFunc func(ctx context.Context, data json.RawMessage) error
+
+// Router reads Envelope messages and dispatches their data to the handler
+// registered for their type. The zero value is ready to use and a Router
+// may serve any number of connections concurrently.
+type Router struct {
+	// Concurrency is the number of handlers that may run concurrently for
+	// a connection. If zero, handlers run one at a time in the order of
+	// the messages.
+	Concurrency int
+
+	// OnError is called with the errors of handlers, panics recovered from
+	// handlers and errors wrapping ErrUnknownType. If nil, the first error
+	// closes the connection and Serve returns it.
+	OnError func(ctx context.Context, typ string, err error)
+
+	mu       sync.Mutex
+	handlers map[string]HandlerFunc
+}
+
+// Handle registers h as the handler of messages of type typ.
+func (r *Router) Handle(typ string, h HandlerFunc) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.handlers == nil {
+		r.handlers = make(map[string]HandlerFunc)
+	}
+	r.handlers[typ] = h
+}
+
+// Serve reads messages from c and dispatches them until reading fails, ctx
+// expires or, without OnError, a handler fails. Handlers are passed ctx and
+// Serve waits for them before returning.
+func (r *Router) Serve(ctx context.Context, c *websocket.Conn) error {
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	defer cancel()
+
+	var sem chan struct{}
+	if r.Concurrency > 0 {
+		sem = make(chan struct{}, r.Concurrency)
+	}
+
+	var errMu sync.Mutex
+	var handlerErr error
+	fail := func(typ string, err error) {
+		if r.OnError != nil {
+			r.OnError(ctx, typ, err)
+			return
+		}
+		errMu.Lock()
+		// Handlers returning as Serve cancels them do not hide its error.
+		first := handlerErr == nil && (ctx.Err() == nil || !errors.Is(err, context.Canceled))
+		if first {
+			handlerErr = err
+		}
+		errMu.Unlock()
+		if !first {
+			return
+		}
+		if errors.Is(err, ErrUnknownType) {
+			c.Close(websocket.StatusUnsupportedData, "unknown message type")
+		} else {
+			c.Close(websocket.StatusInternalError, "failed to handle message")
+		}
+	}
+
+	for {
+		var env Envelope
+		err := Read(ctx, c, &env)
+		if err != nil {
+			// A handler may be failing concurrently, e.g. the one closing c.
+			cancel()
+			wg.Wait()
+			errMu.Lock()
+			defer errMu.Unlock()
+			if handlerErr != nil {
+				return handlerErr
+			}
+			return err
+		}
+
+		if sem == nil {
+			err = r.call(ctx, env)
+			if err != nil {
+				fail(env.Type, err)
+			}
+			continue
+		}
+
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			err := r.call(ctx, env)
+			if err != nil {
+				fail(env.Type, err)
+			}
+		}()
+	}
+}
+
+// call runs the handler of env and returns a recovered panic as an error.
+func (r *Router) call(ctx context.Context, env Envelope) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("handler for %q panicked: %v", env.Type, p)
+		}
+	}()
+
+	r.mu.Lock()
+	h := r.handlers[env.Type]
+	r.mu.Unlock()
+	if h == nil {
+		return fmt.Errorf("%w %q", ErrUnknownType, env.Type)
+	}
+	return h(ctx, env.Data)
+}
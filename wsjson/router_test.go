@@ -0,0 +1,132 @@
+//go:build !js
+
+package wsjson_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/internal/test/assert"
+	"github.com/coder/websocket/internal/test/wstest"
+	"github.com/coder/websocket/internal/xsync"
+	"github.com/coder/websocket/wsjson"
+)
+
+func TestRouter(t *testing.T) {
+	t.Parallel()
+
+	for name, concurrency := range map[string]int{
+		"sequential": 0,
+		"concurrent": 4,
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+			defer cancel()
+
+			c1, c2 := wstest.Pipe(nil, nil)
+			defer c2.CloseNow()
+			defer c1.CloseNow()
+
+			texts := make(chan string, 1)
+			errs := make(chan error, 2)
+			var r wsjson.Router
+			r.Concurrency = concurrency
+			r.OnError = func(ctx context.Context, typ string, err error) {
+				errs <- err
+			}
+			r.Handle("chat", func(ctx context.Context, data json.RawMessage) error {
+				var msg struct{ Text string }
+				err := json.Unmarshal(data, &msg)
+				if err != nil {
+					return err
+				}
+				texts <- msg.Text
+				return nil
+			})
+			r.Handle("panic", func(ctx context.Context, data json.RawMessage) error {
+				panic("boom")
+			})
+			serveErr := xsync.Go(func() error {
+				return r.Serve(ctx, c2)
+			})
+
+			err := wsjson.Write(ctx, c1, wsjson.Envelope{Type: "chat", Data: json.RawMessage(`{"text":"hi"}`)})
+			assert.Success(t, err)
+			assert.Equal(t, "text", "hi", <-texts)
+
+			err = wsjson.Write(ctx, c1, wsjson.Envelope{Type: "panic"})
+			assert.Success(t, err)
+			assert.Contains(t, <-errs, `handler for "panic" panicked: boom`)
+
+			err = wsjson.Write(ctx, c1, wsjson.Envelope{Type: "unknown"})
+			assert.Success(t, err)
+			assert.ErrorIs(t, wsjson.ErrUnknownType, <-errs)
+
+			err = c1.Close(websocket.StatusNormalClosure, "")
+			assert.Success(t, err)
+			assert.Equal(t, "close status", websocket.StatusNormalClosure, websocket.CloseStatus(<-serveErr))
+		})
+	}
+
+	t.Run("handlerError", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+
+		c1, c2 := wstest.Pipe(nil, nil)
+		defer c2.CloseNow()
+		defer c1.CloseNow()
+
+		errFail := errors.New("fail")
+		var r wsjson.Router
+		r.Handle("fail", func(ctx context.Context, data json.RawMessage) error {
+			return errFail
+		})
+		serveErr := xsync.Go(func() error {
+			return r.Serve(ctx, c2)
+		})
+
+		err := wsjson.Write(ctx, c1, wsjson.Envelope{Type: "fail"})
+		assert.Success(t, err)
+		_, _, err = c1.Read(ctx)
+		assert.Equal(t, "close status", websocket.StatusInternalError, websocket.CloseStatus(err))
+		assert.ErrorIs(t, errFail, <-serveErr)
+	})
+
+	t.Run("concurrentHandlerError", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+
+		c1, c2 := wstest.Pipe(nil, nil)
+		defer c2.CloseNow()
+		defer c1.CloseNow()
+
+		errFail := errors.New("fail")
+		started := make(chan struct{})
+		r := wsjson.Router{Concurrency: 1}
+		r.Handle("fail", func(ctx context.Context, data json.RawMessage) error {
+			close(started)
+			// Fails only once the read failed.
+			<-ctx.Done()
+			return errFail
+		})
+		serveErr := xsync.Go(func() error {
+			return r.Serve(ctx, c2)
+		})
+
+		err := wsjson.Write(ctx, c1, wsjson.Envelope{Type: "fail"})
+		assert.Success(t, err)
+		<-started
+		c1.CloseNow()
+		assert.ErrorIs(t, errFail, <-serveErr)
+	})
+}
@@ -1,10 +1,19 @@
-// Package wsjson provides helpers for reading and writing JSON messages.
+// Package wsjson provides helpers for reading and writing JSON messages,
+// routing them by type with Router and structured close reasons.
+//
+// JSON is written as text messages by default, which peers may require to
+// be valid UTF-8. encoding/json only guarantees it for values it encodes
+// itself so pass ValidateUTF8 to WriteOpts when writing json.RawMessage or
+// types implementing json.Marshaler, or use WriteBinary and ReadBinary.
 package wsjson // import "github.com/coder/websocket/wsjson"
 
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"unicode/utf8"
 
 	"github.com/coder/websocket"
 	"github.com/coder/websocket/internal/bpool"
@@ -12,22 +21,84 @@ import (
 	"github.com/coder/websocket/internal/util"
 )
 
+// ErrInvalidUTF8 is returned by WriteOpts with ValidateUTF8 when the encoded
+// JSON is not valid UTF-8. The message is not written.
+var ErrInvalidUTF8 = errors.New("JSON is not valid UTF-8")
+
+// WriteOption is an option of WriteOpts.
+type WriteOption int
+
+const (
+	// ValidateUTF8 validates that the encoded JSON is valid UTF-8 before
+	// writing it as a text message so that peers validating text messages
+	// do not fail the connection with StatusInvalidFramePayloadData.
+	ValidateUTF8 WriteOption = iota + 1
+)
+
+// ReadOptions represents the options of ReadWithOptions.
+type ReadOptions struct {
+	// MaxSize limits the message to MaxSize bytes instead of the read limit
+	// of the connection, e.g. to accept a large document without raising
+	// the limit of all messages with Conn.SetReadLimit. Set to -1 to
+	// disable the limit. The zero value uses the limit of the connection.
+	// See Conn.ReaderWithLimit.
+	MaxSize int64
+
+	// BufferSize is the expected size of the message. The buffer the
+	// message is read into grows to it upfront to avoid growing it
+	// repeatedly while reading a large message.
+	BufferSize int
+
+	// Binary requires a binary message like ReadBinary.
+	Binary bool
+}
+
 // Read reads a JSON message from c into v.
 // It will reuse buffers in between calls to avoid allocations.
 func Read(ctx context.Context, c *websocket.Conn, v any) error {
-	return read(ctx, c, v)
+	return read(ctx, c, v, &ReadOptions{})
+}
+
+// ReadBinary is like Read but requires a binary message, e.g. one written
+// with WriteBinary. If the message is a text message, the connection is
+// closed with StatusUnsupportedData.
+func ReadBinary(ctx context.Context, c *websocket.Conn, v any) error {
+	return read(ctx, c, v, &ReadOptions{Binary: true})
+}
+
+// ReadWithOptions is like Read but with options. A nil opts is the same
+// as Read.
+func ReadWithOptions(ctx context.Context, c *websocket.Conn, v any, opts *ReadOptions) error {
+	if opts == nil {
+		opts = &ReadOptions{}
+	}
+	return read(ctx, c, v, opts)
 }
 
-func read(ctx context.Context, c *websocket.Conn, v any) (err error) {
+func read(ctx context.Context, c *websocket.Conn, v any, opts *ReadOptions) (err error) {
 	defer errd.Wrap(&err, "failed to read JSON message")
 
-	_, r, err := c.Reader(ctx)
+	var typ websocket.MessageType
+	var r io.Reader
+	if opts.MaxSize != 0 {
+		typ, r, err = c.ReaderWithLimit(ctx, opts.MaxSize)
+	} else {
+		typ, r, err = c.Reader(ctx)
+	}
 	if err != nil {
 		return err
 	}
 
+	if opts.Binary && typ != websocket.MessageBinary {
+		c.Close(websocket.StatusUnsupportedData, "expected binary message")
+		return fmt.Errorf("expected binary message but got %v", typ)
+	}
+
 	b := bpool.Get()
 	defer bpool.Put(b)
+	if opts.BufferSize > 0 {
+		b.Grow(opts.BufferSize)
+	}
 
 	_, err = b.ReadFrom(r)
 	if err != nil {
@@ -46,21 +117,47 @@ func read(ctx context.Context, c *websocket.Conn, v any) (err error) {
 // Write writes the JSON message v to c.
 // It will reuse buffers in between calls to avoid allocations.
 func Write(ctx context.Context, c *websocket.Conn, v any) error {
-	return write(ctx, c, v)
+	return write(ctx, c, websocket.MessageText, v, false)
+}
+
+// WriteOpts is like Write but with options.
+func WriteOpts(ctx context.Context, c *websocket.Conn, v any, opts ...WriteOption) error {
+	validateUTF8 := false
+	for _, opt := range opts {
+		if opt == ValidateUTF8 {
+			validateUTF8 = true
+		}
+	}
+	return write(ctx, c, websocket.MessageText, v, validateUTF8)
+}
+
+// WriteBinary is like Write but writes a binary message, e.g. for peers that
+// reject text messages or JSON that may not be valid UTF-8.
+// Read it with ReadBinary or Read.
+func WriteBinary(ctx context.Context, c *websocket.Conn, v any) error {
+	return write(ctx, c, websocket.MessageBinary, v, false)
 }
 
-func write(ctx context.Context, c *websocket.Conn, v any) (err error) {
+func write(ctx context.Context, c *websocket.Conn, typ websocket.MessageType, v any, validateUTF8 bool) (err error) {
 	defer errd.Wrap(&err, "failed to write JSON message")
 
 	// json.Marshal cannot reuse buffers between calls as it has to return
 	// a copy of the byte slice but Encoder does as it directly writes to w.
+	var writeErr error
 	err = json.NewEncoder(util.WriterFunc(func(p []byte) (int, error) {
-		err := c.Write(ctx, websocket.MessageText, p)
-		if err != nil {
-			return 0, err
+		if validateUTF8 && !utf8.Valid(p) {
+			writeErr = ErrInvalidUTF8
+		} else {
+			writeErr = c.Write(ctx, typ, p)
+		}
+		if writeErr != nil {
+			return 0, writeErr
 		}
 		return len(p), nil
 	})).Encode(v)
+	if writeErr != nil {
+		return writeErr
+	}
 	if err != nil {
 		return fmt.Errorf("failed to marshal JSON: %w", err)
 	}
@@ -4,11 +4,37 @@ import (
 	"encoding/json"
 	"io"
 	"strconv"
+	"strings"
 	"testing"
 
+	"github.com/coder/websocket/internal/test/assert"
 	"github.com/coder/websocket/internal/test/xrand"
+	"github.com/coder/websocket/wsjson"
 )
 
+func TestCloseReason(t *testing.T) {
+	t.Parallel()
+
+	exp := wsjson.CloseReason{
+		Code:       "rate_limited",
+		Message:    "too many messages",
+		RetryAfter: 30,
+	}
+	reason, err := wsjson.EncodeCloseReason(exp)
+	assert.Success(t, err)
+	assert.Equal(t, "reason", `{"code":"rate_limited","message":"too many messages","retryAfter":30}`, reason)
+
+	act, err := wsjson.DecodeCloseReason(reason)
+	assert.Success(t, err)
+	assert.Equal(t, "close reason", exp, act)
+
+	_, err = wsjson.EncodeCloseReason(wsjson.CloseReason{Message: strings.Repeat("x", wsjson.MaxCloseReason)})
+	assert.Contains(t, err, "must be at most 123")
+
+	_, err = wsjson.DecodeCloseReason("going away")
+	assert.Error(t, err)
+}
+
 func BenchmarkJSON(b *testing.B) {
 	sizes := []int{
 		8,
@@ -0,0 +1,157 @@
+//go:build !js
+
+// Package wslimit protects public WebSocket endpoints from abusive clients by
+// limiting connections per IP address and the rate of incoming messages.
+package wslimit // import "github.com/coder/websocket/wslimit"
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/coder/websocket"
+)
+
+// ErrRateExceeded is returned by reads once the peer exceeded the message rate.
+var ErrRateExceeded = errors.New("wslimit: message rate exceeded")
+
+// IPLimiter limits the number of connections per client IP address and the
+// rate of messages each connection may send.
+type IPLimiter struct {
+	maxConns int
+	rate     float64
+
+	mu    sync.Mutex
+	conns map[string]int
+}
+
+// NewIPLimiter returns an IPLimiter allowing maxConnsPerIP connections per
+// IP address, each sending at most msgsPerSecond messages per second.
+// Zero disables the respective limit.
+func NewIPLimiter(maxConnsPerIP int, msgsPerSecond float64) *IPLimiter {
+	return &IPLimiter{
+		maxConns: maxConnsPerIP,
+		rate:     msgsPerSecond,
+		conns:    make(map[string]int),
+	}
+}
+
+// Handler returns an http.Handler that accepts connections with opts and
+// calls fn with each. The connection is closed when fn returns.
+//
+// Clients at their connection limit get 429 Too Many Requests.
+// The IP address is taken from http.Request.RemoteAddr so deploy the handler
+// behind middleware that sets it if there is a reverse proxy in front.
+func (l *IPLimiter) Handler(opts *websocket.AcceptOptions, fn func(c *Conn)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			ip = r.RemoteAddr
+		}
+		if !l.acquire(ip) {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+			return
+		}
+		defer l.release(ip)
+
+		c, err := websocket.Accept(w, r, opts)
+		if err != nil {
+			return
+		}
+		defer c.CloseNow()
+
+		fn(l.Conn(c))
+	})
+}
+
+// Conn wraps c to enforce the message rate of l on its reads.
+// Handler already wraps the connections it accepts.
+func (l *IPLimiter) Conn(c *websocket.Conn) *Conn {
+	burst := max(l.rate, 1)
+	return &Conn{
+		Conn:   c,
+		rate:   l.rate,
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+	}
+}
+
+func (l *IPLimiter) acquire(ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.maxConns > 0 && l.conns[ip] >= l.maxConns {
+		return false
+	}
+	l.conns[ip]++
+	return true
+}
+
+func (l *IPLimiter) release(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.conns[ip]--
+	if l.conns[ip] <= 0 {
+		delete(l.conns, ip)
+	}
+}
+
+// Conn is a connection whose incoming message rate is limited.
+// A peer sending faster than the rate allows, beyond a burst of one second's
+// worth of messages, is disconnected with StatusPolicyViolation.
+type Conn struct {
+	*websocket.Conn
+
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// Reader is like websocket.Conn.Reader but enforces the message rate.
+func (c *Conn) Reader(ctx context.Context) (websocket.MessageType, io.Reader, error) {
+	typ, r, err := c.Conn.Reader(ctx)
+	if err != nil {
+		return 0, nil, err
+	}
+	err = c.take()
+	if err != nil {
+		return 0, nil, err
+	}
+	return typ, r, nil
+}
+
+// Read is like websocket.Conn.Read but enforces the message rate.
+func (c *Conn) Read(ctx context.Context) (websocket.MessageType, []byte, error) {
+	typ, p, err := c.Conn.Read(ctx)
+	if err != nil {
+		return 0, nil, err
+	}
+	err = c.take()
+	if err != nil {
+		return 0, nil, err
+	}
+	return typ, p, nil
+}
+
+// take consumes a token for a message. Reads are not concurrent so it
+// needs no lock.
+func (c *Conn) take() error {
+	if c.rate <= 0 {
+		return nil
+	}
+	now := time.Now()
+	c.tokens = min(c.burst, c.tokens+now.Sub(c.last).Seconds()*c.rate)
+	c.last = now
+	if c.tokens < 1 {
+		c.Conn.Close(websocket.StatusPolicyViolation, "message rate exceeded")
+		return ErrRateExceeded
+	}
+	c.tokens--
+	return nil
+}
@@ -0,0 +1,67 @@
+//go:build !js
+
+package wslimit_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/internal/test/assert"
+	"github.com/coder/websocket/wslimit"
+)
+
+func TestIPLimiter(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	readErrs := make(chan error, 1)
+	l := wslimit.NewIPLimiter(1, 1)
+	s := httptest.NewServer(l.Handler(nil, func(c *wslimit.Conn) {
+		for {
+			_, _, err := c.Read(ctx)
+			if err != nil {
+				readErrs <- err
+				return
+			}
+		}
+	}))
+	defer s.Close()
+	u := strings.Replace(s.URL, "http", "ws", 1)
+
+	c, _, err := websocket.Dial(ctx, u, nil)
+	assert.Success(t, err)
+	defer c.CloseNow()
+
+	_, resp, err := websocket.Dial(ctx, u, nil)
+	assert.Error(t, err)
+	assert.Equal(t, "status code", http.StatusTooManyRequests, resp.StatusCode)
+
+	for range 2 {
+		err = c.Write(ctx, websocket.MessageText, []byte("hi"))
+		assert.Success(t, err)
+	}
+	_, _, err = c.Read(ctx)
+	assert.Equal(t, "close status", websocket.StatusPolicyViolation, websocket.CloseStatus(err))
+	assert.ErrorIs(t, wslimit.ErrRateExceeded, <-readErrs)
+
+	// The slot is released once the handler returns.
+	for {
+		c, _, err = websocket.Dial(ctx, u, nil)
+		if err == nil {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			t.Fatal(ctx.Err())
+		case <-time.After(time.Millisecond * 10):
+		}
+	}
+	c.CloseNow()
+}
@@ -0,0 +1,102 @@
+// Package wsmetrics provides an expvar implementation of websocket.Metrics.
+//
+// See the wsprom subpackage, a separate module, for Prometheus.
+package wsmetrics // import "github.com/coder/websocket/wsmetrics"
+
+import (
+	"expvar"
+	"strconv"
+	"time"
+
+	"github.com/coder/websocket"
+)
+
+// sizeBuckets are the upper bounds of the message size histogram buckets.
+var sizeBuckets = []int{64, 256, 1 << 10, 4 << 10, 16 << 10, 64 << 10, 256 << 10, 1 << 20}
+
+// Expvar publishes connection metrics with expvar.
+type Expvar struct {
+	// MessagesRead and MessagesWritten count data messages.
+	MessagesRead    *expvar.Int
+	MessagesWritten *expvar.Int
+
+	// BytesRead and BytesWritten sum the sizes of data messages.
+	BytesRead    *expvar.Int
+	BytesWritten *expvar.Int
+
+	// ReadSizes and WriteSizes are histograms of data message sizes. Keys are
+	// bucket upper bounds in bytes such as "le_1024", plus "le_inf".
+	// Buckets are not cumulative.
+	ReadSizes  *expvar.Map
+	WriteSizes *expvar.Map
+
+	// Closes counts closed connections by status code.
+	Closes *expvar.Map
+
+	// Handshakes counts successful opening handshakes and HandshakeSeconds
+	// sums their durations.
+	Handshakes       *expvar.Int
+	HandshakeSeconds *expvar.Float
+}
+
+// NewExpvar returns an Expvar publishing a map named name.
+// As with expvar.Publish, it panics if the name is already in use.
+func NewExpvar(name string) *Expvar {
+	e := &Expvar{
+		MessagesRead:     new(expvar.Int),
+		MessagesWritten:  new(expvar.Int),
+		BytesRead:        new(expvar.Int),
+		BytesWritten:     new(expvar.Int),
+		ReadSizes:        new(expvar.Map),
+		WriteSizes:       new(expvar.Map),
+		Closes:           new(expvar.Map),
+		Handshakes:       new(expvar.Int),
+		HandshakeSeconds: new(expvar.Float),
+	}
+
+	m := expvar.NewMap(name)
+	m.Set("messages_read", e.MessagesRead)
+	m.Set("messages_written", e.MessagesWritten)
+	m.Set("bytes_read", e.BytesRead)
+	m.Set("bytes_written", e.BytesWritten)
+	m.Set("read_sizes", e.ReadSizes)
+	m.Set("write_sizes", e.WriteSizes)
+	m.Set("closes", e.Closes)
+	m.Set("handshakes", e.Handshakes)
+	m.Set("handshake_seconds", e.HandshakeSeconds)
+	return e
+}
+
+// IncMessagesRead implements websocket.Metrics.
+func (e *Expvar) IncMessagesRead(size int) {
+	e.MessagesRead.Add(1)
+	e.BytesRead.Add(int64(size))
+	e.ReadSizes.Add(bucket(size), 1)
+}
+
+// IncMessagesWritten implements websocket.Metrics.
+func (e *Expvar) IncMessagesWritten(size int) {
+	e.MessagesWritten.Add(1)
+	e.BytesWritten.Add(int64(size))
+	e.WriteSizes.Add(bucket(size), 1)
+}
+
+// IncClose implements websocket.Metrics.
+func (e *Expvar) IncClose(code websocket.StatusCode) {
+	e.Closes.Add(strconv.Itoa(int(code)), 1)
+}
+
+// ObserveHandshakeDuration implements websocket.Metrics.
+func (e *Expvar) ObserveHandshakeDuration(d time.Duration) {
+	e.Handshakes.Add(1)
+	e.HandshakeSeconds.Add(d.Seconds())
+}
+
+func bucket(size int) string {
+	for _, b := range sizeBuckets {
+		if size <= b {
+			return "le_" + strconv.Itoa(b)
+		}
+	}
+	return "le_inf"
+}
@@ -0,0 +1,49 @@
+//go:build !js
+
+package wsmetrics_test
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/internal/test/assert"
+	"github.com/coder/websocket/internal/test/wstest"
+	"github.com/coder/websocket/internal/xsync"
+	"github.com/coder/websocket/wsmetrics"
+)
+
+var _ websocket.Metrics = (*wsmetrics.Expvar)(nil)
+
+func TestExpvar(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	// Unique so the test can run with -count.
+	m := wsmetrics.NewExpvar("websocket_test_" + strconv.FormatInt(time.Now().UnixNano(), 10))
+	c1, c2 := wstest.Pipe(&websocket.DialOptions{Metrics: m}, &websocket.AcceptOptions{Metrics: m})
+	defer c2.CloseNow()
+	defer c1.CloseNow()
+
+	errs := xsync.Go(func() error {
+		return c1.Write(ctx, websocket.MessageText, []byte(strings.Repeat("x", 100)))
+	})
+	_, _, err := c2.Read(ctx)
+	assert.Success(t, err)
+	assert.Success(t, <-errs)
+
+	closed := c2.CloseRead(ctx)
+	assert.Success(t, c1.Close(websocket.StatusNormalClosure, ""))
+	<-closed.Done()
+
+	assert.Equal(t, "handshakes", int64(2), m.Handshakes.Value())
+	assert.Equal(t, "messages read", int64(1), m.MessagesRead.Value())
+	assert.Equal(t, "bytes written", int64(100), m.BytesWritten.Value())
+	assert.Equal(t, "read sizes", `{"le_256": 1}`, m.ReadSizes.String())
+	assert.Equal(t, "closes", `{"1000": 2}`, m.Closes.String())
+}
@@ -0,0 +1,75 @@
+// Package wsprom provides a Prometheus implementation of websocket.Metrics.
+//
+// It is a separate module so that the websocket module has no dependencies.
+package wsprom // import "github.com/coder/websocket/wsmetrics/wsprom"
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/coder/websocket"
+)
+
+// Metrics collects connection metrics for Prometheus.
+type Metrics struct {
+	// MessageSizes is a histogram of data message sizes in bytes with a
+	// direction label of "read" or "write". Its count and sum are the number
+	// of messages and bytes.
+	MessageSizes *prometheus.HistogramVec
+
+	// Closes counts closed connections with a code label of the status code.
+	Closes *prometheus.CounterVec
+
+	// HandshakeDuration is a histogram of the durations of successful opening
+	// handshakes in seconds.
+	HandshakeDuration prometheus.Histogram
+}
+
+// New returns Metrics registered with reg under the websocket namespace.
+// As with prometheus.Registerer.MustRegister, it panics if they are already
+// registered.
+func New(reg prometheus.Registerer) *Metrics {
+	m := &Metrics{
+		MessageSizes: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "websocket",
+			Name:      "message_size_bytes",
+			Help:      "Sizes of data messages read and written.",
+			Buckets:   prometheus.ExponentialBuckets(64, 4, 8),
+		}, []string{"direction"}),
+		Closes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "websocket",
+			Name:      "closes_total",
+			Help:      "Closed connections by status code.",
+		}, []string{"code"}),
+		HandshakeDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: "websocket",
+			Name:      "handshake_duration_seconds",
+			Help:      "Durations of successful opening handshakes.",
+			Buckets:   prometheus.DefBuckets,
+		}),
+	}
+	reg.MustRegister(m.MessageSizes, m.Closes, m.HandshakeDuration)
+	return m
+}
+
+// IncMessagesRead implements websocket.Metrics.
+func (m *Metrics) IncMessagesRead(size int) {
+	m.MessageSizes.WithLabelValues("read").Observe(float64(size))
+}
+
+// IncMessagesWritten implements websocket.Metrics.
+func (m *Metrics) IncMessagesWritten(size int) {
+	m.MessageSizes.WithLabelValues("write").Observe(float64(size))
+}
+
+// IncClose implements websocket.Metrics.
+func (m *Metrics) IncClose(code websocket.StatusCode) {
+	m.Closes.WithLabelValues(strconv.Itoa(int(code))).Inc()
+}
+
+// ObserveHandshakeDuration implements websocket.Metrics.
+func (m *Metrics) ObserveHandshakeDuration(d time.Duration) {
+	m.HandshakeDuration.Observe(d.Seconds())
+}
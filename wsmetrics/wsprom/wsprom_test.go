@@ -0,0 +1,77 @@
+package wsprom_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/internal/test/assert"
+	"github.com/coder/websocket/internal/test/wstest"
+	"github.com/coder/websocket/internal/xsync"
+	"github.com/coder/websocket/wsmetrics/wsprom"
+)
+
+var _ websocket.Metrics = (*wsprom.Metrics)(nil)
+
+func TestMetrics(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	m := wsprom.New(prometheus.NewRegistry())
+	c1, c2 := wstest.Pipe(&websocket.DialOptions{Metrics: m}, &websocket.AcceptOptions{Metrics: m})
+	defer c2.CloseNow()
+	defer c1.CloseNow()
+
+	errs := xsync.Go(func() error {
+		return c1.Write(ctx, websocket.MessageText, []byte(strings.Repeat("x", 100)))
+	})
+	_, _, err := c2.Read(ctx)
+	assert.Success(t, err)
+	assert.Success(t, <-errs)
+
+	closed := c2.CloseRead(ctx)
+	assert.Success(t, c1.Close(websocket.StatusNormalClosure, ""))
+	<-closed.Done()
+
+	var handshakes dto.Metric
+	err = m.HandshakeDuration.Write(&handshakes)
+	assert.Success(t, err)
+	assert.Equal(t, "handshakes", uint64(2), handshakes.GetHistogram().GetSampleCount())
+	assert.Equal(t, "closes", 2.0, testutil.ToFloat64(m.Closes.WithLabelValues("1000")))
+
+	err = testutil.CollectAndCompare(m.MessageSizes, strings.NewReader(`
+# HELP websocket_message_size_bytes Sizes of data messages read and written.
+# TYPE websocket_message_size_bytes histogram
+websocket_message_size_bytes_bucket{direction="read",le="64"} 0
+websocket_message_size_bytes_bucket{direction="read",le="256"} 1
+websocket_message_size_bytes_bucket{direction="read",le="1024"} 1
+websocket_message_size_bytes_bucket{direction="read",le="4096"} 1
+websocket_message_size_bytes_bucket{direction="read",le="16384"} 1
+websocket_message_size_bytes_bucket{direction="read",le="65536"} 1
+websocket_message_size_bytes_bucket{direction="read",le="262144"} 1
+websocket_message_size_bytes_bucket{direction="read",le="1.048576e+06"} 1
+websocket_message_size_bytes_bucket{direction="read",le="+Inf"} 1
+websocket_message_size_bytes_sum{direction="read"} 100
+websocket_message_size_bytes_count{direction="read"} 1
+websocket_message_size_bytes_bucket{direction="write",le="64"} 0
+websocket_message_size_bytes_bucket{direction="write",le="256"} 1
+websocket_message_size_bytes_bucket{direction="write",le="1024"} 1
+websocket_message_size_bytes_bucket{direction="write",le="4096"} 1
+websocket_message_size_bytes_bucket{direction="write",le="16384"} 1
+websocket_message_size_bytes_bucket{direction="write",le="65536"} 1
+websocket_message_size_bytes_bucket{direction="write",le="262144"} 1
+websocket_message_size_bytes_bucket{direction="write",le="1.048576e+06"} 1
+websocket_message_size_bytes_bucket{direction="write",le="+Inf"} 1
+websocket_message_size_bytes_sum{direction="write"} 100
+websocket_message_size_bytes_count{direction="write"} 1
+`))
+	assert.Success(t, err)
+}
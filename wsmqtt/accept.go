@@ -0,0 +1,35 @@
+//go:build !js
+
+package wsmqtt
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/coder/websocket"
+)
+
+// Accept accepts a WebSocket connection with the mqtt subprotocol and returns
+// it as a net.Conn. If the client does not offer the subprotocol, the
+// connection is closed with StatusProtocolError and an error is returned.
+//
+// opts is copied and may be nil. Its Subprotocols are replaced.
+func Accept(w http.ResponseWriter, r *http.Request, opts *websocket.AcceptOptions) (net.Conn, error) {
+	var o websocket.AcceptOptions
+	if opts != nil {
+		o = *opts
+	}
+	o.Subprotocols = []string{Subprotocol}
+
+	c, err := websocket.Accept(w, r, &o)
+	if err != nil {
+		return nil, fmt.Errorf("failed to accept MQTT: %w", err)
+	}
+	if c.Subprotocol() != Subprotocol {
+		c.Close(websocket.StatusProtocolError, "mqtt subprotocol required")
+		return nil, errors.New("failed to accept MQTT: client did not offer the mqtt subprotocol")
+	}
+	return netConn(c), nil
+}
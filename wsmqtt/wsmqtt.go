@@ -0,0 +1,44 @@
+// Package wsmqtt adapts WebSocket connections to the net.Conn packet transport
+// expected by MQTT libraries.
+//
+// MQTT over WebSockets requires the "mqtt" subprotocol and binary messages.
+// Dial and Accept negotiate the subprotocol and the returned net.Conn fails
+// the connection if the peer sends a text message.
+//
+// See section 6 of https://docs.oasis-open.org/mqtt/mqtt/v5.0/mqtt-v5.0.html
+package wsmqtt // import "github.com/coder/websocket/wsmqtt"
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/coder/websocket"
+)
+
+// Subprotocol is the WebSocket subprotocol of MQTT.
+const Subprotocol = "mqtt"
+
+// Dial dials u with the mqtt subprotocol and returns the connection as a
+// net.Conn. The dial fails if the server does not select the subprotocol.
+//
+// opts is copied and may be nil. Its Subprotocols are replaced.
+func Dial(ctx context.Context, u string, opts *websocket.DialOptions) (net.Conn, *http.Response, error) {
+	var o websocket.DialOptions
+	if opts != nil {
+		o = *opts
+	}
+	o.Subprotocols = []string{Subprotocol}
+	o.SubprotocolRequired = true
+
+	c, resp, err := websocket.Dial(ctx, u, &o)
+	if err != nil {
+		return nil, resp, fmt.Errorf("failed to dial MQTT: %w", err)
+	}
+	return netConn(c), resp, nil
+}
+
+func netConn(c *websocket.Conn) net.Conn {
+	return websocket.NetConn(context.Background(), c, websocket.MessageBinary)
+}
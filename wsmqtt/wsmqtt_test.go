@@ -0,0 +1,91 @@
+//go:build !js
+
+package wsmqtt_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/internal/test/assert"
+	"github.com/coder/websocket/internal/xsync"
+	"github.com/coder/websocket/wsmqtt"
+)
+
+func TestMQTT(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	conns := make(chan net.Conn, 1)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := wsmqtt.Accept(w, r, nil)
+		if err != nil {
+			return
+		}
+		conns <- c
+	}))
+	defer s.Close()
+	u := strings.Replace(s.URL, "http", "ws", 1)
+
+	t.Run("binary", func(t *testing.T) {
+		c, _, err := wsmqtt.Dial(ctx, u, nil)
+		assert.Success(t, err)
+		defer c.Close()
+		sc := <-conns
+
+		// A CONNECT packet header.
+		packet := []byte{0x10, 0x00}
+		_, err = c.Write(packet)
+		assert.Success(t, err)
+
+		b := make([]byte, len(packet))
+		_, err = io.ReadFull(sc, b)
+		assert.Success(t, err)
+		assert.Equal(t, "packet", packet, b)
+
+		errs := xsync.Go(sc.Close)
+		_, err = c.Read(b)
+		assert.ErrorIs(t, io.EOF, err)
+		assert.Success(t, <-errs)
+	})
+
+	t.Run("text", func(t *testing.T) {
+		c, _, err := websocket.Dial(ctx, u, &websocket.DialOptions{
+			Subprotocols: []string{wsmqtt.Subprotocol},
+		})
+		assert.Success(t, err)
+		defer c.CloseNow()
+		sc := <-conns
+		defer sc.Close()
+
+		err = c.Write(ctx, websocket.MessageText, []byte("hi"))
+		assert.Success(t, err)
+
+		errs := make(chan error, 1)
+		go func() {
+			_, err := sc.Read(make([]byte, 2))
+			errs <- err
+		}()
+
+		_, _, err = c.Read(ctx)
+		assert.Equal(t, "close status", websocket.StatusUnsupportedData, websocket.CloseStatus(err))
+		assert.Contains(t, <-errs, "unexpected frame type")
+	})
+
+	t.Run("noSubprotocol", func(t *testing.T) {
+		c, _, err := websocket.Dial(ctx, u, nil)
+		assert.Success(t, err)
+		defer c.CloseNow()
+
+		_, _, err = c.Read(ctx)
+		assert.Equal(t, "close status", websocket.StatusProtocolError, websocket.CloseStatus(err))
+	})
+}
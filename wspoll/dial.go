@@ -0,0 +1,141 @@
+//go:build !js
+
+package wspoll
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// DialOptions configures Dial.
+type DialOptions struct {
+	// HTTPClient is used for the requests. Its Timeout must be above the
+	// PollTimeout of the server. Defaults to http.DefaultClient.
+	HTTPClient *http.Client
+
+	// HTTPHeader is sent with every request.
+	HTTPHeader http.Header
+
+	// ReadLimit is the maximum size of a message from the server.
+	// Defaults to 32768 bytes. Set to -1 to disable.
+	ReadLimit int64
+
+	// ReadBuffer is the maximum total size of the messages from the server
+	// that were not read yet. The server exceeding it fails the connection.
+	// It must be at least ReadLimit. Defaults to 1 MiB. Set to -1 to disable.
+	ReadBuffer int64
+}
+
+type client struct {
+	opts *DialOptions
+	hc   *http.Client
+	u    string
+}
+
+// Dial creates a session with the Handler at u, an http or https URL.
+// ctx only bounds the creation of the session.
+func Dial(ctx context.Context, u string, opts *DialOptions) (*Conn, error) {
+	if opts == nil {
+		opts = &DialOptions{}
+	}
+	cl := &client{
+		opts: opts,
+		hc:   opts.HTTPClient,
+	}
+	if cl.hc == nil {
+		cl.hc = http.DefaultClient
+	}
+
+	resp, err := cl.do(ctx, http.MethodPost, u, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create session: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to create session: unexpected status %v", resp.StatusCode)
+	}
+	id, err := io.ReadAll(io.LimitReader(resp.Body, 256))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read session ID: %w", err)
+	}
+
+	pu, err := url.Parse(u)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse URL: %w", err)
+	}
+	q := pu.Query()
+	q.Set("session", string(id))
+	pu.RawQuery = q.Encode()
+	cl.u = pu.String()
+
+	c := newConn(opts.ReadLimit, opts.ReadBuffer, nil)
+	go cl.poll(c)
+	go cl.send(c)
+	return c, nil
+}
+
+func (cl *client) do(ctx context.Context, method, u string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range cl.opts.HTTPHeader {
+		req.Header[k] = v
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/octet-stream")
+	}
+	return cl.hc.Do(req)
+}
+
+// poll receives messages from the server until the connection is closed.
+func (cl *client) poll(c *Conn) {
+	defer c.CloseNow()
+	for {
+		resp, err := cl.do(c.ctx, http.MethodGet, cl.u, nil)
+		if err != nil {
+			return
+		}
+		var msgs []message
+		switch resp.StatusCode {
+		case http.StatusOK:
+			msgs, err = decodeMessages(resp.Body, c.readLimit)
+		case http.StatusNoContent:
+		default:
+			err = fmt.Errorf("unexpected status %v", resp.StatusCode)
+		}
+		resp.Body.Close()
+		if err != nil {
+			return
+		}
+		err = c.receive(msgs)
+		if err != nil {
+			return
+		}
+	}
+}
+
+// send sends queued messages to the server until the connection is closed.
+func (cl *client) send(c *Conn) {
+	for {
+		msgs, err := c.takeOut(c.ctx)
+		if err != nil {
+			return
+		}
+		resp, err := cl.do(c.ctx, http.MethodPost, cl.u, encodeMessages(msgs))
+		if err != nil {
+			c.CloseNow()
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNoContent {
+			c.CloseNow()
+			return
+		}
+		c.delivered(msgs)
+	}
+}
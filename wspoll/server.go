@@ -0,0 +1,189 @@
+//go:build !js
+
+package wspoll
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/coder/websocket"
+)
+
+// Options configures Handler.
+type Options struct {
+	// PollTimeout is how long a poll waits for messages before the server
+	// responds without any. It must be below the timeouts of proxies
+	// between the client and server. Defaults to 25s.
+	PollTimeout time.Duration
+
+	// SessionTimeout closes sessions without requests from the client for
+	// that long. Defaults to 60s.
+	SessionTimeout time.Duration
+
+	// ReadLimit is the maximum size of a message from the client.
+	// Defaults to 32768 bytes. Set to -1 to disable.
+	ReadLimit int64
+
+	// ReadBuffer is the maximum total size of the messages from the client
+	// that were not read yet, which also bounds the body of each request.
+	// A client exceeding it fails the connection. It must be at least
+	// ReadLimit. Defaults to 1 MiB. Set to -1 to disable.
+	ReadBuffer int64
+
+	// MaxSessions is the maximum number of open sessions. Requests creating
+	// a session beyond it are rejected with 503 Service Unavailable.
+	// Defaults to 1024. Set to -1 to disable.
+	MaxSessions int
+}
+
+const defaultMaxSessions = 1024
+
+type server struct {
+	opts Options
+	fn   func(ctx context.Context, c *Conn)
+
+	mu       sync.Mutex
+	sessions map[string]*session
+}
+
+type session struct {
+	c     *Conn
+	timer *time.Timer
+}
+
+// Handler returns an http.Handler serving fn for each session created by
+// Dial.
+//
+// ctx is canceled when the connection is closed. The connection is closed
+// with StatusNormalClosure when fn returns.
+func Handler(opts *Options, fn func(ctx context.Context, c *Conn)) http.Handler {
+	s := &server{
+		fn:       fn,
+		sessions: make(map[string]*session),
+	}
+	if opts != nil {
+		s.opts = *opts
+	}
+	if s.opts.PollTimeout <= 0 {
+		s.opts.PollTimeout = time.Second * 25
+	}
+	if s.opts.SessionTimeout <= 0 {
+		s.opts.SessionTimeout = time.Second * 60
+	}
+	if s.opts.ReadBuffer == 0 {
+		s.opts.ReadBuffer = defaultReadBuffer
+	}
+	if s.opts.MaxSessions == 0 {
+		s.opts.MaxSessions = defaultMaxSessions
+	}
+	return s
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("session")
+	if id == "" {
+		if r.Method != http.MethodPost {
+			http.Error(w, "missing session", http.StatusBadRequest)
+			return
+		}
+		s.create(w)
+		return
+	}
+
+	s.mu.Lock()
+	sess := s.sessions[id]
+	s.mu.Unlock()
+	if sess == nil {
+		http.Error(w, "unknown session", http.StatusNotFound)
+		return
+	}
+	sess.timer.Reset(s.opts.SessionTimeout)
+
+	switch r.Method {
+	case http.MethodGet:
+		s.poll(w, r, sess.c)
+	case http.MethodPost:
+		body := r.Body
+		if s.opts.ReadBuffer >= 0 {
+			// Framing included so that empty messages are bounded too.
+			body = http.MaxBytesReader(w, body, s.opts.ReadBuffer)
+		}
+		msgs, err := decodeMessages(body, sess.c.readLimit)
+		if err == nil {
+			err = sess.c.receive(msgs)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			sess.c.CloseNow()
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *server) create(w http.ResponseWriter) {
+	b := make([]byte, 16)
+	rand.Read(b)
+	id := base64.RawURLEncoding.EncodeToString(b)
+
+	sess := &session{}
+	sess.c = newConn(s.opts.ReadLimit, s.opts.ReadBuffer, func() {
+		sess.timer.Stop()
+		s.mu.Lock()
+		delete(s.sessions, id)
+		s.mu.Unlock()
+	})
+	sess.timer = time.AfterFunc(s.opts.SessionTimeout, func() {
+		sess.c.CloseNow()
+	})
+	s.mu.Lock()
+	full := s.opts.MaxSessions > 0 && len(s.sessions) >= s.opts.MaxSessions
+	if !full {
+		s.sessions[id] = sess
+	}
+	s.mu.Unlock()
+	if full {
+		sess.c.CloseNow()
+		http.Error(w, "too many sessions", http.StatusServiceUnavailable)
+		return
+	}
+
+	go func() {
+		s.fn(sess.c.ctx, sess.c)
+		sess.c.Close(websocket.StatusNormalClosure, "")
+	}()
+
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte(id))
+}
+
+// poll responds with the messages queued for the client, waiting up to
+// PollTimeout for some.
+func (s *server) poll(w http.ResponseWriter, r *http.Request, c *Conn) {
+	ctx, cancel := context.WithTimeout(r.Context(), s.opts.PollTimeout)
+	defer cancel()
+
+	msgs, err := c.takeOut(ctx)
+	if err != nil {
+		http.Error(w, "session closed", http.StatusGone)
+		return
+	}
+	if len(msgs) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	_, err = w.Write(encodeMessages(msgs))
+	if err != nil {
+		c.CloseNow()
+		return
+	}
+	c.delivered(msgs)
+}
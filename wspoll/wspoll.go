@@ -0,0 +1,327 @@
+//go:build !js
+
+// Package wspoll is an experimental long-polling transport for environments
+// where proxies strip the Upgrade header so WebSocket handshakes never reach
+// the server.
+//
+// A Conn implements the Read, Write and Close subset of websocket.Conn over
+// pairs of HTTP requests identified by a session ID: the client creates the
+// session with a POST request, polls for messages from the server with GET
+// requests and sends messages to the server with POST requests. Messages are
+// batched and framed as a type byte, a 4 byte big endian length and the
+// payload.
+//
+// Writes are queued without backpressure and a message may be lost if a
+// request fails, which fails the connection.
+//
+// Any request without a session ID creates a session. Authenticate requests,
+// e.g. with middleware around Handler, and see Options.MaxSessions.
+package wspoll // import "github.com/coder/websocket/wspoll"
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/coder/websocket"
+)
+
+const (
+	opText   = byte(websocket.MessageText)
+	opBinary = byte(websocket.MessageBinary)
+	opClose  = 8
+)
+
+const (
+	defaultReadLimit  = 32768
+	defaultReadBuffer = 1 << 20
+)
+
+type message struct {
+	op byte
+	p  []byte
+}
+
+func closeMessage(code websocket.StatusCode, reason string) message {
+	p := make([]byte, 2+len(reason))
+	binary.BigEndian.PutUint16(p, uint16(code))
+	copy(p[2:], reason)
+	return message{op: opClose, p: p}
+}
+
+func parseClose(p []byte) websocket.CloseError {
+	if len(p) < 2 {
+		return websocket.CloseError{Code: websocket.StatusNoStatusRcvd}
+	}
+	return websocket.CloseError{
+		Code:   websocket.StatusCode(binary.BigEndian.Uint16(p)),
+		Reason: string(p[2:]),
+	}
+}
+
+func encodeMessages(msgs []message) []byte {
+	var b bytes.Buffer
+	for _, m := range msgs {
+		var h [5]byte
+		h[0] = m.op
+		binary.BigEndian.PutUint32(h[1:], uint32(len(m.p)))
+		b.Write(h[:])
+		b.Write(m.p)
+	}
+	return b.Bytes()
+}
+
+func decodeMessages(r io.Reader, limit int64) ([]message, error) {
+	var msgs []message
+	for {
+		var h [5]byte
+		_, err := io.ReadFull(r, h[:])
+		if err == io.EOF {
+			return msgs, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read message header: %w", err)
+		}
+
+		m := message{op: h[0]}
+		n := int64(binary.BigEndian.Uint32(h[1:]))
+		switch {
+		case m.op != opText && m.op != opBinary && m.op != opClose:
+			return nil, fmt.Errorf("unknown message type %d", m.op)
+		case limit >= 0 && n > limit:
+			return nil, fmt.Errorf("%w: message of %d bytes exceeds limit of %d", websocket.ErrMessageTooBig, n, limit)
+		}
+		// n is not trusted with the limit disabled, so the buffer only grows
+		// with the bytes actually received.
+		var b bytes.Buffer
+		_, err = io.CopyN(&b, r, n)
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read message: %w", err)
+		}
+		m.p = b.Bytes()
+		msgs = append(msgs, m)
+	}
+}
+
+// Conn is a long-polling connection, created by Dial or Handler.
+//
+// Read must only be called from one goroutine at a time.
+// Write and Close may be called concurrently.
+type Conn struct {
+	ctx        context.Context
+	cancel     context.CancelFunc
+	readLimit  int64
+	readBuffer int64
+	onClose    func()
+
+	mu             sync.Mutex
+	in             []message
+	inBytes        int64
+	out            []message
+	closeSent      bool
+	closeDelivered bool
+	closeReceived  *websocket.CloseError
+
+	inSignal  chan struct{}
+	outSignal chan struct{}
+	closeRecv chan struct{}
+	closeOnce sync.Once
+}
+
+func newConn(readLimit, readBuffer int64, onClose func()) *Conn {
+	if readLimit == 0 {
+		readLimit = defaultReadLimit
+	}
+	if readBuffer == 0 {
+		readBuffer = defaultReadBuffer
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Conn{
+		ctx:        ctx,
+		cancel:     cancel,
+		readLimit:  readLimit,
+		readBuffer: readBuffer,
+		onClose:    onClose,
+		inSignal:   make(chan struct{}, 1),
+		outSignal:  make(chan struct{}, 1),
+		closeRecv:  make(chan struct{}),
+	}
+}
+
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// Read reads a message from the peer. Once the peer's close message is read,
+// it returns an error wrapping a websocket.CloseError.
+//
+// Like with websocket.Conn, ctx expiring closes the connection.
+func (c *Conn) Read(ctx context.Context) (websocket.MessageType, []byte, error) {
+	for {
+		c.mu.Lock()
+		if len(c.in) > 0 {
+			m := c.in[0]
+			c.in = c.in[1:]
+			c.inBytes -= int64(len(m.p))
+			c.mu.Unlock()
+			return websocket.MessageType(m.op), m.p, nil
+		}
+		ce := c.closeReceived
+		c.mu.Unlock()
+		if ce != nil {
+			return 0, nil, fmt.Errorf("received close message: %w", *ce)
+		}
+
+		select {
+		case <-c.inSignal:
+		case <-c.ctx.Done():
+			return 0, nil, fmt.Errorf("failed to read: %w", net.ErrClosed)
+		case <-ctx.Done():
+			c.CloseNow()
+			return 0, nil, fmt.Errorf("failed to read: %w", ctx.Err())
+		}
+	}
+}
+
+// Write queues a message for the peer. It does not wait for the message to
+// be delivered.
+func (c *Conn) Write(ctx context.Context, typ websocket.MessageType, p []byte) error {
+	if typ != websocket.MessageText && typ != websocket.MessageBinary {
+		return fmt.Errorf("failed to write: unexpected message type %v", typ)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closeSent || c.ctx.Err() != nil {
+		return fmt.Errorf("failed to write: %w", net.ErrClosed)
+	}
+	c.out = append(c.out, message{op: byte(typ), p: bytes.Clone(p)})
+	notify(c.outSignal)
+	return nil
+}
+
+// Close sends a close message and waits up to 5s for the peer's before
+// closing the connection. The peer's close message is received even if
+// nothing is reading.
+func (c *Conn) Close(code websocket.StatusCode, reason string) error {
+	c.mu.Lock()
+	if c.closeSent || c.ctx.Err() != nil {
+		c.mu.Unlock()
+		return fmt.Errorf("failed to close: %w", net.ErrClosed)
+	}
+	c.closeSent = true
+	c.out = append(c.out, closeMessage(code, reason))
+	notify(c.outSignal)
+	c.mu.Unlock()
+
+	t := time.NewTimer(time.Second * 5)
+	defer t.Stop()
+	select {
+	case <-c.closeRecv:
+	case <-c.ctx.Done():
+	case <-t.C:
+	}
+	c.CloseNow()
+	return nil
+}
+
+// CloseNow closes the connection without waiting for the peer's close
+// message.
+func (c *Conn) CloseNow() error {
+	err := fmt.Errorf("failed to close: %w", net.ErrClosed)
+	c.closeOnce.Do(func() {
+		err = nil
+		c.cancel()
+		if c.onClose != nil {
+			c.onClose()
+		}
+	})
+	return err
+}
+
+// takeOut waits for queued messages and takes them. It returns no messages
+// if ctx expires.
+func (c *Conn) takeOut(ctx context.Context) ([]message, error) {
+	for {
+		c.mu.Lock()
+		if len(c.out) > 0 {
+			msgs := c.out
+			c.out = nil
+			c.mu.Unlock()
+			return msgs, nil
+		}
+		c.mu.Unlock()
+
+		select {
+		case <-c.outSignal:
+		case <-c.ctx.Done():
+			return nil, net.ErrClosed
+		case <-ctx.Done():
+			return nil, nil
+		}
+	}
+}
+
+// delivered is called once msgs taken with takeOut reached the peer.
+func (c *Conn) delivered(msgs []message) {
+	if msgs[len(msgs)-1].op != opClose {
+		return
+	}
+	c.mu.Lock()
+	c.closeDelivered = true
+	done := c.closeReceived != nil
+	c.mu.Unlock()
+	if done {
+		c.CloseNow()
+	}
+}
+
+// receive queues msgs from the peer for Read. The peer's close message is
+// answered right away so the close handshake does not depend on Read.
+//
+// It fails if the messages queued for Read would exceed the read buffer.
+func (c *Conn) receive(msgs []message) error {
+	c.mu.Lock()
+	if c.closeReceived != nil {
+		c.mu.Unlock()
+		return nil
+	}
+	for _, m := range msgs {
+		if m.op != opClose {
+			c.inBytes += int64(len(m.p))
+			if c.readBuffer >= 0 && c.inBytes > c.readBuffer {
+				c.mu.Unlock()
+				return fmt.Errorf("%w: messages queued for Read exceed the read buffer of %d bytes", websocket.ErrMessageTooBig, c.readBuffer)
+			}
+			c.in = append(c.in, m)
+			continue
+		}
+		ce := parseClose(m.p)
+		c.closeReceived = &ce
+		close(c.closeRecv)
+		if !c.closeSent {
+			c.closeSent = true
+			c.out = append(c.out, closeMessage(ce.Code, ce.Reason))
+			notify(c.outSignal)
+		}
+		break
+	}
+	done := c.closeDelivered && c.closeReceived != nil
+	c.mu.Unlock()
+	notify(c.inSignal)
+	if done {
+		c.CloseNow()
+	}
+	return nil
+}
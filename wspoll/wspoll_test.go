@@ -0,0 +1,184 @@
+//go:build !js
+
+package wspoll_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/internal/test/assert"
+	"github.com/coder/websocket/wspoll"
+)
+
+func TestConn(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	serverErr := make(chan error, 1)
+	s := httptest.NewServer(wspoll.Handler(&wspoll.Options{
+		PollTimeout: time.Millisecond * 50,
+	}, func(ctx context.Context, c *wspoll.Conn) {
+		for {
+			typ, p, err := c.Read(ctx)
+			if err != nil {
+				serverErr <- err
+				return
+			}
+			err = c.Write(ctx, typ, p)
+			if err != nil {
+				serverErr <- err
+				return
+			}
+		}
+	}))
+	defer s.Close()
+
+	c, err := wspoll.Dial(ctx, s.URL, nil)
+	assert.Success(t, err)
+	defer c.CloseNow()
+
+	err = c.Write(ctx, websocket.MessageText, []byte("hello"))
+	assert.Success(t, err)
+	err = c.Write(ctx, websocket.MessageBinary, []byte{0, 1, 2})
+	assert.Success(t, err)
+
+	// Outlive a few polls.
+	time.Sleep(time.Millisecond * 200)
+
+	typ, p, err := c.Read(ctx)
+	assert.Success(t, err)
+	assert.Equal(t, "type", websocket.MessageText, typ)
+	assert.Equal(t, "message", "hello", string(p))
+	typ, p, err = c.Read(ctx)
+	assert.Success(t, err)
+	assert.Equal(t, "type", websocket.MessageBinary, typ)
+	assert.Equal(t, "message", []byte{0, 1, 2}, p)
+
+	err = c.Close(websocket.StatusGoingAway, "bye")
+	assert.Success(t, err)
+	assert.Equal(t, "close status", websocket.StatusGoingAway, websocket.CloseStatus(<-serverErr))
+	_, _, err = c.Read(ctx)
+	assert.Equal(t, "close status", websocket.StatusGoingAway, websocket.CloseStatus(err))
+}
+
+func TestHandlerClose(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	s := httptest.NewServer(wspoll.Handler(nil, func(ctx context.Context, c *wspoll.Conn) {
+		c.Write(ctx, websocket.MessageText, []byte("bye"))
+	}))
+	defer s.Close()
+
+	c, err := wspoll.Dial(ctx, s.URL, nil)
+	assert.Success(t, err)
+	defer c.CloseNow()
+
+	_, p, err := c.Read(ctx)
+	assert.Success(t, err)
+	assert.Equal(t, "message", "bye", string(p))
+	_, _, err = c.Read(ctx)
+	assert.Equal(t, "close status", websocket.StatusNormalClosure, websocket.CloseStatus(err))
+}
+
+func TestLimits(t *testing.T) {
+	t.Parallel()
+
+	t.Run("readBuffer", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+
+		serverErr := make(chan error, 1)
+		s := httptest.NewServer(wspoll.Handler(&wspoll.Options{
+			PollTimeout: time.Millisecond * 50,
+			ReadBuffer:  100,
+		}, func(ctx context.Context, c *wspoll.Conn) {
+			// Never read so that the messages stay queued.
+			<-ctx.Done()
+			serverErr <- ctx.Err()
+		}))
+		defer s.Close()
+
+		c, err := wspoll.Dial(ctx, s.URL, nil)
+		assert.Success(t, err)
+		defer c.CloseNow()
+
+		for i := 0; i < 3; i++ {
+			err = c.Write(ctx, websocket.MessageBinary, make([]byte, 50))
+			assert.Success(t, err)
+		}
+		select {
+		case err = <-serverErr:
+			assert.ErrorIs(t, context.Canceled, err)
+		case <-ctx.Done():
+			t.Fatal("server did not fail the connection")
+		}
+		_, _, err = c.Read(ctx)
+		assert.Error(t, err)
+	})
+
+	t.Run("maxSessions", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+
+		s := httptest.NewServer(wspoll.Handler(&wspoll.Options{
+			MaxSessions: 1,
+		}, func(ctx context.Context, c *wspoll.Conn) {
+			c.Read(ctx)
+		}))
+		defer s.Close()
+
+		c, err := wspoll.Dial(ctx, s.URL, nil)
+		assert.Success(t, err)
+		defer c.CloseNow()
+
+		_, err = wspoll.Dial(ctx, s.URL, nil)
+		assert.Contains(t, err, "503")
+	})
+}
+
+func TestHandlerDeclaredLength(t *testing.T) {
+	// Not parallel to measure the allocations of the request alone.
+
+	s := httptest.NewServer(wspoll.Handler(&wspoll.Options{
+		ReadLimit: -1,
+	}, func(ctx context.Context, c *wspoll.Conn) {
+		c.Read(ctx)
+	}))
+	defer s.Close()
+
+	resp, err := http.Post(s.URL, "", nil)
+	assert.Success(t, err)
+	id, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Success(t, err)
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	// A binary message declaring 4 GiB with only a few bytes sent.
+	body := append([]byte{byte(websocket.MessageBinary), 0xff, 0xff, 0xff, 0xff}, "hello"...)
+	resp, err = http.Post(s.URL+"?session="+string(id), "application/octet-stream", bytes.NewReader(body))
+	assert.Success(t, err)
+	resp.Body.Close()
+	runtime.ReadMemStats(&after)
+
+	assert.Equal(t, "status code", http.StatusRequestEntityTooLarge, resp.StatusCode)
+	if d := after.TotalAlloc - before.TotalAlloc; d > 64<<20 {
+		t.Fatalf("allocated %d bytes for the declared length", d)
+	}
+}
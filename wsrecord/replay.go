@@ -0,0 +1,223 @@
+//go:build !js
+
+package wsrecord
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ReplayOptions configures Replay.
+type ReplayOptions struct {
+	// HTTPHeader is sent with the handshake request. Set
+	// Sec-WebSocket-Protocol and Sec-WebSocket-Extensions in it to negotiate
+	// what the recorded connection negotiated, e.g. permessage-deflate for
+	// frames with RSV1 set.
+	HTTPHeader http.Header
+
+	// TLSConfig is used for wss URLs.
+	TLSConfig *tls.Config
+
+	// Realtime sends frames with the delays between them in the recording
+	// instead of as fast as possible.
+	Realtime bool
+}
+
+// Replay connects to the server at u and sends it the frames of frames
+// recorded from the client, as recorded and without validation. The frames
+// should be from a single connection of the recording.
+//
+// It returns the frames the server sent. If the last frame sent is a close
+// frame, Replay waits for the server to close the connection. Otherwise it
+// closes the connection after sending.
+func Replay(ctx context.Context, u string, frames []Frame, opts *ReplayOptions) ([]Frame, error) {
+	if opts == nil {
+		opts = &ReplayOptions{}
+	}
+
+	nc, br, err := replayHandshake(ctx, u, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to replay: %w", err)
+	}
+	defer nc.Close()
+	stop := context.AfterFunc(ctx, func() {
+		nc.Close()
+	})
+	defer stop()
+
+	var received []Frame
+	var readErr error
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		received, readErr = readFrames(br)
+	}()
+
+	err = replayFrames(nc, frames, opts.Realtime)
+	if err != nil || !lastIsClose(frames) {
+		nc.Close()
+	}
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		return received, fmt.Errorf("failed to replay: %w", ctx.Err())
+	}
+	if err != nil {
+		return received, fmt.Errorf("failed to replay: %w", err)
+	}
+	if readErr != nil && !errors.Is(readErr, net.ErrClosed) {
+		return received, fmt.Errorf("failed to replay: %w", readErr)
+	}
+	return received, nil
+}
+
+// ReplayHandler is like Replay but replays frames against h.
+func ReplayHandler(ctx context.Context, h http.Handler, frames []Frame, opts *ReplayOptions) ([]Frame, error) {
+	s := httptest.NewServer(h)
+	defer s.Close()
+	return Replay(ctx, "ws"+strings.TrimPrefix(s.URL, "http"), frames, opts)
+}
+
+func replayHandshake(ctx context.Context, u string, opts *ReplayOptions) (net.Conn, *bufio.Reader, error) {
+	pu, err := url.Parse(u)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse url: %w", err)
+	}
+
+	var nc net.Conn
+	switch pu.Scheme {
+	case "ws":
+		pu.Scheme = "http"
+		var d net.Dialer
+		nc, err = d.DialContext(ctx, "tcp", hostPort(pu, "80"))
+	case "wss":
+		pu.Scheme = "https"
+		d := tls.Dialer{Config: opts.TLSConfig}
+		nc, err = d.DialContext(ctx, "tcp", hostPort(pu, "443"))
+	default:
+		return nil, nil, fmt.Errorf("unexpected url scheme: %q", pu.Scheme)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", pu.String(), nil)
+	if err != nil {
+		nc.Close()
+		return nil, nil, err
+	}
+	for k, v := range opts.HTTPHeader {
+		req.Header[k] = v
+	}
+	key := make([]byte, 16)
+	rand.Read(key)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", base64.StdEncoding.EncodeToString(key))
+
+	if deadline, ok := ctx.Deadline(); ok {
+		nc.SetDeadline(deadline)
+	}
+	err = req.Write(nc)
+	if err != nil {
+		nc.Close()
+		return nil, nil, fmt.Errorf("failed to write handshake request: %w", err)
+	}
+	br := bufio.NewReader(nc)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		nc.Close()
+		return nil, nil, fmt.Errorf("failed to read handshake response: %w", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		nc.Close()
+		return nil, nil, fmt.Errorf("expected handshake response status code %v but got %v", http.StatusSwitchingProtocols, resp.StatusCode)
+	}
+	nc.SetDeadline(time.Time{})
+	return nc, br, nil
+}
+
+func hostPort(u *url.URL, port string) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+func replayFrames(w io.Writer, frames []Frame, realtime bool) error {
+	var start, first time.Time
+	var b []byte
+	for _, f := range frames {
+		if !f.FromClient {
+			continue
+		}
+		if realtime {
+			if start.IsZero() {
+				start, first = time.Now(), f.Time
+			}
+			time.Sleep(time.Until(start.Add(f.Time.Sub(first))))
+		}
+
+		maskKey := make([]byte, 4)
+		rand.Read(maskKey)
+		b = appendFrame(b[:0], f, maskKey)
+		_, err := w.Write(b)
+		if err != nil {
+			return fmt.Errorf("failed to write frame: %w", err)
+		}
+	}
+	return nil
+}
+
+func lastIsClose(frames []Frame) bool {
+	for i := len(frames) - 1; i >= 0; i-- {
+		if frames[i].FromClient {
+			return frames[i].Opcode == 8
+		}
+	}
+	return false
+}
+
+// readFrames reads the frames the server sends until the connection closes.
+func readFrames(r io.Reader) ([]Frame, error) {
+	var frames []Frame
+	var buf []byte
+	p := make([]byte, 32<<10)
+	for {
+		n, err := r.Read(p)
+		buf = append(buf, p[:n]...)
+		for {
+			f, fn, perr := parseFrame(buf)
+			if perr != nil {
+				return frames, perr
+			}
+			if fn == 0 {
+				break
+			}
+			buf = buf[fn:]
+			f.Time = time.Now()
+			frames = append(frames, f)
+		}
+		if errors.Is(err, io.EOF) {
+			return frames, nil
+		}
+		if err != nil {
+			return frames, err
+		}
+	}
+}
@@ -0,0 +1,360 @@
+//go:build !js
+
+// Package wsrecord records the frames of WebSocket connections and replays
+// them against servers to reproduce protocol bugs seen in production.
+//
+// Recordings are JSON lines, one Frame per line, with payloads unmasked.
+// They are written by a Recorder and read with ReadFrames.
+package wsrecord // import "github.com/coder/websocket/wsrecord"
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Frame is a recorded WebSocket frame.
+type Frame struct {
+	Time time.Time `json:"time"`
+
+	// Conn identifies the connection within the recording.
+	Conn int64 `json:"conn"`
+
+	// FromClient is whether the client sent the frame.
+	FromClient bool `json:"from_client"`
+
+	Fin    bool `json:"fin"`
+	RSV1   bool `json:"rsv1,omitempty"`
+	RSV2   bool `json:"rsv2,omitempty"`
+	RSV3   bool `json:"rsv3,omitempty"`
+	Opcode byte `json:"opcode"`
+
+	// Payload is the unmasked payload.
+	Payload []byte `json:"payload"`
+}
+
+// ReadFrames reads all frames of a recording.
+func ReadFrames(r io.Reader) ([]Frame, error) {
+	var frames []Frame
+	d := json.NewDecoder(r)
+	for {
+		var f Frame
+		err := d.Decode(&f)
+		if errors.Is(err, io.EOF) {
+			return frames, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read frame: %w", err)
+		}
+		frames = append(frames, f)
+	}
+}
+
+// Recorder writes the frames of connections to a recording.
+//
+// All methods may be called concurrently.
+type Recorder struct {
+	nextConn atomic.Int64
+
+	mu  sync.Mutex
+	enc *json.Encoder
+	err error
+}
+
+// NewRecorder returns a Recorder writing to w.
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{
+		enc: json.NewEncoder(w),
+	}
+}
+
+// Err returns the first error writing the recording.
+func (r *Recorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+func (r *Recorder) record(f Frame) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return
+	}
+	err := r.enc.Encode(f)
+	if err != nil {
+		r.err = fmt.Errorf("failed to write frame: %w", err)
+	}
+}
+
+// Handler returns a handler that records the connections accepted by h.
+func (r *Recorder) Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		h.ServeHTTP(hijacker{ResponseWriter: w, rec: r}, req)
+	})
+}
+
+// NetDialContext wraps dial to record the connections it dials. Pass the
+// result in websocket.DialOptions.NetDialContext. dial defaults to
+// net.Dialer.DialContext.
+//
+// Connections to wss URLs cannot be recorded this way as the dialed
+// connection carries encrypted bytes. Record them on the server instead.
+func (r *Recorder) NetDialContext(dial func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	if dial == nil {
+		var d net.Dialer
+		dial = d.DialContext
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		nc, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return r.wrap(nc, true), nil
+	}
+}
+
+type hijacker struct {
+	http.ResponseWriter
+	rec *Recorder
+}
+
+var _ http.Hijacker = hijacker{}
+
+func (hj hijacker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	nc, brw, err := http.NewResponseController(hj.ResponseWriter).Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	err = brw.Writer.Flush()
+	if err != nil {
+		nc.Close()
+		return nil, nil, err
+	}
+
+	rc := hj.rec.wrap(nc, false)
+	b, _ := brw.Reader.Peek(brw.Reader.Buffered())
+	rc.buffered = bytes.Clone(b)
+	rc.read.write(rc.buffered)
+	brw.Reader.Reset(rc)
+	brw.Writer.Reset(rc)
+	return rc, brw, nil
+}
+
+// wrap returns nc recording its frames. client is whether nc is the client
+// end of the connection.
+func (r *Recorder) wrap(nc net.Conn, client bool) *conn {
+	id := r.nextConn.Add(1)
+	return &conn{
+		Conn: nc,
+		read: &stream{
+			rec:        r,
+			conn:       id,
+			fromClient: !client,
+			http:       client,
+		},
+		written: &stream{
+			rec:        r,
+			conn:       id,
+			fromClient: client,
+			http:       client,
+		},
+	}
+}
+
+// conn records the frames read from and written to a net.Conn.
+type conn struct {
+	net.Conn
+	read    *stream
+	written *stream
+
+	// writeMu makes writes and their recording atomic. Otherwise a write
+	// returning in one goroutine, e.g. the HTTP request, could be recorded
+	// after a later write in another goroutine.
+	writeMu sync.Mutex
+
+	// buffered holds the bytes the HTTP server read before the connection
+	// was hijacked. They are returned by Read first so that they are not
+	// lost if the caller resets the bufio.Reader to the connection.
+	buffered []byte
+}
+
+func (c *conn) Read(p []byte) (int, error) {
+	if len(c.buffered) > 0 {
+		n := copy(p, c.buffered)
+		c.buffered = c.buffered[n:]
+		return n, nil
+	}
+	n, err := c.Conn.Read(p)
+	c.read.write(p[:n])
+	return n, err
+}
+
+func (c *conn) Write(p []byte) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	n, err := c.Conn.Write(p)
+	c.written.write(p[:n])
+	return n, err
+}
+
+// stream parses the frames sent in one direction of a connection.
+type stream struct {
+	rec        *Recorder
+	conn       int64
+	fromClient bool
+
+	mu  sync.Mutex
+	buf []byte
+	// http is whether the stream begins with an HTTP message to skip.
+	http bool
+	err  error
+}
+
+func (s *stream) write(p []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil || len(p) == 0 {
+		return
+	}
+
+	s.buf = append(s.buf, p...)
+	if s.http {
+		i := bytes.Index(s.buf, []byte("\r\n\r\n"))
+		if i < 0 {
+			return
+		}
+		s.buf = s.buf[i+4:]
+		s.http = false
+	}
+
+	for {
+		f, n, err := parseFrame(s.buf)
+		if err != nil {
+			// The stream cannot be resynchronized.
+			s.err = err
+			s.buf = nil
+			return
+		}
+		if n == 0 {
+			return
+		}
+		s.buf = s.buf[n:]
+
+		f.Time = time.Now()
+		f.Conn = s.conn
+		f.FromClient = s.fromClient
+		s.rec.record(f)
+	}
+}
+
+// parseFrame parses the frame at the start of b and returns it with its
+// length, or a zero length if b does not hold a complete frame yet.
+func parseFrame(b []byte) (Frame, int, error) {
+	if len(b) < 2 {
+		return Frame{}, 0, nil
+	}
+	f := Frame{
+		Fin:    b[0]&(1<<7) != 0,
+		RSV1:   b[0]&(1<<6) != 0,
+		RSV2:   b[0]&(1<<5) != 0,
+		RSV3:   b[0]&(1<<4) != 0,
+		Opcode: b[0] & 0xf,
+	}
+	masked := b[1]&(1<<7) != 0
+
+	n := 2
+	length := uint64(b[1] &^ (1 << 7))
+	switch length {
+	case 126:
+		if len(b) < n+2 {
+			return Frame{}, 0, nil
+		}
+		length = uint64(binary.BigEndian.Uint16(b[n:]))
+		n += 2
+	case 127:
+		if len(b) < n+8 {
+			return Frame{}, 0, nil
+		}
+		length = binary.BigEndian.Uint64(b[n:])
+		n += 8
+	}
+	if length > math.MaxInt32 {
+		return Frame{}, 0, fmt.Errorf("frame payload length %v is too large", length)
+	}
+
+	var maskKey []byte
+	if masked {
+		if len(b) < n+4 {
+			return Frame{}, 0, nil
+		}
+		maskKey = b[n : n+4]
+		n += 4
+	}
+
+	if len(b) < n+int(length) {
+		return Frame{}, 0, nil
+	}
+	f.Payload = bytes.Clone(b[n : n+int(length)])
+	if masked {
+		for i := range f.Payload {
+			f.Payload[i] ^= maskKey[i%4]
+		}
+	}
+	return f, n + int(length), nil
+}
+
+// appendFrame appends the wire encoding of f to b, masking the payload with
+// maskKey if it is not nil.
+func appendFrame(b []byte, f Frame, maskKey []byte) []byte {
+	var b0 byte
+	if f.Fin {
+		b0 |= 1 << 7
+	}
+	if f.RSV1 {
+		b0 |= 1 << 6
+	}
+	if f.RSV2 {
+		b0 |= 1 << 5
+	}
+	if f.RSV3 {
+		b0 |= 1 << 4
+	}
+	b0 |= f.Opcode & 0xf
+	b = append(b, b0)
+
+	var b1 byte
+	if maskKey != nil {
+		b1 = 1 << 7
+	}
+	switch length := len(f.Payload); {
+	case length < 126:
+		b = append(b, b1|byte(length))
+	case length <= math.MaxUint16:
+		b = append(b, b1|126)
+		b = binary.BigEndian.AppendUint16(b, uint16(length))
+	default:
+		b = append(b, b1|127)
+		b = binary.BigEndian.AppendUint64(b, uint64(length))
+	}
+
+	if maskKey == nil {
+		return append(b, f.Payload...)
+	}
+	b = append(b, maskKey...)
+	for i, c := range f.Payload {
+		b = append(b, c^maskKey[i%4])
+	}
+	return b
+}
@@ -0,0 +1,230 @@
+//go:build !js
+
+package wsrecord_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/internal/test/assert"
+	"github.com/coder/websocket/wsrecord"
+)
+
+func echo(w http.ResponseWriter, r *http.Request) {
+	c, err := websocket.Accept(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer c.CloseNow()
+	for {
+		typ, p, err := c.Read(r.Context())
+		if err != nil {
+			return
+		}
+		err = c.Write(r.Context(), typ, p)
+		if err != nil {
+			return
+		}
+	}
+}
+
+type frame struct {
+	fromClient bool
+	opcode     byte
+	payload    string
+}
+
+func summarize(frames []wsrecord.Frame) []frame {
+	var s []frame
+	for _, f := range frames {
+		s = append(s, frame{f.FromClient, f.Opcode, string(f.Payload)})
+	}
+	return s
+}
+
+func TestRecordReplay(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	var serverRecording, clientRecording bytes.Buffer
+	serverRec := wsrecord.NewRecorder(&serverRecording)
+	clientRec := wsrecord.NewRecorder(&clientRecording)
+
+	done := make(chan struct{})
+	s := httptest.NewServer(serverRec.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer close(done)
+		echo(w, r)
+	})))
+	defer s.Close()
+
+	c, _, err := websocket.Dial(ctx, strings.Replace(s.URL, "http", "ws", 1), &websocket.DialOptions{
+		NetDialContext: clientRec.NetDialContext(nil),
+	})
+	assert.Success(t, err)
+	defer c.CloseNow()
+
+	err = c.Write(ctx, websocket.MessageText, []byte("hello"))
+	assert.Success(t, err)
+	_, p, err := c.Read(ctx)
+	assert.Success(t, err)
+	assert.Equal(t, "echo", "hello", string(p))
+	err = c.Close(websocket.StatusNormalClosure, "bye")
+	assert.Success(t, err)
+	<-done
+
+	closePayload := "\x03\xe8bye"
+	exp := []frame{
+		{true, 1, "hello"},
+		{false, 1, "hello"},
+		{true, 8, closePayload},
+		{false, 8, closePayload},
+	}
+
+	assert.Success(t, serverRec.Err())
+	serverFrames, err := wsrecord.ReadFrames(&serverRecording)
+	assert.Success(t, err)
+	assert.Equal(t, "server recording", exp, summarize(serverFrames))
+
+	assert.Success(t, clientRec.Err())
+	clientFrames, err := wsrecord.ReadFrames(&clientRecording)
+	assert.Success(t, err)
+	assert.Equal(t, "client recording", exp, summarize(clientFrames))
+
+	received, err := wsrecord.ReplayHandler(ctx, http.HandlerFunc(echo), serverFrames, nil)
+	assert.Success(t, err)
+	assert.Equal(t, "replayed frames", []frame{
+		{false, 1, "hello"},
+		{false, 8, closePayload},
+	}, summarize(received))
+}
+
+func TestRecordBufferedFrame(t *testing.T) {
+	t.Parallel()
+
+	var recording bytes.Buffer
+	rec := wsrecord.NewRecorder(&recording)
+	done := make(chan struct{})
+	s := httptest.NewServer(rec.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer close(done)
+		echo(w, r)
+	})))
+	defer s.Close()
+
+	nc, err := net.Dial("tcp", s.Listener.Addr().String())
+	assert.Success(t, err)
+	defer nc.Close()
+	nc.SetDeadline(time.Now().Add(time.Second * 10))
+
+	// The first frame is sent along with the handshake so the HTTP server
+	// reads it before the connection is hijacked.
+	req := "GET / HTTP/1.1\r\n" +
+		"Host: " + s.Listener.Addr().String() + "\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Sec-WebSocket-Version: 13\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n\r\n"
+	// A masked text frame with a zero mask key.
+	_, err = nc.Write(append([]byte(req), 0x81, 0x82, 0, 0, 0, 0, 'h', 'i'))
+	assert.Success(t, err)
+
+	br := bufio.NewReader(nc)
+	resp, err := http.ReadResponse(br, nil)
+	assert.Success(t, err)
+	assert.Equal(t, "status code", http.StatusSwitchingProtocols, resp.StatusCode)
+	b := make([]byte, 4)
+	_, err = io.ReadFull(br, b)
+	assert.Success(t, err)
+	assert.Equal(t, "echo", []byte{0x81, 2, 'h', 'i'}, b)
+	nc.Close()
+	<-done
+
+	assert.Success(t, rec.Err())
+	frames, err := wsrecord.ReadFrames(&recording)
+	assert.Success(t, err)
+	assert.Equal(t, "recording", []frame{
+		{true, 1, "hi"},
+		{false, 1, "hi"},
+	}, summarize(frames))
+}
+
+func TestRecordConcurrentWrites(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	const n = 20
+	var serverRecording, clientRecording bytes.Buffer
+	serverRec := wsrecord.NewRecorder(&serverRecording)
+	clientRec := wsrecord.NewRecorder(&clientRecording)
+
+	done := make(chan struct{})
+	s := httptest.NewServer(serverRec.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer close(done)
+		c, err := websocket.Accept(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer c.CloseNow()
+
+		var wg sync.WaitGroup
+		for i := range n {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				c.Write(ctx, websocket.MessageText, []byte(fmt.Sprint(i)))
+			}()
+		}
+		wg.Wait()
+		c.Close(websocket.StatusNormalClosure, "")
+	})))
+	defer s.Close()
+
+	c, _, err := websocket.Dial(ctx, strings.Replace(s.URL, "http", "ws", 1), &websocket.DialOptions{
+		NetDialContext: clientRec.NetDialContext(nil),
+	})
+	assert.Success(t, err)
+	defer c.CloseNow()
+
+	var received []string
+	for {
+		_, p, err := c.Read(ctx)
+		if err != nil {
+			assert.Equal(t, "close status", websocket.StatusNormalClosure, websocket.CloseStatus(err))
+			break
+		}
+		received = append(received, string(p))
+	}
+	<-done
+
+	serverFrames := func(rec *wsrecord.Recorder, recording *bytes.Buffer) []string {
+		assert.Success(t, rec.Err())
+		frames, err := wsrecord.ReadFrames(recording)
+		assert.Success(t, err)
+		var payloads []string
+		for _, f := range frames {
+			if !f.FromClient && f.Opcode == 1 {
+				payloads = append(payloads, string(f.Payload))
+			}
+		}
+		return payloads
+	}
+	assert.Equal(t, "server recording", received, serverFrames(serverRec, &serverRecording))
+	assert.Equal(t, "client recording", received, serverFrames(clientRec, &clientRecording))
+	slices.Sort(received)
+	assert.Equal(t, "messages", n, len(slices.Compact(received)))
+}
@@ -0,0 +1,337 @@
+// Package wsrpc provides request/response calls over a WebSocket connection.
+//
+// Each call is a binary message carrying an ID that correlates the response
+// with the request, so any number of calls may be in flight concurrently in
+// both directions. Payloads are encoded with a pluggable Codec.
+package wsrpc // import "github.com/coder/websocket/wsrpc"
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/coder/websocket"
+)
+
+// Codec encodes and decodes request and response payloads.
+type Codec interface {
+	Marshal(v any) ([]byte, error)
+	Unmarshal(p []byte, v any) error
+}
+
+// JSON is the default Codec.
+var JSON Codec = jsonCodec{}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(p []byte, v any) error {
+	return json.Unmarshal(p, v)
+}
+
+// Error is returned by Call when the peer's handler fails or the method
+// is not registered.
+type Error struct {
+	Method  string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("wsrpc: %v: %v", e.Method, e.Message)
+}
+
+// Handler handles a call. decode unmarshals the request payload into v.
+// The returned value is encoded as the response payload.
+//
+// ctx is canceled when the caller gives up on the call or Serve returns.
+type Handler func(ctx context.Context, decode func(v any) error) (any, error)
+
+// Options configures a Conn.
+type Options struct {
+	// Codec encodes payloads. Both peers must use the same Codec.
+	// Defaults to JSON.
+	Codec Codec
+
+	// MaxConcurrent is the maximum number of handlers running at once.
+	// Requests beyond it fail with an Error without running a handler.
+	// Defaults to 256. Set to -1 to disable.
+	MaxConcurrent int
+}
+
+const defaultMaxConcurrent = 256
+
+// Conn makes and serves calls over a WebSocket connection.
+//
+// Serve must be running for calls to complete in either direction.
+// All methods may be called concurrently.
+type Conn struct {
+	c             *websocket.Conn
+	codec         Codec
+	maxConcurrent int
+
+	mu       sync.Mutex
+	handlers map[string]Handler
+	nextID   uint64
+	pending  map[uint64]chan frame
+	serving  map[uint64]context.CancelFunc
+	done     chan struct{}
+	err      error
+}
+
+// NewConn wraps c. The connection must not be read from by anything but Serve.
+func NewConn(c *websocket.Conn, opts *Options) *Conn {
+	if opts == nil {
+		opts = &Options{}
+	}
+	codec := opts.Codec
+	if codec == nil {
+		codec = JSON
+	}
+	maxConcurrent := opts.MaxConcurrent
+	if maxConcurrent == 0 {
+		maxConcurrent = defaultMaxConcurrent
+	}
+	return &Conn{
+		c:             c,
+		codec:         codec,
+		maxConcurrent: maxConcurrent,
+		handlers:      make(map[string]Handler),
+		pending:       make(map[uint64]chan frame),
+		serving:       make(map[uint64]context.CancelFunc),
+		done:          make(chan struct{}),
+	}
+}
+
+// Handle registers h as the handler for method.
+func (c *Conn) Handle(method string, h Handler) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.handlers[method] = h
+}
+
+// Call calls method on the peer with req and decodes the response into resp.
+// resp may be nil to discard the response.
+//
+// If ctx expires before the response arrives, the peer is told to cancel
+// the handler's context and ctx.Err() is returned.
+func (c *Conn) Call(ctx context.Context, method string, req, resp any) (err error) {
+	p, err := c.codec.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	ch := make(chan frame, 1)
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return c.err
+	}
+	c.nextID++
+	id := c.nextID
+	c.pending[id] = ch
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+	}()
+
+	err = c.c.Write(ctx, websocket.MessageBinary, frame{kind: kindRequest, id: id, name: method, payload: p}.bytes())
+	if err != nil {
+		return fmt.Errorf("failed to call %v: %w", method, err)
+	}
+
+	select {
+	case f := <-ch:
+		if f.kind == kindError {
+			return &Error{Method: method, Message: f.name}
+		}
+		if resp == nil {
+			return nil
+		}
+		err = c.codec.Unmarshal(f.payload, resp)
+		if err != nil {
+			return fmt.Errorf("failed to unmarshal response: %w", err)
+		}
+		return nil
+	case <-ctx.Done():
+		c.c.WriteAsync(websocket.MessageBinary, frame{kind: kindCancel, id: id}.bytes(), nil)
+		return fmt.Errorf("failed to call %v: %w", method, ctx.Err())
+	case <-c.done:
+		return c.err
+	}
+}
+
+// Serve reads from the connection, running handlers for requests and
+// completing calls with responses, until the connection fails or ctx expires.
+// As with Conn.Read, ctx expiring closes the connection.
+//
+// Handlers run in their own goroutines. Serve waits for them before returning
+// and pending calls then fail with the returned error. A request reusing the
+// ID of a call still being handled fails the connection.
+func (c *Conn) Serve(ctx context.Context) (err error) {
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	defer func() {
+		cancel()
+		wg.Wait()
+
+		c.mu.Lock()
+		c.err = fmt.Errorf("wsrpc: connection closed: %w", err)
+		close(c.done)
+		c.mu.Unlock()
+	}()
+
+	for {
+		_, p, err := c.c.Read(ctx)
+		if err != nil {
+			return err
+		}
+		f, err := parseFrame(p)
+		if err != nil {
+			c.c.Close(websocket.StatusUnsupportedData, "invalid RPC message")
+			return err
+		}
+
+		switch f.kind {
+		case kindRequest:
+			c.mu.Lock()
+			_, dup := c.serving[f.id]
+			full := c.maxConcurrent > 0 && len(c.serving) >= c.maxConcurrent
+			c.mu.Unlock()
+			if dup {
+				c.c.Close(websocket.StatusUnsupportedData, "duplicate RPC request ID")
+				return fmt.Errorf("wsrpc: duplicate request ID %v", f.id)
+			}
+			if full {
+				c.c.WriteAsync(websocket.MessageBinary, frame{kind: kindError, id: f.id, name: "too many concurrent calls"}.bytes(), nil)
+				continue
+			}
+
+			hctx, hcancel := context.WithCancel(ctx)
+			c.mu.Lock()
+			c.serving[f.id] = hcancel
+			c.mu.Unlock()
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer func() {
+					c.mu.Lock()
+					delete(c.serving, f.id)
+					c.mu.Unlock()
+					hcancel()
+				}()
+				c.handle(ctx, hctx, f)
+			}()
+		case kindResponse, kindError:
+			c.mu.Lock()
+			ch := c.pending[f.id]
+			c.mu.Unlock()
+			if ch != nil {
+				select {
+				case ch <- f:
+				default:
+				}
+			}
+		case kindCancel:
+			c.mu.Lock()
+			hcancel := c.serving[f.id]
+			c.mu.Unlock()
+			if hcancel != nil {
+				hcancel()
+			}
+		}
+	}
+}
+
+// handle runs the handler for the request f and writes the response with
+// the Serve context ctx.
+func (c *Conn) handle(ctx, hctx context.Context, f frame) {
+	resp := frame{kind: kindResponse, id: f.id}
+
+	c.mu.Lock()
+	h := c.handlers[f.name]
+	c.mu.Unlock()
+	if h == nil {
+		resp.kind = kindError
+		resp.name = fmt.Sprintf("unknown method %q", f.name)
+	} else {
+		v, err := h(hctx, func(v any) error {
+			return c.codec.Unmarshal(f.payload, v)
+		})
+		if err == nil {
+			resp.payload, err = c.codec.Marshal(v)
+		}
+		if err != nil {
+			resp.kind = kindError
+			resp.name = err.Error()
+		}
+	}
+
+	if hctx.Err() != nil {
+		// The caller is gone.
+		return
+	}
+	_ = c.c.Write(ctx, websocket.MessageBinary, resp.bytes())
+}
+
+const (
+	kindRequest byte = iota + 1
+	kindResponse
+	kindError
+	kindCancel
+)
+
+// frame is a single RPC message. name is the method of a request or the
+// message of an error.
+//
+// It is encoded as the kind byte, the uvarint ID, the uvarint length of name,
+// name and then the payload.
+type frame struct {
+	kind    byte
+	id      uint64
+	name    string
+	payload []byte
+}
+
+func (f frame) bytes() []byte {
+	b := make([]byte, 0, 1+2*binary.MaxVarintLen64+len(f.name)+len(f.payload))
+	b = append(b, f.kind)
+	b = binary.AppendUvarint(b, f.id)
+	b = binary.AppendUvarint(b, uint64(len(f.name)))
+	b = append(b, f.name...)
+	return append(b, f.payload...)
+}
+
+var errInvalidFrame = errors.New("wsrpc: invalid message")
+
+func parseFrame(p []byte) (frame, error) {
+	if len(p) == 0 || p[0] < kindRequest || p[0] > kindCancel {
+		return frame{}, errInvalidFrame
+	}
+	f := frame{kind: p[0]}
+	p = p[1:]
+
+	id, n := binary.Uvarint(p)
+	if n <= 0 {
+		return frame{}, errInvalidFrame
+	}
+	f.id = id
+	p = p[n:]
+
+	l, n := binary.Uvarint(p)
+	if n <= 0 || l > uint64(len(p)-n) {
+		return frame{}, errInvalidFrame
+	}
+	p = p[n:]
+	f.name = string(p[:l])
+	f.payload = p[l:]
+	return f, nil
+}
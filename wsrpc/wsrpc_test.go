@@ -0,0 +1,171 @@
+//go:build !js
+
+package wsrpc_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/internal/test/assert"
+	"github.com/coder/websocket/internal/test/wstest"
+	"github.com/coder/websocket/internal/xsync"
+	"github.com/coder/websocket/wsrpc"
+)
+
+func TestConn(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	c1, c2 := wstest.Pipe(nil, nil)
+	defer c2.CloseNow()
+	defer c1.CloseNow()
+
+	client := wsrpc.NewConn(c1, nil)
+	server := wsrpc.NewConn(c2, nil)
+
+	server.Handle("add", func(ctx context.Context, decode func(v any) error) (any, error) {
+		var args [2]int
+		err := decode(&args)
+		if err != nil {
+			return nil, err
+		}
+		return args[0] + args[1], nil
+	})
+	canceled := make(chan struct{})
+	server.Handle("block", func(ctx context.Context, decode func(v any) error) (any, error) {
+		<-ctx.Done()
+		close(canceled)
+		return nil, ctx.Err()
+	})
+	server.Handle("fail", func(ctx context.Context, decode func(v any) error) (any, error) {
+		return nil, errors.New("boom")
+	})
+
+	serveCtx, serveCancel := context.WithCancel(ctx)
+	defer serveCancel()
+	clientErrs := xsync.Go(func() error {
+		return client.Serve(serveCtx)
+	})
+	serverErrs := xsync.Go(func() error {
+		return server.Serve(serveCtx)
+	})
+
+	t.Run("concurrent", func(t *testing.T) {
+		errs := make(chan error, 10)
+		for i := range 10 {
+			go func() {
+				var sum int
+				err := client.Call(ctx, "add", [2]int{i, 1}, &sum)
+				if err == nil && sum != i+1 {
+					err = fmt.Errorf("expected %v but got %v", i+1, sum)
+				}
+				errs <- err
+			}()
+		}
+		for range 10 {
+			assert.Success(t, <-errs)
+		}
+	})
+
+	t.Run("error", func(t *testing.T) {
+		err := client.Call(ctx, "fail", nil, nil)
+		var rerr *wsrpc.Error
+		if !errors.As(err, &rerr) {
+			t.Fatalf("expected wsrpc.Error: %v", err)
+		}
+		assert.Equal(t, "message", "boom", rerr.Message)
+
+		err = client.Call(ctx, "missing", nil, nil)
+		assert.Contains(t, err, `unknown method "missing"`)
+	})
+
+	t.Run("cancel", func(t *testing.T) {
+		callCtx, callCancel := context.WithTimeout(ctx, time.Millisecond*50)
+		defer callCancel()
+
+		err := client.Call(callCtx, "block", nil, nil)
+		assert.ErrorIs(t, context.DeadlineExceeded, err)
+
+		select {
+		case <-canceled:
+		case <-ctx.Done():
+			t.Fatal(ctx.Err())
+		}
+	})
+
+	serveCancel()
+	assert.ErrorIs(t, context.Canceled, <-clientErrs)
+	assert.ErrorIs(t, context.Canceled, <-serverErrs)
+
+	err := client.Call(ctx, "add", [2]int{1, 2}, nil)
+	assert.Contains(t, err, "connection closed")
+}
+
+func TestConnLimits(t *testing.T) {
+	t.Parallel()
+
+	t.Run("maxConcurrent", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+
+		c1, c2 := wstest.Pipe(nil, nil)
+		defer c2.CloseNow()
+		defer c1.CloseNow()
+
+		client := wsrpc.NewConn(c1, nil)
+		server := wsrpc.NewConn(c2, &wsrpc.Options{MaxConcurrent: 1})
+		started := make(chan struct{})
+		server.Handle("block", func(ctx context.Context, decode func(v any) error) (any, error) {
+			close(started)
+			<-ctx.Done()
+			return nil, ctx.Err()
+		})
+		go client.Serve(ctx)
+		go server.Serve(ctx)
+
+		callCtx, callCancel := context.WithCancel(ctx)
+		defer callCancel()
+		go client.Call(callCtx, "block", nil, nil)
+		<-started
+
+		err := client.Call(ctx, "block", nil, nil)
+		assert.Contains(t, err, "too many concurrent calls")
+	})
+
+	t.Run("duplicateID", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+
+		c1, c2 := wstest.Pipe(nil, nil)
+		defer c2.CloseNow()
+		defer c1.CloseNow()
+
+		server := wsrpc.NewConn(c2, nil)
+		server.Handle("block", func(ctx context.Context, decode func(v any) error) (any, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		})
+		serverErrs := xsync.Go(func() error {
+			return server.Serve(ctx)
+		})
+		c1.CloseRead(ctx)
+
+		// Two requests for the block method with ID 1.
+		req := []byte{1, 1, 5, 'b', 'l', 'o', 'c', 'k'}
+		for range 2 {
+			err := c1.Write(ctx, websocket.MessageBinary, req)
+			assert.Success(t, err)
+		}
+		assert.Contains(t, <-serverErrs, "duplicate request ID 1")
+	})
+}
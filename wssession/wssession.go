@@ -0,0 +1,283 @@
+// Package wssession provides a client session that redials dropped WebSocket
+// connections and replays outgoing messages the peer has not acknowledged.
+package wssession // import "github.com/coder/websocket/wssession"
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/coder/websocket"
+)
+
+// ErrBufferFull is returned by Write when MaxBuffered unacknowledged
+// messages are already queued.
+var ErrBufferFull = errors.New("wssession: replay buffer full")
+
+// Options configures a Session.
+type Options struct {
+	// Dial opens a new connection. It is called for the initial connection
+	// and for every reconnect.
+	Dial func(ctx context.Context) (*websocket.Conn, error)
+
+	// MaxBuffered bounds the number of unacknowledged messages kept for replay.
+	// Defaults to 64.
+	MaxBuffered int
+
+	// ManualAck keeps messages buffered until they are acknowledged with
+	// Session.Ack, e.g. once the peer confirms receipt at the application level.
+	// By default a message is acknowledged as soon as it is written to a
+	// connection, which only protects writes made while disconnected.
+	ManualAck bool
+
+	// OnReconnect is called with each new connection before buffered messages
+	// are replayed. Use it to perform an application level resume handshake.
+	// If it returns an error, the connection is closed and dialing is retried.
+	OnReconnect func(ctx context.Context, c *websocket.Conn) error
+
+	// RetryDelay is the delay between failed dial attempts.
+	// Defaults to 1s.
+	RetryDelay time.Duration
+}
+
+type message struct {
+	seq uint64
+	typ websocket.MessageType
+	p   []byte
+}
+
+// Session is a client WebSocket connection that survives disconnects.
+//
+// Messages passed to Write are queued and replayed in order on the next
+// connection until acknowledged. Read redials whenever the current connection
+// fails, so an application must keep reading for the session to reconnect.
+//
+// Write, Ack and Close may be called concurrently. Read must only be called
+// from one goroutine at a time.
+type Session struct {
+	opts Options
+
+	// writeMu serializes writes and replays so messages stay in order.
+	writeMu sync.Mutex
+
+	mu     sync.Mutex
+	conn   *websocket.Conn
+	buf    []message
+	seq    uint64
+	closed bool
+}
+
+// Dial opens the initial connection of a Session.
+func Dial(ctx context.Context, opts Options) (*Session, error) {
+	if opts.Dial == nil {
+		return nil, errors.New("wssession: Options.Dial is required")
+	}
+	if opts.MaxBuffered <= 0 {
+		opts.MaxBuffered = 64
+	}
+	if opts.RetryDelay <= 0 {
+		opts.RetryDelay = time.Second
+	}
+
+	c, err := opts.Dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &Session{
+		opts: opts,
+		conn: c,
+	}, nil
+}
+
+// Write queues a message and writes it to the current connection, if any.
+// It returns the sequence number of the message for use with Ack.
+//
+// A failure to write to the connection is not returned as the message
+// remains queued and is replayed once Read reconnects.
+func (s *Session) Write(ctx context.Context, typ websocket.MessageType, p []byte) (uint64, error) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return 0, net.ErrClosed
+	}
+	if len(s.buf) >= s.opts.MaxBuffered {
+		s.mu.Unlock()
+		return 0, ErrBufferFull
+	}
+	s.seq++
+	m := message{
+		seq: s.seq,
+		typ: typ,
+		p:   append([]byte(nil), p...),
+	}
+	s.buf = append(s.buf, m)
+	c := s.conn
+	s.mu.Unlock()
+
+	if c != nil {
+		_ = s.send(ctx, c, m)
+	}
+	return m.seq, nil
+}
+
+// Ack releases all buffered messages with a sequence number up to and
+// including seq.
+func (s *Session) Ack(seq uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := 0
+	for i < len(s.buf) && s.buf[i].seq <= seq {
+		i++
+	}
+	s.buf = append(s.buf[:0], s.buf[i:]...)
+}
+
+// Buffered returns the number of unacknowledged messages.
+func (s *Session) Buffered() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.buf)
+}
+
+// Read reads a message, redialing and replaying buffered messages whenever
+// the connection fails. It returns when a message is read, ctx expires,
+// the peer closes with StatusNormalClosure or the session is closed.
+func (s *Session) Read(ctx context.Context) (websocket.MessageType, []byte, error) {
+	for {
+		c, err := s.connect(ctx)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		typ, p, err := c.Read(ctx)
+		if err == nil {
+			return typ, p, nil
+		}
+		s.drop(c)
+		if ctx.Err() != nil || websocket.CloseStatus(err) == websocket.StatusNormalClosure || s.isClosed() {
+			return 0, nil, err
+		}
+	}
+}
+
+// Close closes the session and its current connection with the given
+// status code and reason. Buffered messages are discarded.
+func (s *Session) Close(code websocket.StatusCode, reason string) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return net.ErrClosed
+	}
+	s.closed = true
+	c := s.conn
+	s.conn = nil
+	s.buf = nil
+	s.mu.Unlock()
+
+	if c == nil {
+		return nil
+	}
+	return c.Close(code, reason)
+}
+
+func (s *Session) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+func (s *Session) send(ctx context.Context, c *websocket.Conn, m message) error {
+	err := c.Write(ctx, m.typ, m.p)
+	if err != nil {
+		s.drop(c)
+		return err
+	}
+	if !s.opts.ManualAck {
+		s.Ack(m.seq)
+	}
+	return nil
+}
+
+// drop forgets c if it is still the current connection and closes it.
+func (s *Session) drop(c *websocket.Conn) {
+	s.mu.Lock()
+	if s.conn == c {
+		s.conn = nil
+	}
+	s.mu.Unlock()
+
+	c.CloseNow()
+}
+
+func (s *Session) connect(ctx context.Context) (*websocket.Conn, error) {
+	for {
+		s.mu.Lock()
+		c, closed := s.conn, s.closed
+		s.mu.Unlock()
+		if closed {
+			return nil, net.ErrClosed
+		}
+		if c != nil {
+			return c, nil
+		}
+
+		c, err := s.opts.Dial(ctx)
+		if err == nil {
+			err = s.resume(ctx, c)
+			if err == nil {
+				return c, nil
+			}
+			c.CloseNow()
+		}
+
+		t := time.NewTimer(s.opts.RetryDelay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil, ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// resume runs OnReconnect, replays the buffered messages on c and then makes
+// c the current connection.
+func (s *Session) resume(ctx context.Context, c *websocket.Conn) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	if s.opts.OnReconnect != nil {
+		err := s.opts.OnReconnect(ctx, c)
+		if err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	buf := append([]message(nil), s.buf...)
+	s.mu.Unlock()
+
+	for _, m := range buf {
+		err := c.Write(ctx, m.typ, m.p)
+		if err != nil {
+			return err
+		}
+		if !s.opts.ManualAck {
+			s.Ack(m.seq)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return net.ErrClosed
+	}
+	s.conn = c
+	return nil
+}
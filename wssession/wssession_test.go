@@ -0,0 +1,108 @@
+//go:build !js
+
+package wssession_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/internal/test/assert"
+	"github.com/coder/websocket/internal/test/wstest"
+	"github.com/coder/websocket/internal/xsync"
+	"github.com/coder/websocket/wssession"
+)
+
+func TestSession(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	servers := make(chan *websocket.Conn, 2)
+	s, err := wssession.Dial(ctx, wssession.Options{
+		Dial: func(ctx context.Context) (*websocket.Conn, error) {
+			c1, c2 := wstest.Pipe(nil, nil)
+			servers <- c2
+			return c1, nil
+		},
+		ManualAck:  true,
+		RetryDelay: time.Millisecond,
+	})
+	assert.Success(t, err)
+	defer s.Close(websocket.StatusInternalError, "")
+
+	srv1 := <-servers
+	errs := xsync.Go(func() error {
+		defer srv1.CloseNow()
+		_, p, err := srv1.Read(ctx)
+		if err != nil {
+			return err
+		}
+		assert.Equal(t, "first message", "a", string(p))
+		return nil
+	})
+
+	seq, err := s.Write(ctx, websocket.MessageText, []byte("a"))
+	assert.Success(t, err)
+	assert.Equal(t, "seq", uint64(1), seq)
+	assert.Success(t, <-errs)
+
+	// The connection is gone so b is only queued.
+	seq, err = s.Write(ctx, websocket.MessageText, []byte("b"))
+	assert.Success(t, err)
+	assert.Equal(t, "seq", uint64(2), seq)
+	assert.Equal(t, "buffered", 2, s.Buffered())
+
+	type readResult struct {
+		p   []byte
+		err error
+	}
+	reads := make(chan readResult, 1)
+	go func() {
+		_, p, err := s.Read(ctx)
+		reads <- readResult{p, err}
+	}()
+
+	srv2 := <-servers
+	defer srv2.CloseNow()
+	for _, exp := range []string{"a", "b"} {
+		_, p, err := srv2.Read(ctx)
+		assert.Success(t, err)
+		assert.Equal(t, "replayed message", exp, string(p))
+	}
+
+	err = srv2.Write(ctx, websocket.MessageText, []byte("ack 2"))
+	assert.Success(t, err)
+
+	r := <-reads
+	assert.Success(t, r.err)
+	assert.Equal(t, "read message", "ack 2", string(r.p))
+
+	s.Ack(2)
+	assert.Equal(t, "buffered", 0, s.Buffered())
+}
+
+func TestSessionBufferFull(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	s, err := wssession.Dial(ctx, wssession.Options{
+		Dial: func(ctx context.Context) (*websocket.Conn, error) {
+			c1, c2 := wstest.Pipe(nil, nil)
+			c2.CloseNow()
+			return c1, nil
+		},
+		MaxBuffered: 1,
+	})
+	assert.Success(t, err)
+	defer s.Close(websocket.StatusInternalError, "")
+
+	_, err = s.Write(ctx, websocket.MessageBinary, []byte("a"))
+	assert.Success(t, err)
+	_, err = s.Write(ctx, websocket.MessageBinary, []byte("b"))
+	assert.ErrorIs(t, wssession.ErrBufferFull, err)
+}
@@ -0,0 +1,207 @@
+//go:build !js
+
+// Package wssockjs serves SockJS clients over the SockJS websocket transport.
+//
+// It speaks the transport's framing so existing SockJS browser clients,
+// including STOMP over SockJS, can connect to servers built on this module
+// while they are migrated to plain WebSockets. Only the websocket transport
+// is supported so clients must not disable it.
+//
+// See https://github.com/sockjs/sockjs-protocol
+package wssockjs // import "github.com/coder/websocket/wssockjs"
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coder/websocket"
+)
+
+// Options configures Handler.
+type Options struct {
+	// AcceptOptions are passed to websocket.Accept.
+	AcceptOptions *websocket.AcceptOptions
+
+	// HeartbeatInterval is the interval between heartbeat frames that keep
+	// the SockJS client from timing out. Defaults to 25s.
+	HeartbeatInterval time.Duration
+}
+
+// Handler returns an http.Handler for a SockJS endpoint. It must be mounted
+// on the SockJS prefix, e.g. with http.Handle("/echo/", ...).
+//
+// fn is called with each session. The session is closed when fn returns.
+func Handler(opts *Options, fn func(s *Session)) http.Handler {
+	if opts == nil {
+		opts = &Options{}
+	}
+	heartbeat := opts.HeartbeatInterval
+	if heartbeat <= 0 {
+		heartbeat = time.Second * 25
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/info"):
+			serveInfo(w)
+		case isWebSocketPath(r.URL.Path):
+			c, err := websocket.Accept(w, r, opts.AcceptOptions)
+			if err != nil {
+				return
+			}
+			serveSession(c, heartbeat, fn)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+}
+
+// isWebSocketPath reports whether p ends in /<server>/<session>/websocket.
+func isWebSocketPath(p string) bool {
+	parts := strings.Split(p, "/")
+	if len(parts) < 4 || parts[len(parts)-1] != "websocket" {
+		return false
+	}
+	for _, part := range parts[len(parts)-3 : len(parts)-1] {
+		if part == "" || strings.Contains(part, ".") {
+			return false
+		}
+	}
+	return true
+}
+
+func serveInfo(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-store, no-cache, must-revalidate, max-age=0")
+	json.NewEncoder(w).Encode(map[string]any{
+		"websocket":     true,
+		"origins":       []string{"*:*"},
+		"cookie_needed": false,
+		"entropy":       rand.Uint32(),
+	})
+}
+
+func serveSession(c *websocket.Conn, heartbeat time.Duration, fn func(s *Session)) {
+	defer c.CloseNow()
+
+	s := &Session{c: c}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	err := c.Write(ctx, websocket.MessageText, []byte("o"))
+	if err != nil {
+		return
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		t := time.NewTicker(heartbeat)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+				// Not bounded by ctx as its expiry would close the connection
+				// before the close frame is sent.
+				err := c.WriteTimeout(heartbeat, websocket.MessageText, []byte("h"))
+				if err != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	fn(s)
+	cancel()
+	wg.Wait()
+	s.Close(3000, "Go away!")
+}
+
+// Session is a SockJS session over a WebSocket connection.
+//
+// Read must only be called from one goroutine at a time.
+// Write and Close may be called concurrently.
+type Session struct {
+	c *websocket.Conn
+
+	// pending holds messages that arrived batched in one frame.
+	pending []string
+
+	closeOnce sync.Once
+}
+
+// Conn returns the underlying WebSocket connection.
+func (s *Session) Conn() *websocket.Conn {
+	return s.c
+}
+
+// Read reads a message sent by the client.
+func (s *Session) Read(ctx context.Context) (string, error) {
+	for len(s.pending) == 0 {
+		_, p, err := s.c.Read(ctx)
+		if err != nil {
+			return "", err
+		}
+		if len(p) == 0 {
+			continue
+		}
+
+		if p[0] == '"' {
+			var msg string
+			err = json.Unmarshal(p, &msg)
+			s.pending = append(s.pending, msg)
+		} else {
+			err = json.Unmarshal(p, &s.pending)
+		}
+		if err != nil {
+			s.c.Close(websocket.StatusUnsupportedData, "broken framing")
+			return "", fmt.Errorf("failed to unmarshal SockJS frame: %w", err)
+		}
+	}
+
+	msg := s.pending[0]
+	s.pending = s.pending[1:]
+	return msg, nil
+}
+
+// Write writes msgs to the client in a single frame.
+func (s *Session) Write(ctx context.Context, msgs ...string) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+	p, err := json.Marshal(msgs)
+	if err != nil {
+		return fmt.Errorf("failed to marshal SockJS frame: %w", err)
+	}
+	return s.c.Write(ctx, websocket.MessageText, append([]byte("a"), p...))
+}
+
+// Close sends the client a SockJS close frame with code and reason and
+// then closes the WebSocket connection. SockJS applications conventionally
+// use codes in the 3000-4999 range.
+func (s *Session) Close(code int, reason string) error {
+	err := errors.New("session already closed")
+	s.closeOnce.Do(func() {
+		p, _ := json.Marshal([]any{code, reason})
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+
+		err = s.c.Write(ctx, websocket.MessageText, append([]byte("c"), p...))
+		if err != nil {
+			s.c.CloseNow()
+			return
+		}
+		err = s.c.Close(websocket.StatusNormalClosure, "")
+	})
+	return err
+}
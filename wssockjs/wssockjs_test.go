@@ -0,0 +1,88 @@
+//go:build !js
+
+package wssockjs_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/internal/test/assert"
+	"github.com/coder/websocket/wssockjs"
+)
+
+func TestHandler(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	s := httptest.NewServer(http.StripPrefix("/echo", wssockjs.Handler(&wssockjs.Options{
+		HeartbeatInterval: time.Millisecond * 50,
+	}, func(s *wssockjs.Session) {
+		for {
+			msg, err := s.Read(ctx)
+			if err != nil {
+				return
+			}
+			if msg == "bye" {
+				return
+			}
+			err = s.Write(ctx, msg)
+			if err != nil {
+				return
+			}
+		}
+	})))
+	defer s.Close()
+
+	resp, err := http.Get(s.URL + "/echo/info")
+	assert.Success(t, err)
+	defer resp.Body.Close()
+	var info map[string]any
+	assert.Success(t, json.NewDecoder(resp.Body).Decode(&info))
+	assert.Equal(t, "websocket", true, info["websocket"])
+
+	c, _, err := websocket.Dial(ctx, strings.Replace(s.URL, "http", "ws", 1)+"/echo/000/session/websocket", nil)
+	assert.Success(t, err)
+	defer c.CloseNow()
+
+	read := func() string {
+		for {
+			_, p, err := c.Read(ctx)
+			assert.Success(t, err)
+			if string(p) != "h" {
+				return string(p)
+			}
+		}
+	}
+
+	assert.Equal(t, "open frame", "o", read())
+
+	err = c.Write(ctx, websocket.MessageText, []byte(`["a","b"]`))
+	assert.Success(t, err)
+	assert.Equal(t, "message frame", `a["a"]`, read())
+	assert.Equal(t, "message frame", `a["b"]`, read())
+
+	// Wait for a heartbeat.
+	_, p, err := c.Read(ctx)
+	assert.Success(t, err)
+	assert.Equal(t, "heartbeat frame", "h", string(p))
+
+	err = c.Write(ctx, websocket.MessageText, []byte(`"bye"`))
+	assert.Success(t, err)
+	assert.Equal(t, "close frame", `c[3000,"Go away!"]`, read())
+
+	_, _, err = c.Read(ctx)
+	assert.Equal(t, "close status", websocket.StatusNormalClosure, websocket.CloseStatus(err))
+
+	resp, err = http.Get(s.URL + "/echo/websocket")
+	assert.Success(t, err)
+	resp.Body.Close()
+	assert.Equal(t, "status", http.StatusNotFound, resp.StatusCode)
+}
@@ -0,0 +1,206 @@
+//go:build !js
+
+package wssse
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/coder/websocket"
+)
+
+// ClientConn is the client side of a stream of messages from Handler.
+// *websocket.Conn implements it.
+type ClientConn interface {
+	// Read reads a message from the server. Once the server closed the
+	// stream, it returns an error wrapping a websocket.CloseError.
+	Read(ctx context.Context) (websocket.MessageType, []byte, error)
+
+	// CloseNow closes the connection.
+	CloseNow() error
+}
+
+// DialOptions configures Dial.
+type DialOptions struct {
+	// DialOptions are passed to websocket.Dial. Its HTTPClient and
+	// HTTPHeader are also used for the SSE request.
+	DialOptions *websocket.DialOptions
+}
+
+// Dial connects to a Handler at u with a WebSocket and falls back to SSE if
+// the WebSocket handshake fails, e.g. because a proxy blocks upgrades.
+func Dial(ctx context.Context, u string, opts *DialOptions) (ClientConn, error) {
+	if opts == nil {
+		opts = &DialOptions{}
+	}
+	wsOpts := opts.DialOptions
+	if wsOpts == nil {
+		wsOpts = &websocket.DialOptions{}
+	}
+
+	c, _, err := websocket.Dial(ctx, u, wsOpts)
+	if err == nil {
+		return c, nil
+	}
+	if ctx.Err() != nil {
+		return nil, err
+	}
+
+	sc, err2 := dialSSE(ctx, u, wsOpts)
+	if err2 != nil {
+		return nil, fmt.Errorf("%w; failed to fall back to SSE: %w", err, err2)
+	}
+	return sc, nil
+}
+
+func dialSSE(ctx context.Context, u string, opts *websocket.DialOptions) (*sseClient, error) {
+	switch {
+	case strings.HasPrefix(u, "ws://"):
+		u = "http://" + strings.TrimPrefix(u, "ws://")
+	case strings.HasPrefix(u, "wss://"):
+		u = "https://" + strings.TrimPrefix(u, "wss://")
+	}
+
+	// The request outlives ctx, which only bounds the response headers.
+	reqCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	stop := context.AfterFunc(ctx, cancel)
+	req, err := http.NewRequestWithContext(reqCtx, "GET", u, nil)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to create SSE request: %w", err)
+	}
+	for k, v := range opts.HTTPHeader {
+		req.Header[k] = v
+	}
+	req.Header.Set("Accept", "text/event-stream")
+
+	client := opts.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if !stop() {
+		err = ctx.Err()
+	}
+	if err != nil {
+		cancel()
+		if resp != nil {
+			resp.Body.Close()
+		}
+		return nil, fmt.Errorf("failed to send SSE request: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		cancel()
+		resp.Body.Close()
+		return nil, fmt.Errorf("expected SSE response but got status %v and content type %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	return &sseClient{
+		resp:   resp,
+		br:     bufio.NewReader(resp.Body),
+		cancel: cancel,
+	}, nil
+}
+
+// sseClient is a ClientConn over an SSE response.
+type sseClient struct {
+	resp   *http.Response
+	br     *bufio.Reader
+	cancel context.CancelFunc
+
+	mu       sync.Mutex
+	closeErr error
+}
+
+// Read reads the next event. Like with a WebSocket, ctx expiring closes the
+// connection.
+func (c *sseClient) Read(ctx context.Context) (websocket.MessageType, []byte, error) {
+	c.mu.Lock()
+	closeErr := c.closeErr
+	c.mu.Unlock()
+	if closeErr != nil {
+		return 0, nil, closeErr
+	}
+
+	stop := context.AfterFunc(ctx, c.cancel)
+	defer stop()
+
+	var event string
+	var data []string
+	for {
+		line, err := c.br.ReadString('\n')
+		if err != nil {
+			if ctx.Err() != nil {
+				err = ctx.Err()
+			}
+			c.CloseNow()
+			return 0, nil, fmt.Errorf("failed to read SSE event: %w", err)
+		}
+		line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+
+		if line != "" {
+			field, value, _ := strings.Cut(line, ":")
+			value = strings.TrimPrefix(value, " ")
+			switch field {
+			case "event":
+				event = value
+			case "data":
+				data = append(data, value)
+			}
+			continue
+		}
+
+		if data == nil {
+			// Comments and events without data.
+			event = ""
+			continue
+		}
+		p := strings.Join(data, "\n")
+		switch event {
+		case "", "message":
+			return websocket.MessageText, []byte(p), nil
+		case "binary":
+			b, err := base64.StdEncoding.DecodeString(p)
+			if err != nil {
+				c.CloseNow()
+				return 0, nil, fmt.Errorf("failed to decode binary SSE event: %w", err)
+			}
+			return websocket.MessageBinary, b, nil
+		case "close":
+			return 0, nil, c.closeEvent(p)
+		}
+		event = ""
+		data = nil
+	}
+}
+
+// closeEvent parses the data of a close event and closes the connection.
+func (c *sseClient) closeEvent(p string) error {
+	code, reason, _ := strings.Cut(p, " ")
+	n, err := strconv.Atoi(code)
+	if err != nil {
+		err = fmt.Errorf("failed to parse SSE close event %q: %w", p, err)
+	} else {
+		err = fmt.Errorf("received close event: %w", websocket.CloseError{
+			Code:   websocket.StatusCode(n),
+			Reason: reason,
+		})
+	}
+
+	c.mu.Lock()
+	c.closeErr = err
+	c.mu.Unlock()
+	c.CloseNow()
+	return err
+}
+
+func (c *sseClient) CloseNow() error {
+	c.cancel()
+	return c.resp.Body.Close()
+}
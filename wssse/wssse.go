@@ -0,0 +1,208 @@
+//go:build !js
+
+// Package wssse streams messages from a server to clients over a WebSocket
+// or, where upgrades are blocked, over Server-Sent Events.
+//
+// Handler accepts both. The function it serves writes messages to a Conn,
+// which is a *websocket.Conn or an SSE stream with the same methods, so the
+// server does not need to know which transport the client used. Dial
+// connects to Handler with a WebSocket and falls back to SSE if the
+// handshake fails.
+//
+// SSE only carries messages from the server to the client so the fallback
+// is a degraded mode for push updates such as notifications and feeds.
+// Text messages are sent as the data of SSE events, readable by browser
+// EventSource clients, and binary messages as base64 encoded "binary"
+// events.
+//
+// See https://html.spec.whatwg.org/multipage/server-sent-events.html
+package wssse // import "github.com/coder/websocket/wssse"
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coder/websocket"
+)
+
+// Conn is the server side of a stream of messages to a client.
+// *websocket.Conn implements it.
+type Conn interface {
+	// Write writes a message to the client.
+	Write(ctx context.Context, typ websocket.MessageType, p []byte) error
+
+	// Close ends the stream with a status code and reason that the client
+	// reads as a websocket.CloseError.
+	Close(code websocket.StatusCode, reason string) error
+}
+
+// Options configures Handler.
+type Options struct {
+	// AcceptOptions are passed to websocket.Accept.
+	AcceptOptions *websocket.AcceptOptions
+
+	// HeartbeatInterval is the interval between SSE comments keeping
+	// proxies from closing idle streams. Defaults to 25s.
+	HeartbeatInterval time.Duration
+}
+
+// Handler returns an http.Handler serving fn over a WebSocket if the request
+// is a WebSocket handshake and over SSE otherwise.
+//
+// ctx is canceled when the client disconnects. The connection is closed
+// with StatusNormalClosure when fn returns.
+func Handler(opts *Options, fn func(ctx context.Context, c Conn)) http.Handler {
+	if opts == nil {
+		opts = &Options{}
+	}
+	heartbeat := opts.HeartbeatInterval
+	if heartbeat <= 0 {
+		heartbeat = time.Second * 25
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+			c, err := websocket.Accept(w, r, opts.AcceptOptions)
+			if err != nil {
+				return
+			}
+			defer c.CloseNow()
+
+			// The client does not send messages but control frames must
+			// still be read.
+			ctx := c.CloseRead(context.Background())
+			fn(ctx, c)
+			c.Close(websocket.StatusNormalClosure, "")
+			return
+		}
+
+		serveSSE(w, r, heartbeat, fn)
+	})
+}
+
+func serveSSE(w http.ResponseWriter, r *http.Request, heartbeat time.Duration, fn func(ctx context.Context, c Conn)) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	c := &sseConn{
+		w:      w,
+		rc:     http.NewResponseController(w),
+		cancel: cancel,
+	}
+	err := c.flush()
+	if err != nil {
+		return
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		t := time.NewTicker(heartbeat)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+				err := c.writeEvent(ctx, ": heartbeat\n\n")
+				if err != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	fn(ctx, c)
+	c.Close(websocket.StatusNormalClosure, "")
+	cancel()
+	wg.Wait()
+}
+
+// sseConn is a Conn over an SSE response.
+type sseConn struct {
+	w      http.ResponseWriter
+	rc     *http.ResponseController
+	cancel context.CancelFunc
+
+	mu     sync.Mutex
+	closed bool
+}
+
+func (c *sseConn) Write(ctx context.Context, typ websocket.MessageType, p []byte) error {
+	var b strings.Builder
+	switch typ {
+	case websocket.MessageText:
+		if strings.ContainsRune(string(p), '\r') {
+			return errors.New("failed to write SSE event: text message contains a carriage return")
+		}
+		for _, line := range strings.Split(string(p), "\n") {
+			b.WriteString("data: " + line + "\n")
+		}
+	case websocket.MessageBinary:
+		b.WriteString("event: binary\ndata: " + base64.StdEncoding.EncodeToString(p) + "\n")
+	default:
+		return fmt.Errorf("failed to write SSE event: unexpected message type %v", typ)
+	}
+	b.WriteString("\n")
+	return c.writeEvent(ctx, b.String())
+}
+
+func (c *sseConn) Close(code websocket.StatusCode, reason string) error {
+	err := c.writeEvent(context.Background(), fmt.Sprintf("event: close\ndata: %d %s\n\n", code, lineBreaks.Replace(reason)))
+	c.mu.Lock()
+	c.closed = true
+	c.mu.Unlock()
+	c.cancel()
+	return err
+}
+
+var lineBreaks = strings.NewReplacer("\r", " ", "\n", " ")
+
+// writeEvent writes and flushes an event. The write is bounded by the
+// deadline of ctx and a failed write closes the stream.
+func (c *sseConn) writeEvent(ctx context.Context, ev string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return net.ErrClosed
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		c.rc.SetWriteDeadline(deadline)
+		defer c.rc.SetWriteDeadline(time.Time{})
+	}
+	_, err := c.w.Write([]byte(ev))
+	if err == nil {
+		err = c.flush()
+	}
+	if err != nil {
+		c.closed = true
+		c.cancel()
+		return fmt.Errorf("failed to write SSE event: %w", err)
+	}
+	return nil
+}
+
+func (c *sseConn) flush() error {
+	err := c.rc.Flush()
+	if err != nil {
+		return fmt.Errorf("failed to flush: %w", err)
+	}
+	return nil
+}
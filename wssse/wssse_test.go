@@ -0,0 +1,72 @@
+//go:build !js
+
+package wssse_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/internal/test/assert"
+	"github.com/coder/websocket/wssse"
+)
+
+func TestDial(t *testing.T) {
+	t.Parallel()
+
+	h := wssse.Handler(nil, func(ctx context.Context, c wssse.Conn) {
+		err := c.Write(ctx, websocket.MessageText, []byte("hello\nworld"))
+		if err != nil {
+			return
+		}
+		err = c.Write(ctx, websocket.MessageBinary, []byte{0, 1, 2})
+		if err != nil {
+			return
+		}
+		c.Close(websocket.StatusGoingAway, "bye")
+	})
+
+	for name, h := range map[string]http.Handler{
+		"websocket": h,
+		"sse": http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// A proxy blocking upgrades.
+			if r.Header.Get("Upgrade") != "" {
+				http.Error(w, "upgrades are not allowed", http.StatusForbidden)
+				return
+			}
+			h.ServeHTTP(w, r)
+		}),
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+			defer cancel()
+
+			s := httptest.NewServer(h)
+			defer s.Close()
+
+			c, err := wssse.Dial(ctx, s.URL, nil)
+			assert.Success(t, err)
+			defer c.CloseNow()
+			_, isWebSocket := c.(*websocket.Conn)
+			assert.Equal(t, "WebSocket", name == "websocket", isWebSocket)
+
+			typ, p, err := c.Read(ctx)
+			assert.Success(t, err)
+			assert.Equal(t, "type", websocket.MessageText, typ)
+			assert.Equal(t, "message", "hello\nworld", string(p))
+
+			typ, p, err = c.Read(ctx)
+			assert.Success(t, err)
+			assert.Equal(t, "type", websocket.MessageBinary, typ)
+			assert.Equal(t, "message", []byte{0, 1, 2}, p)
+
+			_, _, err = c.Read(ctx)
+			assert.Equal(t, "close status", websocket.StatusGoingAway, websocket.CloseStatus(err))
+		})
+	}
+}